@@ -6,6 +6,7 @@ import (
 	"analytic-app/internal/services"
 	"analytic-app/pkg/config"
 	"log"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,20 +23,56 @@ func main() {
 	defer db.Close()
 
 	// Initialize services
-	eventService := services.NewEventService(db)
+	transformService := services.NewTransformService(db)
+	internalTrafficService := services.NewInternalTrafficService(db)
+	revenueService := services.NewRevenueService(db)
+	userService := services.NewUserService(db)
+	groupService := services.NewGroupService(db)
+	cohortService := services.NewCohortService(db)
+	segmentService := services.NewSegmentService(db, cohortService)
+	eventService := services.NewEventService(db, transformService, internalTrafficService, revenueService, groupService)
 	analyticsService := services.NewAnalyticsService(db)
 	adminService := services.NewAdminService(db)
 	realTimeService := services.NewRealTimeService(db)
+	funnelService := services.NewFunnelService(db)
+	retentionService := services.NewRetentionService(db)
+	pathService := services.NewPathService(db)
+	goalService := services.NewGoalService(db)
+	attributionService := services.NewAttributionService(db)
+	experimentService := services.NewExperimentService(db)
+	flagService := services.NewFlagService(db, eventService)
+	recordingService := services.NewRecordingService(db)
+
+	// Delete recordings past their project's retention
+	recordingService.StartRetention(time.Hour)
 
 	// Initialize handlers
 	websocketHandler := handlers.NewWebSocketHandler(adminService)
 	eventHandler := handlers.NewEventHandler(eventService, adminService, websocketHandler)
-	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService)
+	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService, adminService)
 	adminHandler := handlers.NewAdminHandler(adminService)
 	realTimeHandler := handlers.NewRealTimeHandler(realTimeService, adminService)
+	ruleHandler := handlers.NewRuleHandler(transformService, adminService)
+	internalTrafficHandler := handlers.NewInternalTrafficHandler(internalTrafficService, adminService)
+	funnelHandler := handlers.NewFunnelHandler(funnelService, adminService)
+	retentionHandler := handlers.NewRetentionHandler(retentionService, adminService)
+	pathHandler := handlers.NewPathHandler(pathService, adminService)
+	sessionHandler := handlers.NewSessionHandler(analyticsService, adminService)
+	goalHandler := handlers.NewGoalHandler(goalService, adminService)
+	revenueHandler := handlers.NewRevenueHandler(analyticsService, revenueService, adminService)
+	attributionHandler := handlers.NewAttributionHandler(attributionService, adminService)
+	experimentHandler := handlers.NewExperimentHandler(experimentService, adminService)
+	flagHandler := handlers.NewFlagHandler(flagService, adminService)
+	engagementHandler := handlers.NewEngagementHandler(analyticsService, adminService)
+	userHandler := handlers.NewUserHandler(userService, eventService, adminService)
+	groupHandler := handlers.NewGroupHandler(groupService, adminService)
+	segmentHandler := handlers.NewSegmentHandler(segmentService, cohortService, goalService, adminService)
+	cohortHandler := handlers.NewCohortHandler(cohortService, adminService)
+	recordingHandler := handlers.NewRecordingHandler(recordingService, adminService)
+	heatmapHandler := handlers.NewHeatmapHandler(analyticsService, adminService)
 
 	// Setup router
-	router := setupRouter(eventHandler, analyticsHandler, adminHandler, realTimeHandler, websocketHandler)
+	router := setupRouter(eventHandler, analyticsHandler, adminHandler, realTimeHandler, websocketHandler, ruleHandler, internalTrafficHandler, funnelHandler, retentionHandler, pathHandler, sessionHandler, goalHandler, revenueHandler, attributionHandler, experimentHandler, flagHandler, engagementHandler, userHandler, groupHandler, segmentHandler, cohortHandler, recordingHandler, heatmapHandler, internalTrafficService)
 
 	// Start server
 	log.Printf("Server starting on port %s", cfg.Port)
@@ -44,11 +81,11 @@ func main() {
 	}
 }
 
-func setupRouter(eventHandler *handlers.EventHandler, analyticsHandler *handlers.AnalyticsHandler, adminHandler *handlers.AdminHandler, realTimeHandler *handlers.RealTimeHandler, websocketHandler *handlers.WebSocketHandler) *gin.Engine {
+func setupRouter(eventHandler *handlers.EventHandler, analyticsHandler *handlers.AnalyticsHandler, adminHandler *handlers.AdminHandler, realTimeHandler *handlers.RealTimeHandler, websocketHandler *handlers.WebSocketHandler, ruleHandler *handlers.RuleHandler, internalTrafficHandler *handlers.InternalTrafficHandler, funnelHandler *handlers.FunnelHandler, retentionHandler *handlers.RetentionHandler, pathHandler *handlers.PathHandler, sessionHandler *handlers.SessionHandler, goalHandler *handlers.GoalHandler, revenueHandler *handlers.RevenueHandler, attributionHandler *handlers.AttributionHandler, experimentHandler *handlers.ExperimentHandler, flagHandler *handlers.FlagHandler, engagementHandler *handlers.EngagementHandler, userHandler *handlers.UserHandler, groupHandler *handlers.GroupHandler, segmentHandler *handlers.SegmentHandler, cohortHandler *handlers.CohortHandler, recordingHandler *handlers.RecordingHandler, heatmapHandler *handlers.HeatmapHandler, internalTrafficService *services.InternalTrafficService) *gin.Engine {
 	router := gin.Default()
 
 	// Add comprehensive middleware
-	router.Use(handlers.CORSMiddleware())
+	router.Use(handlers.CORSMiddleware(internalTrafficService))
 	router.Use(handlers.LoggingMiddleware())
 	router.Use(handlers.ErrorHandlingMiddleware())
 
@@ -57,6 +94,11 @@ func setupRouter(eventHandler *handlers.EventHandler, analyticsHandler *handlers
 		c.JSON(200, gin.H{"status": "ok"})
 	})
 
+	// Reports accept saved segments, cohorts and a goal: ?segment_id=...&cohort_id=...&goal_id=...
+	report := segmentHandler.SegmentMiddleware()
+	// Reports without conversion metrics reject a goal_id
+	noGoal := handlers.RejectGoal()
+
 	// Event tracking API
 	api := router.Group("/api/v1")
 	{
@@ -64,12 +106,25 @@ func setupRouter(eventHandler *handlers.EventHandler, analyticsHandler *handlers
 		api.POST("/track", eventHandler.APIKeyValidationMiddleware(), eventHandler.TrackEvent)
 		api.GET("/events", eventHandler.GetEvents)
 
+		// Feature flag evaluation with API key validation
+		api.POST("/flags/evaluate", eventHandler.APIKeyValidationMiddleware(), flagHandler.Evaluate)
+
+		// User identification with API key validation
+		api.POST("/identify", eventHandler.APIKeyValidationMiddleware(), userHandler.Identify)
+		api.POST("/groups/identify", eventHandler.APIKeyValidationMiddleware(), groupHandler.Identify)
+
+		// Session recording ingestion with API key validation
+		api.POST("/recordings/start", eventHandler.APIKeyValidationMiddleware(), recordingHandler.StartRecording)
+		api.POST("/recordings/:session_id/chunks", eventHandler.APIKeyValidationMiddleware(), recordingHandler.AddRecordingChunk)
+
 		// Analytics endpoints
-		api.GET("/dashboard", analyticsHandler.GetDashboard)
-		api.GET("/analytics/events-by-day", analyticsHandler.GetEventsByDay)
-		api.GET("/analytics/top-pages", analyticsHandler.GetTopPages)
-		api.GET("/analytics/top-countries", analyticsHandler.GetTopCountries)
-		api.GET("/analytics/top-event-types", analyticsHandler.GetTopEventTypes)
+		api.GET("/dashboard", report, analyticsHandler.GetDashboard)
+		api.GET("/analytics/events-by-day", report, analyticsHandler.GetEventsByDay)
+		api.GET("/analytics/timeseries", report, analyticsHandler.GetTimeSeries)
+		api.POST("/analytics/insights", report, analyticsHandler.GetInsight)
+		api.GET("/analytics/top-pages", report, analyticsHandler.GetTopPages)
+		api.GET("/analytics/top-countries", report, analyticsHandler.GetTopCountries)
+		api.GET("/analytics/top-event-types", report, analyticsHandler.GetTopEventTypes)
 	}
 
 	// Admin API
@@ -88,11 +143,125 @@ func setupRouter(eventHandler *handlers.EventHandler, analyticsHandler *handlers
 		admin.GET("/projects/:id/script/download", adminHandler.DownloadTrackingScript)
 
 		// Real-time analytics endpoints
-		admin.GET("/projects/:id/realtime/stats", realTimeHandler.GetProjectStats)
-		admin.GET("/projects/:id/realtime/events", realTimeHandler.GetRecentEvents)
-		admin.GET("/projects/:id/realtime/event-types", realTimeHandler.GetEventTypeStats)
-		admin.GET("/projects/:id/realtime/countries", realTimeHandler.GetCountryStats)
-		admin.GET("/projects/:id/realtime/pages", realTimeHandler.GetPageStats)
+		admin.GET("/projects/:id/realtime/stats", report, realTimeHandler.GetProjectStats)
+		admin.GET("/projects/:id/realtime/events", report, realTimeHandler.GetRecentEvents)
+		admin.GET("/projects/:id/realtime/event-types", report, realTimeHandler.GetEventTypeStats)
+		admin.GET("/projects/:id/realtime/countries", report, realTimeHandler.GetCountryStats)
+		admin.GET("/projects/:id/realtime/pages", report, realTimeHandler.GetPageStats)
+
+		// Ingestion transformation rules
+		admin.GET("/projects/:id/rules", ruleHandler.GetRules)
+		admin.POST("/projects/:id/rules", ruleHandler.CreateRule)
+		admin.POST("/projects/:id/rules/dry-run", ruleHandler.DryRun)
+		admin.GET("/projects/:id/rules/:rule_id", ruleHandler.GetRule)
+		admin.PUT("/projects/:id/rules/:rule_id", ruleHandler.UpdateRule)
+		admin.DELETE("/projects/:id/rules/:rule_id", ruleHandler.DeleteRule)
+
+		// Internal traffic exclusion
+		admin.GET("/projects/:id/internal-traffic", internalTrafficHandler.GetFilter)
+		admin.PUT("/projects/:id/internal-traffic", internalTrafficHandler.SaveFilter)
+		admin.DELETE("/projects/:id/internal-traffic", internalTrafficHandler.DeleteFilter)
+
+		// Funnel analysis
+		admin.POST("/projects/:id/funnels", noGoal, report, funnelHandler.RunFunnel)
+
+		// Retention analysis
+		admin.POST("/projects/:id/retention", noGoal, report, retentionHandler.RunRetention)
+
+		// Engagement
+		admin.POST("/projects/:id/engagement/active-users", noGoal, report, engagementHandler.GetActiveUsers)
+		admin.POST("/projects/:id/engagement/stickiness", noGoal, report, engagementHandler.GetStickiness)
+		admin.GET("/projects/:id/engagement/pages", noGoal, report, engagementHandler.GetPageEngagement)
+		admin.POST("/projects/:id/lifecycle", noGoal, report, engagementHandler.GetLifecycle)
+
+		// Users
+		admin.GET("/projects/:id/users", noGoal, report, userHandler.GetUsers)
+		admin.GET("/projects/:id/users/:user_id", noGoal, report, userHandler.GetUser)
+		admin.GET("/projects/:id/users/:user_id/events", noGoal, report, userHandler.GetUserEvents)
+		admin.GET("/projects/:id/users/:user_id/traits", userHandler.GetUserTraits)
+
+		// Groups
+		admin.GET("/projects/:id/group-types", groupHandler.GetGroupTypes)
+		admin.POST("/projects/:id/group-types", groupHandler.CreateGroupType)
+		admin.DELETE("/projects/:id/group-types/:group_type_id", groupHandler.DeleteGroupType)
+		admin.GET("/projects/:id/groups/:group_type", report, groupHandler.GetGroups)
+		admin.GET("/projects/:id/groups/:group_type/:group_key", report, groupHandler.GetGroup)
+
+		// Segments
+		admin.GET("/projects/:id/segments", segmentHandler.GetSegments)
+		admin.POST("/projects/:id/segments", segmentHandler.CreateSegment)
+		admin.GET("/projects/:id/segments/:segment_id", segmentHandler.GetSegment)
+		admin.PUT("/projects/:id/segments/:segment_id", segmentHandler.UpdateSegment)
+		admin.DELETE("/projects/:id/segments/:segment_id", segmentHandler.DeleteSegment)
+
+		// Cohorts
+		admin.GET("/projects/:id/cohorts", cohortHandler.GetCohorts)
+		admin.POST("/projects/:id/cohorts", cohortHandler.CreateCohort)
+		admin.GET("/projects/:id/cohorts/:cohort_id", cohortHandler.GetCohort)
+		admin.PUT("/projects/:id/cohorts/:cohort_id", cohortHandler.UpdateCohort)
+		admin.DELETE("/projects/:id/cohorts/:cohort_id", cohortHandler.DeleteCohort)
+		admin.POST("/projects/:id/cohorts/:cohort_id/members", cohortHandler.AddCohortMembers)
+		admin.GET("/projects/:id/cohorts/:cohort_id/size", cohortHandler.GetCohortSize)
+		admin.GET("/projects/:id/cohorts/:cohort_id/export", cohortHandler.ExportCohort)
+
+		// Path analysis
+		admin.GET("/projects/:id/paths", noGoal, report, pathHandler.GetPaths)
+
+		// Session analytics
+		admin.GET("/projects/:id/sessions", report, sessionHandler.GetSessions)
+		admin.GET("/projects/:id/sessions/stats", report, sessionHandler.GetSessionStats)
+		admin.GET("/projects/:id/sessions/entry-pages", report, sessionHandler.GetEntryPages)
+		admin.GET("/projects/:id/sessions/exit-pages", report, sessionHandler.GetExitPages)
+		admin.GET("/projects/:id/sessions/:session_id", sessionHandler.GetSession)
+
+		// Session recordings
+		admin.GET("/projects/:id/recording-settings", recordingHandler.GetSettings)
+		admin.PUT("/projects/:id/recording-settings", recordingHandler.SaveSettings)
+		admin.GET("/projects/:id/recordings", recordingHandler.GetRecordings)
+		admin.GET("/projects/:id/recordings/:session_id", recordingHandler.GetRecording)
+		admin.GET("/projects/:id/recordings/:session_id/events", recordingHandler.GetRecordingEvents)
+		admin.DELETE("/projects/:id/recordings/:session_id", recordingHandler.DeleteRecording)
+
+		// Click heatmaps
+		admin.GET("/projects/:id/heatmaps", report, heatmapHandler.GetHeatmap)
+
+		// Goals and conversions
+		admin.GET("/projects/:id/goals", goalHandler.GetGoals)
+		admin.POST("/projects/:id/goals", goalHandler.CreateGoal)
+		admin.GET("/projects/:id/goals/report", report, goalHandler.GetGoalsReport)
+		admin.GET("/projects/:id/goals/:goal_id", goalHandler.GetGoal)
+		admin.PUT("/projects/:id/goals/:goal_id", goalHandler.UpdateGoal)
+		admin.DELETE("/projects/:id/goals/:goal_id", goalHandler.DeleteGoal)
+		admin.GET("/projects/:id/goals/:goal_id/report", report, goalHandler.GetGoalReport)
+
+		// Revenue reports
+		admin.GET("/projects/:id/revenue/summary", report, revenueHandler.GetSummary)
+		admin.GET("/projects/:id/revenue/timeseries", report, revenueHandler.GetTimeSeries)
+		admin.GET("/projects/:id/revenue/products", report, revenueHandler.GetTopProducts)
+		admin.GET("/projects/:id/revenue/attribution", report, revenueHandler.GetAttribution)
+
+		// Marketing attribution
+		admin.POST("/projects/:id/attribution", report, attributionHandler.RunAttribution)
+
+		// Experiments
+		admin.GET("/projects/:id/experiments", experimentHandler.GetExperiments)
+		admin.POST("/projects/:id/experiments", experimentHandler.CreateExperiment)
+		admin.GET("/projects/:id/experiments/:experiment_id", experimentHandler.GetExperiment)
+		admin.PUT("/projects/:id/experiments/:experiment_id", experimentHandler.UpdateExperiment)
+		admin.DELETE("/projects/:id/experiments/:experiment_id", experimentHandler.DeleteExperiment)
+		admin.GET("/projects/:id/experiments/:experiment_id/results", report, experimentHandler.GetResults)
+
+		// Feature flags
+		admin.GET("/projects/:id/flags", flagHandler.GetFlags)
+		admin.POST("/projects/:id/flags", flagHandler.CreateFlag)
+		admin.GET("/projects/:id/flags/:flag_id", flagHandler.GetFlag)
+		admin.PUT("/projects/:id/flags/:flag_id", flagHandler.UpdateFlag)
+		admin.DELETE("/projects/:id/flags/:flag_id", flagHandler.DeleteFlag)
+
+		// Currency exchange rates
+		admin.GET("/currency-rates", revenueHandler.GetCurrencyRates)
+		admin.PUT("/currency-rates/:currency", revenueHandler.SetCurrencyRate)
+		admin.DELETE("/currency-rates/:currency", revenueHandler.DeleteCurrencyRate)
 
 		// WebSocket endpoint for real-time events
 		admin.GET("/projects/:id/ws", websocketHandler.HandleWebSocket)
@@ -24,15 +24,59 @@ func NewConnection(databaseURL string) (*DB, error) {
 		&models.Session{},
 		&models.User{},
 		&models.Project{},
+		&models.TransformRule{},
+		&models.InternalTrafficFilter{},
+		&models.Goal{},
+		&models.OrderItem{},
+		&models.CurrencyRate{},
+		&models.Experiment{},
+		&models.FeatureFlag{},
+		&models.UserTraits{},
+		&models.UserTraitChange{},
+		&models.GroupType{},
+		&models.Group{},
+		&models.Segment{},
+		&models.Cohort{},
+		&models.CohortMember{},
+		&models.RecordingSettings{},
+		&models.Recording{},
+		&models.RecordingChunk{},
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := backfillSessionProjects(db); err != nil {
+		return nil, err
+	}
+
 	log.Println("Database connected and migrated successfully")
 	return &DB{db}, nil
 }
 
+// backfillSessionProjects sets the project of the sessions stored before sessions recorded
+// one, from the first of their events with a project, so per-project session reports see them
+func backfillSessionProjects(db *gorm.DB) error {
+	result := db.Exec(`
+		UPDATE sessions
+		SET project_id = first_events.project_id
+		FROM (
+			SELECT DISTINCT ON (session_id) session_id, project_id
+			FROM events
+			WHERE project_id IS NOT NULL AND session_id IN (SELECT id FROM sessions WHERE project_id IS NULL)
+			ORDER BY session_id, created_at
+		) AS first_events
+		WHERE sessions.id = first_events.session_id AND sessions.project_id IS NULL
+	`)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Backfilled the project of %d sessions", result.RowsAffected)
+	}
+	return nil
+}
+
 func (db *DB) Close() error {
 	sqlDB, err := db.DB.DB()
 	if err != nil {
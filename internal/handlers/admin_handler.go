@@ -4,6 +4,7 @@ import (
 	"analytic-app/internal/services"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -27,6 +28,10 @@ func (h *AdminHandler) CreateProject(c *gin.Context) {
 
 	project, err := h.adminService.CreateProject(&req)
 	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid timezone") || strings.HasPrefix(err.Error(), "invalid currency") {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+			return
+		}
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to create project", err.Error())
 		return
 	}
@@ -110,6 +115,10 @@ func (h *AdminHandler) UpdateProject(c *gin.Context) {
 			JSONErrorResponse(c, http.StatusNotFound, "Project not found")
 			return
 		}
+		if strings.HasPrefix(err.Error(), "invalid timezone") || strings.HasPrefix(err.Error(), "invalid currency") {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+			return
+		}
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to update project", err.Error())
 		return
 	}
@@ -184,7 +193,7 @@ func (h *AdminHandler) GetTrackingScript(c *gin.Context) {
 		return
 	}
 
-	script, err := h.adminService.GenerateTrackingScript(project.APIKey)
+	script, err := h.adminService.GenerateTrackingScript(project.APIKey, trackingScriptOptions(c))
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to generate tracking script", err.Error())
 		return
@@ -203,7 +212,7 @@ func (h *AdminHandler) GetTrackingScriptByAPIKey(c *gin.Context) {
 		return
 	}
 
-	script, err := h.adminService.GenerateTrackingScript(apiKey)
+	script, err := h.adminService.GenerateTrackingScript(apiKey, trackingScriptOptions(c))
 	if err != nil {
 		if err.Error() == "invalid API key" {
 			JSONErrorResponse(c, http.StatusNotFound, "Invalid API key")
@@ -238,7 +247,7 @@ func (h *AdminHandler) DownloadTrackingScript(c *gin.Context) {
 		return
 	}
 
-	script, err := h.adminService.GenerateTrackingScript(project.APIKey)
+	script, err := h.adminService.GenerateTrackingScript(project.APIKey, trackingScriptOptions(c))
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to generate tracking script", err.Error())
 		return
@@ -249,3 +258,8 @@ func (h *AdminHandler) DownloadTrackingScript(c *gin.Context) {
 	c.Header("Content-Disposition", "attachment; filename=\"analytics-tracking.js\"")
 	c.String(http.StatusOK, script)
 }
+
+// trackingScriptOptions reads the opt-in features of a tracking script: ?recording=true
+func trackingScriptOptions(c *gin.Context) services.TrackingScriptOptions {
+	return services.TrackingScriptOptions{Recording: queryBool(c, "recording")}
+}
@@ -2,57 +2,138 @@ package handlers
 
 import (
 	"analytic-app/internal/services"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AnalyticsHandler struct {
 	analyticsService *services.AnalyticsService
+	adminService     *services.AdminService
 }
 
-func NewAnalyticsHandler(analyticsService *services.AnalyticsService) *AnalyticsHandler {
-	return &AnalyticsHandler{analyticsService: analyticsService}
+func NewAnalyticsHandler(analyticsService *services.AnalyticsService, adminService *services.AdminService) *AnalyticsHandler {
+	return &AnalyticsHandler{
+		analyticsService: analyticsService,
+		adminService:     adminService,
+	}
+}
+
+// analyticsQuery resolves the optional project_id and the shared date range/timezone parameters
+func (h *AnalyticsHandler) analyticsQuery(c *gin.Context) (services.AnalyticsQuery, bool) {
+	project, ok := optionalProject(c, h.adminService)
+	if !ok {
+		return services.AnalyticsQuery{}, false
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return q, false
+	}
+
+	return q, true
 }
 
 // GetDashboard handles GET /dashboard
 func (h *AnalyticsHandler) GetDashboard(c *gin.Context) {
-	stats, err := h.analyticsService.GetDashboardStats()
+	q, ok := h.analyticsQuery(c)
+	if !ok {
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	stats, err := h.analyticsService.GetDashboardStats(q)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch dashboard stats", err.Error())
 		return
 	}
 
-	JSONSuccessResponse(c, stats)
+	meta := queryMeta(q)
+	if cmp != nil {
+		if meta["comparison"], err = totalsComparison(cmp, stats, h.analyticsService.GetDashboardStats); err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch dashboard stats", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, stats, meta)
 }
 
 // GetEventsByDay handles GET /analytics/events-by-day
 func (h *AnalyticsHandler) GetEventsByDay(c *gin.Context) {
+	q, ok := h.analyticsQuery(c)
+	if !ok {
+		return
+	}
+
 	daysStr := c.DefaultQuery("days", "7")
 	days, err := strconv.Atoi(daysStr)
-	if err != nil || days > 365 {
+	if err != nil || days < 1 || days > 365 {
 		days = 7
 	}
 
-	data, err := h.analyticsService.GetEventCountByDay(days)
+	// Without an explicit range, cover the last N calendar days including today
+	if q.From.IsZero() {
+		todayStart, _ := q.Today()
+		q.From = todayStart.AddDate(0, 0, -(days - 1))
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	data, err := h.analyticsService.GetEventCountByDay(q)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch events by day", err.Error())
 		return
 	}
+	if data == nil {
+		data = []services.EventCountByDay{}
+	}
 
-	JSONSuccessResponse(c, data, gin.H{"days": days})
+	meta := queryMeta(q)
+	meta["days"] = days
+	if cmp != nil {
+		previous, err := h.analyticsService.GetEventCountByDay(cmp.query)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch events by day", err.Error())
+			return
+		}
+		if previous == nil {
+			previous = []services.EventCountByDay{}
+		}
+		meta["comparison"] = cmp.meta(previous, gin.H{"days": services.AlignDays(data, previous, q, cmp.query)})
+	}
+	JSONSuccessResponse(c, data, meta)
 }
 
 // GetTopPages handles GET /analytics/top-pages
 func (h *AnalyticsHandler) GetTopPages(c *gin.Context) {
+	q, ok := h.analyticsQuery(c)
+	if !ok {
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
 	limitStr := c.DefaultQuery("limit", "10")
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil || limit > 100 {
 		limit = 10
 	}
 
-	data, err := h.analyticsService.GetTopPages(limit)
+	data, err := h.analyticsService.GetTopPages(q, limit)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch top pages", err.Error())
 		return
@@ -63,39 +144,197 @@ func (h *AnalyticsHandler) GetTopPages(c *gin.Context) {
 		data = []services.TopPage{}
 	}
 
-	JSONSuccessResponse(c, data, gin.H{"limit": limit})
+	meta := queryMeta(q)
+	meta["limit"] = limit
+	if cmp != nil {
+		meta["comparison"], err = rowsComparison(cmp, "page_url", data,
+			func(row services.TopPage) string { return row.PageURL },
+			func(row services.TopPage) float64 { return float64(row.Count) },
+			h.analyticsService.GetTopPages)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch top pages", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, data, meta)
 }
 
 // GetTopCountries handles GET /analytics/top-countries
 func (h *AnalyticsHandler) GetTopCountries(c *gin.Context) {
+	q, ok := h.analyticsQuery(c)
+	if !ok {
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
 	limitStr := c.DefaultQuery("limit", "10")
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil || limit > 100 {
 		limit = 10
 	}
 
-	data, err := h.analyticsService.GetTopCountries(limit)
+	data, err := h.analyticsService.GetTopCountries(q, limit)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch top countries", err.Error())
 		return
 	}
+	if data == nil {
+		data = []services.CountryStats{}
+	}
 
-	JSONSuccessResponse(c, data, gin.H{"limit": limit})
+	meta := queryMeta(q)
+	meta["limit"] = limit
+	if cmp != nil {
+		meta["comparison"], err = rowsComparison(cmp, "country", data,
+			func(row services.CountryStats) string { return row.Country },
+			func(row services.CountryStats) float64 { return float64(row.Count) },
+			h.analyticsService.GetTopCountries)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch top countries", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, data, meta)
 }
 
 // GetTopEventTypes handles GET /analytics/top-event-types
 func (h *AnalyticsHandler) GetTopEventTypes(c *gin.Context) {
+	q, ok := h.analyticsQuery(c)
+	if !ok {
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
 	limitStr := c.DefaultQuery("limit", "10")
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil || limit > 100 {
 		limit = 10
 	}
 
-	data, err := h.analyticsService.GetTopEventTypes(limit)
+	data, err := h.analyticsService.GetTopEventTypes(q, limit)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch top event types", err.Error())
 		return
 	}
+	if data == nil {
+		data = []services.EventTypeStats{}
+	}
+
+	meta := queryMeta(q)
+	meta["limit"] = limit
+	if cmp != nil {
+		meta["comparison"], err = rowsComparison(cmp, "event_type", data,
+			func(row services.EventTypeStats) string { return row.EventType },
+			func(row services.EventTypeStats) float64 { return float64(row.Count) },
+			h.analyticsService.GetTopEventTypes)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch top event types", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, data, meta)
+}
+
+// GetTimeSeries handles GET /analytics/timeseries
+func (h *AnalyticsHandler) GetTimeSeries(c *gin.Context) {
+	q, ok := h.analyticsQuery(c)
+	if !ok {
+		return
+	}
+
+	req := services.TimeSeriesRequest{
+		Granularity: c.DefaultQuery("granularity", services.GranularityDay),
+	}
+	for _, metric := range strings.Split(c.DefaultQuery("metrics", "events"), ",") {
+		if metric = strings.TrimSpace(metric); metric != "" {
+			req.Metrics = append(req.Metrics, metric)
+		}
+	}
+
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	// Resolve the default window first so the comparison period is derived from it
+	q = services.WithDefaultWindow(q, req.Granularity)
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	series, err := h.analyticsService.GetTimeSeries(q, req)
+	if err != nil {
+		if errors.Is(err, services.ErrGoalRequired) || errors.Is(err, services.ErrTooManyBuckets) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch time series", err.Error())
+		return
+	}
+
+	meta := queryMeta(q)
+	if cmp != nil {
+		previous, err := h.analyticsService.GetTimeSeries(cmp.query, req)
+		if err != nil {
+			if errors.Is(err, services.ErrGoalRequired) || errors.Is(err, services.ErrTooManyBuckets) {
+				JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+				return
+			}
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch time series", err.Error())
+			return
+		}
+		totals := make(map[string]services.Delta, len(series.Totals))
+		for metric, value := range series.Totals {
+			totals[metric] = services.NewDelta(value, previous.Totals[metric])
+		}
+		meta["comparison"] = cmp.meta(previous, gin.H{
+			"points": services.AlignSeries(series, previous),
+			"totals": totals,
+		})
+	}
+	JSONSuccessResponse(c, series, meta)
+}
+
+// GetInsight handles POST /analytics/insights
+func (h *AnalyticsHandler) GetInsight(c *gin.Context) {
+	q, ok := h.analyticsQuery(c)
+	if !ok {
+		return
+	}
+
+	var req services.InsightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid insight query", err.Error())
+		return
+	}
+
+	interval := req.Interval
+	if interval == "" {
+		interval = services.GranularityDay
+	}
+	q = services.WithDefaultWindow(q, interval)
+	insight, err := h.analyticsService.GetInsight(q, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrGoalRequired) || errors.Is(err, services.ErrTooManyBuckets) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid insight query", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to run insight query", err.Error())
+		return
+	}
 
-	JSONSuccessResponse(c, data, gin.H{"limit": limit})
+	JSONSuccessResponse(c, insight, queryMeta(q))
 }
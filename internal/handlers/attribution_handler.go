@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AttributionHandler struct {
+	attributionService *services.AttributionService
+	adminService       *services.AdminService
+}
+
+func NewAttributionHandler(attributionService *services.AttributionService, adminService *services.AdminService) *AttributionHandler {
+	return &AttributionHandler{
+		attributionService: attributionService,
+		adminService:       adminService,
+	}
+}
+
+// RunAttribution handles POST /admin/projects/:id/attribution
+func (h *AttributionHandler) RunAttribution(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+	// Touchpoints are the sessions of a person, which groups do not have
+	if q.GroupType != "" {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", "aggregate_by is not supported by attribution")
+		return
+	}
+
+	var req services.AttributionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid attribution", err.Error())
+		return
+	}
+
+	q = services.WithDefaultWindow(q, services.GranularityDay)
+	result, err := h.attributionService.Attribute(q, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrGoalNotFound) {
+			JSONErrorResponse(c, http.StatusNotFound, "Goal not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to compute attribution", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, result, queryMeta(q))
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type CohortHandler struct {
+	cohortService *services.CohortService
+	adminService  *services.AdminService
+}
+
+func NewCohortHandler(cohortService *services.CohortService, adminService *services.AdminService) *CohortHandler {
+	return &CohortHandler{
+		cohortService: cohortService,
+		adminService:  adminService,
+	}
+}
+
+// cohortID parses the cohort_id path parameter, writing the error response when it is invalid
+func cohortID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("cohort_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid cohort ID")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// CreateCohort handles POST /admin/projects/:id/cohorts
+func (h *CohortHandler) CreateCohort(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.CohortRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	cohort, err := h.cohortService.CreateCohort(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to create cohort", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"cohort": cohort})
+}
+
+// GetCohorts handles GET /admin/projects/:id/cohorts
+func (h *CohortHandler) GetCohorts(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	cohorts, err := h.cohortService.GetCohorts(project.ID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch cohorts", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, cohorts)
+}
+
+// GetCohort handles GET /admin/projects/:id/cohorts/:cohort_id
+func (h *CohortHandler) GetCohort(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+	id, ok := cohortID(c)
+	if !ok {
+		return
+	}
+
+	cohort, err := h.cohortService.GetCohort(project.ID, id)
+	if err != nil {
+		if err.Error() == "cohort not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Cohort not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch cohort", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"cohort": cohort})
+}
+
+// UpdateCohort handles PUT /admin/projects/:id/cohorts/:cohort_id
+func (h *CohortHandler) UpdateCohort(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+	id, ok := cohortID(c)
+	if !ok {
+		return
+	}
+
+	var req services.UpdateCohortRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	cohort, err := h.cohortService.UpdateCohort(project.ID, id, &req)
+	if err != nil {
+		if err.Error() == "cohort not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Cohort not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to update cohort", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"cohort": cohort})
+}
+
+// DeleteCohort handles DELETE /admin/projects/:id/cohorts/:cohort_id
+func (h *CohortHandler) DeleteCohort(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+	id, ok := cohortID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.cohortService.DeleteCohort(project.ID, id); err != nil {
+		if err.Error() == "cohort not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Cohort not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to delete cohort", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Cohort deleted successfully"})
+}
+
+// AddCohortMembers handles POST /admin/projects/:id/cohorts/:cohort_id/members. The user IDs
+// come as JSON or as a CSV upload (Content-Type: text/csv) of user IDs in the first column.
+func (h *CohortHandler) AddCohortMembers(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+	id, ok := cohortID(c)
+	if !ok {
+		return
+	}
+
+	var req services.CohortMembersRequest
+	if c.ContentType() == "text/csv" {
+		userIDs, err := readUserIDsCSV(c.Request.Body)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid CSV", err.Error())
+			return
+		}
+		req.UserIDs = userIDs
+		req.Replace = queryBool(c, "replace")
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	cohort, err := h.cohortService.AddMembers(project.ID, id, &req)
+	if err != nil {
+		if err.Error() == "cohort not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Cohort not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to add cohort members", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"cohort": cohort})
+}
+
+// GetCohortSize handles GET /admin/projects/:id/cohorts/:cohort_id/size
+func (h *CohortHandler) GetCohortSize(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+	id, ok := cohortID(c)
+	if !ok {
+		return
+	}
+
+	cohort, err := h.cohortService.CalculateSize(project.ID, id)
+	if err != nil {
+		if err.Error() == "cohort not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Cohort not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to calculate cohort size", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"size": cohort.Size, "calculated_at": cohort.CalculatedAt})
+}
+
+// ExportCohort handles GET /admin/projects/:id/cohorts/:cohort_id/export, streaming the
+// members as CSV
+func (h *CohortHandler) ExportCohort(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+	id, ok := cohortID(c)
+	if !ok {
+		return
+	}
+
+	cohort, err := h.cohortService.GetCohort(project.ID, id)
+	if err != nil {
+		if err.Error() == "cohort not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Cohort not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch cohort", err.Error())
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="cohort-%s.csv"`, cohort.ID))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"user_id", "first_seen", "last_seen"})
+	err = h.cohortService.EachMember(cohort, func(member services.CohortMemberRow) error {
+		return writer.Write([]string{csvCell(member.UserID), formatOptionalTime(member.FirstSeen), formatOptionalTime(member.LastSeen)})
+	})
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		// The status is already sent; a truncated file is all that can be signalled
+		log.Printf("Failed to export cohort %s: %v", cohort.ID, err)
+	}
+}
+
+// readUserIDsCSV reads user IDs from the first column of a CSV, skipping a user_id header
+func readUserIDsCSV(body io.Reader) ([]string, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	var userIDs []string
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) == 0 {
+			continue
+		}
+		value := strings.TrimSpace(record[0])
+		if len(userIDs) == 0 && strings.EqualFold(value, "user_id") {
+			continue
+		}
+		userIDs = append(userIDs, value)
+	}
+	return userIDs, nil
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvCell quotes a value a spreadsheet would otherwise evaluate as a formula, since user IDs
+// come straight from tracked events
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EngagementHandler struct {
+	analyticsService *services.AnalyticsService
+	adminService     *services.AdminService
+}
+
+func NewEngagementHandler(analyticsService *services.AnalyticsService, adminService *services.AdminService) *EngagementHandler {
+	return &EngagementHandler{
+		analyticsService: analyticsService,
+		adminService:     adminService,
+	}
+}
+
+// engagementQuery resolves the analytics query and the request of an engagement report.
+// The body is optional; without it every event but flag exposures makes a person active.
+func (h *EngagementHandler) engagementQuery(c *gin.Context) (services.AnalyticsQuery, *services.EngagementRequest, bool) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return services.AnalyticsQuery{}, nil, false
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return q, nil, false
+	}
+
+	var req services.EngagementRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return q, nil, false
+	}
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid engagement request", err.Error())
+		return q, nil, false
+	}
+
+	return q, &req, true
+}
+
+// GetActiveUsers handles POST /admin/projects/:id/engagement/active-users
+func (h *EngagementHandler) GetActiveUsers(c *gin.Context) {
+	q, req, ok := h.engagementQuery(c)
+	if !ok {
+		return
+	}
+
+	// Resolve the default window first so the comparison period is derived from it
+	q = services.WithDefaultWindow(q, services.GranularityDay)
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	series, err := h.analyticsService.GetActiveUsers(q, req)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyBuckets) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid engagement request", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch active users", err.Error())
+		return
+	}
+
+	meta := queryMeta(q)
+	if cmp != nil {
+		previous, err := h.analyticsService.GetActiveUsers(cmp.query, req)
+		if err != nil {
+			if errors.Is(err, services.ErrTooManyBuckets) {
+				JSONErrorResponse(c, http.StatusBadRequest, "Invalid engagement request", err.Error())
+				return
+			}
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch active users", err.Error())
+			return
+		}
+		totals := make(map[string]services.Delta, len(series.Totals))
+		for metric, value := range series.Totals {
+			totals[metric] = services.NewDelta(value, previous.Totals[metric])
+		}
+		meta["comparison"] = cmp.meta(previous, gin.H{
+			"points": services.AlignSeries(series, previous),
+			"totals": totals,
+		})
+	}
+	JSONSuccessResponse(c, series, meta)
+}
+
+// GetStickiness handles POST /admin/projects/:id/engagement/stickiness
+func (h *EngagementHandler) GetStickiness(c *gin.Context) {
+	q, req, ok := h.engagementQuery(c)
+	if !ok {
+		return
+	}
+
+	period := req.Period
+	if period == "" {
+		period = services.GranularityWeek
+	}
+	q = services.WithDefaultWindow(q, period)
+
+	stickiness, err := h.analyticsService.GetStickiness(q, req)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyBuckets) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid engagement request", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch stickiness", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, stickiness, queryMeta(q))
+}
+
+// GetLifecycle handles POST /admin/projects/:id/lifecycle
+func (h *EngagementHandler) GetLifecycle(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	var req services.LifecycleRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid lifecycle request", err.Error())
+		return
+	}
+
+	interval := req.Interval
+	if interval == "" {
+		interval = services.GranularityDay
+	}
+	// Resolve the default window first so the comparison period is derived from it
+	q = services.WithDefaultWindow(q, interval)
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	lifecycle, err := h.analyticsService.GetLifecycle(q, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyBuckets) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid lifecycle request", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch lifecycle", err.Error())
+		return
+	}
+
+	meta := queryMeta(q)
+	if cmp != nil {
+		previous, err := h.analyticsService.GetLifecycle(cmp.query, &req)
+		if err != nil {
+			if errors.Is(err, services.ErrTooManyBuckets) {
+				JSONErrorResponse(c, http.StatusBadRequest, "Invalid lifecycle request", err.Error())
+				return
+			}
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch lifecycle", err.Error())
+			return
+		}
+		totals := make(map[string]services.Delta, len(lifecycle.Totals))
+		for metric, value := range lifecycle.Totals {
+			totals[metric] = services.NewDelta(value, previous.Totals[metric])
+		}
+		meta["comparison"] = cmp.meta(previous, gin.H{
+			"points": services.AlignSeries(lifecycle, previous),
+			"totals": totals,
+		})
+	}
+	JSONSuccessResponse(c, lifecycle, meta)
+}
+
+// GetPageEngagement handles GET /admin/projects/:id/engagement/pages
+func (h *EngagementHandler) GetPageEngagement(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	pages, err := h.analyticsService.GetPageEngagement(q, &services.PageEngagementRequest{
+		PageURL: c.Query("page_url"),
+		Limit:   limit,
+	})
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch page engagement", err.Error())
+		return
+	}
+
+	meta := queryMeta(q)
+	meta["limit"] = limit
+	JSONSuccessResponse(c, pages, meta)
+}
@@ -3,6 +3,8 @@ package handlers
 import (
 	"analytic-app/internal/models"
 	"analytic-app/internal/services"
+	"analytic-app/pkg/utils"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -76,13 +78,40 @@ func (h *EventHandler) TrackEvent(c *gin.Context) {
 	// Set project ID from validated project
 	req.ProjectID = &project.ID
 
+	// Keep request details for internal traffic detection
+	req.Origin = &services.RequestOrigin{
+		IP:      utils.GetRealIP(c.Request),
+		Header:  c.Request.Header,
+		Cookies: c.Request.Cookies(),
+	}
+
 	// Get IP from request if not provided
 	if req.IPAddress == "" {
-		req.IPAddress = c.ClientIP()
+		req.IPAddress = req.Origin.IP
 	}
 
 	event, err := h.eventService.CreateEvent(&req)
 	if err != nil {
+		if err == services.ErrEventDropped || err == services.ErrInternalTraffic {
+			JSONSuccessResponse(c, gin.H{
+				"dropped": true,
+				"reason":  err.Error(),
+				"project": project.Name,
+			})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidOrder) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid order data", err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrInvalidTraits) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid traits", err.Error())
+			return
+		}
+		if errors.Is(err, services.ErrInvalidGroups) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid groups", err.Error())
+			return
+		}
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to track event", err.Error())
 		return
 	}
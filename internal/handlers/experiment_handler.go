@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"analytic-app/internal/models"
+	"analytic-app/internal/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ExperimentHandler struct {
+	experimentService *services.ExperimentService
+	adminService      *services.AdminService
+}
+
+func NewExperimentHandler(experimentService *services.ExperimentService, adminService *services.AdminService) *ExperimentHandler {
+	return &ExperimentHandler{
+		experimentService: experimentService,
+		adminService:      adminService,
+	}
+}
+
+// CreateExperiment handles POST /admin/projects/:id/experiments
+func (h *ExperimentHandler) CreateExperiment(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.ExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	experiment, err := h.experimentService.CreateExperiment(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to create experiment", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"experiment": experiment})
+}
+
+// GetExperiments handles GET /admin/projects/:id/experiments
+func (h *ExperimentHandler) GetExperiments(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	experiments, err := h.experimentService.GetExperiments(project.ID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch experiments", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, experiments)
+}
+
+// GetExperiment handles GET /admin/projects/:id/experiments/:experiment_id
+func (h *ExperimentHandler) GetExperiment(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	experiment, ok := h.requireExperiment(c, project)
+	if !ok {
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"experiment": experiment})
+}
+
+// UpdateExperiment handles PUT /admin/projects/:id/experiments/:experiment_id
+func (h *ExperimentHandler) UpdateExperiment(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	experimentID, err := uuid.Parse(c.Param("experiment_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid experiment ID")
+		return
+	}
+
+	var req services.UpdateExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	experiment, err := h.experimentService.UpdateExperiment(project.ID, experimentID, &req)
+	if err != nil {
+		if err.Error() == "experiment not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Experiment not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to update experiment", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"experiment": experiment})
+}
+
+// DeleteExperiment handles DELETE /admin/projects/:id/experiments/:experiment_id
+func (h *ExperimentHandler) DeleteExperiment(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	experimentID, err := uuid.Parse(c.Param("experiment_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid experiment ID")
+		return
+	}
+
+	if err := h.experimentService.DeleteExperiment(project.ID, experimentID); err != nil {
+		if err.Error() == "experiment not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Experiment not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to delete experiment", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Experiment deleted successfully"})
+}
+
+// GetResults handles GET /admin/projects/:id/experiments/:experiment_id/results
+func (h *ExperimentHandler) GetResults(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	experiment, ok := h.requireExperiment(c, project)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	// Default to the time the experiment ran
+	if q.From.IsZero() {
+		q.From = experiment.CreatedAt
+		if experiment.StartedAt != nil {
+			q.From = *experiment.StartedAt
+		}
+	}
+	if q.To.IsZero() {
+		q.To = time.Now()
+		if experiment.EndedAt != nil {
+			q.To = *experiment.EndedAt
+		}
+	}
+
+	results, err := h.experimentService.GetResults(q, experiment)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to compute experiment results", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, results, queryMeta(q))
+}
+
+// requireExperiment loads the experiment named in the path, writing the error response itself when it fails
+func (h *ExperimentHandler) requireExperiment(c *gin.Context, project *models.Project) (*models.Experiment, bool) {
+	experimentID, err := uuid.Parse(c.Param("experiment_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid experiment ID")
+		return nil, false
+	}
+
+	experiment, err := h.experimentService.GetExperiment(project.ID, experimentID)
+	if err != nil {
+		if err.Error() == "experiment not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Experiment not found")
+			return nil, false
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch experiment", err.Error())
+		return nil, false
+	}
+	return experiment, true
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"analytic-app/internal/models"
+	"analytic-app/internal/services"
+	"analytic-app/pkg/utils"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type FlagHandler struct {
+	flagService  *services.FlagService
+	adminService *services.AdminService
+}
+
+func NewFlagHandler(flagService *services.FlagService, adminService *services.AdminService) *FlagHandler {
+	return &FlagHandler{
+		flagService:  flagService,
+		adminService: adminService,
+	}
+}
+
+// Evaluate handles POST /flags/evaluate, authenticated by the project API key
+func (h *FlagHandler) Evaluate(c *gin.Context) {
+	// Get project from middleware
+	projectInterface, exists := c.Get("project")
+	if !exists {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Project context not found")
+		return
+	}
+
+	project, ok := projectInterface.(*models.Project)
+	if !ok {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Invalid project context")
+		return
+	}
+
+	var req services.FlagEvaluationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	// Keep request details for the exposure events
+	req.Origin = &services.RequestOrigin{
+		IP:      utils.GetRealIP(c.Request),
+		Header:  c.Request.Header,
+		Cookies: c.Request.Cookies(),
+	}
+	req.IPAddress = req.Origin.IP
+	if userAgent := c.Request.UserAgent(); userAgent != "" {
+		req.UserAgent = &userAgent
+	}
+
+	flags, err := h.flagService.Evaluate(project, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrExposureSessionRequired) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to evaluate flags", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"flags": flags})
+}
+
+// CreateFlag handles POST /admin/projects/:id/flags
+func (h *FlagHandler) CreateFlag(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.FlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	flag, err := h.flagService.CreateFlag(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to create flag", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"flag": flag})
+}
+
+// GetFlags handles GET /admin/projects/:id/flags
+func (h *FlagHandler) GetFlags(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	flags, err := h.flagService.GetFlags(project.ID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch flags", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, flags)
+}
+
+// GetFlag handles GET /admin/projects/:id/flags/:flag_id
+func (h *FlagHandler) GetFlag(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	flagID, err := uuid.Parse(c.Param("flag_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	flag, err := h.flagService.GetFlag(project.ID, flagID)
+	if err != nil {
+		if err.Error() == "flag not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Flag not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch flag", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"flag": flag})
+}
+
+// UpdateFlag handles PUT /admin/projects/:id/flags/:flag_id
+func (h *FlagHandler) UpdateFlag(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	flagID, err := uuid.Parse(c.Param("flag_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	var req services.UpdateFlagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	flag, err := h.flagService.UpdateFlag(project.ID, flagID, &req)
+	if err != nil {
+		if err.Error() == "flag not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Flag not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to update flag", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"flag": flag})
+}
+
+// DeleteFlag handles DELETE /admin/projects/:id/flags/:flag_id
+func (h *FlagHandler) DeleteFlag(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	flagID, err := uuid.Parse(c.Param("flag_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid flag ID")
+		return
+	}
+
+	if err := h.flagService.DeleteFlag(project.ID, flagID); err != nil {
+		if err.Error() == "flag not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Flag not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to delete flag", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Flag deleted successfully"})
+}
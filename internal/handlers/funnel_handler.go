@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FunnelHandler struct {
+	funnelService *services.FunnelService
+	adminService  *services.AdminService
+}
+
+func NewFunnelHandler(funnelService *services.FunnelService, adminService *services.AdminService) *FunnelHandler {
+	return &FunnelHandler{
+		funnelService: funnelService,
+		adminService:  adminService,
+	}
+}
+
+// RunFunnel handles POST /admin/projects/:id/funnels
+func (h *FunnelHandler) RunFunnel(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	var req services.FunnelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid funnel", err.Error())
+		return
+	}
+
+	q = services.WithDefaultWindow(q, services.GranularityDay)
+	funnel, err := h.funnelService.Run(q, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrGoalNotFound) {
+			JSONErrorResponse(c, http.StatusNotFound, "Goal not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to compute funnel", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, funnel, queryMeta(q))
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"analytic-app/internal/models"
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type GoalHandler struct {
+	goalService  *services.GoalService
+	adminService *services.AdminService
+}
+
+func NewGoalHandler(goalService *services.GoalService, adminService *services.AdminService) *GoalHandler {
+	return &GoalHandler{
+		goalService:  goalService,
+		adminService: adminService,
+	}
+}
+
+// CreateGoal handles POST /admin/projects/:id/goals
+func (h *GoalHandler) CreateGoal(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.GoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	goal, err := h.goalService.CreateGoal(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to create goal", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"goal": goal})
+}
+
+// GetGoals handles GET /admin/projects/:id/goals
+func (h *GoalHandler) GetGoals(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	goals, err := h.goalService.GetGoals(project.ID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch goals", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, goals)
+}
+
+// GetGoal handles GET /admin/projects/:id/goals/:goal_id
+func (h *GoalHandler) GetGoal(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	goal, ok := h.requireGoal(c, project)
+	if !ok {
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"goal": goal})
+}
+
+// UpdateGoal handles PUT /admin/projects/:id/goals/:goal_id
+func (h *GoalHandler) UpdateGoal(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	goalID, err := uuid.Parse(c.Param("goal_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid goal ID")
+		return
+	}
+
+	var req services.UpdateGoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	goal, err := h.goalService.UpdateGoal(project.ID, goalID, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrGoalNotFound) {
+			JSONErrorResponse(c, http.StatusNotFound, "Goal not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to update goal", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"goal": goal})
+}
+
+// DeleteGoal handles DELETE /admin/projects/:id/goals/:goal_id
+func (h *GoalHandler) DeleteGoal(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	goalID, err := uuid.Parse(c.Param("goal_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid goal ID")
+		return
+	}
+
+	if err := h.goalService.DeleteGoal(project.ID, goalID); err != nil {
+		if errors.Is(err, services.ErrGoalNotFound) {
+			JSONErrorResponse(c, http.StatusNotFound, "Goal not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to delete goal", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Goal deleted successfully"})
+}
+
+// GetGoalsReport handles GET /admin/projects/:id/goals/report
+func (h *GoalHandler) GetGoalsReport(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	summaries, err := h.goalService.GetGoalSummaries(q)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch goal report", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, summaries, queryMeta(q))
+}
+
+// GetGoalReport handles GET /admin/projects/:id/goals/:goal_id/report
+func (h *GoalHandler) GetGoalReport(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	goal, ok := h.requireGoal(c, project)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	breakdown := c.Query("breakdown")
+	report, err := h.goalService.GetGoalReport(q, goal, breakdown)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to fetch goal report", err.Error())
+		return
+	}
+
+	meta := queryMeta(q)
+	if cmp != nil {
+		fetch := func(q services.AnalyticsQuery) (services.GoalMetrics, error) {
+			previous, err := h.goalService.GetGoalReport(q, goal, "")
+			if err != nil {
+				return services.GoalMetrics{}, err
+			}
+			return previous.GoalMetrics, nil
+		}
+		if meta["comparison"], err = totalsComparison(cmp, report.GoalMetrics, fetch); err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch goal report", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, report, meta)
+}
+
+// requireGoal loads the goal named in the path, writing the error response itself when it fails
+func (h *GoalHandler) requireGoal(c *gin.Context, project *models.Project) (*models.Goal, bool) {
+	goalID, err := uuid.Parse(c.Param("goal_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid goal ID")
+		return nil, false
+	}
+
+	goal, err := h.goalService.GetGoal(project.ID, goalID)
+	if err != nil {
+		if errors.Is(err, services.ErrGoalNotFound) {
+			JSONErrorResponse(c, http.StatusNotFound, "Goal not found")
+			return nil, false
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch goal", err.Error())
+		return nil, false
+	}
+	return goal, true
+}
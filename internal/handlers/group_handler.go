@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"analytic-app/internal/models"
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type GroupHandler struct {
+	groupService *services.GroupService
+	adminService *services.AdminService
+}
+
+func NewGroupHandler(groupService *services.GroupService, adminService *services.AdminService) *GroupHandler {
+	return &GroupHandler{
+		groupService: groupService,
+		adminService: adminService,
+	}
+}
+
+// Identify handles POST /groups/identify, authenticated by the project API key
+func (h *GroupHandler) Identify(c *gin.Context) {
+	// Get project from middleware
+	projectInterface, exists := c.Get("project")
+	if !exists {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Project context not found")
+		return
+	}
+
+	project, ok := projectInterface.(*models.Project)
+	if !ok {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Invalid project context")
+		return
+	}
+
+	var req services.GroupIdentifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	group, err := h.groupService.IdentifyGroup(project.ID, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidGroups) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid group", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to identify group", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"group": group})
+}
+
+// CreateGroupType handles POST /admin/projects/:id/group-types
+func (h *GroupHandler) CreateGroupType(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.GroupTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	groupType, err := h.groupService.CreateGroupType(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to create group type", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"group_type": groupType})
+}
+
+// GetGroupTypes handles GET /admin/projects/:id/group-types
+func (h *GroupHandler) GetGroupTypes(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	groupTypes, err := h.groupService.GetGroupTypes(project.ID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch group types", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, groupTypes)
+}
+
+// DeleteGroupType handles DELETE /admin/projects/:id/group-types/:group_type_id
+func (h *GroupHandler) DeleteGroupType(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	groupTypeID, err := uuid.Parse(c.Param("group_type_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid group type ID")
+		return
+	}
+
+	if err := h.groupService.DeleteGroupType(project.ID, groupTypeID); err != nil {
+		if err.Error() == "group type not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Group type not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to delete group type", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Group type deleted successfully"})
+}
+
+// GetGroups handles GET /admin/projects/:id/groups/:group_type
+func (h *GroupHandler) GetGroups(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	req := services.GroupListRequest{
+		GroupType: c.Param("group_type"),
+		Search:    c.Query("search"),
+		Sort:      c.Query("sort"),
+	}
+	req.Limit, req.Offset = pagination(c, 50, 100)
+
+	groups, total, err := h.groupService.ListGroups(q, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to fetch groups", err.Error())
+		return
+	}
+
+	meta := queryMeta(q)
+	meta["total"] = total
+	meta["limit"] = req.Limit
+	meta["offset"] = req.Offset
+	JSONSuccessResponse(c, groups, meta)
+}
+
+// GetGroup handles GET /admin/projects/:id/groups/:group_type/:group_key
+func (h *GroupHandler) GetGroup(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	group, err := h.groupService.GetGroup(q, c.Param("group_type"), c.Param("group_key"))
+	if err != nil {
+		if err.Error() == "group not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Group not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch group", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, group, queryMeta(q))
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HeatmapHandler struct {
+	analyticsService *services.AnalyticsService
+	adminService     *services.AdminService
+}
+
+func NewHeatmapHandler(analyticsService *services.AnalyticsService, adminService *services.AdminService) *HeatmapHandler {
+	return &HeatmapHandler{
+		analyticsService: analyticsService,
+		adminService:     adminService,
+	}
+}
+
+// GetHeatmap handles GET /admin/projects/:id/heatmaps?page_url=...
+func (h *HeatmapHandler) GetHeatmap(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	req := services.HeatmapRequest{
+		PageURL:  c.Query("page_url"),
+		Viewport: c.Query("viewport"),
+		Position: c.Query("position"),
+	}
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{"columns", &req.Columns},
+		{"row_height", &req.RowHeight},
+		{"limit", &req.Limit},
+	} {
+		if value := c.Query(param.name); value != "" {
+			if *param.value, err = strconv.Atoi(value); err != nil {
+				JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", param.name+" must be a number")
+				return
+			}
+		}
+	}
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid heatmap request", err.Error())
+		return
+	}
+
+	heatmap, err := h.analyticsService.GetHeatmap(q, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch heatmap", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, heatmap, queryMeta(q))
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"analytic-app/internal/models"
+	"analytic-app/internal/services"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requireProject parses the :id path parameter and verifies the project exists.
+// It writes the error response itself and returns false when the request should stop.
+func requireProject(c *gin.Context, adminService *services.AdminService) (*models.Project, bool) {
+	projectID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid project ID", err.Error())
+		return nil, false
+	}
+
+	project, err := adminService.GetProjectByID(projectID)
+	if err != nil {
+		if err.Error() == "project not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Project not found")
+			return nil, false
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project", err.Error())
+		return nil, false
+	}
+
+	return &project.Project, true
+}
+
+// queryBool reads an optional boolean query parameter, defaulting to false
+func queryBool(c *gin.Context, key string) bool {
+	value, err := strconv.ParseBool(c.Query(key))
+	return err == nil && value
+}
+
+// optionalProject resolves the project_id query parameter when present.
+// It writes the error response itself and returns false when the request should stop.
+func optionalProject(c *gin.Context, adminService *services.AdminService) (*models.Project, bool) {
+	idStr := c.Query("project_id")
+	if idStr == "" {
+		return nil, true
+	}
+
+	projectID, err := uuid.Parse(idStr)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid project ID", err.Error())
+		return nil, false
+	}
+
+	project, err := adminService.GetProjectByID(projectID)
+	if err != nil {
+		if err.Error() == "project not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Project not found")
+			return nil, false
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project", err.Error())
+		return nil, false
+	}
+
+	return &project.Project, true
+}
+
+// parseAnalyticsQuery reads the from, to, timezone, include_internal and filter query parameters.
+// Dates may be given as YYYY-MM-DD (a whole day in the reporting timezone, "to" inclusive)
+// or as RFC 3339 timestamps. The project's timezone is used when none is requested.
+func parseAnalyticsQuery(c *gin.Context, project *models.Project) (services.AnalyticsQuery, error) {
+	q := services.AnalyticsQuery{
+		Location:        time.UTC,
+		IncludeInternal: queryBool(c, "include_internal"),
+	}
+
+	timezone := c.Query("timezone")
+	if timezone == "" && project != nil {
+		timezone = project.Timezone
+	}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return q, fmt.Errorf("invalid timezone %q", timezone)
+		}
+		q.Location = loc
+	}
+
+	if project != nil {
+		q.ProjectID = &project.ID
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseQueryTime(from, q.Location, false)
+		if err != nil {
+			return q, err
+		}
+		q.From = t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseQueryTime(to, q.Location, true)
+		if err != nil {
+			return q, err
+		}
+		q.To = t
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	// Dimension filters: ?event_type=click&filter[country]=VN
+	q.Filters = c.QueryMap("filter")
+	if eventType := c.Query("event_type"); eventType != "" {
+		q.Filters["event_type"] = eventType
+	}
+
+	// Count groups of a type instead of people: ?aggregate_by=company
+	q.GroupType = c.Query("aggregate_by")
+
+	// Saved segments and cohorts, resolved by SegmentMiddleware: ?segment_id=...&cohort_id=...
+	if value, exists := c.Get("segments"); exists {
+		for _, segment := range value.([]services.QuerySegment) {
+			if project == nil || segment.ProjectID != project.ID {
+				return q, fmt.Errorf("%s %s does not belong to the project", segment.Type, segment.ID)
+			}
+			q.Segments = append(q.Segments, segment)
+		}
+	}
+	if value, exists := c.Get("goal"); exists {
+		goal := value.(*services.QueryGoal)
+		if project == nil || goal.ProjectID != project.ID {
+			return q, fmt.Errorf("goal %s does not belong to the project", goal.ID)
+		}
+		q.Goal = goal
+	}
+	if err := q.Validate(); err != nil {
+		return q, err
+	}
+
+	return q, nil
+}
+
+func parseQueryTime(value string, loc *time.Location, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD or RFC 3339", value)
+	}
+	if endOfDay {
+		return day.AddDate(0, 0, 1), nil
+	}
+	return day, nil
+}
+
+// queryMeta describes the resolved analytics query in a response
+func queryMeta(q services.AnalyticsQuery) gin.H {
+	meta := gin.H{"timezone": q.Timezone()}
+	if q.ProjectID != nil {
+		meta["project_id"] = q.ProjectID
+	}
+	if !q.From.IsZero() {
+		meta["from"] = q.From.In(q.Location)
+	}
+	if !q.To.IsZero() {
+		meta["to"] = q.To.In(q.Location)
+	}
+	if len(q.Filters) > 0 {
+		meta["filters"] = q.Filters
+	}
+	if q.GroupType != "" {
+		meta["aggregate_by"] = q.GroupType
+	}
+	if len(q.Segments) > 0 {
+		meta["segments"] = q.Segments
+	}
+	if q.Goal != nil {
+		meta["goal"] = q.Goal
+	}
+	return meta
+}
+
+// comparison is a resolved compare=... request
+type comparison struct {
+	mode  string
+	query services.AnalyticsQuery
+}
+
+// comparisonQuery reads compare (previous_period, previous_year or custom) with compare_from
+// and compare_to for custom ranges. It returns nil when no comparison was requested and
+// writes the error response itself, returning false, when the parameters are invalid.
+func comparisonQuery(c *gin.Context, q services.AnalyticsQuery) (*comparison, bool) {
+	mode := c.Query("compare")
+	if mode == "" {
+		return nil, true
+	}
+
+	var from, to time.Time
+	var err error
+	if value := c.Query("compare_from"); value != "" {
+		if from, err = parseQueryTime(value, q.Location, false); err != nil {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return nil, false
+		}
+	}
+	if value := c.Query("compare_to"); value != "" {
+		if to, err = parseQueryTime(value, q.Location, true); err != nil {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return nil, false
+		}
+	}
+
+	prev, err := services.ComparisonQuery(q, mode, from, to)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return nil, false
+	}
+
+	return &comparison{mode: mode, query: prev}, true
+}
+
+// meta describes the comparison period, the comparison data and its deltas in a response
+func (cmp *comparison) meta(previous interface{}, deltas gin.H) gin.H {
+	meta := gin.H{
+		"period":   cmp.query.Period(cmp.mode),
+		"previous": previous,
+	}
+	for key, value := range deltas {
+		meta[key] = value
+	}
+	return meta
+}
+
+// maxComparisonRows bounds the comparison query of a top-N breakdown
+const maxComparisonRows = 1000
+
+// totalsComparison fetches the comparison report and compares every numeric total
+func totalsComparison[T any](cmp *comparison, current T, fetch func(services.AnalyticsQuery) (T, error)) (gin.H, error) {
+	previous, err := fetch(cmp.query)
+	if err != nil {
+		return nil, err
+	}
+
+	totals, err := services.CompareTotals(current, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	return cmp.meta(previous, gin.H{"totals": totals}), nil
+}
+
+// rowsComparison fetches the comparison values of the current top-N rows, matched on a dimension
+func rowsComparison[T any](cmp *comparison, dimension string, current []T, key func(T) string, value func(T) float64, fetch func(services.AnalyticsQuery, int) ([]T, error)) (gin.H, error) {
+	keys := make([]string, 0, len(current))
+	for _, row := range current {
+		keys = append(keys, key(row))
+	}
+
+	q := cmp.query
+	q.In = map[string][]string{dimension: keys}
+	previous, err := fetch(q, maxComparisonRows)
+	if err != nil {
+		return nil, err
+	}
+	if previous == nil {
+		previous = []T{}
+	}
+
+	return cmp.meta(previous, gin.H{"rows": services.CompareRows(current, previous, key, value)}), nil
+}
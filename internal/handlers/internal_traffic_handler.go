@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InternalTrafficHandler struct {
+	internalTrafficService *services.InternalTrafficService
+	adminService           *services.AdminService
+}
+
+func NewInternalTrafficHandler(internalTrafficService *services.InternalTrafficService, adminService *services.AdminService) *InternalTrafficHandler {
+	return &InternalTrafficHandler{
+		internalTrafficService: internalTrafficService,
+		adminService:           adminService,
+	}
+}
+
+// GetFilter handles GET /admin/projects/:id/internal-traffic
+func (h *InternalTrafficHandler) GetFilter(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	filter, err := h.internalTrafficService.GetFilter(project.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrInternalTrafficFilterNotFound) {
+			JSONErrorResponse(c, http.StatusNotFound, "Internal traffic filter not configured")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch internal traffic filter", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"filter": filter})
+}
+
+// SaveFilter handles PUT /admin/projects/:id/internal-traffic
+func (h *InternalTrafficHandler) SaveFilter(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.InternalTrafficRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	filter, err := h.internalTrafficService.SaveFilter(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to save internal traffic filter", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"filter": filter})
+}
+
+// DeleteFilter handles DELETE /admin/projects/:id/internal-traffic
+func (h *InternalTrafficHandler) DeleteFilter(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	if err := h.internalTrafficService.DeleteFilter(project.ID); err != nil {
+		if errors.Is(err, services.ErrInternalTrafficFilterNotFound) {
+			JSONErrorResponse(c, http.StatusNotFound, "Internal traffic filter not configured")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to delete internal traffic filter", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Internal traffic filter deleted successfully"})
+}
@@ -1,16 +1,18 @@
 package handlers
 
 import (
+	"analytic-app/internal/services"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // CORSMiddleware handles Cross-Origin Resource Sharing
-func CORSMiddleware() gin.HandlerFunc {
+func CORSMiddleware(internalTrafficService *services.InternalTrafficService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
 
@@ -22,7 +24,17 @@ func CORSMiddleware() gin.HandlerFunc {
 		}
 
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		// Preflights may also ask for the header a project names as its internal traffic bypass
+		// header, which is configurable
+		allowHeaders := "Content-Type, Content-Encoding, Authorization, X-Requested-With, X-API-Key"
+		if c.Request.Method == "OPTIONS" {
+			bypass := internalTrafficService.AllowedBypassHeaders(c.Request.Header.Get("Access-Control-Request-Headers"))
+			if len(bypass) > 0 {
+				allowHeaders += ", " + strings.Join(bypass, ", ")
+			}
+		}
+		c.Header("Access-Control-Allow-Headers", allowHeaders)
+		c.Header("Vary", "Origin, Access-Control-Request-Headers")
 		c.Header("Access-Control-Expose-Headers", "Content-Length, X-Error-Details")
 		c.Header("Access-Control-Allow-Credentials", "true")
 		c.Header("Access-Control-Max-Age", "86400") // 24 hours
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PathHandler struct {
+	pathService  *services.PathService
+	adminService *services.AdminService
+}
+
+func NewPathHandler(pathService *services.PathService, adminService *services.AdminService) *PathHandler {
+	return &PathHandler{
+		pathService:  pathService,
+		adminService: adminService,
+	}
+}
+
+// GetPaths handles GET /admin/projects/:id/paths
+func (h *PathHandler) GetPaths(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	req := services.PathRequest{
+		PathType:   c.Query("path_type"),
+		StartPoint: c.Query("start"),
+		EndPoint:   c.Query("end"),
+	}
+	if value := c.Query("depth"); value != "" {
+		if req.Depth, err = strconv.Atoi(value); err != nil {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", "depth must be a number")
+			return
+		}
+	}
+	if value := c.Query("nodes_per_step"); value != "" {
+		if req.NodesPerStep, err = strconv.Atoi(value); err != nil {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", "nodes_per_step must be a number")
+			return
+		}
+	}
+	for _, eventType := range strings.Split(c.Query("exclude"), ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			req.ExcludeEventTypes = append(req.ExcludeEventTypes, eventType)
+		}
+	}
+
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	q = services.WithDefaultWindow(q, services.GranularityDay)
+	paths, err := h.pathService.GetPaths(q, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch paths", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, paths, queryMeta(q))
+}
@@ -6,7 +6,6 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
 type RealTimeHandler struct {
@@ -23,39 +22,48 @@ func NewRealTimeHandler(realTimeService *services.RealTimeService, adminService
 
 // GetProjectStats handles GET /admin/projects/:id/realtime/stats
 func (h *RealTimeHandler) GetProjectStats(c *gin.Context) {
-	idStr := c.Param("id")
-	projectID, err := uuid.Parse(idStr)
-	if err != nil {
-		JSONErrorResponse(c, http.StatusBadRequest, "Invalid project ID", err.Error())
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
 		return
 	}
 
-	// Verify project exists
-	_, err = h.adminService.GetProjectByID(projectID)
+	q, err := parseAnalyticsQuery(c, project)
 	if err != nil {
-		if err.Error() == "project not found" {
-			JSONErrorResponse(c, http.StatusNotFound, "Project not found", "")
-			return
-		}
-		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project", err.Error())
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
 		return
 	}
 
-	stats, err := h.realTimeService.GetProjectStats(projectID)
+	stats, err := h.realTimeService.GetProjectStats(q)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project statistics", err.Error())
 		return
 	}
 
-	JSONSuccessResponse(c, stats, nil)
+	meta := queryMeta(q)
+	if cmp != nil {
+		if meta["comparison"], err = totalsComparison(cmp, stats, h.realTimeService.GetProjectStats); err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project statistics", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, stats, meta)
 }
 
 // GetRecentEvents handles GET /admin/projects/:id/realtime/events
 func (h *RealTimeHandler) GetRecentEvents(c *gin.Context) {
-	idStr := c.Param("id")
-	projectID, err := uuid.Parse(idStr)
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
 	if err != nil {
-		JSONErrorResponse(c, http.StatusBadRequest, "Invalid project ID", err.Error())
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
 		return
 	}
 
@@ -65,18 +73,7 @@ func (h *RealTimeHandler) GetRecentEvents(c *gin.Context) {
 		limit = 50
 	}
 
-	// Verify project exists
-	_, err = h.adminService.GetProjectByID(projectID)
-	if err != nil {
-		if err.Error() == "project not found" {
-			JSONErrorResponse(c, http.StatusNotFound, "Project not found", "")
-			return
-		}
-		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project", err.Error())
-		return
-	}
-
-	events, err := h.realTimeService.GetRecentEvents(projectID, limit)
+	events, err := h.realTimeService.GetRecentEvents(q, limit)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch recent events", err.Error())
 		return
@@ -87,15 +84,26 @@ func (h *RealTimeHandler) GetRecentEvents(c *gin.Context) {
 		events = []services.RecentEvent{}
 	}
 
-	JSONSuccessResponse(c, events, gin.H{"limit": limit})
+	meta := queryMeta(q)
+	meta["limit"] = limit
+	JSONSuccessResponse(c, events, meta)
 }
 
 // GetEventTypeStats handles GET /admin/projects/:id/realtime/event-types
 func (h *RealTimeHandler) GetEventTypeStats(c *gin.Context) {
-	idStr := c.Param("id")
-	projectID, err := uuid.Parse(idStr)
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
 	if err != nil {
-		JSONErrorResponse(c, http.StatusBadRequest, "Invalid project ID", err.Error())
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
 		return
 	}
 
@@ -105,18 +113,7 @@ func (h *RealTimeHandler) GetEventTypeStats(c *gin.Context) {
 		limit = 10
 	}
 
-	// Verify project exists
-	_, err = h.adminService.GetProjectByID(projectID)
-	if err != nil {
-		if err.Error() == "project not found" {
-			JSONErrorResponse(c, http.StatusNotFound, "Project not found", "")
-			return
-		}
-		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project", err.Error())
-		return
-	}
-
-	stats, err := h.realTimeService.GetEventTypeStats(projectID, limit)
+	stats, err := h.realTimeService.GetEventTypeStats(q, limit)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch event type statistics", err.Error())
 		return
@@ -127,15 +124,36 @@ func (h *RealTimeHandler) GetEventTypeStats(c *gin.Context) {
 		stats = []services.EventTypeStats{}
 	}
 
-	JSONSuccessResponse(c, stats, gin.H{"limit": limit})
+	meta := queryMeta(q)
+	meta["limit"] = limit
+	if cmp != nil {
+		meta["comparison"], err = rowsComparison(cmp, "event_type", stats,
+			func(row services.EventTypeStats) string { return row.EventType },
+			func(row services.EventTypeStats) float64 { return float64(row.Count) },
+			h.realTimeService.GetEventTypeStats)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch event type statistics", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, stats, meta)
 }
 
 // GetCountryStats handles GET /admin/projects/:id/realtime/countries
 func (h *RealTimeHandler) GetCountryStats(c *gin.Context) {
-	idStr := c.Param("id")
-	projectID, err := uuid.Parse(idStr)
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
 	if err != nil {
-		JSONErrorResponse(c, http.StatusBadRequest, "Invalid project ID", err.Error())
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
 		return
 	}
 
@@ -145,18 +163,7 @@ func (h *RealTimeHandler) GetCountryStats(c *gin.Context) {
 		limit = 10
 	}
 
-	// Verify project exists
-	_, err = h.adminService.GetProjectByID(projectID)
-	if err != nil {
-		if err.Error() == "project not found" {
-			JSONErrorResponse(c, http.StatusNotFound, "Project not found", "")
-			return
-		}
-		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project", err.Error())
-		return
-	}
-
-	stats, err := h.realTimeService.GetCountryStats(projectID, limit)
+	stats, err := h.realTimeService.GetCountryStats(q, limit)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch country statistics", err.Error())
 		return
@@ -167,15 +174,36 @@ func (h *RealTimeHandler) GetCountryStats(c *gin.Context) {
 		stats = []services.CountryStats{}
 	}
 
-	JSONSuccessResponse(c, stats, gin.H{"limit": limit})
+	meta := queryMeta(q)
+	meta["limit"] = limit
+	if cmp != nil {
+		meta["comparison"], err = rowsComparison(cmp, "country", stats,
+			func(row services.CountryStats) string { return row.Country },
+			func(row services.CountryStats) float64 { return float64(row.Count) },
+			h.realTimeService.GetCountryStats)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch country statistics", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, stats, meta)
 }
 
 // GetPageStats handles GET /admin/projects/:id/realtime/pages
 func (h *RealTimeHandler) GetPageStats(c *gin.Context) {
-	idStr := c.Param("id")
-	projectID, err := uuid.Parse(idStr)
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
 	if err != nil {
-		JSONErrorResponse(c, http.StatusBadRequest, "Invalid project ID", err.Error())
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
 		return
 	}
 
@@ -185,18 +213,7 @@ func (h *RealTimeHandler) GetPageStats(c *gin.Context) {
 		limit = 10
 	}
 
-	// Verify project exists
-	_, err = h.adminService.GetProjectByID(projectID)
-	if err != nil {
-		if err.Error() == "project not found" {
-			JSONErrorResponse(c, http.StatusNotFound, "Project not found", "")
-			return
-		}
-		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch project", err.Error())
-		return
-	}
-
-	stats, err := h.realTimeService.GetPageStats(projectID, limit)
+	stats, err := h.realTimeService.GetPageStats(q, limit)
 	if err != nil {
 		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch page statistics", err.Error())
 		return
@@ -207,5 +224,17 @@ func (h *RealTimeHandler) GetPageStats(c *gin.Context) {
 		stats = []services.PageStats{}
 	}
 
-	JSONSuccessResponse(c, stats, gin.H{"limit": limit})
+	meta := queryMeta(q)
+	meta["limit"] = limit
+	if cmp != nil {
+		meta["comparison"], err = rowsComparison(cmp, "page_url", stats,
+			func(row services.PageStats) string { return row.PageURL },
+			func(row services.PageStats) float64 { return float64(row.Count) },
+			h.realTimeService.GetPageStats)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch page statistics", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, stats, meta)
 }
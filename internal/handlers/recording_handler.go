@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"analytic-app/internal/models"
+	"analytic-app/internal/services"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RecordingHandler struct {
+	recordingService *services.RecordingService
+	adminService     *services.AdminService
+}
+
+func NewRecordingHandler(recordingService *services.RecordingService, adminService *services.AdminService) *RecordingHandler {
+	return &RecordingHandler{
+		recordingService: recordingService,
+		adminService:     adminService,
+	}
+}
+
+// StartRecording handles POST /recordings/start, authenticated by the project API key
+func (h *RecordingHandler) StartRecording(c *gin.Context) {
+	// Get project from middleware
+	projectInterface, exists := c.Get("project")
+	if !exists {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Project context not found")
+		return
+	}
+
+	project, ok := projectInterface.(*models.Project)
+	if !ok {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Invalid project context")
+		return
+	}
+
+	var req services.StartRecordingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	start, err := h.recordingService.StartRecording(project.ID, req.SessionID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to start recording", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, start)
+}
+
+// AddRecordingChunk handles POST /recordings/:session_id/chunks?sequence=N, authenticated by
+// the project API key. The body is a JSON array of recorded events, gzip-compressed when sent
+// with Content-Encoding: gzip.
+func (h *RecordingHandler) AddRecordingChunk(c *gin.Context) {
+	// Get project from middleware
+	projectInterface, exists := c.Get("project")
+	if !exists {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Project context not found")
+		return
+	}
+
+	project, ok := projectInterface.(*models.Project)
+	if !ok {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Invalid project context")
+		return
+	}
+
+	sequence, err := strconv.Atoi(c.Query("sequence"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", "sequence must be a chunk number")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, services.MaxRecordingChunkSize+1))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	chunk, err := h.recordingService.AddChunk(project.ID, &services.RecordingChunkRequest{
+		SessionID:  c.Param("session_id"),
+		Sequence:   sequence,
+		Data:       data,
+		Compressed: strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip"),
+	})
+	if err != nil {
+		if err.Error() == "recording not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Recording not started for this session")
+			return
+		}
+		if errors.Is(err, services.ErrInvalidRecording) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid recording chunk", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to store recording chunk", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, chunk)
+}
+
+// GetSettings handles GET /admin/projects/:id/recording-settings
+func (h *RecordingHandler) GetSettings(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	settings, err := h.recordingService.GetSettings(project.ID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch recording settings", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"settings": settings})
+}
+
+// SaveSettings handles PUT /admin/projects/:id/recording-settings
+func (h *RecordingHandler) SaveSettings(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.RecordingSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	settings, err := h.recordingService.SaveSettings(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to save recording settings", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"settings": settings})
+}
+
+// GetRecordings handles GET /admin/projects/:id/recordings
+func (h *RecordingHandler) GetRecordings(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	limit, offset := pagination(c, 50, 100)
+	recordings, total, err := h.recordingService.ListRecordings(project.ID, limit, offset)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch recordings", err.Error())
+		return
+	}
+
+	// Always return an array, even if empty
+	if recordings == nil {
+		recordings = []models.Recording{}
+	}
+
+	JSONSuccessResponse(c, recordings, gin.H{"total": total, "limit": limit, "offset": offset})
+}
+
+// GetRecording handles GET /admin/projects/:id/recordings/:session_id
+func (h *RecordingHandler) GetRecording(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	recording, err := h.recordingService.GetRecording(project.ID, c.Param("session_id"))
+	if err != nil {
+		if err.Error() == "recording not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Recording not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch recording", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, recording)
+}
+
+// GetRecordingEvents handles GET /admin/projects/:id/recordings/:session_id/events, streaming
+// the recorded events as one JSON array in playback order
+func (h *RecordingHandler) GetRecordingEvents(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	recording, err := h.recordingService.GetRecording(project.ID, c.Param("session_id"))
+	if err != nil {
+		if err.Error() == "recording not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Recording not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch recording", err.Error())
+		return
+	}
+
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	separator := "["
+	err = h.recordingService.EachEvent(&recording.Recording, func(event json.RawMessage) error {
+		if _, err := io.WriteString(c.Writer, separator); err != nil {
+			return err
+		}
+		separator = ","
+		_, err := c.Writer.Write(event)
+		return err
+	})
+	if separator == "[" {
+		io.WriteString(c.Writer, separator)
+	}
+	io.WriteString(c.Writer, "]")
+	if err != nil {
+		// The status is already sent; a truncated stream is all that can be signalled
+		log.Printf("Failed to stream recording %s: %v", recording.ID, err)
+	}
+}
+
+// DeleteRecording handles DELETE /admin/projects/:id/recordings/:session_id
+func (h *RecordingHandler) DeleteRecording(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	if err := h.recordingService.DeleteRecording(project.ID, c.Param("session_id")); err != nil {
+		if err.Error() == "recording not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Recording not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to delete recording", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Recording deleted successfully"})
+}
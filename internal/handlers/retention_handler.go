@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RetentionHandler struct {
+	retentionService *services.RetentionService
+	adminService     *services.AdminService
+}
+
+func NewRetentionHandler(retentionService *services.RetentionService, adminService *services.AdminService) *RetentionHandler {
+	return &RetentionHandler{
+		retentionService: retentionService,
+		adminService:     adminService,
+	}
+}
+
+// RunRetention handles POST /admin/projects/:id/retention
+func (h *RetentionHandler) RunRetention(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	var req services.RetentionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid retention request", err.Error())
+		return
+	}
+
+	period := req.Period
+	if period == "" {
+		period = services.GranularityWeek
+	}
+	q = services.WithDefaultWindow(q, period)
+
+	retention, err := h.retentionService.Run(q, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyBuckets) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid retention request", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to compute retention", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, retention, queryMeta(q))
+}
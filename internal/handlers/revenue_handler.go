@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"analytic-app/internal/models"
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RevenueHandler struct {
+	analyticsService *services.AnalyticsService
+	revenueService   *services.RevenueService
+	adminService     *services.AdminService
+}
+
+func NewRevenueHandler(analyticsService *services.AnalyticsService, revenueService *services.RevenueService, adminService *services.AdminService) *RevenueHandler {
+	return &RevenueHandler{
+		analyticsService: analyticsService,
+		revenueService:   revenueService,
+		adminService:     adminService,
+	}
+}
+
+// revenueQuery resolves the project and the analytics query of a revenue report
+func (h *RevenueHandler) revenueQuery(c *gin.Context) (*models.Project, services.AnalyticsQuery, bool) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return nil, services.AnalyticsQuery{}, false
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return nil, q, false
+	}
+
+	return project, q, true
+}
+
+// revenueMeta adds the reporting currency to the query metadata
+func revenueMeta(q services.AnalyticsQuery, project *models.Project) gin.H {
+	meta := queryMeta(q)
+	meta["currency"] = project.Currency
+	return meta
+}
+
+// revenueLimit reads the limit parameter, defaulting to 10
+func revenueLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+	return limit
+}
+
+// GetSummary handles GET /admin/projects/:id/revenue/summary
+func (h *RevenueHandler) GetSummary(c *gin.Context) {
+	project, q, ok := h.revenueQuery(c)
+	if !ok {
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	summary, err := h.analyticsService.GetRevenueSummary(q, project.Currency)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch revenue summary", err.Error())
+		return
+	}
+
+	meta := revenueMeta(q, project)
+	if cmp != nil {
+		fetch := func(q services.AnalyticsQuery) (*services.RevenueSummary, error) {
+			return h.analyticsService.GetRevenueSummary(q, project.Currency)
+		}
+		if meta["comparison"], err = totalsComparison(cmp, summary, fetch); err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch revenue summary", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, summary, meta)
+}
+
+// GetTimeSeries handles GET /admin/projects/:id/revenue/timeseries
+func (h *RevenueHandler) GetTimeSeries(c *gin.Context) {
+	project, q, ok := h.revenueQuery(c)
+	if !ok {
+		return
+	}
+
+	req := services.TimeSeriesRequest{Granularity: c.DefaultQuery("granularity", services.GranularityDay)}
+	if err := req.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	// Resolve the default window first so the comparison period is derived from it
+	q = services.WithDefaultWindow(q, req.Granularity)
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	series, err := h.analyticsService.GetRevenueTimeSeries(q, req.Granularity)
+	if err != nil {
+		if errors.Is(err, services.ErrTooManyBuckets) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch revenue time series", err.Error())
+		return
+	}
+
+	meta := revenueMeta(q, project)
+	if cmp != nil {
+		previous, err := h.analyticsService.GetRevenueTimeSeries(cmp.query, req.Granularity)
+		if err != nil {
+			if errors.Is(err, services.ErrTooManyBuckets) {
+				JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+				return
+			}
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch revenue time series", err.Error())
+			return
+		}
+		totals := make(map[string]services.Delta, len(series.Totals))
+		for metric, value := range series.Totals {
+			totals[metric] = services.NewDelta(value, previous.Totals[metric])
+		}
+		meta["comparison"] = cmp.meta(previous, gin.H{
+			"points": services.AlignSeries(series, previous),
+			"totals": totals,
+		})
+	}
+	JSONSuccessResponse(c, series, meta)
+}
+
+// GetTopProducts handles GET /admin/projects/:id/revenue/products
+func (h *RevenueHandler) GetTopProducts(c *gin.Context) {
+	project, q, ok := h.revenueQuery(c)
+	if !ok {
+		return
+	}
+
+	limit := revenueLimit(c)
+	products, err := h.analyticsService.GetTopProducts(q, limit)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch top products", err.Error())
+		return
+	}
+
+	// Always return an array, even if empty
+	if products == nil {
+		products = []services.ProductRevenue{}
+	}
+
+	meta := revenueMeta(q, project)
+	meta["limit"] = limit
+	JSONSuccessResponse(c, products, meta)
+}
+
+// GetAttribution handles GET /admin/projects/:id/revenue/attribution
+func (h *RevenueHandler) GetAttribution(c *gin.Context) {
+	project, q, ok := h.revenueQuery(c)
+	if !ok {
+		return
+	}
+
+	dimension := c.DefaultQuery("by", "channel")
+	limit := revenueLimit(c)
+	rows, err := h.analyticsService.GetAttributedRevenue(q, dimension, limit)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to fetch revenue attribution", err.Error())
+		return
+	}
+
+	// Always return an array, even if empty
+	if rows == nil {
+		rows = []services.AttributedRevenue{}
+	}
+
+	meta := revenueMeta(q, project)
+	meta["by"] = dimension
+	meta["limit"] = limit
+	JSONSuccessResponse(c, rows, meta)
+}
+
+// GetCurrencyRates handles GET /admin/currency-rates
+func (h *RevenueHandler) GetCurrencyRates(c *gin.Context) {
+	rates, err := h.revenueService.GetRates()
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch currency rates", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, rates, gin.H{"reference_currency": services.ReferenceCurrency})
+}
+
+// SetCurrencyRate handles PUT /admin/currency-rates/:currency
+func (h *RevenueHandler) SetCurrencyRate(c *gin.Context) {
+	var req services.CurrencyRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	rate, err := h.revenueService.SetRate(c.Param("currency"), req.Rate)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to save currency rate", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"rate": rate})
+}
+
+// DeleteCurrencyRate handles DELETE /admin/currency-rates/:currency
+func (h *RevenueHandler) DeleteCurrencyRate(c *gin.Context) {
+	if err := h.revenueService.DeleteRate(c.Param("currency")); err != nil {
+		if err.Error() == "currency rate not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Currency rate not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to delete currency rate", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Currency rate deleted successfully"})
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type RuleHandler struct {
+	transformService *services.TransformService
+	adminService     *services.AdminService
+}
+
+func NewRuleHandler(transformService *services.TransformService, adminService *services.AdminService) *RuleHandler {
+	return &RuleHandler{
+		transformService: transformService,
+		adminService:     adminService,
+	}
+}
+
+// CreateRule handles POST /admin/projects/:id/rules
+func (h *RuleHandler) CreateRule(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.TransformRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	rule, err := h.transformService.CreateRule(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to create rule", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"rule": rule})
+}
+
+// GetRules handles GET /admin/projects/:id/rules
+func (h *RuleHandler) GetRules(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	rules, err := h.transformService.GetRules(project.ID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch rules", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, rules)
+}
+
+// GetRule handles GET /admin/projects/:id/rules/:rule_id
+func (h *RuleHandler) GetRule(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	ruleID, err := uuid.Parse(c.Param("rule_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	rule, err := h.transformService.GetRule(project.ID, ruleID)
+	if err != nil {
+		if err.Error() == "rule not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Rule not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch rule", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"rule": rule})
+}
+
+// UpdateRule handles PUT /admin/projects/:id/rules/:rule_id
+func (h *RuleHandler) UpdateRule(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	ruleID, err := uuid.Parse(c.Param("rule_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	var req services.UpdateTransformRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	rule, err := h.transformService.UpdateRule(project.ID, ruleID, &req)
+	if err != nil {
+		if err.Error() == "rule not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Rule not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to update rule", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"rule": rule})
+}
+
+// DeleteRule handles DELETE /admin/projects/:id/rules/:rule_id
+func (h *RuleHandler) DeleteRule(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	ruleID, err := uuid.Parse(c.Param("rule_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	if err := h.transformService.DeleteRule(project.ID, ruleID); err != nil {
+		if err.Error() == "rule not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Rule not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to delete rule", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Rule deleted successfully"})
+}
+
+// DryRun handles POST /admin/projects/:id/rules/dry-run
+func (h *RuleHandler) DryRun(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.DryRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	result, err := h.transformService.DryRun(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to run rules", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, result)
+}
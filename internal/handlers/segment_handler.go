@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type SegmentHandler struct {
+	segmentService *services.SegmentService
+	cohortService  *services.CohortService
+	goalService    *services.GoalService
+	adminService   *services.AdminService
+}
+
+func NewSegmentHandler(segmentService *services.SegmentService, cohortService *services.CohortService, goalService *services.GoalService, adminService *services.AdminService) *SegmentHandler {
+	return &SegmentHandler{
+		segmentService: segmentService,
+		cohortService:  cohortService,
+		goalService:    goalService,
+		adminService:   adminService,
+	}
+}
+
+// SegmentMiddleware resolves the segment_id and cohort_id query parameters, and the goal_id
+// of conversion metrics, which parseAnalyticsQuery then adds to the analytics query of the report
+func (h *SegmentHandler) SegmentMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if value := c.Query("goal_id"); value != "" {
+			id, err := uuid.Parse(value)
+			if err != nil {
+				JSONErrorResponse(c, http.StatusBadRequest, "Invalid goal ID")
+				c.Abort()
+				return
+			}
+			goal, err := h.goalService.Resolve(id)
+			if err != nil {
+				if errors.Is(err, services.ErrGoalNotFound) {
+					JSONErrorResponse(c, http.StatusNotFound, "Goal not found")
+				} else {
+					JSONErrorResponse(c, http.StatusInternalServerError, "Failed to load goal", err.Error())
+				}
+				c.Abort()
+				return
+			}
+			c.Set("goal", goal)
+		}
+
+		var segments []services.QuerySegment
+
+		for _, param := range []struct {
+			name, kind, label string
+			resolve           func(uuid.UUID) (*services.QuerySegment, error)
+		}{
+			{"segment_id", "segment", "Segment", h.segmentService.Resolve},
+			{"cohort_id", "cohort", "Cohort", h.cohortService.Resolve},
+		} {
+			value := c.Query(param.name)
+			if value == "" {
+				continue
+			}
+			id, err := uuid.Parse(value)
+			if err != nil {
+				JSONErrorResponse(c, http.StatusBadRequest, "Invalid "+param.kind+" ID")
+				c.Abort()
+				return
+			}
+			segment, err := param.resolve(id)
+			if err != nil {
+				if err.Error() == param.kind+" not found" {
+					JSONErrorResponse(c, http.StatusNotFound, param.label+" not found")
+				} else {
+					JSONErrorResponse(c, http.StatusInternalServerError, "Failed to load "+param.kind, err.Error())
+				}
+				c.Abort()
+				return
+			}
+			segments = append(segments, *segment)
+		}
+
+		if len(segments) > 0 {
+			c.Set("segments", segments)
+		}
+		c.Next()
+	}
+}
+
+// RejectGoal answers 400 to a goal_id on the reports that have no conversion metrics, so
+// it is not silently ignored
+func RejectGoal() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query("goal_id") != "" {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", "goal_id is not supported by this report")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CreateSegment handles POST /admin/projects/:id/segments
+func (h *SegmentHandler) CreateSegment(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	var req services.SegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	segment, err := h.segmentService.CreateSegment(project.ID, &req)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to create segment", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"segment": segment})
+}
+
+// GetSegments handles GET /admin/projects/:id/segments
+func (h *SegmentHandler) GetSegments(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	segments, err := h.segmentService.GetSegments(project.ID)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch segments", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, segments)
+}
+
+// GetSegment handles GET /admin/projects/:id/segments/:segment_id
+func (h *SegmentHandler) GetSegment(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	segmentID, err := uuid.Parse(c.Param("segment_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid segment ID")
+		return
+	}
+
+	segment, err := h.segmentService.GetSegment(project.ID, segmentID)
+	if err != nil {
+		if err.Error() == "segment not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Segment not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch segment", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"segment": segment})
+}
+
+// UpdateSegment handles PUT /admin/projects/:id/segments/:segment_id
+func (h *SegmentHandler) UpdateSegment(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	segmentID, err := uuid.Parse(c.Param("segment_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid segment ID")
+		return
+	}
+
+	var req services.UpdateSegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	segment, err := h.segmentService.UpdateSegment(project.ID, segmentID, &req)
+	if err != nil {
+		if err.Error() == "segment not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Segment not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusBadRequest, "Failed to update segment", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"segment": segment})
+}
+
+// DeleteSegment handles DELETE /admin/projects/:id/segments/:segment_id
+func (h *SegmentHandler) DeleteSegment(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	segmentID, err := uuid.Parse(c.Param("segment_id"))
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid segment ID")
+		return
+	}
+
+	if err := h.segmentService.DeleteSegment(project.ID, segmentID); err != nil {
+		if err.Error() == "segment not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Segment not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to delete segment", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, gin.H{"message": "Segment deleted successfully"})
+}
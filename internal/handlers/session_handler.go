@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"analytic-app/internal/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionHandler struct {
+	analyticsService *services.AnalyticsService
+	adminService     *services.AdminService
+}
+
+func NewSessionHandler(analyticsService *services.AnalyticsService, adminService *services.AdminService) *SessionHandler {
+	return &SessionHandler{
+		analyticsService: analyticsService,
+		adminService:     adminService,
+	}
+}
+
+// sessionQuery resolves the project, the analytics query and the session slices
+func (h *SessionHandler) sessionQuery(c *gin.Context) (services.AnalyticsQuery, services.SessionFilter, bool) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return services.AnalyticsQuery{}, services.SessionFilter{}, false
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return q, services.SessionFilter{}, false
+	}
+
+	f := services.SessionFilter{
+		Channel:    c.Query("channel"),
+		Country:    c.Query("country"),
+		DeviceType: c.Query("device"),
+		Breakdown:  c.Query("breakdown"),
+	}
+	if err := f.Validate(); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return q, f, false
+	}
+
+	return q, f, true
+}
+
+// sessionMeta adds the session slices to the query metadata
+func sessionMeta(q services.AnalyticsQuery, f services.SessionFilter) gin.H {
+	meta := queryMeta(q)
+	meta["channel"] = f.Channel
+	meta["country"] = f.Country
+	meta["device"] = f.DeviceType
+	return meta
+}
+
+// GetSessionStats handles GET /admin/projects/:id/sessions/stats
+func (h *SessionHandler) GetSessionStats(c *gin.Context) {
+	q, f, ok := h.sessionQuery(c)
+	if !ok {
+		return
+	}
+
+	cmp, ok := comparisonQuery(c, q)
+	if !ok {
+		return
+	}
+
+	report, err := h.analyticsService.GetSessionReport(q, f)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch session statistics", err.Error())
+		return
+	}
+
+	meta := sessionMeta(q, f)
+	if cmp != nil {
+		fetch := func(q services.AnalyticsQuery) (services.SessionMetrics, error) {
+			previous, err := h.analyticsService.GetSessionReport(q, services.SessionFilter{Channel: f.Channel, Country: f.Country, DeviceType: f.DeviceType})
+			if err != nil {
+				return services.SessionMetrics{}, err
+			}
+			return previous.SessionMetrics, nil
+		}
+		if meta["comparison"], err = totalsComparison(cmp, report.SessionMetrics, fetch); err != nil {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch session statistics", err.Error())
+			return
+		}
+	}
+	JSONSuccessResponse(c, report, meta)
+}
+
+// GetEntryPages handles GET /admin/projects/:id/sessions/entry-pages
+func (h *SessionHandler) GetEntryPages(c *gin.Context) {
+	q, f, ok := h.sessionQuery(c)
+	if !ok {
+		return
+	}
+
+	limitStr := c.DefaultQuery("limit", "10")
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	pages, err := h.analyticsService.GetEntryPages(q, f, limit)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch entry pages", err.Error())
+		return
+	}
+
+	// Always return an array, even if empty
+	if pages == nil {
+		pages = []services.EntryPage{}
+	}
+
+	meta := sessionMeta(q, f)
+	meta["limit"] = limit
+	JSONSuccessResponse(c, pages, meta)
+}
+
+// GetExitPages handles GET /admin/projects/:id/sessions/exit-pages
+func (h *SessionHandler) GetExitPages(c *gin.Context) {
+	q, f, ok := h.sessionQuery(c)
+	if !ok {
+		return
+	}
+
+	limitStr := c.DefaultQuery("limit", "10")
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	pages, err := h.analyticsService.GetExitPages(q, f, limit)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch exit pages", err.Error())
+		return
+	}
+
+	// Always return an array, even if empty
+	if pages == nil {
+		pages = []services.ExitPage{}
+	}
+
+	meta := sessionMeta(q, f)
+	meta["limit"] = limit
+	JSONSuccessResponse(c, pages, meta)
+}
+
+// GetSessions handles GET /admin/projects/:id/sessions
+func (h *SessionHandler) GetSessions(c *gin.Context) {
+	q, f, ok := h.sessionQuery(c)
+	if !ok {
+		return
+	}
+
+	req := services.SessionListRequest{
+		SessionFilter: f,
+		HasEvent:      c.Query("has_event"),
+		Sort:          c.Query("sort"),
+	}
+	req.Limit, req.Offset = pagination(c, 50, 100)
+	if value := c.Query("min_duration"); value != "" {
+		duration, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || duration < 0 {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", "min_duration must be a non-negative number of seconds")
+			return
+		}
+		req.MinDuration = &duration
+	}
+	if value := c.Query("converted"); value != "" {
+		converted, err := strconv.ParseBool(value)
+		if err != nil {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", "converted must be true or false")
+			return
+		}
+		req.Converted = &converted
+	}
+
+	sessions, total, err := h.analyticsService.ListSessions(q, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidSessionSort) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch sessions", err.Error())
+		return
+	}
+
+	meta := sessionMeta(q, f)
+	meta["total"] = total
+	meta["limit"] = req.Limit
+	meta["offset"] = req.Offset
+	JSONSuccessResponse(c, sessions, meta)
+}
+
+// GetSession handles GET /admin/projects/:id/sessions/:session_id
+func (h *SessionHandler) GetSession(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	detail, err := h.analyticsService.GetSessionDetail(project.ID, c.Param("session_id"))
+	if err != nil {
+		if err.Error() == "session not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "Session not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch session", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, detail)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"analytic-app/internal/models"
+	"analytic-app/internal/services"
+	"analytic-app/pkg/utils"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserHandler struct {
+	userService  *services.UserService
+	eventService *services.EventService
+	adminService *services.AdminService
+}
+
+func NewUserHandler(userService *services.UserService, eventService *services.EventService, adminService *services.AdminService) *UserHandler {
+	return &UserHandler{
+		userService:  userService,
+		eventService: eventService,
+		adminService: adminService,
+	}
+}
+
+// pagination reads the limit and offset query parameters
+func pagination(c *gin.Context, defaultLimit, maxLimit int) (int, int) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLimit)))
+	if err != nil || limit < 1 || limit > maxLimit {
+		limit = defaultLimit
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+// GetUsers handles GET /admin/projects/:id/users
+func (h *UserHandler) GetUsers(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	req := services.UserListRequest{
+		Search:  c.Query("search"),
+		Country: c.Query("country"),
+		Sort:    c.Query("sort"),
+	}
+	req.Limit, req.Offset = pagination(c, 50, 100)
+	if value := c.Query("last_seen_after"); value != "" {
+		if req.LastSeenAfter, err = parseQueryTime(value, q.Location, false); err != nil {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return
+		}
+	}
+	if value := c.Query("last_seen_before"); value != "" {
+		if req.LastSeenBefore, err = parseQueryTime(value, q.Location, true); err != nil {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return
+		}
+	}
+
+	// Property filters: ?property[plan]=pro
+	properties := c.QueryMap("property")
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		req.Properties = append(req.Properties, services.InsightFilter{Field: "properties." + key, Value: properties[key]})
+	}
+
+	users, total, err := h.userService.ListUsers(q, &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidUserList) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch users", err.Error())
+		return
+	}
+
+	meta := queryMeta(q)
+	meta["total"] = total
+	meta["limit"] = req.Limit
+	meta["offset"] = req.Offset
+	JSONSuccessResponse(c, users, meta)
+}
+
+// GetUser handles GET /admin/projects/:id/users/:user_id
+func (h *UserHandler) GetUser(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	profile, err := h.userService.GetProfile(q, project.Currency, c.Param("user_id"))
+	if err != nil {
+		if err.Error() == "user not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "User not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch user", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, profile, queryMeta(q))
+}
+
+// GetUserEvents handles GET /admin/projects/:id/users/:user_id/events
+func (h *UserHandler) GetUserEvents(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	q, err := parseAnalyticsQuery(c, project)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid query parameters", err.Error())
+		return
+	}
+
+	limit, offset := pagination(c, 50, 1000)
+	events, total, err := h.userService.GetTimeline(q, c.Param("user_id"), limit, offset)
+	if err != nil {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch user events", err.Error())
+		return
+	}
+
+	// Always return an array, even if empty
+	if events == nil {
+		events = []models.Event{}
+	}
+
+	meta := queryMeta(q)
+	meta["total"] = total
+	meta["limit"] = limit
+	meta["offset"] = offset
+	JSONSuccessResponse(c, events, meta)
+}
+
+// Identify handles POST /identify, authenticated by the project API key
+func (h *UserHandler) Identify(c *gin.Context) {
+	// Get project from middleware
+	projectInterface, exists := c.Get("project")
+	if !exists {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Project context not found")
+		return
+	}
+
+	project, ok := projectInterface.(*models.Project)
+	if !ok {
+		JSONErrorResponse(c, http.StatusInternalServerError, "Invalid project context")
+		return
+	}
+
+	var req services.IdentifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		JSONErrorResponse(c, http.StatusBadRequest, "Invalid request data", err.Error())
+		return
+	}
+
+	traits, err := h.userService.ApplyTraits(project.ID, req.UserID, req.TraitOperations, services.TraitSourceIdentify)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTraits) {
+			JSONErrorResponse(c, http.StatusBadRequest, "Invalid traits", err.Error())
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to identify user", err.Error())
+		return
+	}
+
+	// An identify event links the anonymous events of the session to the user
+	if req.SessionID != "" {
+		userID := req.UserID
+		_, err := h.eventService.CreateEvent(&services.CreateEventRequest{
+			ProjectID: &project.ID,
+			SessionID: req.SessionID,
+			UserID:    &userID,
+			EventType: "identify",
+			EventName: "Identify",
+			IPAddress: utils.GetRealIP(c.Request),
+			Origin: &services.RequestOrigin{
+				IP:      utils.GetRealIP(c.Request),
+				Header:  c.Request.Header,
+				Cookies: c.Request.Cookies(),
+			},
+		})
+		if err != nil && err != services.ErrEventDropped && err != services.ErrInternalTraffic {
+			JSONErrorResponse(c, http.StatusInternalServerError, "Failed to identify user", err.Error())
+			return
+		}
+	}
+
+	JSONSuccessResponse(c, gin.H{"user": traits})
+}
+
+// GetUserTraits handles GET /admin/projects/:id/users/:user_id/traits
+func (h *UserHandler) GetUserTraits(c *gin.Context) {
+	project, ok := requireProject(c, h.adminService)
+	if !ok {
+		return
+	}
+
+	traits, err := h.userService.GetTraits(project.ID, c.Param("user_id"))
+	if err != nil {
+		if err.Error() == "user not found" {
+			JSONErrorResponse(c, http.StatusNotFound, "User not found")
+			return
+		}
+		JSONErrorResponse(c, http.StatusInternalServerError, "Failed to fetch user traits", err.Error())
+		return
+	}
+
+	JSONSuccessResponse(c, traits)
+}
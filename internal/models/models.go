@@ -16,6 +16,7 @@ type Event struct {
 	EventType  string     `json:"event_type" gorm:"not null;index"`
 	EventName  string     `json:"event_name" gorm:"not null"`
 	Properties string     `json:"properties" gorm:"type:jsonb"`
+	Groups     string     `json:"groups" gorm:"type:jsonb;not null;default:'{}'"` // JSON object of group type to group key
 
 	// Page/Screen info
 	PageURL   *string `json:"page_url,omitempty"`
@@ -34,6 +35,15 @@ type Event struct {
 	Language     *string `json:"language,omitempty"`
 	Platform     *string `json:"platform,omitempty"`
 
+	// Set when the event matched the project's internal traffic filter
+	IsInternal bool `json:"is_internal" gorm:"default:false;index"`
+
+	// E-commerce info, set on purchase and refund events
+	OrderID     *string  `json:"order_id,omitempty" gorm:"index"`
+	Revenue     *float64 `json:"revenue,omitempty"`      // in Currency
+	Currency    *string  `json:"currency,omitempty"`     // ISO 4217 code
+	BaseRevenue *float64 `json:"base_revenue,omitempty"` // revenue converted to the project currency at ingestion
+
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -41,21 +51,39 @@ type Event struct {
 // Session represents a user session
 type Session struct {
 	ID         string     `json:"id" gorm:"primaryKey"`
+	ProjectID  *uuid.UUID `json:"project_id,omitempty" gorm:"type:uuid;index"`
 	UserID     *string    `json:"user_id,omitempty" gorm:"index"`
-	StartTime  time.Time  `json:"start_time" gorm:"not null"`
+	StartTime  time.Time  `json:"start_time" gorm:"not null;index"`
 	EndTime    *time.Time `json:"end_time,omitempty"`
 	Duration   *int64     `json:"duration,omitempty"` // in seconds
 	EventCount int        `json:"event_count" gorm:"default:0"`
+	PageViews  int        `json:"page_views" gorm:"default:0"`
 
 	// First page info
 	LandingPage *string `json:"landing_page,omitempty"`
 	Referrer    *string `json:"referrer,omitempty"`
 
+	// Last page info
+	ExitPage *string `json:"exit_page,omitempty"`
+
+	// Acquisition info, classified from the first event
+	Channel     *string `json:"channel,omitempty" gorm:"index"`
+	UTMSource   *string `json:"utm_source,omitempty"`
+	UTMMedium   *string `json:"utm_medium,omitempty"`
+	UTMCampaign *string `json:"utm_campaign,omitempty"`
+
 	// Device info
-	UserAgent *string `json:"user_agent,omitempty"`
-	IPAddress string  `json:"ip_address" gorm:"not null"`
-	Country   *string `json:"country,omitempty"`
-	City      *string `json:"city,omitempty"`
+	UserAgent  *string `json:"user_agent,omitempty"`
+	IPAddress  string  `json:"ip_address" gorm:"not null"`
+	Country    *string `json:"country,omitempty" gorm:"index"`
+	City       *string `json:"city,omitempty"`
+	DeviceType *string `json:"device_type,omitempty" gorm:"index"`
+
+	// Set when the first event of the session was internal traffic
+	IsInternal bool `json:"is_internal" gorm:"default:false;index"`
+
+	// Set when the session was sampled for recording
+	RecordingID *uuid.UUID `json:"recording_id,omitempty" gorm:"type:uuid"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -90,11 +118,236 @@ type Project struct {
 	TotalSessions int        `json:"total_sessions" gorm:"default:0"`
 	TotalUsers    int        `json:"total_users" gorm:"default:0"`
 	LastEventTime *time.Time `json:"last_event_time,omitempty"`
+	Timezone      string     `json:"timezone" gorm:"not null;default:'UTC'"` // IANA zone used for reports
+	Currency      string     `json:"currency" gorm:"not null;default:'USD'"` // ISO 4217 code revenue is reported in
 	IsActive      bool       `json:"is_active" gorm:"default:true"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
+// TransformRule is an ordered ingestion rule that rewrites or drops events for a project
+type TransformRule struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID   uuid.UUID `json:"project_id" gorm:"type:uuid;not null;index"`
+	Name        string    `json:"name" gorm:"not null"`
+	Description *string   `json:"description,omitempty"`
+	Position    int       `json:"position" gorm:"not null;default:0"`
+	Condition   string    `json:"condition"`                 // expression, empty matches every event
+	Actions     string    `json:"actions" gorm:"type:jsonb"` // JSON array of rule actions
+	StopOnMatch bool      `json:"stop_on_match" gorm:"default:false"`
+	IsActive    bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Goal is a conversion tracked for a project. Its conditions are evaluated against events at query time.
+type Goal struct {
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID     uuid.UUID `json:"project_id" gorm:"type:uuid;not null;index"`
+	Name          string    `json:"name" gorm:"not null"`
+	Description   *string   `json:"description,omitempty"`
+	Conditions    string    `json:"conditions" gorm:"type:jsonb;not null"` // JSON array of insight filters, all must match
+	ValueProperty *string   `json:"value_property,omitempty"`              // numeric property holding the goal value
+	Value         float64   `json:"value" gorm:"default:0"`                // value per completion when the property is missing
+	IsActive      bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// InternalTrafficFilter describes which requests count as a project's own (internal) traffic
+type InternalTrafficFilter struct {
+	ProjectID         uuid.UUID `json:"project_id" gorm:"type:uuid;primaryKey"`
+	ExcludedCIDRs     string    `json:"excluded_cidrs" gorm:"type:jsonb"`    // JSON array of CIDR ranges or IPs
+	ExcludedUserIDs   string    `json:"excluded_user_ids" gorm:"type:jsonb"` // JSON array of user IDs
+	BypassHeader      *string   `json:"bypass_header,omitempty"`
+	BypassHeaderValue *string   `json:"bypass_header_value,omitempty"`
+	BypassCookie      *string   `json:"bypass_cookie,omitempty"`
+	BypassCookieValue *string   `json:"bypass_cookie_value,omitempty"`
+	Action            string    `json:"action" gorm:"not null;default:'drop'"` // drop or flag
+	IsActive          bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// OrderItem is a line item of a purchase or refund event
+type OrderItem struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID   uuid.UUID `json:"project_id" gorm:"type:uuid;not null;index"`
+	EventID     uuid.UUID `json:"event_id" gorm:"type:uuid;not null;index"`
+	OrderID     string    `json:"order_id" gorm:"not null;index"`
+	ProductID   string    `json:"product_id" gorm:"not null;index"`
+	ProductName *string   `json:"product_name,omitempty"`
+	Category    *string   `json:"category,omitempty"`
+	Price       float64   `json:"price"` // unit price in the event currency
+	Quantity    int       `json:"quantity" gorm:"default:1"`
+	BaseRevenue *float64  `json:"base_revenue,omitempty"` // price * quantity in the project currency
+	IsRefund    bool      `json:"is_refund" gorm:"default:false"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CurrencyRate is a locally configured exchange rate: how many units of the currency buy one US dollar
+type CurrencyRate struct {
+	Currency  string    `json:"currency" gorm:"primaryKey"`
+	Rate      float64   `json:"rate" gorm:"not null"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Experiment is an A/B test of a project. People are assigned the variant of their first
+// exposure event; the goals are the metrics the variants are compared on.
+type Experiment struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID        uuid.UUID  `json:"project_id" gorm:"type:uuid;not null;uniqueIndex:idx_experiments_project_key"`
+	Key              string     `json:"key" gorm:"not null;uniqueIndex:idx_experiments_project_key"` // sent in the experiment property of exposure events
+	Name             string     `json:"name" gorm:"not null"`
+	Description      *string    `json:"description,omitempty"`
+	Variants         string     `json:"variants" gorm:"type:jsonb;not null"` // JSON array of variants, the first is the control
+	ExposureEvent    string     `json:"exposure_event" gorm:"not null"`
+	VariantProperty  string     `json:"variant_property" gorm:"not null;default:'variant'"`
+	GoalIDs          string     `json:"goal_ids" gorm:"type:jsonb"` // JSON array of goal IDs
+	ConfidenceLevel  float64    `json:"confidence_level" gorm:"default:0.95"`
+	TargetSampleSize *int64     `json:"target_sample_size,omitempty"` // planned exposures over all variants
+	Status           string     `json:"status" gorm:"not null;default:'draft'"`
+	StartedAt        *time.Time `json:"started_at,omitempty"`
+	EndedAt          *time.Time `json:"ended_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// FeatureFlag serves a deterministic on/off decision or variant to the people of a project.
+// Flags linked to an experiment serve its variants and record its exposure events.
+type FeatureFlag struct {
+	ID                uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID         uuid.UUID  `json:"project_id" gorm:"type:uuid;not null;uniqueIndex:idx_feature_flags_project_key"`
+	Key               string     `json:"key" gorm:"not null;uniqueIndex:idx_feature_flags_project_key"`
+	Name              string     `json:"name" gorm:"not null"`
+	Description       *string    `json:"description,omitempty"`
+	IsActive          bool       `json:"is_active" gorm:"default:true"`
+	RolloutPercentage float64    `json:"rollout_percentage" gorm:"not null;default:0"` // share of matching people served the flag, 0 to 100
+	Targeting         string     `json:"targeting" gorm:"type:jsonb"`                  // JSON array of property filters on the evaluated properties
+	Variants          string     `json:"variants" gorm:"type:jsonb"`                   // JSON array of weighted variants, empty for an on/off flag
+	ExperimentID      *uuid.UUID `json:"experiment_id,omitempty" gorm:"type:uuid;index"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// UserTraits holds the traits of a user in a project, set by identify calls and $set/$set_once
+// event properties, e.g. plan or company
+type UserTraits struct {
+	ProjectID uuid.UUID `json:"project_id" gorm:"type:uuid;primaryKey"`
+	UserID    string    `json:"user_id" gorm:"primaryKey"`
+	Traits    string    `json:"traits" gorm:"type:jsonb;not null;default:'{}'"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserTraitChange records a change of a user trait. A removed trait has no new value.
+type UserTraitChange struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
+	UserID    string    `json:"user_id" gorm:"not null;index"`
+	ProjectID uuid.UUID `json:"project_id" gorm:"type:uuid;not null;index"`
+	Trait     string    `json:"trait" gorm:"not null"`
+	OldValue  *string   `json:"old_value,omitempty" gorm:"type:jsonb"`
+	NewValue  *string   `json:"new_value,omitempty" gorm:"type:jsonb"`
+	Source    string    `json:"source" gorm:"not null"` // identify or event
+	ChangedAt time.Time `json:"changed_at" gorm:"not null;index"`
+}
+
+// GroupType is a kind of account events can be associated with, such as company or workspace
+type GroupType struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID uuid.UUID `json:"project_id" gorm:"type:uuid;not null;uniqueIndex:idx_group_types_project_key"`
+	Key       string    `json:"key" gorm:"not null;uniqueIndex:idx_group_types_project_key"`
+	Name      string    `json:"name" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Group is an account of a group type, identified by its key within the project
+type Group struct {
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID  uuid.UUID `json:"project_id" gorm:"type:uuid;not null;uniqueIndex:idx_groups_project_type_key"`
+	GroupType  string    `json:"group_type" gorm:"not null;uniqueIndex:idx_groups_project_type_key"`
+	GroupKey   string    `json:"group_key" gorm:"not null;uniqueIndex:idx_groups_project_type_key"`
+	Properties string    `json:"properties" gorm:"type:jsonb;not null;default:'{}'"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Segment is a saved set of filters on events, their sessions and users, optionally
+// restricted to the members of a cohort
+type Segment struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID   uuid.UUID  `json:"project_id" gorm:"type:uuid;not null;index"`
+	Name        string     `json:"name" gorm:"not null"`
+	Description *string    `json:"description,omitempty"`
+	Filters     string     `json:"filters" gorm:"type:jsonb"` // JSON array of insight filters
+	CohortID    *uuid.UUID `json:"cohort_id,omitempty" gorm:"type:uuid;index"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Cohort is a set of identified users: a static list of user IDs, or the users matching a
+// behavioural definition evaluated when the cohort is used
+type Cohort struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID    uuid.UUID  `json:"project_id" gorm:"type:uuid;not null;index"`
+	Name         string     `json:"name" gorm:"not null"`
+	Description  *string    `json:"description,omitempty"`
+	Type         string     `json:"type" gorm:"not null"`         // static or dynamic
+	Definition   string     `json:"definition" gorm:"type:jsonb"` // JSON behavioural definition of dynamic cohorts
+	Size         int64      `json:"size" gorm:"default:0"`        // members when last calculated
+	CalculatedAt *time.Time `json:"calculated_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// CohortMember is a user of a static cohort
+type CohortMember struct {
+	CohortID  uuid.UUID `json:"cohort_id" gorm:"type:uuid;primaryKey"`
+	UserID    string    `json:"user_id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordingSettings configures the session recordings of a project. Masking is applied by the
+// recorder in the browser, so masked content never leaves the page.
+type RecordingSettings struct {
+	ProjectID        uuid.UUID `json:"project_id" gorm:"type:uuid;primaryKey"`
+	Enabled          bool      `json:"enabled" gorm:"default:false"`
+	SampleRate       float64   `json:"sample_rate" gorm:"not null;default:0"`     // share of sessions recorded, 0 to 1
+	RetentionDays    int       `json:"retention_days" gorm:"not null;default:30"` // recordings are deleted after this many days
+	MaskAllInputs    bool      `json:"mask_all_inputs" gorm:"not null"`
+	MaskAllText      bool      `json:"mask_all_text" gorm:"default:false"`
+	MaskTextSelector *string   `json:"mask_text_selector,omitempty"` // CSS selector of elements whose text is masked
+	BlockSelector    *string   `json:"block_selector,omitempty"`     // CSS selector of elements left out of recordings
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Recording is the DOM recording of a session, stored as ordered compressed chunks
+type Recording struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey"`
+	ProjectID uuid.UUID  `json:"project_id" gorm:"type:uuid;not null;index"`
+	SessionID string     `json:"session_id" gorm:"not null;uniqueIndex"`
+	StartedAt *time.Time `json:"started_at,omitempty"` // timestamp of the first recorded event
+	EndedAt   *time.Time `json:"ended_at,omitempty"`   // timestamp of the last recorded event
+	Chunks    int        `json:"chunks" gorm:"default:0"`
+	Events    int        `json:"events" gorm:"default:0"`
+	Size      int64      `json:"size" gorm:"default:0"` // compressed bytes
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null;index"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// RecordingChunk is a gzip-compressed JSON array of recorded events
+type RecordingChunk struct {
+	RecordingID uuid.UUID `json:"recording_id" gorm:"type:uuid;primaryKey"`
+	Sequence    int       `json:"sequence" gorm:"primaryKey"`
+	Data        []byte    `json:"-" gorm:"type:bytea;not null"`
+	Events      int       `json:"events"`
+	Size        int       `json:"size"` // compressed bytes
+	CreatedAt   time.Time `json:"created_at"`
+}
+
 // BeforeCreate sets the UUID for events
 func (e *Event) BeforeCreate(tx *gorm.DB) error {
 	if e.ID == uuid.Nil {
@@ -114,6 +367,94 @@ func (p *Project) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// BeforeCreate sets the UUID for transform rules
+func (r *TransformRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for goals
+func (g *Goal) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for order items
+func (i *OrderItem) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for experiments
+func (e *Experiment) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for feature flags
+func (f *FeatureFlag) BeforeCreate(tx *gorm.DB) error {
+	if f.ID == uuid.Nil {
+		f.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for user trait changes
+func (c *UserTraitChange) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for group types
+func (g *GroupType) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for groups
+func (g *Group) BeforeCreate(tx *gorm.DB) error {
+	if g.ID == uuid.Nil {
+		g.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for segments
+func (s *Segment) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for cohorts
+func (c *Cohort) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate sets the UUID for recordings
+func (r *Recording) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
 // generateAPIKey generates a unique API key for projects
 func generateAPIKey() string {
 	return "ak_" + uuid.New().String()[:8] + uuid.New().String()[:8]
@@ -3,8 +3,13 @@ package services
 import (
 	"analytic-app/internal/database"
 	"analytic-app/internal/models"
+	"crypto/sha512"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"log"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,6 +31,8 @@ type CreateProjectRequest struct {
 	Description *string `json:"description,omitempty"`
 	OwnerName   string  `json:"owner_name" binding:"required"`
 	OwnerEmail  string  `json:"owner_email" binding:"required,email"`
+	Timezone    *string `json:"timezone,omitempty"`
+	Currency    *string `json:"currency,omitempty"`
 }
 
 // UpdateProjectRequest represents the request to update a project
@@ -35,6 +42,8 @@ type UpdateProjectRequest struct {
 	Description *string `json:"description,omitempty"`
 	OwnerName   *string `json:"owner_name,omitempty"`
 	OwnerEmail  *string `json:"owner_email,omitempty"`
+	Timezone    *string `json:"timezone,omitempty"`
+	Currency    *string `json:"currency,omitempty"`
 	IsActive    *bool   `json:"is_active,omitempty"`
 }
 
@@ -49,12 +58,30 @@ type ProjectResponse struct {
 
 // CreateProject creates a new project
 func (s *AdminService) CreateProject(req *CreateProjectRequest) (*models.Project, error) {
+	timezone := "UTC"
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q", *req.Timezone)
+		}
+		timezone = *req.Timezone
+	}
+	currency := ReferenceCurrency
+	if req.Currency != nil {
+		code, err := NormalizeCurrency(*req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		currency = code
+	}
+
 	project := &models.Project{
 		Name:        req.Name,
 		Domain:      req.Domain,
 		Description: req.Description,
 		OwnerName:   req.OwnerName,
 		OwnerEmail:  req.OwnerEmail,
+		Timezone:    timezone,
+		Currency:    currency,
 		IsActive:    true,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
@@ -180,6 +207,19 @@ func (s *AdminService) UpdateProject(id uuid.UUID, req *UpdateProjectRequest) (*
 	if req.OwnerEmail != nil {
 		updates["owner_email"] = *req.OwnerEmail
 	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q", *req.Timezone)
+		}
+		updates["timezone"] = *req.Timezone
+	}
+	if req.Currency != nil {
+		code, err := NormalizeCurrency(*req.Currency)
+		if err != nil {
+			return nil, err
+		}
+		updates["currency"] = code
+	}
 	if req.IsActive != nil {
 		updates["is_active"] = *req.IsActive
 	}
@@ -236,13 +276,52 @@ func (s *AdminService) RegenerateAPIKey(id uuid.UUID) (*models.Project, error) {
 	return &project, nil
 }
 
+// TrackingScriptOptions selects the opt-in features of a generated tracking script
+type TrackingScriptOptions struct {
+	Recording bool // start the session recorder on load; sampling and masking follow the project's recording settings
+}
+
+// The rrweb 1.1.3 recorder the tracking script loads when recording starts. It sees the
+// recorded pages before masking, so it is served from this server's static files (installed
+// by make recorder) rather than a third-party CDN, and loaded with its integrity hash.
+const (
+	rrwebRecorderFile = "./web/static/js/rrweb-record.min.js"
+	rrwebRecorderPath = "/static/js/rrweb-record.min.js"
+)
+
+var (
+	rrwebRecorderOnce      sync.Once
+	rrwebRecorderIntegrity string
+)
+
+// recorderIntegrity returns the Subresource Integrity hash of the installed recorder, or an
+// empty string when it isn't installed and recording is unavailable
+func recorderIntegrity() string {
+	rrwebRecorderOnce.Do(func() {
+		data, err := os.ReadFile(rrwebRecorderFile)
+		if err != nil {
+			log.Printf("Session recorder not installed, recording is unavailable: %v", err)
+			return
+		}
+		sum := sha512.Sum384(data)
+		rrwebRecorderIntegrity = "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+	})
+	return rrwebRecorderIntegrity
+}
+
 // GenerateTrackingScript generates the JavaScript tracking script for a project
-func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
+func (s *AdminService) GenerateTrackingScript(apiKey string, options TrackingScriptOptions) (string, error) {
 	project, err := s.GetProjectByAPIKey(apiKey)
 	if err != nil {
 		return "", err
 	}
 
+	recorderURL := ""
+	integrity := recorderIntegrity()
+	if integrity != "" {
+		recorderURL = "http://localhost:8080" + rrwebRecorderPath
+	}
+
 	script := fmt.Sprintf(`<!-- Analytics Tracking Script for %s -->
 <script>
 (function() {
@@ -250,6 +329,13 @@ func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
     var config = {
         apiKey: '%s',
         endpoint: '%s/api/v1/track',
+        flagsEndpoint: '%s/api/v1/flags/evaluate',
+        identifyEndpoint: '%s/api/v1/identify',
+        groupsEndpoint: '%s/api/v1/groups/identify',
+        recordingsEndpoint: '%s/api/v1/recordings',
+        recorderUrl: '%s',
+        recorderIntegrity: '%s',
+        recording: %t,
         projectId: '%s',
         projectName: '%s',
         domain: '%s'
@@ -262,6 +348,10 @@ func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
             this.endpoint = config.endpoint;
             this.sessionId = this.generateSessionId();
             this.userId = null;
+            this.anonymousId = this.getAnonymousId();
+            this.flags = {};
+            this.groups = {};
+            this.maxErrorReports = 5;
             this.projectId = config.projectId;
             this.init();
         }
@@ -270,21 +360,54 @@ func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
             return 'session-' + Date.now() + '-' + Math.random().toString(36).substr(2, 9);
         }
 
+        // Persisted so feature flags stay stable across visits before the user is identified
+        getAnonymousId() {
+            try {
+                var id = localStorage.getItem('analytics_anonymous_id');
+                if (!id) {
+                    id = 'anon-' + Date.now() + '-' + Math.random().toString(36).substr(2, 9);
+                    localStorage.setItem('analytics_anonymous_id', id);
+                }
+                return id;
+            } catch (error) {
+                return this.sessionId;
+            }
+        }
+
         init() {
             // Auto-track page view
             this.trackPageView();
+
+            // Scroll depth milestones and engaged time heartbeats of the page view
+            this.trackEngagement();
+
+            // Opt-in session recording
+            if (this.config.recording) {
+                this.startRecording();
+            }
             
-            // Auto-track clicks
+            // Auto-track every click, in the capture phase so handlers stopping propagation don't hide it
             document.addEventListener('click', (e) => {
-                if (e.target.tagName === 'BUTTON' || e.target.tagName === 'A') {
-                    this.trackClick(e.target);
-                }
-            });
+                this.trackClick(e.target, e);
+            }, true);
 
             // Auto-track form submissions
             document.addEventListener('submit', (e) => {
                 this.trackFormSubmit(e.target);
             });
+
+            // Auto-track uncaught errors and unhandled promise rejections
+            window.addEventListener('error', (e) => {
+                this.trackError(e.message, {
+                    source: e.filename,
+                    line: e.lineno,
+                    column: e.colno
+                });
+            });
+            window.addEventListener('unhandledrejection', (e) => {
+                const reason = e.reason;
+                this.trackError(reason && reason.message ? reason.message : reason, { source: 'unhandledrejection' });
+            });
         }
 
         async track(eventData) {
@@ -298,12 +421,15 @@ func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
                 screen_height: screen.height,
                 language: navigator.language,
                 platform: navigator.platform,
+                groups: this.groups,
                 ...eventData
             };
 
             try {
                 await fetch(this.endpoint, {
                     method: 'POST',
+                    keepalive: document.visibilityState === 'hidden', // outlives the page when it is closed
+                    credentials: 'include', // lets internal traffic bypass cookies reach the server
                     headers: {
                         'Content-Type': 'application/json',
                         'X-API-Key': this.config.apiKey
@@ -315,30 +441,181 @@ func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
             }
         }
 
+        // Starts a page view; its id ties the scroll and engagement events to it
         trackPageView() {
+            this.pageViewId = 'pv-' + Date.now() + '-' + Math.random().toString(36).substr(2, 9);
+            this.scrollMilestones = [];
+            this.errorReports = {};
+            this.maxScrollDepth = 0;
+            this.engagedSince = document.visibilityState === 'visible' ? Date.now() : null;
             this.track({
                 event_type: 'page_view',
                 event_name: 'Page View',
                 page_url: window.location.href,
                 page_title: document.title,
-                referrer: document.referrer || null
+                referrer: document.referrer || null,
+                properties: { page_view_id: this.pageViewId }
+            });
+        }
+
+        // Reports scroll depth milestones once per page view as the visitor scrolls, and engaged
+        // time every 15 seconds while the tab is visible. Hiding the tab sends the time engaged so
+        // far and pauses the clock until the tab is shown again.
+        trackEngagement() {
+            let scrollTimer = null;
+            window.addEventListener('scroll', () => {
+                if (!scrollTimer) {
+                    scrollTimer = setTimeout(() => {
+                        scrollTimer = null;
+                        this.trackScrollDepth();
+                    }, 250);
+                }
+            }, { passive: true });
+            // The part of the page visible on load counts toward the depth, but milestones wait
+            // for a scroll so a page view alone stays a bounce
+            this.maxScrollDepth = this.scrollDepth();
+
+            setInterval(() => this.trackEngagedTime(), 15000);
+            document.addEventListener('visibilitychange', () => {
+                if (document.visibilityState === 'visible') {
+                    this.engagedSince = Date.now();
+                } else {
+                    this.trackEngagedTime();
+                    this.engagedSince = null;
+                }
+            });
+            window.addEventListener('pagehide', () => {
+                this.trackEngagedTime();
+                this.engagedSince = null;
+            });
+        }
+
+        // Depth is the percent of the page scrolled past the bottom of the viewport
+        scrollDepth() {
+            const height = document.documentElement.scrollHeight;
+            return height > 0 ? Math.min(100, Math.round((window.scrollY + window.innerHeight) / height * 100)) : 100;
+        }
+
+        trackScrollDepth() {
+            const depth = this.scrollDepth();
+            this.maxScrollDepth = Math.max(this.maxScrollDepth, depth);
+            [25, 50, 75, 100].forEach((milestone) => {
+                if (depth >= milestone && this.scrollMilestones.indexOf(milestone) === -1) {
+                    this.scrollMilestones.push(milestone);
+                    this.track({
+                        event_type: 'scroll',
+                        event_name: 'Scroll Depth',
+                        page_url: window.location.href,
+                        properties: { depth: milestone, page_view_id: this.pageViewId }
+                    });
+                }
             });
         }
 
-        trackClick(element) {
-            const elementName = element.textContent?.trim() || element.className || 'Unknown Element';
+        // Sends the seconds engaged since the last heartbeat, if the tab has been visible
+        trackEngagedTime() {
+            if (this.engagedSince === null) {
+                return;
+            }
+            const now = Date.now();
+            const seconds = Math.round((now - this.engagedSince) / 1000);
+            if (seconds < 1) {
+                return;
+            }
+            this.engagedSince = now;
             this.track({
-                event_type: 'click',
-                event_name: 'Click: ' + elementName,
+                event_type: 'engagement',
+                event_name: 'Engaged Time',
                 page_url: window.location.href,
                 properties: {
-                    element_tag: element.tagName,
-                    element_class: element.className,
-                    element_id: element.id
+                    engaged_seconds: seconds,
+                    scroll_depth: this.maxScrollDepth,
+                    page_view_id: this.pageViewId
                 }
             });
         }
 
+        // Records the clicked element (the closest interactive one), its selector and the click
+        // position relative to the page and the viewport, for heatmaps
+        trackClick(element, event) {
+            const target = element.closest('a, button, [role="button"], input, select, textarea, label, summary') || element;
+            const className = typeof target.className === 'string' ? target.className : '';
+            const text = (target.textContent || '').trim().replace(/\s+/g, ' ').slice(0, 100);
+            const elementName = text || className || 'Unknown Element';
+            const properties = {
+                element_tag: target.tagName,
+                element_class: className,
+                element_id: target.id,
+                element_text: text,
+                selector: this.cssSelector(target),
+                viewport_width: window.innerWidth,
+                viewport_height: window.innerHeight,
+                page_width: document.documentElement.scrollWidth,
+                page_height: document.documentElement.scrollHeight
+            };
+            if (event) {
+                properties.x = Math.round(event.pageX);
+                properties.y = Math.round(event.pageY);
+                properties.client_x = Math.round(event.clientX);
+                properties.client_y = Math.round(event.clientY);
+            }
+            this.track({
+                event_type: 'click',
+                event_name: 'Click: ' + elementName,
+                page_url: window.location.href,
+                properties: properties
+            });
+            this.watchDeadClick(target, properties);
+        }
+
+        // Builds a selector that survives re-renders: tag and position steps up to the closest id
+        cssSelector(element) {
+            const steps = [];
+            while (element && element.nodeType === 1 && element !== document.documentElement) {
+                if (element.id) {
+                    steps.unshift('#' + CSS.escape(element.id));
+                    break;
+                }
+                let step = element.tagName.toLowerCase();
+                const parent = element.parentElement;
+                if (parent) {
+                    const siblings = Array.from(parent.children).filter((child) => child.tagName === element.tagName);
+                    if (siblings.length > 1) {
+                        step += ':nth-of-type(' + (siblings.indexOf(element) + 1) + ')';
+                    }
+                }
+                steps.unshift(step);
+                element = parent;
+            }
+            return steps.join(' > ');
+        }
+
+        // Reports a dead click when a click is followed by no navigation, DOM change or scroll
+        // within 2 seconds. Form fields and media react without changing the page and are skipped.
+        watchDeadClick(target, properties) {
+            if (!window.MutationObserver || target.closest('input, select, textarea, label, video, audio')) {
+                return;
+            }
+            const url = window.location.href;
+            let changed = false;
+            const onChange = () => { changed = true; };
+            const observer = new MutationObserver(onChange);
+            observer.observe(document.body, { childList: true, subtree: true, attributes: true, characterData: true });
+            window.addEventListener('scroll', onChange, { passive: true });
+            setTimeout(() => {
+                observer.disconnect();
+                window.removeEventListener('scroll', onChange);
+                if (!changed && window.location.href === url && document.visibilityState === 'visible') {
+                    this.track({
+                        event_type: 'dead_click',
+                        event_name: 'Dead Click',
+                        page_url: url,
+                        properties: properties
+                    });
+                }
+            }, 2000);
+        }
+
         trackFormSubmit(form) {
             const formName = form.getAttribute('name') || form.getAttribute('id') || 'Unknown Form';
             this.track({
@@ -352,6 +629,23 @@ func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
             });
         }
 
+        // Reported as error events, shown in session details. An error thrown on every frame or
+        // timer tick is reported at most maxErrorReports times per page view.
+        trackError(message, properties) {
+            message = String(message);
+            const reports = this.errorReports[message] || 0;
+            if (reports >= this.maxErrorReports) {
+                return;
+            }
+            this.errorReports[message] = reports + 1;
+            this.track({
+                event_type: 'error',
+                event_name: 'Error',
+                page_url: window.location.href,
+                properties: Object.assign({ message: message }, properties || {})
+            });
+        }
+
         trackCustomEvent(eventName, eventType, properties) {
             this.track({
                 event_type: eventType || 'custom',
@@ -361,9 +655,258 @@ func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
             });
         }
 
+        // items: [{product_id, product_name, category, price, quantity}]
+        trackPurchase(orderId, revenue, currency, items, properties) {
+            this.track({
+                event_type: 'purchase',
+                event_name: 'Purchase',
+                page_url: window.location.href,
+                order_id: String(orderId),
+                revenue: revenue,
+                currency: currency || undefined,
+                items: items || [],
+                properties: properties || {}
+            });
+        }
+
+        // Leave revenue and items out to refund the whole order
+        trackRefund(orderId, revenue, currency, items) {
+            this.track({
+                event_type: 'refund',
+                event_name: 'Refund',
+                page_url: window.location.href,
+                order_id: String(orderId),
+                revenue: revenue,
+                currency: currency || undefined,
+                items: items || []
+            });
+        }
+
+        // Evaluates the given flags (all flags when omitted); exposures are recorded server-side
+        async loadFeatureFlags(keys, properties) {
+            try {
+                const response = await fetch(this.config.flagsEndpoint, {
+                    method: 'POST',
+                    credentials: 'include',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'X-API-Key': this.config.apiKey
+                    },
+                    body: JSON.stringify({
+                        user_id: this.userId,
+                        anonymous_id: this.anonymousId,
+                        session_id: this.sessionId,
+                        properties: properties || {},
+                        flags: keys || []
+                    })
+                });
+                const result = await response.json();
+                this.flags = { ...this.flags, ...((result.data && result.data.flags) || {}) };
+            } catch (error) {
+                console.warn('Feature flag evaluation failed:', error);
+            }
+            return this.flags;
+        }
+
+        // Returns the variant of a loaded flag, true for an enabled flag without variants, or false
+        getFeatureFlag(key) {
+            const flag = this.flags[key];
+            if (!flag || !flag.enabled) {
+                return false;
+            }
+            return flag.variant || true;
+        }
+
+        isFeatureEnabled(key) {
+            return this.getFeatureFlag(key) !== false;
+        }
+
         setUserId(userId) {
             this.userId = userId;
         }
+
+        // Identifies the current visitor and updates their traits; traitsOnce are only set when missing
+        async identify(userId, traits, traitsOnce) {
+            this.userId = userId;
+            try {
+                await fetch(this.config.identifyEndpoint, {
+                    method: 'POST',
+                    credentials: 'include',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'X-API-Key': this.config.apiKey
+                    },
+                    body: JSON.stringify({
+                        user_id: userId,
+                        session_id: this.sessionId,
+                        $set: traits || {},
+                        $set_once: traitsOnce || {}
+                    })
+                });
+            } catch (error) {
+                console.warn('Identify failed:', error);
+            }
+        }
+
+        // Records the page with rrweb when the project samples this session. Masking follows the
+        // project's recording settings; events are sent in gzip-compressed chunks, and as plain
+        // JSON when the page is going away.
+        async startRecording() {
+            if (this.recorder) {
+                return;
+            }
+            if (!this.config.recorderUrl) {
+                console.warn('Session recording is unavailable: the recorder is not installed on the analytics server');
+                return;
+            }
+            this.recorder = { sequence: 0, buffer: [] };
+            try {
+                const response = await fetch(this.config.recordingsEndpoint + '/start', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'X-API-Key': this.config.apiKey
+                    },
+                    body: JSON.stringify({ session_id: this.sessionId })
+                });
+                const result = await response.json();
+                const settings = result.data || {};
+                if (!settings.recording) {
+                    return;
+                }
+
+                await new Promise((resolve, reject) => {
+                    const script = document.createElement('script');
+                    script.src = this.config.recorderUrl;
+                    script.integrity = this.config.recorderIntegrity;
+                    script.crossOrigin = 'anonymous';
+                    script.onload = resolve;
+                    script.onerror = reject;
+                    document.head.appendChild(script);
+                });
+
+                this.recorder.stop = window.rrwebRecord({
+                    emit: (event) => {
+                        this.recorder.buffer.push(event);
+                        if (this.recorder.buffer.length >= 100) {
+                            this.flushRecording();
+                        }
+                    },
+                    maskAllInputs: settings.mask_all_inputs,
+                    maskTextSelector: settings.mask_all_text ? '*' : settings.mask_text_selector,
+                    blockSelector: settings.block_selector
+                });
+                this.recorder.timer = setInterval(() => this.flushRecording(), 5000);
+                window.addEventListener('pagehide', () => this.flushRecordingOnUnload());
+            } catch (error) {
+                console.warn('Session recording failed:', error);
+            }
+        }
+
+        stopRecording() {
+            if (this.recorder && this.recorder.stop) {
+                this.recorder.stop();
+                clearInterval(this.recorder.timer);
+                this.flushRecording();
+            }
+        }
+
+        // Uploads the buffered events as the next chunk, gzip-compressed when the browser can
+        async flushRecording() {
+            if (!this.recorder || this.recorder.buffer.length === 0) {
+                return;
+            }
+            const events = this.recorder.buffer;
+            const sequence = this.recorder.sequence++;
+            this.recorder.buffer = [];
+
+            const headers = { 'Content-Type': 'application/json', 'X-API-Key': this.config.apiKey };
+            let body = JSON.stringify(events);
+            try {
+                if (window.CompressionStream) {
+                    const stream = new Blob([body]).stream().pipeThrough(new CompressionStream('gzip'));
+                    body = await new Response(stream).blob();
+                    headers['Content-Encoding'] = 'gzip';
+                }
+                await fetch(this.recordingChunkUrl(sequence), {
+                    method: 'POST',
+                    headers: headers,
+                    body: body
+                });
+            } catch (error) {
+                console.warn('Session recording upload failed:', error);
+            }
+        }
+
+        // Uploads the buffered events when the page is going away. Nothing is awaited, so the
+        // requests start before the page is gone: the events are sent uncompressed, split in
+        // chunks that fit the 64 KB keepalive limit. Keepalive requests share that limit, so
+        // chunks past it are sent as plain requests, which may not outlive the page.
+        flushRecordingOnUnload() {
+            if (!this.recorder || this.recorder.buffer.length === 0) {
+                return;
+            }
+            const events = this.recorder.buffer;
+            this.recorder.buffer = [];
+
+            const limit = 65536;
+            let budget = limit;
+            const send = (chunk) => {
+                const body = '[' + chunk.join(',') + ']';
+                const size = new Blob([body]).size;
+                const keepalive = size <= budget;
+                if (keepalive) {
+                    budget -= size;
+                }
+                fetch(this.recordingChunkUrl(this.recorder.sequence++), {
+                    method: 'POST',
+                    keepalive: keepalive,
+                    headers: { 'Content-Type': 'application/json', 'X-API-Key': this.config.apiKey },
+                    body: body
+                }).catch(() => {});
+            };
+
+            let chunk = [];
+            let size = 2;
+            for (const event of events) {
+                const json = JSON.stringify(event);
+                const eventSize = new Blob([json]).size + 1;
+                if (chunk.length > 0 && size + eventSize > limit) {
+                    send(chunk);
+                    chunk = [];
+                    size = 2;
+                }
+                chunk.push(json);
+                size += eventSize;
+            }
+            send(chunk);
+        }
+
+        recordingChunkUrl(sequence) {
+            return this.config.recordingsEndpoint + '/' + encodeURIComponent(this.sessionId) + '/chunks?sequence=' + sequence;
+        }
+
+        // Associates the following events with a group and updates the group's properties
+        async group(groupType, groupKey, properties) {
+            this.groups = { ...this.groups, [groupType]: groupKey };
+            try {
+                await fetch(this.config.groupsEndpoint, {
+                    method: 'POST',
+                    credentials: 'include',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'X-API-Key': this.config.apiKey
+                    },
+                    body: JSON.stringify({
+                        group_type: groupType,
+                        group_key: groupKey,
+                        properties: properties || {}
+                    })
+                });
+            } catch (error) {
+                console.warn('Group identify failed:', error);
+            }
+        }
     }
 
     // Initialize tracker
@@ -374,14 +917,57 @@ func (s *AdminService) GenerateTrackingScript(apiKey string) (string, error) {
         window.analytics.trackCustomEvent(eventName, eventType, properties);
     };
     
+    window.trackPurchase = function(orderId, revenue, currency, items, properties) {
+        window.analytics.trackPurchase(orderId, revenue, currency, items, properties);
+    };
+
+    window.trackRefund = function(orderId, revenue, currency, items) {
+        window.analytics.trackRefund(orderId, revenue, currency, items);
+    };
+    
+    window.loadFeatureFlags = function(keys, properties) {
+        return window.analytics.loadFeatureFlags(keys, properties);
+    };
+
+    window.getFeatureFlag = function(key) {
+        return window.analytics.getFeatureFlag(key);
+    };
+
+    window.isFeatureEnabled = function(key) {
+        return window.analytics.isFeatureEnabled(key);
+    };
+
     window.setUserId = function(userId) {
         window.analytics.setUserId(userId);
     };
+
+    window.identify = function(userId, traits, traitsOnce) {
+        return window.analytics.identify(userId, traits, traitsOnce);
+    };
+
+    window.group = function(groupType, groupKey, properties) {
+        return window.analytics.group(groupType, groupKey, properties);
+    };
+
+    window.startRecording = function() {
+        return window.analytics.startRecording();
+    };
+
+    window.stopRecording = function() {
+        window.analytics.stopRecording();
+    };
 })();
 </script>`,
 		project.Name,
 		apiKey,
 		"http://localhost:8080", // This should be configurable
+		"http://localhost:8080",
+		"http://localhost:8080",
+		"http://localhost:8080",
+		"http://localhost:8080",
+		recorderURL,
+		integrity,
+		options.Recording,
 		project.ID.String(),
 		project.Name,
 		project.Domain,
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ActiveUserMetrics are the metrics of an active users time series
+var ActiveUserMetrics = []string{"dau", "wau", "mau", "dau_mau"}
+
+// stickinessPeriodDays is the most days a person can be active in a stickiness period
+var stickinessPeriodDays = map[string]int{
+	GranularityWeek:  7,
+	GranularityMonth: 31,
+}
+
+// EngagementRequest defines the events that make a person active, all of which must match
+// the filters. Every event but flag exposures counts when there are none.
+type EngagementRequest struct {
+	Active []InsightFilter `json:"active,omitempty"`
+	Period string          `json:"period,omitempty"` // stickiness period, week or month
+}
+
+// StickinessRow counts the people active on a number of days of a period. A person active
+// in several periods of the window is counted once per period.
+type StickinessRow struct {
+	Days   int     `json:"days"`
+	People int64   `json:"people"`
+	Share  float64 `json:"share"` // of all active person-periods, 0 to 1
+}
+
+// StickinessResult is the histogram of active days per period
+type StickinessResult struct {
+	Period        string          `json:"period"`
+	PersonPeriods int64           `json:"person_periods"`
+	AverageDays   float64         `json:"average_days"`
+	Rows          []StickinessRow `json:"rows"`
+}
+
+type activeUsersRow struct {
+	Day string
+	DAU int64
+	WAU int64
+	MAU int64
+}
+
+type stickinessRow struct {
+	Days   int
+	People int64
+}
+
+// Validate checks the active event filters and the period
+func (r *EngagementRequest) Validate() error {
+	if len(r.Active) > maxInsightFilters {
+		return fmt.Errorf("at most %d active filters are allowed", maxInsightFilters)
+	}
+	if _, err := filtersCondition(r.Active); err != nil {
+		return err
+	}
+	if r.Period != "" {
+		if _, ok := stickinessPeriodDays[r.Period]; !ok {
+			return fmt.Errorf("unknown stickiness period %q", r.Period)
+		}
+	}
+	return nil
+}
+
+// activeCondition renders the filters of the events that make a person active. Without
+// filters, flag exposures are left out: the server records them whenever flags are evaluated,
+// by the backend too, so they don't show the person did anything.
+func activeCondition(filters []InsightFilter) (sqlFragment, error) {
+	if len(filters) == 0 {
+		return sqlFragment{sql: fmt.Sprintf("events.event_type <> '%s'", eventTypeExposure)}, nil
+	}
+	return filtersCondition(filters)
+}
+
+// activeCTEs renders the days each person was active on, in the reporting timezone.
+// People are resolved as in funnels and retention.
+func (q AnalyticsQuery) activeCTEs(active sqlFragment) (string, []interface{}) {
+	where, whereArgs := q.conditions(true)
+	args := []interface{}{*q.ProjectID, q.Timezone()}
+	args = append(args, whereArgs...)
+	args = append(args, active.args...)
+	return fmt.Sprintf(`%s,
+		active AS (
+			SELECT DISTINCT %s AS person, CAST(events.created_at AT TIME ZONE ? AS date) AS day
+			FROM events
+			%s
+			WHERE %s AND %s
+		)`, sessionPeopleCTE, q.actorColumn(), sessionPeopleJoin, where, active.sql), args
+}
+
+// GetActiveUsers returns the daily, weekly and monthly active people of every day in the
+// window, each counted over the trailing 1, 7 and 30 days, and the DAU/MAU ratio. Totals
+// are the averages over the window.
+func (s *AnalyticsService) GetActiveUsers(q AnalyticsQuery, req *EngagementRequest) (*TimeSeries, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("engagement reports require a project")
+	}
+	active, err := activeCondition(req.Active)
+	if err != nil {
+		return nil, err
+	}
+
+	q = WithDefaultWindow(q, GranularityDay)
+	buckets, err := timeBuckets(q.From, q.To, q.location(), GranularityDay)
+	if err != nil {
+		return nil, err
+	}
+	series := &TimeSeries{
+		Granularity: GranularityDay,
+		Metrics:     ActiveUserMetrics,
+		Points:      make([]TimeSeriesPoint, 0, len(buckets)),
+		Totals:      map[string]float64{"dau": 0, "wau": 0, "mau": 0, "dau_mau": 0},
+	}
+	if len(buckets) == 0 {
+		return series, nil
+	}
+
+	// The monthly count of the first day looks back 29 days before the window
+	lookback := q
+	lookback.From = buckets[0].AddDate(0, 0, -29)
+	ctes, args := lookback.activeCTEs(active)
+	args = append(args, buckets[0].Format("2006-01-02"), buckets[len(buckets)-1].Format("2006-01-02"))
+
+	var rows []activeUsersRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		days AS (
+			SELECT CAST(day AS date) AS day
+			FROM generate_series(CAST(? AS date), CAST(? AS date), interval '1 day') AS day
+		)
+		SELECT
+			TO_CHAR(days.day, 'YYYY-MM-DD') AS day,
+			COUNT(DISTINCT active.person) FILTER (WHERE active.day = days.day) AS dau,
+			COUNT(DISTINCT active.person) FILTER (WHERE active.day > days.day - 7) AS wau,
+			COUNT(DISTINCT active.person) AS mau
+		FROM days
+		LEFT JOIN active ON active.day > days.day - 30 AND active.day <= days.day
+		GROUP BY days.day
+	`, ctes), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byDay := make(map[string]activeUsersRow, len(rows))
+	for _, row := range rows {
+		byDay[row.Day] = row
+	}
+
+	for _, bucket := range buckets {
+		row := byDay[bucket.Format("2006-01-02")]
+		values := map[string]float64{
+			"dau":     float64(row.DAU),
+			"wau":     float64(row.WAU),
+			"mau":     float64(row.MAU),
+			"dau_mau": 0,
+		}
+		if row.MAU > 0 {
+			values["dau_mau"] = float64(row.DAU) / float64(row.MAU)
+		}
+		series.Points = append(series.Points, TimeSeriesPoint{Bucket: bucket, Values: values})
+		series.Totals["dau"] += values["dau"]
+		series.Totals["wau"] += values["wau"]
+		series.Totals["mau"] += values["mau"]
+	}
+
+	days := float64(len(buckets))
+	series.Totals["dau"] /= days
+	series.Totals["wau"] /= days
+	series.Totals["mau"] /= days
+	if series.Totals["mau"] > 0 {
+		series.Totals["dau_mau"] = series.Totals["dau"] / series.Totals["mau"]
+	}
+	return series, nil
+}
+
+// GetStickiness returns how many people were active on 1, 2, ... days of each calendar week
+// or month of the window. Periods cut by the window only count their days inside it.
+func (s *AnalyticsService) GetStickiness(q AnalyticsQuery, req *EngagementRequest) (*StickinessResult, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("engagement reports require a project")
+	}
+	active, err := activeCondition(req.Active)
+	if err != nil {
+		return nil, err
+	}
+	period := req.Period
+	if period == "" {
+		period = GranularityWeek
+	}
+	maxDays, ok := stickinessPeriodDays[period]
+	if !ok {
+		return nil, fmt.Errorf("unknown stickiness period %q", period)
+	}
+
+	q = WithDefaultWindow(q, period)
+	ctes, args := q.activeCTEs(active)
+
+	var rows []stickinessRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		periods AS (
+			SELECT active.person, date_trunc('%s', active.day) AS period, COUNT(*) AS days
+			FROM active
+			GROUP BY active.person, date_trunc('%s', active.day)
+		)
+		SELECT periods.days, COUNT(*) AS people
+		FROM periods
+		GROUP BY periods.days
+	`, ctes, period, period), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[int]int64, len(rows))
+	result := &StickinessResult{Period: period, Rows: make([]StickinessRow, 0, maxDays)}
+	var activeDays int64
+	for _, row := range rows {
+		counts[row.Days] = row.People
+		result.PersonPeriods += row.People
+		activeDays += int64(row.Days) * row.People
+	}
+	for days := 1; days <= maxDays; days++ {
+		row := StickinessRow{Days: days, People: counts[days]}
+		if result.PersonPeriods > 0 {
+			row.Share = float64(row.People) / float64(result.PersonPeriods)
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if result.PersonPeriods > 0 {
+		result.AverageDays = float64(activeDays) / float64(result.PersonPeriods)
+	}
+	return result, nil
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Click event types of the tracking script
+const (
+	EventTypeClick     = "click"
+	EventTypeDeadClick = "dead_click" // a click followed by no navigation, DOM change or scroll
+)
+
+// Heatmap viewport buckets, by viewport width
+const (
+	ViewportMobile  = "mobile"  // narrower than 768px
+	ViewportTablet  = "tablet"  // 768px to 1023px
+	ViewportDesktop = "desktop" // 1024px and wider
+)
+
+// Heatmap click positions
+const (
+	HeatmapPositionPage     = "page"     // relative to the top left of the page
+	HeatmapPositionViewport = "viewport" // relative to the top left of the visible window
+)
+
+const (
+	defaultHeatmapColumns   = 20
+	maxHeatmapColumns       = 100
+	defaultHeatmapRowHeight = 50
+	maxHeatmapRows          = 400
+	maxHeatmapElements      = 50
+	rageClickMinClicks      = 3 // clicks on one element, each within rageClickGap of the previous one
+	rageClickGap            = "1 second"
+)
+
+// HeatmapRequest selects the clicks of a page. Query strings and fragments of page URLs are
+// ignored. Columns split the width of the page (or viewport) evenly; rows are RowHeight
+// pixels high.
+type HeatmapRequest struct {
+	PageURL   string
+	Viewport  string // one of the viewport buckets, empty for all
+	Position  string // page or viewport
+	Columns   int
+	RowHeight int
+	Limit     int // top elements
+}
+
+// HeatmapCell counts the clicks of a cell of the density grid
+type HeatmapCell struct {
+	Column int   `json:"column"`
+	Row    int   `json:"row"`
+	Clicks int64 `json:"clicks"`
+}
+
+// HeatmapGrid is a sparse click density grid: only cells with clicks are listed
+type HeatmapGrid struct {
+	Columns   int           `json:"columns"`
+	RowHeight int           `json:"row_height"` // in pixels
+	Rows      int           `json:"rows"`
+	MaxClicks int64         `json:"max_clicks"`
+	Cells     []HeatmapCell `json:"cells"`
+}
+
+// HeatmapElement is a clicked element, identified by its CSS selector
+type HeatmapElement struct {
+	Selector    string `json:"selector"`
+	ElementTag  string `json:"element_tag"`
+	ElementText string `json:"element_text"`
+	Clicks      int64  `json:"clicks"`
+	Sessions    int64  `json:"sessions"`
+	RageClicks  int64  `json:"rage_clicks"`
+	DeadClicks  int64  `json:"dead_clicks"`
+}
+
+// HeatmapTotals counts the clicks of a page. RageClicks counts bursts of rapid clicks on an
+// element, DeadClicks the clicks that had no visible effect.
+type HeatmapTotals struct {
+	Clicks            int64 `json:"clicks"`
+	Sessions          int64 `json:"sessions"`
+	RageClicks        int64 `json:"rage_clicks"`
+	RageClickSessions int64 `json:"rage_click_sessions"`
+	DeadClicks        int64 `json:"dead_clicks"`
+	DeadClickSessions int64 `json:"dead_click_sessions"`
+}
+
+// Heatmap aggregates the clicks of a page
+type Heatmap struct {
+	PageURL  string `json:"page_url"`
+	Viewport string `json:"viewport,omitempty"`
+	Position string `json:"position"`
+	HeatmapTotals
+	Grid     HeatmapGrid      `json:"grid"`
+	Elements []HeatmapElement `json:"elements"`
+}
+
+// Validate checks the page, viewport and position and applies the grid defaults
+func (r *HeatmapRequest) Validate() error {
+	if r.PageURL == "" {
+		return errors.New("page_url is required")
+	}
+	switch r.Viewport {
+	case "", ViewportMobile, ViewportTablet, ViewportDesktop:
+	default:
+		return fmt.Errorf("unknown viewport %q", r.Viewport)
+	}
+	switch r.Position {
+	case "":
+		r.Position = HeatmapPositionPage
+	case HeatmapPositionPage, HeatmapPositionViewport:
+	default:
+		return fmt.Errorf("unknown position %q", r.Position)
+	}
+	if r.Columns == 0 {
+		r.Columns = defaultHeatmapColumns
+	}
+	if r.Columns < 1 || r.Columns > maxHeatmapColumns {
+		return fmt.Errorf("columns must be between 1 and %d", maxHeatmapColumns)
+	}
+	if r.RowHeight == 0 {
+		r.RowHeight = defaultHeatmapRowHeight
+	}
+	if r.RowHeight < 10 || r.RowHeight > 1000 {
+		return errors.New("row_height must be between 10 and 1000 pixels")
+	}
+	if r.Limit < 1 || r.Limit > maxHeatmapElements {
+		r.Limit = 10
+	}
+	return nil
+}
+
+// heatmapCTE renders the clicks of the page as page_clicks (dead clicks included), clicks,
+// and rage, one row per burst of rapid clicks on an element
+func (q AnalyticsQuery) heatmapCTE(r *HeatmapRequest) (string, []interface{}, error) {
+	x, y, width := "properties.x", "properties.y", "properties.page_width"
+	if r.Position == HeatmapPositionViewport {
+		x, y, width = "properties.client_x", "properties.client_y", "properties.viewport_width"
+	}
+	var columns []string
+	var args []interface{}
+	for _, field := range []string{x, y, width} {
+		number, err := propertyNumber(field)
+		if err != nil {
+			return "", nil, err
+		}
+		columns = append(columns, number.sql)
+		args = append(args, number.args...)
+	}
+
+	where, whereArgs := q.conditions(true)
+	args = append(args, whereArgs...)
+	args = append(args, EventTypeClick, EventTypeDeadClick, pageURLQueryPattern, stripPageURLQuery(r.PageURL))
+
+	viewport := ""
+	if r.Viewport != "" {
+		viewportWidth, err := propertyNumber("properties.viewport_width")
+		if err != nil {
+			return "", nil, err
+		}
+		bounds := map[string]string{
+			ViewportMobile:  "< 768",
+			ViewportTablet:  "BETWEEN 768 AND 1023.99",
+			ViewportDesktop: ">= 1024",
+		}[r.Viewport]
+		viewport = fmt.Sprintf(" AND %s %s", viewportWidth.sql, bounds)
+		args = append(args, viewportWidth.args...)
+	}
+
+	return fmt.Sprintf(`page_clicks AS (
+			SELECT
+				events.id,
+				events.session_id,
+				events.created_at,
+				events.event_type = '%s' AS dead,
+				%s AS x,
+				%s AS y,
+				%s AS width,
+				COALESCE(events.properties ->> 'selector', '') AS selector,
+				COALESCE(events.properties ->> 'element_tag', '') AS element_tag,
+				COALESCE(events.properties ->> 'element_text', '') AS element_text
+			FROM events
+			WHERE %s AND events.event_type IN (?, ?) AND regexp_replace(events.page_url, ?, '') = ?%s
+		),
+		clicks AS (
+			SELECT * FROM page_clicks WHERE NOT dead
+		),
+		click_gaps AS (
+			SELECT clicks.*, CASE
+				WHEN clicks.selector != '' AND clicks.selector = LAG(clicks.selector) OVER w
+					AND clicks.created_at - LAG(clicks.created_at) OVER w <= INTERVAL '%s' THEN 0
+				ELSE 1
+			END AS new_burst
+			FROM clicks
+			WINDOW w AS (PARTITION BY clicks.session_id ORDER BY clicks.created_at, clicks.id)
+		),
+		click_bursts AS (
+			SELECT click_gaps.*, SUM(new_burst) OVER (PARTITION BY session_id ORDER BY created_at, id) AS burst
+			FROM click_gaps
+		),
+		rage AS (
+			SELECT session_id, burst, MIN(selector) AS selector, COUNT(*) AS clicks
+			FROM click_bursts
+			WHERE selector != ''
+			GROUP BY session_id, burst
+			HAVING COUNT(*) >= %d
+		)`, EventTypeDeadClick, columns[0], columns[1], columns[2], where, viewport, rageClickGap, rageClickMinClicks), args, nil
+}
+
+// GetHeatmap returns the click density grid, top clicked elements and frustration signals of a page
+func (s *AnalyticsService) GetHeatmap(q AnalyticsQuery, r *HeatmapRequest) (*Heatmap, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	cte, args, err := q.heatmapCTE(r)
+	if err != nil {
+		return nil, err
+	}
+
+	heatmap := &Heatmap{
+		PageURL:  stripPageURLQuery(r.PageURL),
+		Viewport: r.Viewport,
+		Position: r.Position,
+		Grid:     HeatmapGrid{Columns: r.Columns, RowHeight: r.RowHeight, Cells: []HeatmapCell{}},
+		Elements: []HeatmapElement{},
+	}
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT
+			(SELECT COUNT(*) FROM clicks) AS clicks,
+			(SELECT COUNT(DISTINCT session_id) FROM clicks) AS sessions,
+			(SELECT COUNT(*) FROM rage) AS rage_clicks,
+			(SELECT COUNT(DISTINCT session_id) FROM rage) AS rage_click_sessions,
+			(SELECT COUNT(*) FROM page_clicks WHERE dead) AS dead_clicks,
+			(SELECT COUNT(DISTINCT session_id) FROM page_clicks WHERE dead) AS dead_click_sessions
+	`, cte), args...).Scan(&heatmap.HeatmapTotals).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Clicks past the last row are left out of the grid
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT
+			CAST(LEAST(FLOOR(x / width * ?), ?) AS integer) AS column,
+			CAST(FLOOR(y / ?) AS integer) AS row,
+			COUNT(*) AS clicks
+		FROM clicks
+		WHERE x >= 0 AND y >= 0 AND width > 0 AND y < ?
+		GROUP BY 1, 2
+		ORDER BY 2, 1
+	`, cte), append(args, r.Columns, r.Columns-1, r.RowHeight, r.RowHeight*maxHeatmapRows)...).Scan(&heatmap.Grid.Cells).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, cell := range heatmap.Grid.Cells {
+		if cell.Clicks > heatmap.Grid.MaxClicks {
+			heatmap.Grid.MaxClicks = cell.Clicks
+		}
+		if cell.Row+1 > heatmap.Grid.Rows {
+			heatmap.Grid.Rows = cell.Row + 1
+		}
+	}
+
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		elements AS (
+			SELECT
+				selector,
+				MIN(element_tag) AS element_tag,
+				MIN(element_text) AS element_text,
+				COUNT(*) FILTER (WHERE NOT dead) AS clicks,
+				COUNT(DISTINCT session_id) FILTER (WHERE NOT dead) AS sessions,
+				COUNT(*) FILTER (WHERE dead) AS dead_clicks
+			FROM page_clicks
+			WHERE selector != ''
+			GROUP BY selector
+		),
+		rage_elements AS (
+			SELECT selector, COUNT(*) AS rage_clicks FROM rage GROUP BY selector
+		)
+		SELECT elements.*, COALESCE(rage_elements.rage_clicks, 0) AS rage_clicks
+		FROM elements
+		LEFT JOIN rage_elements ON rage_elements.selector = elements.selector
+		ORDER BY elements.clicks DESC, elements.selector
+		LIMIT ?
+	`, cte), append(args, r.Limit)...).Scan(&heatmap.Elements).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return heatmap, nil
+}
@@ -0,0 +1,544 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Insight metrics
+const (
+	MetricCount          = "count"
+	MetricUniqueUsers    = "unique_users"
+	MetricUniqueSessions = "unique_sessions"
+	MetricUniqueGroups   = "unique_groups"
+	MetricConversions    = "conversions"
+	MetricConversionRate = "conversion_rate"
+	MetricSum            = "sum"
+	MetricAvg            = "avg"
+	MetricMin            = "min"
+	MetricMax            = "max"
+	MetricP50            = "p50"
+	MetricP75            = "p75"
+	MetricP90            = "p90"
+	MetricP95            = "p95"
+	MetricP99            = "p99"
+)
+
+// Insight filter operators, on top of the property filter operators
+const (
+	InsightRegex    = "regex"
+	InsightIsSet    = "is_set"
+	InsightIsNotSet = "is_not_set"
+)
+
+const (
+	maxInsightGroupBy       = 3
+	maxInsightFilters       = 20
+	defaultInsightLimit     = 1000
+	maxInsightLimit         = 10000
+	maxInsightRegexLength   = 256
+	insightStatementTimeout = 10 * time.Second
+)
+
+// propertyPrefix marks a field as a key path inside the event properties
+const propertyPrefix = "properties."
+
+var (
+	propertyKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_$\-]{1,64}$`)
+
+	// numericPattern guards the cast of property values to numbers
+	numericPattern = `^\s*-{0,1}[0-9]+(\.[0-9]+){0,1}([eE][-+]{0,1}[0-9]+){0,1}\s*$`
+
+	aggregateMetrics = map[string]string{
+		MetricSum: "SUM(%s)",
+		MetricAvg: "AVG(%s)",
+		MetricMin: "MIN(%s)",
+		MetricMax: "MAX(%s)",
+		MetricP50: "PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY %s)",
+		MetricP75: "PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY %s)",
+		MetricP90: "PERCENTILE_CONT(0.9) WITHIN GROUP (ORDER BY %s)",
+		MetricP95: "PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY %s)",
+		MetricP99: "PERCENTILE_CONT(0.99) WITHIN GROUP (ORDER BY %s)",
+	}
+)
+
+// InsightMetric is the value an insight computes. Aggregates other than the counts
+// take a numeric property; non-numeric values are ignored. unique_groups counts the
+// groups of GroupType. conversions and conversion_rate count the sessions of the matching
+// events that completed the goal_id of the query.
+type InsightMetric struct {
+	Type      string `json:"type"`
+	Property  string `json:"property,omitempty"`
+	GroupType string `json:"group_type,omitempty"`
+}
+
+// InsightFilter restricts the events of an insight. Field is a dimension such as
+// "country" or a property path such as "properties.plan".
+type InsightFilter struct {
+	Field    string      `json:"field"`
+	Operator string      `json:"operator,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+// InsightRequest is an ad-hoc query over events
+type InsightRequest struct {
+	Metric   InsightMetric   `json:"metric"`
+	Filters  []InsightFilter `json:"filters,omitempty"`
+	GroupBy  []string        `json:"group_by,omitempty"`
+	Interval string          `json:"interval,omitempty"`
+	Limit    int             `json:"limit,omitempty"`
+}
+
+// InsightRow is one bucket and group combination of an insight
+type InsightRow struct {
+	Bucket *time.Time        `json:"bucket,omitempty"`
+	Group  map[string]string `json:"group,omitempty"`
+	Value  *float64          `json:"value"`
+}
+
+// InsightResult holds the rows of an insight
+type InsightResult struct {
+	Metric    InsightMetric `json:"metric"`
+	GroupBy   []string      `json:"group_by"`
+	Interval  string        `json:"interval,omitempty"`
+	Rows      []InsightRow  `json:"rows"`
+	Truncated bool          `json:"truncated"`
+}
+
+// sqlFragment is a piece of SQL with its bind arguments in order
+type sqlFragment struct {
+	sql  string
+	args []interface{}
+}
+
+// Validate checks the metric, filters, group-by fields, interval and limit
+func (r *InsightRequest) Validate() error {
+	switch r.Metric.Type {
+	case MetricCount, MetricUniqueUsers, MetricUniqueSessions, MetricConversions, MetricConversionRate:
+	case MetricUniqueGroups:
+		if !groupTypePattern.MatchString(r.Metric.GroupType) {
+			return fmt.Errorf("metric %q requires a group_type", r.Metric.Type)
+		}
+	default:
+		if _, ok := aggregateMetrics[r.Metric.Type]; !ok {
+			return fmt.Errorf("unknown metric %q", r.Metric.Type)
+		}
+		if !isPropertyField(r.Metric.Property) {
+			return fmt.Errorf("metric %q requires a property such as properties.amount", r.Metric.Type)
+		}
+	}
+	if r.Metric.Property != "" {
+		if _, err := propertyPath(r.Metric.Property); err != nil {
+			return err
+		}
+	}
+
+	if len(r.Filters) > maxInsightFilters {
+		return fmt.Errorf("at most %d filters are allowed", maxInsightFilters)
+	}
+	for _, filter := range r.Filters {
+		if _, err := filter.fragment(); err != nil {
+			return err
+		}
+	}
+
+	if len(r.GroupBy) > maxInsightGroupBy {
+		return fmt.Errorf("at most %d group_by fields are allowed", maxInsightGroupBy)
+	}
+	seen := make(map[string]bool)
+	for _, field := range r.GroupBy {
+		if _, err := fieldText(field); err != nil {
+			return err
+		}
+		if seen[field] {
+			return fmt.Errorf("duplicate group_by field %q", field)
+		}
+		seen[field] = true
+	}
+
+	switch r.Interval {
+	case "", GranularityMinute, GranularityHour, GranularityDay, GranularityWeek, GranularityMonth:
+	default:
+		return fmt.Errorf("unknown interval %q", r.Interval)
+	}
+	if r.Limit < 0 || r.Limit > maxInsightLimit {
+		return fmt.Errorf("limit must be between 0 (default) and %d", maxInsightLimit)
+	}
+	return nil
+}
+
+// GetInsight compiles the request to a single parameterized query. Field names only ever
+// reach the SQL through the dimension whitelist or as bind arguments, and the query runs
+// under a statement timeout over a bounded window.
+func (s *AnalyticsService) GetInsight(q AnalyticsQuery, req *InsightRequest) (*InsightResult, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultInsightLimit
+	}
+	// Without a window the query would scan the project's whole history; unbucketed
+	// insights default to the last 30 days
+	window := req.Interval
+	if window == "" {
+		window = GranularityDay
+	}
+	q = WithDefaultWindow(q, window)
+	if req.Interval != "" {
+		if _, err := timeBuckets(q.From, q.To, q.location(), req.Interval); err != nil {
+			return nil, err
+		}
+	}
+
+	var selects, groups []string
+	var selectArgs []interface{}
+
+	// Conversion metrics count the sessions of the matching events that completed the goal
+	with := ""
+	if req.Metric.Type == MetricConversions || req.Metric.Type == MetricConversionRate {
+		if q.Goal == nil {
+			return nil, fmt.Errorf("metric %q: %w", req.Metric.Type, ErrGoalRequired)
+		}
+		cte, cteArgs := q.convertedSessionsCTE()
+		with = "WITH " + cte + " "
+		selectArgs = append(selectArgs, cteArgs...)
+	}
+
+	if req.Interval != "" {
+		selects = append(selects, fmt.Sprintf("TO_CHAR(date_trunc('%s', events.created_at AT TIME ZONE ?), 'YYYY-MM-DD\"T\"HH24:MI:SS') AS bucket", req.Interval))
+		selectArgs = append(selectArgs, q.Timezone())
+		groups = append(groups, "bucket")
+	}
+	for i, field := range req.GroupBy {
+		text, _ := fieldText(field)
+		selects = append(selects, fmt.Sprintf("COALESCE(NULLIF(%s, ''), '%s') AS group_%d", text.sql, BreakdownNone, i))
+		selectArgs = append(selectArgs, text.args...)
+		groups = append(groups, fmt.Sprintf("group_%d", i))
+	}
+	metric, err := req.Metric.fragment(q)
+	if err != nil {
+		return nil, err
+	}
+	selects = append(selects, metric.sql+" AS value")
+	selectArgs = append(selectArgs, metric.args...)
+
+	where, whereArgs := q.conditions(true)
+	for _, filter := range req.Filters {
+		fragment, _ := filter.fragment()
+		where += " AND " + fragment.sql
+		whereArgs = append(whereArgs, fragment.args...)
+	}
+
+	query := fmt.Sprintf("%sSELECT %s FROM events WHERE %s", with, strings.Join(selects, ", "), where)
+	if len(groups) > 0 {
+		query += " GROUP BY " + strings.Join(groups, ", ")
+	}
+	var order []string
+	if req.Interval != "" {
+		order = append(order, "bucket")
+	}
+	order = append(order, "value DESC NULLS LAST")
+	for i := range req.GroupBy {
+		order = append(order, fmt.Sprintf("group_%d", i))
+	}
+	query += " ORDER BY " + strings.Join(order, ", ")
+	query += fmt.Sprintf(" LIMIT %d", limit+1)
+	args := append(selectArgs, whereArgs...)
+
+	result := &InsightResult{
+		Metric:   req.Metric,
+		GroupBy:  req.GroupBy,
+		Interval: req.Interval,
+		Rows:     []InsightRow{},
+	}
+	if result.GroupBy == nil {
+		result.GroupBy = []string{}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", insightStatementTimeout.Milliseconds())).Error; err != nil {
+			return err
+		}
+
+		rows, err := tx.Raw(query, args...).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var bucket sql.NullString
+			values := make([]sql.NullString, len(req.GroupBy))
+			var value sql.NullFloat64
+
+			dest := make([]interface{}, 0, len(values)+2)
+			if req.Interval != "" {
+				dest = append(dest, &bucket)
+			}
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+			dest = append(dest, &value)
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+
+			if len(result.Rows) == limit {
+				result.Truncated = true
+				break
+			}
+
+			row := InsightRow{}
+			if bucket.Valid {
+				if t, err := time.ParseInLocation(bucketLayout, bucket.String, q.location()); err == nil {
+					row.Bucket = &t
+				}
+			}
+			if len(values) > 0 {
+				row.Group = make(map[string]string, len(values))
+				for i, field := range req.GroupBy {
+					row.Group[field] = values[i].String
+				}
+			}
+			if value.Valid {
+				v := value.Float64
+				row.Value = &v
+			}
+			result.Rows = append(result.Rows, row)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// convertedSessionCount counts the sessions in converted_sessions, which the query must define
+const convertedSessionCount = "COUNT(DISTINCT events.session_id) FILTER (WHERE events.session_id IN (SELECT session_id FROM converted_sessions))"
+
+// fragment renders the metric aggregate. unique_users counts groups when the query
+// aggregates by a group type.
+func (m InsightMetric) fragment(q AnalyticsQuery) (sqlFragment, error) {
+	switch m.Type {
+	case MetricCount:
+		return sqlFragment{sql: "COUNT(*)"}, nil
+	case MetricUniqueUsers:
+		return sqlFragment{sql: fmt.Sprintf("COUNT(DISTINCT %s)", q.uniqueUserColumn())}, nil
+	case MetricUniqueSessions:
+		return sqlFragment{sql: "COUNT(DISTINCT events.session_id)"}, nil
+	case MetricConversions:
+		return sqlFragment{sql: convertedSessionCount}, nil
+	case MetricConversionRate:
+		return sqlFragment{sql: convertedSessionCount + "::float / NULLIF(COUNT(DISTINCT events.session_id), 0)"}, nil
+	case MetricUniqueGroups:
+		if !groupTypePattern.MatchString(m.GroupType) {
+			return sqlFragment{}, fmt.Errorf("metric %q requires a group_type", m.Type)
+		}
+		return sqlFragment{sql: fmt.Sprintf("COUNT(DISTINCT %s)", groupKeyColumn(m.GroupType))}, nil
+	}
+
+	aggregate, ok := aggregateMetrics[m.Type]
+	if !ok {
+		return sqlFragment{}, fmt.Errorf("unknown metric %q", m.Type)
+	}
+	number, err := propertyNumber(m.Property)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+	return sqlFragment{sql: fmt.Sprintf(aggregate, number.sql), args: number.args}, nil
+}
+
+// fragment renders the filter as a boolean SQL condition
+func (f InsightFilter) fragment() (sqlFragment, error) {
+	text, err := fieldText(f.Field)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+
+	operator := f.Operator
+	if operator == "" {
+		operator = PropertyEquals
+	}
+
+	switch operator {
+	case PropertyEquals, PropertyNotEquals, PropertyContains, PropertyNotContains, InsightRegex:
+		if f.Value == nil {
+			return sqlFragment{}, fmt.Errorf("filter on %q requires a value", f.Field)
+		}
+	}
+
+	value := toSQLString(f.Value)
+	switch operator {
+	case PropertyEquals:
+		return sqlFragment{sql: text.sql + " = ?", args: append(text.args, value)}, nil
+	case PropertyNotEquals:
+		return sqlFragment{sql: text.sql + " IS DISTINCT FROM ?", args: append(text.args, value)}, nil
+	case PropertyContains:
+		return sqlFragment{sql: text.sql + " ILIKE ?", args: append(text.args, "%"+escapeLike(value)+"%")}, nil
+	case PropertyNotContains:
+		return sqlFragment{sql: "COALESCE(" + text.sql + " NOT ILIKE ?, TRUE)", args: append(text.args, "%"+escapeLike(value)+"%")}, nil
+	case InsightRegex:
+		if len(value) > maxInsightRegexLength {
+			return sqlFragment{}, fmt.Errorf("regex on %q is longer than %d characters", f.Field, maxInsightRegexLength)
+		}
+		if _, err := regexp.Compile(value); err != nil {
+			return sqlFragment{}, fmt.Errorf("invalid regex on %q: %v", f.Field, err)
+		}
+		return sqlFragment{sql: text.sql + " ~ ?", args: append(text.args, value)}, nil
+	case PropertyIn, PropertyNotIn:
+		list, ok := f.Value.([]interface{})
+		if !ok || len(list) == 0 {
+			return sqlFragment{}, fmt.Errorf("filter on %q requires a non-empty list value", f.Field)
+		}
+		values := make([]string, 0, len(list))
+		for _, item := range list {
+			values = append(values, toSQLString(item))
+		}
+		if operator == PropertyIn {
+			return sqlFragment{sql: text.sql + " IN ?", args: append(text.args, values)}, nil
+		}
+		return sqlFragment{sql: "COALESCE(" + text.sql + " NOT IN ?, TRUE)", args: append(text.args, values)}, nil
+	case PropertyGreater, PropertyGreaterOrEq, PropertyLess, PropertyLessOrEq:
+		threshold, ok := toFloat(f.Value)
+		if !ok {
+			return sqlFragment{}, fmt.Errorf("filter on %q requires a numeric value", f.Field)
+		}
+		if !isPropertyField(f.Field) {
+			return sqlFragment{}, fmt.Errorf("operator %q requires a property field", operator)
+		}
+		number, err := propertyNumber(f.Field)
+		if err != nil {
+			return sqlFragment{}, err
+		}
+		comparison := map[string]string{PropertyGreater: ">", PropertyGreaterOrEq: ">=", PropertyLess: "<", PropertyLessOrEq: "<="}[operator]
+		return sqlFragment{sql: fmt.Sprintf("%s %s ?", number.sql, comparison), args: append(number.args, threshold)}, nil
+	case InsightIsSet, PropertyExists:
+		return sqlFragment{sql: "NULLIF(" + text.sql + ", '') IS NOT NULL", args: text.args}, nil
+	case InsightIsNotSet, PropertyNotExists:
+		return sqlFragment{sql: "NULLIF(" + text.sql + ", '') IS NULL", args: text.args}, nil
+	}
+	return sqlFragment{}, fmt.Errorf("unknown filter operator %q", operator)
+}
+
+// filtersCondition renders the AND of the filters, or TRUE when there are none
+func filtersCondition(filters []InsightFilter) (sqlFragment, error) {
+	if len(filters) == 0 {
+		return sqlFragment{sql: "TRUE"}, nil
+	}
+	var clauses []string
+	var args []interface{}
+	for _, filter := range filters {
+		fragment, err := filter.fragment()
+		if err != nil {
+			return sqlFragment{}, err
+		}
+		clauses = append(clauses, fragment.sql)
+		args = append(args, fragment.args...)
+	}
+	return sqlFragment{sql: "(" + strings.Join(clauses, " AND ") + ")", args: args}, nil
+}
+
+// fieldText renders a dimension column, a property value, a user trait, a group or a session
+// field as text
+func fieldText(field string) (sqlFragment, error) {
+	if isTraitField(field) {
+		return traitText(field)
+	}
+	if isGroupField(field) {
+		return groupText(field)
+	}
+	if isSessionField(field) {
+		return sessionText(field)
+	}
+	if !isPropertyField(field) {
+		column, ok := dimensionColumns[field]
+		if !ok {
+			return sqlFragment{}, fmt.Errorf("unknown field %q", field)
+		}
+		return sqlFragment{sql: column}, nil
+	}
+
+	keys, err := propertyPath(field)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+	// Every key is a bind argument: properties -> key ... ->> last key
+	sql := "events.properties"
+	args := make([]interface{}, 0, len(keys))
+	for i, key := range keys {
+		if i == len(keys)-1 {
+			sql += " ->> CAST(? AS text)"
+		} else {
+			sql += " -> CAST(? AS text)"
+		}
+		args = append(args, key)
+	}
+	return sqlFragment{sql: "(" + sql + ")", args: args}, nil
+}
+
+// propertyNumber renders a property value as a number, NULL when it is not numeric
+func propertyNumber(field string) (sqlFragment, error) {
+	text, err := fieldText(field)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+	args := append(append(append([]interface{}{}, text.args...), numericPattern), text.args...)
+	return sqlFragment{
+		sql:  fmt.Sprintf("(CASE WHEN %s ~ ? THEN CAST(%s AS double precision) END)", text.sql, text.sql),
+		args: args,
+	}, nil
+}
+
+// propertyPath splits "properties.a.b" into its keys
+func propertyPath(field string) ([]string, error) {
+	if !isPropertyField(field) {
+		return nil, fmt.Errorf("%q is not a property field", field)
+	}
+	keys := strings.Split(strings.TrimPrefix(field, propertyPrefix), ".")
+	if len(keys) > 5 {
+		return nil, errors.New("property paths are limited to 5 levels")
+	}
+	for _, key := range keys {
+		if !propertyKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid property key %q", key)
+		}
+	}
+	return keys, nil
+}
+
+func isPropertyField(field string) bool {
+	return strings.HasPrefix(field, propertyPrefix)
+}
+
+// toSQLString renders a JSON value the way Postgres ->> renders it
+func toSQLString(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case bool:
+		if value {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// escapeLike escapes the LIKE wildcards of a literal
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Lifecycle states of an active person
+const (
+	LifecycleNew          = "new"
+	LifecycleReturning    = "returning"
+	LifecycleResurrecting = "resurrecting"
+	LifecycleDormant      = "dormant"
+)
+
+// LifecycleMetrics are the metrics of a lifecycle time series
+var LifecycleMetrics = []string{LifecycleNew, LifecycleReturning, LifecycleResurrecting, LifecycleDormant, "active", "quick_ratio"}
+
+// lifecycleIntervals maps the lifecycle intervals to a Postgres interval literal
+var lifecycleIntervals = map[string]string{
+	GranularityDay:   "1 day",
+	GranularityWeek:  "1 week",
+	GranularityMonth: "1 month",
+}
+
+// LifecycleRequest selects the interval and the events that make a person active. Every
+// event but flag exposures counts when there are no filters.
+type LifecycleRequest struct {
+	Interval string          `json:"interval,omitempty"`
+	Filters  []InsightFilter `json:"filters,omitempty"`
+}
+
+type lifecycleRow struct {
+	Bucket string
+	Status string
+	People int64
+}
+
+// Validate checks the interval and the filters
+func (r *LifecycleRequest) Validate() error {
+	if r.Interval != "" {
+		if _, ok := lifecycleIntervals[r.Interval]; !ok {
+			return fmt.Errorf("unknown lifecycle interval %q", r.Interval)
+		}
+	}
+	if len(r.Filters) > maxInsightFilters {
+		return fmt.Errorf("at most %d filters are allowed", maxInsightFilters)
+	}
+	_, err := filtersCondition(r.Filters)
+	return err
+}
+
+// GetLifecycle splits the people active in each period into new (first ever active in the
+// period), returning (also active in the previous period) and resurrecting (active before,
+// but not in the previous period), and counts as dormant the people active in the previous
+// period but not in this one.
+//
+// The users table keeps first_seen and last_seen across all projects, stamped at ingestion,
+// and knows nothing of anonymous people, so the states are derived from the project's events:
+// a person's first period is that of their first matching event.
+func (s *AnalyticsService) GetLifecycle(q AnalyticsQuery, req *LifecycleRequest) (*TimeSeries, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("lifecycle reports require a project")
+	}
+	interval := req.Interval
+	if interval == "" {
+		interval = GranularityDay
+	}
+	step, ok := lifecycleIntervals[interval]
+	if !ok {
+		return nil, fmt.Errorf("unknown lifecycle interval %q", interval)
+	}
+	filters, err := activeCondition(req.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	q = WithDefaultWindow(q, interval)
+	buckets, err := timeBuckets(q.From, q.To, q.location(), interval)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first period is compared with the one before the window
+	previous := q
+	previous.From = previousBucket(truncateTime(q.From, q.location(), interval), interval)
+	activeWhere, activeArgs := previous.conditions(true)
+	history := q
+	history.From = time.Time{}
+	historyWhere, historyArgs := history.conditions(true)
+
+	periodExpr := fmt.Sprintf("date_trunc('%s', %%s AT TIME ZONE ?)", interval)
+	args := []interface{}{*q.ProjectID, q.Timezone()}
+	args = append(args, activeArgs...)
+	args = append(args, filters.args...)
+	args = append(args, q.Timezone())
+	args = append(args, historyArgs...)
+	args = append(args, filters.args...)
+
+	var rows []lifecycleRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		active AS (
+			SELECT DISTINCT %s AS person, %s AS period
+			FROM events
+			%s
+			WHERE %s AND %s
+		),
+		firsts AS (
+			SELECT %s AS person, %s AS first_period
+			FROM events
+			%s
+			WHERE %s AND %s
+			GROUP BY 1
+		),
+		states AS (
+			SELECT this_period.period,
+				CASE
+					WHEN firsts.first_period = this_period.period THEN '%s'
+					WHEN last_period.person IS NOT NULL THEN '%s'
+					ELSE '%s'
+				END AS status
+			FROM active AS this_period
+			JOIN firsts ON firsts.person = this_period.person
+			LEFT JOIN active AS last_period ON last_period.person = this_period.person AND last_period.period = this_period.period - interval '%s'
+			UNION ALL
+			SELECT last_period.period + interval '%s', '%s'
+			FROM active AS last_period
+			LEFT JOIN active AS this_period ON this_period.person = last_period.person AND this_period.period = last_period.period + interval '%s'
+			WHERE this_period.person IS NULL
+		)
+		SELECT TO_CHAR(period, 'YYYY-MM-DD"T"HH24:MI:SS') AS bucket, status, COUNT(*) AS people
+		FROM states
+		GROUP BY 1, 2
+	`,
+		sessionPeopleCTE,
+		q.actorColumn(), fmt.Sprintf(periodExpr, "events.created_at"), sessionPeopleJoin, activeWhere, filters.sql,
+		q.actorColumn(), fmt.Sprintf(periodExpr, "MIN(events.created_at)"), sessionPeopleJoin, historyWhere, filters.sql,
+		LifecycleNew, LifecycleReturning, LifecycleResurrecting,
+		step, step, LifecycleDormant, step,
+	), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byBucket := make(map[string]map[string]float64, len(buckets))
+	for _, row := range rows {
+		if byBucket[row.Bucket] == nil {
+			byBucket[row.Bucket] = make(map[string]float64)
+		}
+		byBucket[row.Bucket][row.Status] = float64(row.People)
+	}
+
+	series := &TimeSeries{
+		Granularity: interval,
+		Metrics:     LifecycleMetrics,
+		Points:      make([]TimeSeriesPoint, 0, len(buckets)),
+	}
+	totals := make(map[string]float64)
+	for _, bucket := range buckets {
+		counts := byBucket[bucket.Format(bucketLayout)]
+		values := lifecycleValues(counts)
+		for _, state := range []string{LifecycleNew, LifecycleReturning, LifecycleResurrecting, LifecycleDormant} {
+			totals[state] += values[state]
+		}
+		series.Points = append(series.Points, TimeSeriesPoint{Bucket: bucket, Values: values})
+	}
+	series.Totals = lifecycleValues(totals)
+
+	return series, nil
+}
+
+// lifecycleValues completes the state counts of a period with the active people and the
+// quick ratio, (new + resurrecting) / dormant, which is 0 when nobody went dormant
+func lifecycleValues(counts map[string]float64) map[string]float64 {
+	values := map[string]float64{
+		LifecycleNew:          counts[LifecycleNew],
+		LifecycleReturning:    counts[LifecycleReturning],
+		LifecycleResurrecting: counts[LifecycleResurrecting],
+		LifecycleDormant:      counts[LifecycleDormant],
+	}
+	values["active"] = values[LifecycleNew] + values[LifecycleReturning] + values[LifecycleResurrecting]
+	values["quick_ratio"] = 0
+	if values[LifecycleDormant] > 0 {
+		values["quick_ratio"] = (values[LifecycleNew] + values[LifecycleResurrecting]) / values[LifecycleDormant]
+	}
+	return values
+}
+
+// previousBucket returns the start of the bucket before start
+func previousBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		return start.AddDate(0, 0, -7)
+	case GranularityMonth:
+		return start.AddDate(0, -1, 0)
+	}
+	return start.AddDate(0, 0, -1)
+}
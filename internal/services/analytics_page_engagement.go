@@ -0,0 +1,187 @@
+package services
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Page engagement event types of the tracking script
+const (
+	EventTypePageView   = "page_view"
+	EventTypeScroll     = "scroll"     // a scroll depth milestone, with a depth property of 25, 50, 75 or 100
+	EventTypeEngagement = "engagement" // an engaged time heartbeat, with engaged_seconds and scroll_depth properties
+)
+
+// interactionCondition excludes the scroll, engagement and error events, which the tracking
+// script sends on its own while a page is open, the dead clicks it sends on top of the clicks
+// they follow, and the flag exposures the server records whenever flags are evaluated. They
+// don't make a session a non-bounce and are left out of event counts.
+var interactionCondition = fmt.Sprintf("events.event_type NOT IN ('%s', '%s', '%s', '%s', '%s')", EventTypeScroll, EventTypeEngagement, EventTypeError, EventTypeDeadClick, eventTypeExposure)
+
+// interactionEvents is a scope keeping the events sent by the visitor's actions
+func interactionEvents(db *gorm.DB) *gorm.DB {
+	return db.Where(interactionCondition)
+}
+
+// interactionCount is how much an event adds to the event count of its session and user
+func interactionCount(eventType string) int {
+	switch eventType {
+	case EventTypeScroll, EventTypeEngagement, EventTypeError, EventTypeDeadClick, eventTypeExposure:
+		return 0
+	}
+	return 1
+}
+
+const maxPageEngagementPages = 100
+
+// scrollDepthMilestones are the depths, in percent of the page, the distribution is bucketed by
+var scrollDepthMilestones = []int{0, 25, 50, 75, 100}
+
+// PageEngagementRequest selects the pages of a page engagement report. PageURL restricts the
+// report to one page; query strings and fragments of page URLs are ignored.
+type PageEngagementRequest struct {
+	PageURL string
+	Limit   int
+}
+
+// ScrollDepthBucket counts the page views whose deepest scroll fell in a bucket: Depth up to
+// the next milestone. ReachedShare is the share of page views that scrolled at least Depth.
+type ScrollDepthBucket struct {
+	Depth        int     `json:"depth"`
+	PageViews    int64   `json:"page_views"`
+	Share        float64 `json:"share"`
+	ReachedShare float64 `json:"reached_share"`
+}
+
+// PageEngagement summarises how far a page is scrolled and how long it is engaged with.
+// Depths are percents of the page and engaged times are seconds the tab was visible.
+type PageEngagement struct {
+	PageURL           string              `json:"page_url"`
+	PageViews         int64               `json:"page_views"`
+	AvgScrollDepth    float64             `json:"avg_scroll_depth"`
+	ScrollDepth       []ScrollDepthBucket `json:"scroll_depth"`
+	AvgEngagedTime    float64             `json:"avg_engaged_time"`
+	MedianEngagedTime float64             `json:"median_engaged_time"`
+}
+
+type pageEngagementRow struct {
+	PageURL           string
+	PageViews         int64
+	AvgScrollDepth    float64
+	Depth0            int64
+	Depth25           int64
+	Depth50           int64
+	Depth75           int64
+	Depth100          int64
+	AvgEngagedTime    float64
+	MedianEngagedTime float64
+}
+
+// GetPageEngagement returns the scroll depth and engaged time of the most viewed pages. A page
+// view is identified by the page_view_id the tracking script sends with its page view,
+// scroll and heartbeat events; page views without scroll or heartbeat events count as
+// unscrolled and not engaged.
+func (s *AnalyticsService) GetPageEngagement(q AnalyticsQuery, req *PageEngagementRequest) ([]PageEngagement, error) {
+	if req.Limit < 1 || req.Limit > maxPageEngagementPages {
+		req.Limit = 10
+	}
+
+	var numbers []string
+	var args []interface{}
+	for _, field := range []string{"properties.depth", "properties.scroll_depth", "properties.engaged_seconds"} {
+		number, err := propertyNumber(field)
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number.sql)
+		args = append(args, number.args...)
+	}
+
+	where, whereArgs := q.conditions(true)
+	args = append(append([]interface{}{pageURLQueryPattern}, args...), whereArgs...)
+	args = append(args, EventTypePageView, EventTypeScroll, EventTypeEngagement)
+	page := ""
+	if req.PageURL != "" {
+		page = " AND regexp_replace(events.page_url, ?, '') = ?"
+		args = append(args, pageURLQueryPattern, stripPageURLQuery(req.PageURL))
+	}
+	args = append(args, EventTypeScroll, EventTypeEngagement, EventTypeEngagement, EventTypePageView, req.Limit)
+
+	// Depths are clamped to 0-100 and the deepest of the milestones and heartbeats is kept
+	var rows []pageEngagementRow
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH page_events AS (
+			SELECT
+				regexp_replace(events.page_url, ?, '') AS page_url,
+				events.session_id,
+				COALESCE(events.properties ->> 'page_view_id', '') AS page_view_id,
+				events.event_type,
+				%s AS depth,
+				%s AS scroll_depth,
+				%s AS engaged_seconds
+			FROM events
+			WHERE %s AND events.event_type IN (?, ?, ?) AND events.page_url IS NOT NULL%s
+		),
+		page_views AS (
+			SELECT
+				page_url,
+				LEAST(GREATEST(COALESCE(MAX(CASE
+					WHEN event_type = ? THEN depth
+					WHEN event_type = ? THEN scroll_depth
+				END), 0), 0), 100) AS depth,
+				COALESCE(SUM(engaged_seconds) FILTER (WHERE event_type = ? AND engaged_seconds > 0), 0) AS engaged_time
+			FROM page_events
+			GROUP BY page_url, session_id, page_view_id
+			HAVING COUNT(*) FILTER (WHERE event_type = ?) > 0
+		)
+		SELECT
+			page_url,
+			COUNT(*) AS page_views,
+			AVG(depth) AS avg_scroll_depth,
+			COUNT(*) FILTER (WHERE depth < 25) AS depth0,
+			COUNT(*) FILTER (WHERE depth >= 25 AND depth < 50) AS depth25,
+			COUNT(*) FILTER (WHERE depth >= 50 AND depth < 75) AS depth50,
+			COUNT(*) FILTER (WHERE depth >= 75 AND depth < 100) AS depth75,
+			COUNT(*) FILTER (WHERE depth >= 100) AS depth100,
+			AVG(engaged_time) AS avg_engaged_time,
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY engaged_time) AS median_engaged_time
+		FROM page_views
+		GROUP BY page_url
+		ORDER BY page_views DESC, page_url
+		LIMIT ?
+	`, numbers[0], numbers[1], numbers[2], where, page), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]PageEngagement, 0, len(rows))
+	for _, row := range rows {
+		pages = append(pages, row.engagement())
+	}
+	return pages, nil
+}
+
+func (r pageEngagementRow) engagement() PageEngagement {
+	page := PageEngagement{
+		PageURL:           r.PageURL,
+		PageViews:         r.PageViews,
+		AvgScrollDepth:    r.AvgScrollDepth,
+		AvgEngagedTime:    r.AvgEngagedTime,
+		MedianEngagedTime: r.MedianEngagedTime,
+		ScrollDepth:       make([]ScrollDepthBucket, 0, len(scrollDepthMilestones)),
+	}
+
+	counts := []int64{r.Depth0, r.Depth25, r.Depth50, r.Depth75, r.Depth100}
+	reached := r.PageViews
+	for i, depth := range scrollDepthMilestones {
+		bucket := ScrollDepthBucket{Depth: depth, PageViews: counts[i]}
+		if r.PageViews > 0 {
+			bucket.Share = float64(counts[i]) / float64(r.PageViews)
+			bucket.ReachedShare = float64(reached) / float64(r.PageViews)
+		}
+		reached -= counts[i]
+		page.ScrollDepth = append(page.ScrollDepth, bucket)
+	}
+	return page
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// dimensionColumns maps the dimensions reports can be filtered on to event columns
+var dimensionColumns = map[string]string{
+	"event_type": "events.event_type",
+	"event_name": "events.event_name",
+	"page_url":   "events.page_url",
+	"referrer":   "events.referrer",
+	"country":    "events.country",
+	"city":       "events.city",
+	"platform":   "events.platform",
+	"language":   "events.language",
+	"user_id":    "events.user_id",
+	"session_id": "events.session_id",
+}
+
+// pageURLQueryPattern matches the query string and fragment of a page URL, for
+// regexp_replace in reports grouping page views by page
+const pageURLQueryPattern = `[?#].*$`
+
+// AnalyticsQuery scopes a report to a project, a time window and a reporting timezone
+type AnalyticsQuery struct {
+	ProjectID       *uuid.UUID
+	From            time.Time // inclusive, zero means unbounded
+	To              time.Time // exclusive, zero means unbounded
+	Location        *time.Location
+	IncludeInternal bool
+	Filters         map[string]string   // dimension equality filters, e.g. country=VN
+	In              map[string][]string // dimension membership filters, used to line up comparison rows
+	Now             time.Time           // reference time for "today", zero means time.Now()
+	GroupType       string              // counts the groups of this type instead of people, only over events in such a group
+	Segments        []QuerySegment      // saved segments and cohorts the events must match
+	Goal            *QueryGoal          // adds conversions of this goal to reports
+}
+
+// Timezone returns the IANA name of the reporting timezone
+func (q AnalyticsQuery) Timezone() string {
+	return q.location().String()
+}
+
+func (q AnalyticsQuery) location() *time.Location {
+	if q.Location == nil {
+		return time.UTC
+	}
+	return q.Location
+}
+
+// now returns the reference time of the query
+func (q AnalyticsQuery) now() time.Time {
+	if q.Now.IsZero() {
+		return time.Now()
+	}
+	return q.Now
+}
+
+// Today returns the bounds of the current calendar day in the reporting timezone
+func (q AnalyticsQuery) Today() (time.Time, time.Time) {
+	now := q.now().In(q.location())
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, q.location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+// Validate checks that every filter refers to a known dimension, a user trait or a group,
+// and the aggregation group type
+func (q AnalyticsQuery) Validate() error {
+	if q.GroupType != "" && !groupTypePattern.MatchString(q.GroupType) {
+		return fmt.Errorf("invalid group type %q", q.GroupType)
+	}
+	for dimension := range q.Filters {
+		if _, ok := dimensionColumn(dimension); !ok {
+			return fmt.Errorf("unknown filter dimension %q", dimension)
+		}
+	}
+	for dimension := range q.In {
+		if _, ok := dimensionColumn(dimension); !ok {
+			return fmt.Errorf("unknown filter dimension %q", dimension)
+		}
+	}
+	return nil
+}
+
+// dimensionColumn renders a filter dimension: an events column, a user trait as user.<name>,
+// a group as group.<type> and its properties as group.<type>.<name>, or a column of the
+// event's session as session.<name>
+func dimensionColumn(dimension string) (sqlFragment, bool) {
+	if isTraitField(dimension) {
+		trait, err := traitText(dimension)
+		return trait, err == nil
+	}
+	if isGroupField(dimension) {
+		group, err := groupText(dimension)
+		return group, err == nil
+	}
+	if isSessionField(dimension) {
+		session, err := sessionText(dimension)
+		return session, err == nil
+	}
+	column, ok := dimensionColumns[dimension]
+	return sqlFragment{sql: column}, ok
+}
+
+// scope restricts an events query to the project, window, traffic selection and filters
+func (q AnalyticsQuery) scope(db *gorm.DB) *gorm.DB {
+	sql, args := q.conditions(true)
+	return db.Where(sql, args...)
+}
+
+// projectScope is scope without the time window
+func (q AnalyticsQuery) projectScope(db *gorm.DB) *gorm.DB {
+	sql, args := q.conditions(false)
+	return db.Where(sql, args...)
+}
+
+// conditions renders the query restrictions on the events table as a SQL fragment,
+// for use in hand-written queries
+func (q AnalyticsQuery) conditions(withWindow bool) (string, []interface{}) {
+	sql, args := q.baseConditions(withWindow)
+	if filterSQL, filterArgs := q.filterConditions(); filterSQL != "" {
+		sql += " AND " + filterSQL
+		args = append(args, filterArgs...)
+	}
+	return sql, args
+}
+
+// baseConditions renders the project, traffic and window restrictions without dimension filters
+func (q AnalyticsQuery) baseConditions(withWindow bool) (string, []interface{}) {
+	clauses := []string{"TRUE"}
+	var args []interface{}
+
+	if q.ProjectID != nil {
+		clauses = append(clauses, "events.project_id = ?")
+		args = append(args, *q.ProjectID)
+	}
+	if !q.IncludeInternal {
+		clauses = append(clauses, "events.is_internal = ?")
+		args = append(args, false)
+	}
+	if withWindow && !q.From.IsZero() {
+		clauses = append(clauses, "events.created_at >= ?")
+		args = append(args, q.From)
+	}
+	if withWindow && !q.To.IsZero() {
+		clauses = append(clauses, "events.created_at < ?")
+		args = append(args, q.To)
+	}
+	if q.GroupType != "" {
+		clauses = append(clauses, groupKeyColumn(q.GroupType)+" IS NOT NULL")
+	}
+
+	return strings.Join(clauses, " AND "), args
+}
+
+// filterConditions renders only the dimension filters and segments, or "" when there are none
+func (q AnalyticsQuery) filterConditions() (string, []interface{}) {
+	dimensions := make([]string, 0, len(q.Filters))
+	for dimension := range q.Filters {
+		if _, ok := dimensionColumn(dimension); ok {
+			dimensions = append(dimensions, dimension)
+		}
+	}
+	sort.Strings(dimensions)
+
+	var clauses []string
+	var args []interface{}
+	for _, dimension := range dimensions {
+		column, _ := dimensionColumn(dimension)
+		clauses = append(clauses, column.sql+" = ?")
+		args = append(append(args, column.args...), q.Filters[dimension])
+	}
+
+	inDimensions := make([]string, 0, len(q.In))
+	for dimension := range q.In {
+		if _, ok := dimensionColumn(dimension); ok {
+			inDimensions = append(inDimensions, dimension)
+		}
+	}
+	sort.Strings(inDimensions)
+	for _, dimension := range inDimensions {
+		if len(q.In[dimension]) == 0 {
+			clauses = append(clauses, "FALSE")
+			continue
+		}
+		column, _ := dimensionColumn(dimension)
+		clauses = append(clauses, column.sql+" IN ?")
+		args = append(append(args, column.args...), q.In[dimension])
+	}
+
+	for _, segment := range q.Segments {
+		clauses = append(clauses, "("+segment.condition.sql+")")
+		args = append(args, segment.condition.args...)
+	}
+
+	return strings.Join(clauses, " AND "), args
+}
+
+// ErrGoalRequired is returned for conversion metrics requested without a goal_id
+var ErrGoalRequired = errors.New("goal_id is required")
+
+// convertedSessionsCTE renders converted_sessions, the sessions of the project that completed
+// the goal of the query
+func (q AnalyticsQuery) convertedSessionsCTE() (string, []interface{}) {
+	sql, args := q.convertedSessions()
+	return fmt.Sprintf("converted_sessions AS (%s)", sql), args
+}
+
+// convertedSessions renders a subquery of the sessions of the project that completed the goal
+// of the query. Like goal reports, completions may happen after the window ends but not before
+// it starts.
+func (q AnalyticsQuery) convertedSessions() (string, []interface{}) {
+	clauses := []string{"(" + q.Goal.condition.sql + ")"}
+	args := append([]interface{}{}, q.Goal.condition.args...)
+	if q.ProjectID != nil {
+		clauses = append(clauses, "events.project_id = ?")
+		args = append(args, *q.ProjectID)
+	}
+	if !q.From.IsZero() {
+		clauses = append(clauses, "events.created_at >= ?")
+		args = append(args, q.From)
+	}
+	return "SELECT DISTINCT events.session_id FROM events WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// conversionRate is the share of sessions that converted, 0 without sessions
+func conversionRate(conversions, sessions int64) float64 {
+	if sessions == 0 {
+		return 0
+	}
+	return float64(conversions) / float64(sessions)
+}
+
+// stripPageURLQuery removes the query string and fragment of a page URL
+func stripPageURLQuery(pageURL string) string {
+	if i := strings.IndexAny(pageURL, "?#"); i >= 0 {
+		return pageURL[:i]
+	}
+	return pageURL
+}
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RevenueMetrics are the metrics of a revenue time series
+var RevenueMetrics = []string{"revenue", "refunds", "net_revenue", "orders", "average_order_value"}
+
+// revenueDimensionColumns maps the session attributes revenue can be attributed to
+var revenueDimensionColumns = map[string]string{
+	"channel":      "sessions.channel",
+	"utm_source":   "sessions.utm_source",
+	"utm_medium":   "sessions.utm_medium",
+	"utm_campaign": "sessions.utm_campaign",
+	"landing_page": "sessions.landing_page",
+	"country":      "sessions.country",
+	"device_type":  "sessions.device_type",
+}
+
+// RevenueSummary totals the orders of a window in the project currency. Revenue is gross,
+// refunds are those issued in the window and rates are fractions between 0 and 1.
+type RevenueSummary struct {
+	Currency          string  `json:"currency"`
+	Orders            int64   `json:"orders"`
+	Revenue           float64 `json:"revenue"`
+	Refunds           float64 `json:"refunds"`
+	NetRevenue        float64 `json:"net_revenue"`
+	AverageOrderValue float64 `json:"average_order_value"`
+	Visitors          int64   `json:"visitors"`
+	Purchasers        int64   `json:"purchasers"`
+	RevenuePerVisitor float64 `json:"revenue_per_visitor"`
+	ConversionRate    float64 `json:"conversion_rate"`
+}
+
+// ProductRevenue holds the sales of a product
+type ProductRevenue struct {
+	ProductID   string  `json:"product_id"`
+	ProductName *string `json:"product_name,omitempty"`
+	Category    *string `json:"category,omitempty"`
+	Quantity    int64   `json:"quantity"`
+	Orders      int64   `json:"orders"`
+	Revenue     float64 `json:"revenue"`
+	Refunds     float64 `json:"refunds"`
+	NetRevenue  float64 `json:"net_revenue"`
+}
+
+// AttributedRevenue holds the revenue of the sessions sharing an acquisition attribute
+type AttributedRevenue struct {
+	Value             string  `json:"value"`
+	Orders            int64   `json:"orders"`
+	Revenue           float64 `json:"revenue"`
+	Refunds           float64 `json:"refunds"`
+	NetRevenue        float64 `json:"net_revenue"`
+	AverageOrderValue float64 `json:"average_order_value"`
+}
+
+type revenueBucketRow struct {
+	Bucket  string
+	Orders  int64
+	Revenue float64
+	Refunds float64
+}
+
+// revenueCTEs renders the orders and refunds of the window. Repeated purchase events of an
+// order are counted once; amounts without a conversion to the project currency are left out.
+func (q AnalyticsQuery) revenueCTEs() (string, []interface{}) {
+	where, args := q.conditions(true)
+	sql := fmt.Sprintf(`orders AS (
+		SELECT DISTINCT ON (events.order_id) events.id, events.order_id, events.session_id, events.created_at, events.base_revenue AS revenue
+		FROM events
+		WHERE %s AND events.event_type = '%s' AND events.order_id IS NOT NULL AND events.base_revenue IS NOT NULL
+		ORDER BY events.order_id, events.created_at
+	),
+	refunds AS (
+		SELECT events.id, events.order_id, events.created_at, events.base_revenue AS amount
+		FROM events
+		WHERE %s AND events.event_type = '%s' AND events.order_id IS NOT NULL AND events.base_revenue IS NOT NULL
+	)`, where, EventTypePurchase, where, EventTypeRefund)
+	return sql, append(append([]interface{}{}, args...), args...)
+}
+
+// GetRevenueSummary returns revenue, refunds, average order value and revenue per visitor.
+// Visitors are people, resolved as in funnels and retention.
+func (s *AnalyticsService) GetRevenueSummary(q AnalyticsQuery, currency string) (*RevenueSummary, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("revenue reports require a project")
+	}
+
+	ctes, cteArgs := q.revenueCTEs()
+	where, whereArgs := q.conditions(true)
+	args := append(append([]interface{}{*q.ProjectID}, cteArgs...), whereArgs...)
+
+	summary := &RevenueSummary{Currency: currency}
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH %s, %s
+		SELECT
+			(SELECT COUNT(*) FROM orders) AS orders,
+			(SELECT COALESCE(SUM(revenue), 0) FROM orders) AS revenue,
+			(SELECT COALESCE(SUM(amount), 0) FROM refunds) AS refunds,
+			COUNT(DISTINCT %s) AS visitors,
+			COUNT(DISTINCT %s) FILTER (WHERE events.event_type = '%s' AND events.base_revenue IS NOT NULL) AS purchasers
+		FROM events
+		%s
+		WHERE %s
+	`, sessionPeopleCTE, ctes, q.actorColumn(), q.actorColumn(), EventTypePurchase, sessionPeopleJoin, where), args...).Scan(summary).Error
+	if err != nil {
+		return nil, err
+	}
+
+	summary.NetRevenue = summary.Revenue - summary.Refunds
+	if summary.Orders > 0 {
+		summary.AverageOrderValue = summary.Revenue / float64(summary.Orders)
+	}
+	if summary.Visitors > 0 {
+		summary.RevenuePerVisitor = summary.NetRevenue / float64(summary.Visitors)
+		summary.ConversionRate = float64(summary.Purchasers) / float64(summary.Visitors)
+	}
+	return summary, nil
+}
+
+// GetRevenueTimeSeries returns the revenue metrics per bucket. Orders count on the day
+// they were placed and refunds on the day they were issued.
+func (s *AnalyticsService) GetRevenueTimeSeries(q AnalyticsQuery, granularity string) (*TimeSeries, error) {
+	if granularity == "" {
+		granularity = GranularityDay
+	}
+
+	q = WithDefaultWindow(q, granularity)
+	buckets, err := timeBuckets(q.From, q.To, q.location(), granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketExpr := fmt.Sprintf("TO_CHAR(date_trunc('%s', {column} AT TIME ZONE ?), 'YYYY-MM-DD\"T\"HH24:MI:SS')", granularity)
+	ctes, args := q.revenueCTEs()
+	args = append(args, q.Timezone(), q.Timezone())
+
+	var rows []revenueBucketRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT bucket, SUM(orders) AS orders, SUM(revenue) AS revenue, SUM(refunds) AS refunds
+		FROM (
+			SELECT %s AS bucket, 1 AS orders, orders.revenue, 0 AS refunds FROM orders
+			UNION ALL
+			SELECT %s AS bucket, 0 AS orders, 0 AS revenue, refunds.amount AS refunds FROM refunds
+		) AS amounts
+		GROUP BY bucket
+	`, ctes, strings.ReplaceAll(bucketExpr, "{column}", "orders.created_at"), strings.ReplaceAll(bucketExpr, "{column}", "refunds.created_at")), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byBucket := make(map[string]revenueBucketRow, len(rows))
+	for _, row := range rows {
+		byBucket[row.Bucket] = row
+	}
+
+	series := &TimeSeries{
+		Granularity: granularity,
+		Metrics:     RevenueMetrics,
+		Points:      make([]TimeSeriesPoint, 0, len(buckets)),
+	}
+	var total revenueBucketRow
+	for _, bucket := range buckets {
+		row := byBucket[bucket.Format(bucketLayout)]
+		total.Orders += row.Orders
+		total.Revenue += row.Revenue
+		total.Refunds += row.Refunds
+		series.Points = append(series.Points, TimeSeriesPoint{Bucket: bucket, Values: row.values()})
+	}
+	series.Totals = total.values()
+
+	return series, nil
+}
+
+// GetTopProducts returns the products with the highest net revenue from their line items
+func (s *AnalyticsService) GetTopProducts(q AnalyticsQuery, limit int) ([]ProductRevenue, error) {
+	ctes, args := q.revenueCTEs()
+
+	var products []ProductRevenue
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT
+			order_items.product_id,
+			MAX(order_items.product_name) AS product_name,
+			MAX(order_items.category) AS category,
+			COALESCE(SUM(order_items.quantity) FILTER (WHERE NOT order_items.is_refund), 0) AS quantity,
+			COUNT(DISTINCT order_items.order_id) FILTER (WHERE NOT order_items.is_refund) AS orders,
+			COALESCE(SUM(order_items.base_revenue) FILTER (WHERE NOT order_items.is_refund), 0) AS revenue,
+			COALESCE(SUM(order_items.base_revenue) FILTER (WHERE order_items.is_refund), 0) AS refunds
+		FROM order_items
+		WHERE order_items.base_revenue IS NOT NULL
+			AND order_items.event_id IN (SELECT id FROM orders UNION ALL SELECT id FROM refunds)
+		GROUP BY order_items.product_id
+		ORDER BY revenue - refunds DESC, order_items.product_id
+		LIMIT ?
+	`, ctes), append(args, limit)...).Scan(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range products {
+		products[i].NetRevenue = products[i].Revenue - products[i].Refunds
+	}
+	return products, nil
+}
+
+// GetAttributedRevenue attributes orders to an acquisition attribute of the session they were
+// placed in. Refunds are attributed to the session of the refunded order.
+func (s *AnalyticsService) GetAttributedRevenue(q AnalyticsQuery, dimension string, limit int) ([]AttributedRevenue, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("revenue reports require a project")
+	}
+	column, ok := revenueDimensionColumns[dimension]
+	if !ok {
+		return nil, fmt.Errorf("unknown revenue dimension %q", dimension)
+	}
+
+	ctes, args := q.revenueCTEs()
+	args = append(args, *q.ProjectID, limit)
+
+	var rows []AttributedRevenue
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		attributed AS (
+			SELECT orders.session_id, 1 AS orders, orders.revenue, 0 AS refunds FROM orders
+			UNION ALL
+			SELECT (
+				SELECT purchases.session_id
+				FROM events AS purchases
+				WHERE purchases.project_id = ? AND purchases.order_id = refunds.order_id AND purchases.event_type = '%s'
+				ORDER BY purchases.created_at
+				LIMIT 1
+			), 0, 0, refunds.amount
+			FROM refunds
+		)
+		SELECT
+			COALESCE(NULLIF(%s, ''), '%s') AS value,
+			SUM(attributed.orders) AS orders,
+			SUM(attributed.revenue) AS revenue,
+			SUM(attributed.refunds) AS refunds
+		FROM attributed
+		LEFT JOIN sessions ON sessions.id = attributed.session_id
+		GROUP BY 1
+		ORDER BY SUM(attributed.revenue) - SUM(attributed.refunds) DESC, value
+		LIMIT ?
+	`, ctes, EventTypePurchase, column, BreakdownNone), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		rows[i].NetRevenue = rows[i].Revenue - rows[i].Refunds
+		if rows[i].Orders > 0 {
+			rows[i].AverageOrderValue = rows[i].Revenue / float64(rows[i].Orders)
+		}
+	}
+	return rows, nil
+}
+
+func (r revenueBucketRow) values() map[string]float64 {
+	values := map[string]float64{
+		"revenue":             r.Revenue,
+		"refunds":             r.Refunds,
+		"net_revenue":         r.Revenue - r.Refunds,
+		"orders":              float64(r.Orders),
+		"average_order_value": 0,
+	}
+	if r.Orders > 0 {
+		values["average_order_value"] = r.Revenue / float64(r.Orders)
+	}
+	return values
+}
@@ -3,7 +3,10 @@ package services
 import (
 	"analytic-app/internal/database"
 	"analytic-app/internal/models"
+	"fmt"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type AnalyticsService struct {
@@ -30,9 +33,18 @@ type EventCountByDay struct {
 	Count int64  `json:"count"`
 }
 
+// TopPage is a page by views. With a goal, ConversionRate is the share of the sessions viewing
+// the page that converted.
 type TopPage struct {
-	PageURL string `json:"page_url"`
-	Count   int64  `json:"count"`
+	PageURL        string   `json:"page_url"`
+	Count          int64    `json:"count"`
+	Conversions    *int64   `json:"conversions,omitempty"`
+	ConversionRate *float64 `json:"conversion_rate,omitempty"`
+}
+
+type topPageRow struct {
+	TopPage
+	Sessions int64
 }
 
 type CountryStats struct {
@@ -40,50 +52,94 @@ type CountryStats struct {
 	Count   int64  `json:"count"`
 }
 
-func (s *AnalyticsService) GetDashboardStats() (*DashboardStats, error) {
+func (s *AnalyticsService) GetDashboardStats(q AnalyticsQuery) (*DashboardStats, error) {
 	stats := &DashboardStats{}
-	today := time.Now().Format("2006-01-02")
+	todayStart, todayEnd := q.Today()
 
-	// Total counts
-	s.db.Model(&models.Event{}).Count(&stats.TotalEvents)
-	s.db.Model(&models.Session{}).Count(&stats.TotalSessions)
-	s.db.Model(&models.User{}).Count(&stats.TotalUsers)
-	s.db.Model(&models.Project{}).Count(&stats.TotalProjects)
+	events := func() *gorm.DB {
+		return s.db.Model(&models.Event{}).Scopes(q.scope)
+	}
+	today := func() *gorm.DB {
+		return s.db.Model(&models.Event{}).
+			Scopes(q.projectScope).
+			Where("events.created_at >= ? AND events.created_at < ?", todayStart, todayEnd)
+	}
 
-	// Today counts
-	s.db.Model(&models.Event{}).Where("DATE(created_at) = ?", today).Count(&stats.EventsToday)
-	s.db.Model(&models.Session{}).Where("DATE(created_at) = ?", today).Count(&stats.SessionsToday)
-	s.db.Model(&models.User{}).Where("DATE(created_at) = ?", today).Count(&stats.UniqueUsersToday)
+	// Total counts, derived from events so they follow the project and window
+	events().Scopes(interactionEvents).Count(&stats.TotalEvents)
+	events().Distinct("session_id").Count(&stats.TotalSessions)
+	users := fmt.Sprintf("COUNT(DISTINCT %s)", q.uniqueUserColumn())
+	events().Select(users).Scan(&stats.TotalUsers)
+	if q.ProjectID != nil {
+		stats.TotalProjects = 1
+	} else {
+		s.db.Model(&models.Project{}).Count(&stats.TotalProjects)
+	}
 
-	// Set unique_visitors_today to same as unique_users_today for now
-	stats.UniqueVisitorsToday = stats.UniqueUsersToday
+	// Today counts, where "today" is the current day in the reporting timezone
+	today().Scopes(interactionEvents).Count(&stats.EventsToday)
+	today().Distinct("session_id").Count(&stats.SessionsToday)
+	today().Select(users).Scan(&stats.UniqueUsersToday)
+
+	// Unique visitors are people, identified or anonymous
+	visitors, err := s.uniqueVisitors(q, todayStart, todayEnd)
+	if err != nil {
+		return nil, err
+	}
+	stats.UniqueVisitorsToday = visitors
 
 	return stats, nil
 }
 
-func (s *AnalyticsService) GetEventCountByDay(days int) ([]EventCountByDay, error) {
-	var results []EventCountByDay
+// uniqueVisitors counts the people active in [from, to). Within a project anonymous sessions
+// are resolved to the user identified in them; across projects each session counts on its own.
+func (s *AnalyticsService) uniqueVisitors(q AnalyticsQuery, from, to time.Time) (int64, error) {
+	q.From, q.To = from, to
+	where, whereArgs := q.conditions(true)
+
+	var count int64
+	if q.ProjectID == nil {
+		err := s.db.Raw(fmt.Sprintf(`
+			SELECT COUNT(DISTINCT COALESCE(NULLIF(events.user_id, ''), events.session_id))
+			FROM events
+			WHERE %s
+		`, where), whereArgs...).Scan(&count).Error
+		return count, err
+	}
 
-	// Calculate the start date using Go time package for better portability
-	startDate := time.Now().AddDate(0, 0, -days)
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT COUNT(DISTINCT %s)
+		FROM events
+		%s
+		WHERE %s
+	`, sessionPeopleCTE, q.actorColumn(), sessionPeopleJoin, where), append([]interface{}{*q.ProjectID}, whereArgs...)...).Scan(&count).Error
+	return count, err
+}
 
-	err := s.db.Raw(`
-		SELECT 
-			DATE(created_at) as date,
-			COUNT(*) as count
-		FROM events 
-		WHERE created_at >= ?
-		GROUP BY DATE(created_at)
-		ORDER BY date DESC
-	`, startDate).Scan(&results).Error
+// GetEventCountByDay returns event counts per calendar day in the query timezone
+func (s *AnalyticsService) GetEventCountByDay(q AnalyticsQuery) ([]EventCountByDay, error) {
+	var results []EventCountByDay
+
+	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope, interactionEvents).
+		Select("TO_CHAR(events.created_at AT TIME ZONE ?, 'YYYY-MM-DD') as date, COUNT(*) as count", q.Timezone()).
+		Group("date").
+		Order("date DESC").
+		Scan(&results).Error
 
 	return results, err
 }
 
-func (s *AnalyticsService) GetTopPages(limit int) ([]TopPage, error) {
+func (s *AnalyticsService) GetTopPages(q AnalyticsQuery, limit int) ([]TopPage, error) {
+	if q.Goal != nil {
+		return s.getTopPagesWithConversions(q, limit)
+	}
+
 	var results []TopPage
 
 	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope, interactionEvents).
 		Select("page_url, COUNT(*) as count").
 		Where("page_url IS NOT NULL AND page_url != ''").
 		Group("page_url").
@@ -94,10 +150,40 @@ func (s *AnalyticsService) GetTopPages(limit int) ([]TopPage, error) {
 	return results, err
 }
 
-func (s *AnalyticsService) GetTopCountries(limit int) ([]CountryStats, error) {
+func (s *AnalyticsService) getTopPagesWithConversions(q AnalyticsQuery, limit int) ([]TopPage, error) {
+	converted, convertedArgs := q.convertedSessions()
+
+	var rows []topPageRow
+	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope, interactionEvents).
+		Select(fmt.Sprintf(`page_url, COUNT(*) as count,
+			COUNT(DISTINCT events.session_id) AS sessions,
+			COUNT(DISTINCT events.session_id) FILTER (WHERE events.session_id IN (%s)) AS conversions`, converted), convertedArgs...).
+		Where("page_url IS NOT NULL AND page_url != ''").
+		Group("page_url").
+		Order("count DESC").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]TopPage, len(rows))
+	for i, row := range rows {
+		results[i] = row.TopPage
+		if row.Conversions != nil {
+			rate := conversionRate(*row.Conversions, row.Sessions)
+			results[i].ConversionRate = &rate
+		}
+	}
+	return results, nil
+}
+
+func (s *AnalyticsService) GetTopCountries(q AnalyticsQuery, limit int) ([]CountryStats, error) {
 	var results []CountryStats
 
 	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope, interactionEvents).
 		Select("country, COUNT(*) as count").
 		Where("country IS NOT NULL AND country != ''").
 		Group("country").
@@ -108,16 +194,11 @@ func (s *AnalyticsService) GetTopCountries(limit int) ([]CountryStats, error) {
 	return results, err
 }
 
-func (s *AnalyticsService) GetTopEventTypes(limit int) ([]struct {
-	EventType string `json:"event_type"`
-	Count     int64  `json:"count"`
-}, error) {
-	var results []struct {
-		EventType string `json:"event_type"`
-		Count     int64  `json:"count"`
-	}
+func (s *AnalyticsService) GetTopEventTypes(q AnalyticsQuery, limit int) ([]EventTypeStats, error) {
+	var results []EventTypeStats
 
 	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope, interactionEvents).
 		Select("event_type, COUNT(*) as count").
 		Group("event_type").
 		Order("count DESC").
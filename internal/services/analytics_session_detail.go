@@ -0,0 +1,286 @@
+package services
+
+import (
+	"analytic-app/internal/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EventTypeError is the event type of the errors reported by the tracked page
+const EventTypeError = "error"
+
+// Session list sort orders
+const (
+	SessionSortStartTime = "start_time"
+	SessionSortDuration  = "duration"
+	SessionSortEvents    = "events"
+)
+
+// ErrInvalidSessionSort is returned for session lists with an unknown sort order
+var ErrInvalidSessionSort = errors.New("invalid session sort")
+
+// maxSessionEvents caps the event stream of a session detail
+const maxSessionEvents = 1000
+
+// SessionListRequest filters and pages the sessions started in the window. The session
+// slices of the filter apply, its breakdown does not.
+type SessionListRequest struct {
+	SessionFilter
+	HasEvent    string // keeps the sessions with an event of this name or type
+	MinDuration *int64 // keeps the sessions lasting longer than this many seconds
+	Converted   *bool  // keeps the sessions with (or without) a purchase or a completion of an active goal
+	Sort        string
+	Limit       int
+	Offset      int
+}
+
+// SessionSummary is a session with whether it converted
+type SessionSummary struct {
+	models.Session
+	Converted bool `json:"converted"`
+}
+
+// SessionEvent is an event of a session stream. Offsets and gaps are in seconds.
+type SessionEvent struct {
+	models.Event
+	OffsetSeconds float64 `json:"offset_seconds"` // since the start of the session
+	GapSeconds    float64 `json:"gap_seconds"`    // since the previous event of the session
+}
+
+// SessionPage is a page view of a session. TimeOnPage runs until the next page view, or the
+// last event of the session for the exit page.
+type SessionPage struct {
+	EventID    uuid.UUID `json:"event_id"`
+	PageURL    *string   `json:"page_url,omitempty"`
+	PageTitle  *string   `json:"page_title,omitempty"`
+	ViewedAt   time.Time `json:"viewed_at"`
+	TimeOnPage float64   `json:"time_on_page"` // in seconds
+	Events     int       `json:"events"`       // events from this page view to the next
+}
+
+// SessionDevice describes the device a session ran on, from its first event
+type SessionDevice struct {
+	DeviceType   *string `json:"device_type,omitempty"`
+	UserAgent    *string `json:"user_agent,omitempty"`
+	Platform     *string `json:"platform,omitempty"`
+	Language     *string `json:"language,omitempty"`
+	ScreenWidth  *int    `json:"screen_width,omitempty"`
+	ScreenHeight *int    `json:"screen_height,omitempty"`
+}
+
+// SessionLocation describes where a session came from
+type SessionLocation struct {
+	Country   *string `json:"country,omitempty"`
+	City      *string `json:"city,omitempty"`
+	IPAddress string  `json:"ip_address"`
+}
+
+// SessionDetail replays a session: its metadata, ordered event stream, page sequence and
+// errors. Truncated is set when the stream was cut at maxSessionEvents.
+type SessionDetail struct {
+	Session   models.Session  `json:"session"`
+	Converted bool            `json:"converted"`
+	Device    SessionDevice   `json:"device"`
+	Location  SessionLocation `json:"location"`
+	Events    []SessionEvent  `json:"events"`
+	Pages     []SessionPage   `json:"pages"`
+	Errors    []SessionEvent  `json:"errors"`
+	Truncated bool            `json:"truncated"`
+}
+
+type sessionSummaryRow struct {
+	SessionSummary
+	Total int64
+}
+
+// ListSessions returns a page of the sessions started in the window, newest first by default,
+// and the number of matching sessions
+func (s *AnalyticsService) ListSessions(q AnalyticsQuery, req *SessionListRequest) ([]SessionSummary, int64, error) {
+	if q.ProjectID == nil {
+		return nil, 0, errors.New("sessions require a project")
+	}
+
+	order := map[string]string{
+		"":                   "start_time DESC",
+		SessionSortStartTime: "start_time DESC",
+		SessionSortDuration:  "COALESCE(duration, 0) DESC",
+		SessionSortEvents:    "event_count DESC",
+	}[req.Sort]
+	if order == "" {
+		return nil, 0, fmt.Errorf("%w: unknown sort %q", ErrInvalidSessionSort, req.Sort)
+	}
+
+	converted, err := s.conversionCondition(*q.ProjectID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	where, whereArgs := q.sessionConditions(SessionFilter{Channel: req.Channel, Country: req.Country, DeviceType: req.DeviceType})
+	clauses := []string{where}
+	if req.HasEvent != "" {
+		clauses = append(clauses, "EXISTS (SELECT 1 FROM events WHERE events.session_id = sessions.id AND (events.event_name = ? OR events.event_type = ?))")
+		whereArgs = append(whereArgs, req.HasEvent, req.HasEvent)
+	}
+	if req.MinDuration != nil {
+		clauses = append(clauses, "COALESCE(sessions.duration, 0) > ?")
+		whereArgs = append(whereArgs, *req.MinDuration)
+	}
+
+	args := append(append([]interface{}{}, converted.args...), whereArgs...)
+	having := "TRUE"
+	if req.Converted != nil {
+		having = "converted = ?"
+		args = append(args, *req.Converted)
+	}
+	args = append(args, req.Limit, req.Offset)
+
+	var rows []sessionSummaryRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH listed AS (
+			SELECT sessions.*, EXISTS (
+				SELECT 1 FROM events WHERE events.session_id = sessions.id AND (%s)
+			) AS converted
+			FROM sessions
+			WHERE %s
+		)
+		SELECT *, COUNT(*) OVER () AS total
+		FROM listed
+		WHERE %s
+		ORDER BY %s, id
+		LIMIT ? OFFSET ?
+	`, converted.sql, strings.Join(clauses, " AND "), having, order), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sessions := make([]SessionSummary, 0, len(rows))
+	var total int64
+	for _, row := range rows {
+		sessions = append(sessions, row.SessionSummary)
+		total = row.Total
+	}
+	if len(rows) == 0 && req.Offset > 0 {
+		// Past the last page the window function has no row to report the total on
+		unpaged := *req
+		unpaged.Offset = 0
+		unpaged.Limit = 1
+		if _, total, err = s.ListSessions(q, &unpaged); err != nil {
+			return nil, 0, err
+		}
+	}
+	return sessions, total, nil
+}
+
+// GetSessionDetail replays a session of a project
+func (s *AnalyticsService) GetSessionDetail(projectID uuid.UUID, sessionID string) (*SessionDetail, error) {
+	var session models.Session
+	if err := s.db.Where("id = ? AND project_id = ?", sessionID, projectID).First(&session).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("session not found")
+		}
+		return nil, err
+	}
+
+	var events []models.Event
+	err := s.db.Where("session_id = ? AND project_id = ?", sessionID, projectID).
+		Order("created_at ASC, id ASC").
+		Limit(maxSessionEvents + 1).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &SessionDetail{
+		Session: session,
+		Location: SessionLocation{
+			Country:   session.Country,
+			City:      session.City,
+			IPAddress: session.IPAddress,
+		},
+		Device: SessionDevice{
+			DeviceType: session.DeviceType,
+			UserAgent:  session.UserAgent,
+		},
+		Events: make([]SessionEvent, 0, len(events)),
+		Pages:  []SessionPage{},
+		Errors: []SessionEvent{},
+	}
+	if len(events) > maxSessionEvents {
+		events = events[:maxSessionEvents]
+		detail.Truncated = true
+	}
+	if len(events) > 0 {
+		first := events[0]
+		detail.Device.Platform = first.Platform
+		detail.Device.Language = first.Language
+		detail.Device.ScreenWidth = first.ScreenWidth
+		detail.Device.ScreenHeight = first.ScreenHeight
+	}
+
+	for i, event := range events {
+		streamed := SessionEvent{Event: event, OffsetSeconds: event.CreatedAt.Sub(session.StartTime).Seconds()}
+		if i > 0 {
+			streamed.GapSeconds = event.CreatedAt.Sub(events[i-1].CreatedAt).Seconds()
+		}
+		detail.Events = append(detail.Events, streamed)
+
+		if event.EventType == EventTypeError {
+			detail.Errors = append(detail.Errors, streamed)
+		}
+		if event.EventType == EventTypePageView {
+			detail.Pages = append(detail.Pages, SessionPage{
+				EventID:   event.ID,
+				PageURL:   event.PageURL,
+				PageTitle: event.PageTitle,
+				ViewedAt:  event.CreatedAt,
+			})
+		}
+		if n := len(detail.Pages); n > 0 {
+			page := &detail.Pages[n-1]
+			page.Events++
+			page.TimeOnPage = event.CreatedAt.Sub(page.ViewedAt).Seconds()
+		}
+	}
+	// A page lasts until the next one is viewed
+	for i := 0; i+1 < len(detail.Pages); i++ {
+		detail.Pages[i].TimeOnPage = detail.Pages[i+1].ViewedAt.Sub(detail.Pages[i].ViewedAt).Seconds()
+	}
+
+	converted, err := s.conversionCondition(projectID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Raw(fmt.Sprintf(`
+		SELECT EXISTS (SELECT 1 FROM events WHERE events.session_id = ? AND (%s))
+	`, converted.sql), append([]interface{}{sessionID}, converted.args...)...).Scan(&detail.Converted).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return detail, nil
+}
+
+// conversionCondition renders the events that convert a session: purchases and the
+// completions of the project's active goals
+func (s *AnalyticsService) conversionCondition(projectID uuid.UUID) (sqlFragment, error) {
+	var goals []models.Goal
+	if err := s.db.Where("project_id = ? AND is_active = ?", projectID, true).Order("created_at ASC").Find(&goals).Error; err != nil {
+		return sqlFragment{}, err
+	}
+
+	condition := sqlFragment{sql: fmt.Sprintf("events.event_type = '%s'", EventTypePurchase)}
+	for i := range goals {
+		goal, err := goalCondition(&goals[i])
+		if err != nil {
+			return sqlFragment{}, err
+		}
+		condition.sql += " OR (" + goal.sql + ")"
+		condition.args = append(condition.args, goal.args...)
+	}
+	return condition, nil
+}
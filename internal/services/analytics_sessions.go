@@ -0,0 +1,326 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sessionDimensionColumns maps the dimensions session reports can be sliced by to session columns
+var sessionDimensionColumns = map[string]string{
+	"channel":     "sessions.channel",
+	"country":     "sessions.country",
+	"device_type": "sessions.device_type",
+}
+
+// sessionFieldColumns maps the session.<name> fields of filters and breakdowns to the columns
+// of the event's session
+var sessionFieldColumns = map[string]string{
+	"channel":      "sessions.channel",
+	"device_type":  "sessions.device_type",
+	"landing_page": "sessions.landing_page",
+	"exit_page":    "sessions.exit_page",
+	"referrer":     "sessions.referrer",
+	"utm_source":   "sessions.utm_source",
+	"utm_medium":   "sessions.utm_medium",
+	"utm_campaign": "sessions.utm_campaign",
+	"country":      "sessions.country",
+	"city":         "sessions.city",
+}
+
+const (
+	maxSessionBreakdowns = 25
+	sessionFieldPrefix   = "session."
+)
+
+// SessionFilter slices session reports by acquisition channel, country and device
+type SessionFilter struct {
+	Channel    string
+	Country    string
+	DeviceType string
+	Breakdown  string // one of the session dimensions
+}
+
+// SessionMetrics summarises a set of sessions. Rates are fractions between 0 and 1 and durations are in seconds.
+// Conversions count the sessions that completed the goal of the query, when it has one.
+type SessionMetrics struct {
+	Sessions        int64    `json:"sessions"`
+	Bounces         int64    `json:"bounces"`
+	BounceRate      float64  `json:"bounce_rate"`
+	AvgDuration     float64  `json:"avg_duration"`
+	MedianDuration  float64  `json:"median_duration"`
+	PagesPerSession float64  `json:"pages_per_session"`
+	Conversions     *int64   `json:"conversions,omitempty"`
+	ConversionRate  *float64 `json:"conversion_rate,omitempty"`
+}
+
+// SessionBreakdown holds the metrics of the sessions sharing a dimension value
+type SessionBreakdown struct {
+	Value string `json:"value"`
+	SessionMetrics
+}
+
+// SessionReport holds session metrics, optionally broken down by a dimension
+type SessionReport struct {
+	SessionMetrics
+	Breakdown  string             `json:"breakdown,omitempty"`
+	Breakdowns []SessionBreakdown `json:"breakdowns,omitempty"`
+}
+
+// EntryPage is a page sessions started on. With a goal, ConversionRate is the share of the
+// sessions that converted.
+type EntryPage struct {
+	PageURL        string   `json:"page_url"`
+	Sessions       int64    `json:"sessions"`
+	Bounces        int64    `json:"bounces"`
+	BounceRate     float64  `json:"bounce_rate"`
+	Conversions    *int64   `json:"conversions,omitempty"`
+	ConversionRate *float64 `json:"conversion_rate,omitempty"`
+}
+
+// ExitPage is a page sessions ended on. ExitRate is the share of the page's views that ended a session.
+// With a goal, ConversionRate is the share of the exits whose session converted.
+type ExitPage struct {
+	PageURL        string   `json:"page_url"`
+	Exits          int64    `json:"exits"`
+	PageViews      int64    `json:"page_views"`
+	ExitRate       float64  `json:"exit_rate"`
+	Conversions    *int64   `json:"conversions,omitempty"`
+	ConversionRate *float64 `json:"conversion_rate,omitempty"`
+}
+
+type sessionMetricsResult struct {
+	Value           string
+	Sessions        int64
+	Bounces         int64
+	AvgDuration     float64
+	MedianDuration  float64
+	PagesPerSession float64
+	Conversions     *int64
+}
+
+// Validate checks the breakdown dimension
+func (f SessionFilter) Validate() error {
+	if f.Breakdown != "" {
+		if _, ok := sessionDimensionColumns[f.Breakdown]; !ok {
+			return fmt.Errorf("unknown session breakdown %q", f.Breakdown)
+		}
+	}
+	return nil
+}
+
+// sessionConditions restricts sessions to the project, traffic selection, window (on the
+// session start) and slices. Event dimension filters keep the sessions with a matching event.
+func (q AnalyticsQuery) sessionConditions(f SessionFilter) (string, []interface{}) {
+	clauses := []string{"TRUE"}
+	var args []interface{}
+
+	if q.ProjectID != nil {
+		clauses = append(clauses, "sessions.project_id = ?")
+		args = append(args, *q.ProjectID)
+	}
+	if !q.IncludeInternal {
+		clauses = append(clauses, "sessions.is_internal = ?")
+		args = append(args, false)
+	}
+	if !q.From.IsZero() {
+		clauses = append(clauses, "sessions.start_time >= ?")
+		args = append(args, q.From)
+	}
+	if !q.To.IsZero() {
+		clauses = append(clauses, "sessions.start_time < ?")
+		args = append(args, q.To)
+	}
+
+	for _, slice := range []struct{ column, value string }{
+		{"sessions.channel", f.Channel},
+		{"sessions.country", f.Country},
+		{"sessions.device_type", f.DeviceType},
+	} {
+		if slice.value != "" {
+			clauses = append(clauses, slice.column+" = ?")
+			args = append(args, slice.value)
+		}
+	}
+
+	if filterSQL, filterArgs := q.filterConditions(); filterSQL != "" {
+		clauses = append(clauses, "EXISTS (SELECT 1 FROM events WHERE events.session_id = sessions.id AND "+filterSQL+")")
+		args = append(args, filterArgs...)
+	}
+
+	return strings.Join(clauses, " AND "), args
+}
+
+// sessionConversions renders the WITH clause of a sessions query and the select of its
+// conversions column, or nothing when the query has no goal
+func (q AnalyticsQuery) sessionConversions() (with string, args []interface{}, conversions string) {
+	if q.Goal == nil {
+		return "", nil, ""
+	}
+	cte, args := q.convertedSessionsCTE()
+	return "WITH " + cte, args, ", COUNT(*) FILTER (WHERE sessions.id IN (SELECT session_id FROM converted_sessions)) AS conversions"
+}
+
+// GetSessionReport returns bounce rate, duration and pages per session of the sessions started in the window
+func (s *AnalyticsService) GetSessionReport(q AnalyticsQuery, f SessionFilter) (*SessionReport, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	with, args, conversions := q.sessionConversions()
+	where, whereArgs := q.sessionConditions(f)
+	args = append(args, whereArgs...)
+	metrics := `
+		COUNT(*) AS sessions,
+		COUNT(*) FILTER (WHERE sessions.event_count <= 1) AS bounces,
+		COALESCE(AVG(COALESCE(sessions.duration, 0)), 0) AS avg_duration,
+		COALESCE(PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY COALESCE(sessions.duration, 0)), 0) AS median_duration,
+		COALESCE(AVG(sessions.page_views), 0) AS pages_per_session` + conversions
+
+	var total sessionMetricsResult
+	if err := s.db.Raw(fmt.Sprintf("%s SELECT %s FROM sessions WHERE %s", with, metrics, where), args...).Scan(&total).Error; err != nil {
+		return nil, err
+	}
+
+	report := &SessionReport{SessionMetrics: total.metrics(), Breakdown: f.Breakdown}
+	if f.Breakdown == "" {
+		return report, nil
+	}
+
+	var rows []sessionMetricsResult
+	err := s.db.Raw(fmt.Sprintf(`
+		%s
+		SELECT COALESCE(NULLIF(%s, ''), '%s') AS value, %s
+		FROM sessions
+		WHERE %s
+		GROUP BY 1
+		ORDER BY sessions DESC, value
+		LIMIT %d
+	`, with, sessionDimensionColumns[f.Breakdown], BreakdownNone, metrics, where, maxSessionBreakdowns), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report.Breakdowns = make([]SessionBreakdown, 0, len(rows))
+	for _, row := range rows {
+		report.Breakdowns = append(report.Breakdowns, SessionBreakdown{Value: row.Value, SessionMetrics: row.metrics()})
+	}
+	return report, nil
+}
+
+// GetEntryPages returns the pages sessions most often started on
+func (s *AnalyticsService) GetEntryPages(q AnalyticsQuery, f SessionFilter, limit int) ([]EntryPage, error) {
+	with, args, conversions := q.sessionConversions()
+	where, whereArgs := q.sessionConditions(f)
+	args = append(append(args, whereArgs...), limit)
+
+	var pages []EntryPage
+	err := s.db.Raw(fmt.Sprintf(`
+		%s
+		SELECT
+			sessions.landing_page AS page_url,
+			COUNT(*) AS sessions,
+			COUNT(*) FILTER (WHERE sessions.event_count <= 1) AS bounces
+			%s
+		FROM sessions
+		WHERE %s AND sessions.landing_page IS NOT NULL AND sessions.landing_page != ''
+		GROUP BY sessions.landing_page
+		ORDER BY sessions DESC, page_url
+		LIMIT ?
+	`, with, conversions, where), args...).Scan(&pages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pages {
+		if pages[i].Sessions > 0 {
+			pages[i].BounceRate = float64(pages[i].Bounces) / float64(pages[i].Sessions)
+		}
+		if pages[i].Conversions != nil {
+			rate := conversionRate(*pages[i].Conversions, pages[i].Sessions)
+			pages[i].ConversionRate = &rate
+		}
+	}
+	return pages, nil
+}
+
+// GetExitPages returns the pages sessions most often ended on, with the share of their views that were exits
+func (s *AnalyticsService) GetExitPages(q AnalyticsQuery, f SessionFilter, limit int) ([]ExitPage, error) {
+	with, args, conversions := q.sessionConversions()
+	selectConversions := ""
+	if with != "" {
+		with += ","
+		selectConversions = ", exits.conversions"
+	} else {
+		with = "WITH"
+	}
+	where, whereArgs := q.sessionConditions(f)
+
+	var pages []ExitPage
+	err := s.db.Raw(fmt.Sprintf(`
+		%s
+		exits AS (
+			SELECT sessions.exit_page AS page_url, COUNT(*) AS exits%s
+			FROM sessions
+			WHERE %s AND sessions.exit_page IS NOT NULL AND sessions.exit_page != ''
+			GROUP BY sessions.exit_page
+			ORDER BY exits DESC, page_url
+			LIMIT ?
+		),
+		views AS (
+			SELECT events.page_url, COUNT(*) AS page_views
+			FROM events
+			JOIN sessions ON sessions.id = events.session_id
+			WHERE %s AND events.event_type = 'page_view' AND events.page_url IN (SELECT page_url FROM exits)
+			GROUP BY events.page_url
+		)
+		SELECT exits.page_url, exits.exits, COALESCE(views.page_views, 0) AS page_views%s
+		FROM exits
+		LEFT JOIN views ON views.page_url = exits.page_url
+		ORDER BY exits.exits DESC, exits.page_url
+	`, with, conversions, where, where, selectConversions), append(append(append(args, whereArgs...), limit), whereArgs...)...).Scan(&pages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pages {
+		if pages[i].PageViews > 0 {
+			pages[i].ExitRate = float64(pages[i].Exits) / float64(pages[i].PageViews)
+		}
+		if pages[i].Conversions != nil {
+			rate := conversionRate(*pages[i].Conversions, pages[i].Exits)
+			pages[i].ConversionRate = &rate
+		}
+	}
+	return pages, nil
+}
+
+func (r sessionMetricsResult) metrics() SessionMetrics {
+	m := SessionMetrics{
+		Sessions:        r.Sessions,
+		Bounces:         r.Bounces,
+		AvgDuration:     r.AvgDuration,
+		MedianDuration:  r.MedianDuration,
+		PagesPerSession: r.PagesPerSession,
+	}
+	if r.Sessions > 0 {
+		m.BounceRate = float64(r.Bounces) / float64(r.Sessions)
+	}
+	if r.Conversions != nil {
+		rate := conversionRate(*r.Conversions, r.Sessions)
+		m.Conversions, m.ConversionRate = r.Conversions, &rate
+	}
+	return m
+}
+
+// sessionText renders a session.<name> field as the column of the event's session
+func sessionText(field string) (sqlFragment, error) {
+	column, ok := sessionFieldColumns[strings.TrimPrefix(field, sessionFieldPrefix)]
+	if !ok {
+		return sqlFragment{}, fmt.Errorf("unknown session field %q", field)
+	}
+	return sqlFragment{sql: fmt.Sprintf("(SELECT %s FROM sessions WHERE sessions.id = events.session_id)", column)}, nil
+}
+
+func isSessionField(field string) bool {
+	return strings.HasPrefix(field, sessionFieldPrefix)
+}
@@ -0,0 +1,359 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Time series granularities
+const (
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+	GranularityWeek   = "week"
+	GranularityMonth  = "month"
+)
+
+// maxTimeSeriesPoints bounds the number of buckets a single request may produce
+const maxTimeSeriesPoints = 2000
+
+// ErrTooManyBuckets is returned when a range holds more than maxTimeSeriesPoints buckets
+var ErrTooManyBuckets = errors.New("too many buckets")
+
+// bucketLayout is how bucket keys are rendered by both Postgres and Go
+const bucketLayout = "2006-01-02T15:04:05"
+
+// TimeSeriesMetrics lists the metrics a time series can return
+var TimeSeriesMetrics = []string{
+	"events",
+	"unique_users",
+	"sessions",
+	"page_views",
+	"bounce_rate",
+	"avg_session_duration",
+	"conversions",
+	"conversion_rate",
+}
+
+// sessionMetrics are computed per session and bucketed by session start
+var sessionMetrics = map[string]bool{
+	"sessions":             true,
+	"bounce_rate":          true,
+	"avg_session_duration": true,
+	"conversions":          true,
+	"conversion_rate":      true,
+}
+
+// goalMetrics need the goal of the query
+var goalMetrics = map[string]bool{
+	"conversions":     true,
+	"conversion_rate": true,
+}
+
+// TimeSeriesRequest selects the metrics and bucket size of a time series
+type TimeSeriesRequest struct {
+	Granularity string
+	Metrics     []string
+}
+
+// TimeSeriesPoint holds the metric values of a single bucket
+type TimeSeriesPoint struct {
+	Bucket time.Time          `json:"bucket"`
+	Values map[string]float64 `json:"values"`
+}
+
+// TimeSeries is a gap-filled series of buckets plus totals over the whole window
+type TimeSeries struct {
+	Granularity string             `json:"granularity"`
+	Metrics     []string           `json:"metrics"`
+	Points      []TimeSeriesPoint  `json:"points"`
+	Totals      map[string]float64 `json:"totals"`
+}
+
+type eventMetricsRow struct {
+	Bucket      string
+	Events      float64
+	UniqueUsers float64
+	PageViews   float64
+}
+
+type sessionMetricsRow struct {
+	Bucket             string
+	Sessions           float64
+	BounceRate         float64
+	AvgSessionDuration float64
+	Conversions        float64
+	ConversionRate     float64
+}
+
+// Validate checks the granularity and metric names
+func (r TimeSeriesRequest) Validate() error {
+	switch r.Granularity {
+	case "", GranularityMinute, GranularityHour, GranularityDay, GranularityWeek, GranularityMonth:
+	default:
+		return fmt.Errorf("unknown granularity %q", r.Granularity)
+	}
+	for _, metric := range r.Metrics {
+		if !isTimeSeriesMetric(metric) {
+			return fmt.Errorf("unknown metric %q", metric)
+		}
+	}
+	return nil
+}
+
+// GetTimeSeries returns the requested metrics bucketed by granularity in the query timezone.
+// Buckets without data are returned with zero values.
+func (s *AnalyticsService) GetTimeSeries(q AnalyticsQuery, req TimeSeriesRequest) (*TimeSeries, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	if req.Granularity == "" {
+		req.Granularity = GranularityDay
+	}
+	if len(req.Metrics) == 0 {
+		req.Metrics = []string{"events"}
+	}
+	for _, metric := range req.Metrics {
+		if goalMetrics[metric] && q.Goal == nil {
+			return nil, fmt.Errorf("metric %q: %w", metric, ErrGoalRequired)
+		}
+	}
+
+	q = WithDefaultWindow(q, req.Granularity)
+	buckets, err := timeBuckets(q.From, q.To, q.location(), req.Granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketExpr := fmt.Sprintf("TO_CHAR(date_trunc('%s', {column} AT TIME ZONE ?), 'YYYY-MM-DD\"T\"HH24:MI:SS')", req.Granularity)
+	values, err := s.timeSeriesValues(q, req.Metrics, bucketExpr)
+	if err != nil {
+		return nil, err
+	}
+	totals, err := s.timeSeriesValues(q, req.Metrics, "'total'")
+	if err != nil {
+		return nil, err
+	}
+
+	series := &TimeSeries{
+		Granularity: req.Granularity,
+		Metrics:     req.Metrics,
+		Points:      make([]TimeSeriesPoint, 0, len(buckets)),
+		Totals:      make(map[string]float64, len(req.Metrics)),
+	}
+
+	for _, bucket := range buckets {
+		point := TimeSeriesPoint{Bucket: bucket, Values: make(map[string]float64, len(req.Metrics))}
+		for _, metric := range req.Metrics {
+			point.Values[metric] = values[bucket.Format(bucketLayout)][metric]
+		}
+		series.Points = append(series.Points, point)
+	}
+	for _, metric := range req.Metrics {
+		series.Totals[metric] = totals["total"][metric]
+	}
+
+	return series, nil
+}
+
+// timeSeriesValues runs the event and session metric queries and indexes the rows by bucket key.
+// bucketExpr is a SQL expression with a {column} placeholder for the timestamp column; when
+// it references the timezone it takes it as its only argument.
+func (s *AnalyticsService) timeSeriesValues(q AnalyticsQuery, metrics []string, bucketExpr string) (map[string]map[string]float64, error) {
+	values := make(map[string]map[string]float64)
+	set := func(bucket, metric string, value float64) {
+		if values[bucket] == nil {
+			values[bucket] = make(map[string]float64)
+		}
+		values[bucket][metric] = value
+	}
+
+	var bucketArgs []interface{}
+	if strings.Contains(bucketExpr, "?") {
+		bucketArgs = []interface{}{q.Timezone()}
+	}
+
+	needEvents, needSessions := false, false
+	for _, metric := range metrics {
+		if sessionMetrics[metric] {
+			needSessions = true
+		} else {
+			needEvents = true
+		}
+	}
+
+	if needEvents {
+		where, args := q.conditions(true)
+		var rows []eventMetricsRow
+		err := s.db.Raw(fmt.Sprintf(`
+			SELECT
+				%s AS bucket,
+				COUNT(*) FILTER (WHERE %s) AS events,
+				COUNT(DISTINCT %s) AS unique_users,
+				COUNT(*) FILTER (WHERE events.event_type = ?) AS page_views
+			FROM events
+			WHERE %s
+			GROUP BY 1
+		`, strings.ReplaceAll(bucketExpr, "{column}", "events.created_at"), interactionCondition, q.uniqueUserColumn(), where), append(append(append([]interface{}{}, bucketArgs...), EventTypePageView), args...)...).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			set(row.Bucket, "events", row.Events)
+			set(row.Bucket, "unique_users", row.UniqueUsers)
+			set(row.Bucket, "page_views", row.PageViews)
+		}
+	}
+
+	if needSessions {
+		// Sessions are built from all of their events in the window; dimension filters
+		// select the sessions that contain at least one matching event.
+		// Conversions count the sessions that completed the goal, in the window or after it.
+		var args []interface{}
+		with, converted := "WITH", "0"
+		if q.Goal != nil {
+			cte, cteArgs := q.convertedSessionsCTE()
+			with, args = "WITH "+cte+",", cteArgs
+			converted = "CASE WHEN session_id IN (SELECT session_id FROM converted_sessions) THEN 1.0 ELSE 0.0 END"
+		}
+		where, whereArgs := q.baseConditions(true)
+		args = append(args, whereArgs...)
+		having := ""
+		if filterSQL, filterArgs := q.filterConditions(); filterSQL != "" {
+			having = "HAVING BOOL_OR(" + filterSQL + ")"
+			args = append(args, filterArgs...)
+		}
+
+		var rows []sessionMetricsRow
+		err := s.db.Raw(fmt.Sprintf(`
+			%s
+			session_stats AS (
+				SELECT
+					events.session_id,
+					MIN(events.created_at) AS started_at,
+					MAX(events.created_at) AS ended_at,
+					COUNT(*) FILTER (WHERE %s) AS event_count
+				FROM events
+				WHERE %s
+				GROUP BY events.session_id
+				%s
+			)
+			SELECT
+				%s AS bucket,
+				COUNT(*) AS sessions,
+				AVG(CASE WHEN event_count <= 1 THEN 1.0 ELSE 0.0 END) AS bounce_rate,
+				AVG(EXTRACT(EPOCH FROM ended_at - started_at)) AS avg_session_duration,
+				SUM(%s) AS conversions,
+				AVG(%s) AS conversion_rate
+			FROM session_stats
+			GROUP BY 1
+		`, with, interactionCondition, where, having, strings.ReplaceAll(bucketExpr, "{column}", "started_at"), converted, converted), append(args, bucketArgs...)...).Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			set(row.Bucket, "sessions", row.Sessions)
+			set(row.Bucket, "bounce_rate", row.BounceRate)
+			set(row.Bucket, "avg_session_duration", row.AvgSessionDuration)
+			set(row.Bucket, "conversions", row.Conversions)
+			set(row.Bucket, "conversion_rate", row.ConversionRate)
+		}
+	}
+
+	return values, nil
+}
+
+func isTimeSeriesMetric(metric string) bool {
+	for _, m := range TimeSeriesMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// WithDefaultWindow fills in a window sized for the granularity when none was requested.
+// A defaulted start is aligned to a bucket boundary so the first bucket is complete.
+func WithDefaultWindow(q AnalyticsQuery, granularity string) AnalyticsQuery {
+	if q.To.IsZero() {
+		q.To = q.now()
+	}
+	if q.From.IsZero() {
+		to := q.To.In(q.location())
+		var from time.Time
+		switch granularity {
+		case GranularityMinute:
+			from = to.Add(-59 * time.Minute)
+		case GranularityHour:
+			from = to.Add(-23 * time.Hour)
+		case GranularityWeek:
+			from = to.AddDate(0, 0, -7*11)
+		case GranularityMonth:
+			from = to.AddDate(0, -11, 0)
+		default:
+			from = to.AddDate(0, 0, -29)
+		}
+		q.From = truncateTime(from, q.location(), granularity)
+	}
+	return q
+}
+
+// truncateTime truncates t to the start of its bucket in loc, like Postgres date_trunc
+func truncateTime(t time.Time, loc *time.Location, granularity string) time.Time {
+	t = t.In(loc)
+	switch granularity {
+	case GranularityMinute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	case GranularityHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case GranularityWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		offset := (int(day.Weekday()) + 6) % 7 // weeks start on Monday
+		return day.AddDate(0, 0, -offset)
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// nextBucket returns the start of the bucket following start
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityMinute:
+		return start.Add(time.Minute)
+	case GranularityHour:
+		return start.Add(time.Hour)
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// timeBuckets lists the bucket starts covering [from, to)
+func timeBuckets(from, to time.Time, loc *time.Location, granularity string) ([]time.Time, error) {
+	switch granularity {
+	case GranularityMinute, GranularityHour, GranularityDay, GranularityWeek, GranularityMonth:
+	default:
+		return nil, fmt.Errorf("unknown granularity %q", granularity)
+	}
+
+	var buckets []time.Time
+	seen := make(map[string]bool)
+	for t := truncateTime(from, loc, granularity); t.Before(to); t = nextBucket(t, granularity) {
+		// Daylight saving transitions can map two instants to the same local bucket
+		key := t.Format(bucketLayout)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		buckets = append(buckets, t)
+		if len(buckets) > maxTimeSeriesPoints {
+			return nil, fmt.Errorf("%w: range produces more than %d %s buckets, use a coarser granularity", ErrTooManyBuckets, maxTimeSeriesPoints, granularity)
+		}
+	}
+	return buckets, nil
+}
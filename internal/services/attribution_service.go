@@ -0,0 +1,365 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"analytic-app/pkg/utils"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Attribution models
+const (
+	AttributionFirstTouch    = "first_touch"
+	AttributionLastTouch     = "last_touch"
+	AttributionLastNonDirect = "last_non_direct"
+	AttributionLinear        = "linear"
+	AttributionTimeDecay     = "time_decay"
+	AttributionPositionBased = "position_based"
+)
+
+const (
+	defaultAttributionModel   = AttributionLastNonDirect
+	defaultLookbackDays       = 30
+	maxLookbackDays           = 90
+	defaultHalfLifeDays       = 7
+	maxAttributionRows        = 50
+	positionBasedEndpointRate = 0.4 // share of the first and of the last touch in the position-based model
+)
+
+// attributionDimensionColumns maps the session attributes conversions can be credited to
+var attributionDimensionColumns = map[string]string{
+	"channel":      "sessions.channel",
+	"utm_source":   "sessions.utm_source",
+	"utm_medium":   "sessions.utm_medium",
+	"utm_campaign": "sessions.utm_campaign",
+}
+
+type AttributionService struct {
+	db *database.DB
+}
+
+func NewAttributionService(db *database.DB) *AttributionService {
+	return &AttributionService{db: db}
+}
+
+// AttributionRequest selects the conversions to credit and how to credit them. Conversions
+// are the completions of a goal, or the events with the given type and/or name.
+type AttributionRequest struct {
+	GoalID       *uuid.UUID `json:"goal_id,omitempty"`
+	EventType    string     `json:"event_type,omitempty"`
+	EventName    string     `json:"event_name,omitempty"`
+	Model        string     `json:"model,omitempty"`
+	Dimension    string     `json:"dimension,omitempty"`
+	LookbackDays int        `json:"lookback_days,omitempty"`
+	HalfLifeDays float64    `json:"half_life_days,omitempty"` // time-decay only
+}
+
+// AttributionRow holds the conversions and revenue credited to a dimension value.
+// Credits are fractional for the models that split a conversion across touches.
+type AttributionRow struct {
+	Value       string  `json:"value"`
+	Conversions float64 `json:"conversions"`
+	Revenue     float64 `json:"revenue"`
+	Share       float64 `json:"share"` // of all credited conversions, 0 to 1
+}
+
+// AttributionResult is the credit of every dimension value under a model
+type AttributionResult struct {
+	Model              string           `json:"model"`
+	Dimension          string           `json:"dimension"`
+	LookbackDays       int              `json:"lookback_days"`
+	HalfLifeDays       float64          `json:"half_life_days,omitempty"`
+	Conversions        int64            `json:"conversions"`
+	Revenue            float64          `json:"revenue"`
+	AverageTouchpoints float64          `json:"average_touchpoints"`
+	Rows               []AttributionRow `json:"rows"`
+}
+
+// touchpoint is a session that led up to a conversion
+type touchpoint struct {
+	at      time.Time
+	value   string
+	channel string
+}
+
+type attributionRow struct {
+	ConversionID uuid.UUID
+	Value        float64
+	StartTime    *time.Time
+	Dimension    *string
+	Channel      *string
+}
+
+// Validate checks the conversion, the model, the dimension and the windows
+func (r *AttributionRequest) Validate() error {
+	if r.GoalID == nil && r.EventType == "" && r.EventName == "" {
+		return errors.New("attribution requires a goal_id, event_type or event_name")
+	}
+	if r.GoalID != nil && (r.EventType != "" || r.EventName != "") {
+		return errors.New("use either a goal or a conversion event")
+	}
+	switch r.Model {
+	case "", AttributionFirstTouch, AttributionLastTouch, AttributionLastNonDirect, AttributionLinear, AttributionTimeDecay, AttributionPositionBased:
+	default:
+		return fmt.Errorf("unknown attribution model %q", r.Model)
+	}
+	if r.Dimension != "" {
+		if _, ok := attributionDimensionColumns[r.Dimension]; !ok {
+			return fmt.Errorf("unknown attribution dimension %q", r.Dimension)
+		}
+	}
+	if r.LookbackDays < 0 || r.LookbackDays > maxLookbackDays {
+		return fmt.Errorf("lookback_days must be between 0 (default) and %d", maxLookbackDays)
+	}
+	if r.HalfLifeDays < 0 || r.HalfLifeDays > maxLookbackDays {
+		return fmt.Errorf("half_life_days must be between 0 and %d", maxLookbackDays)
+	}
+	return nil
+}
+
+// Attribute credits the conversions in the window to the sessions of the same person that
+// started within the lookback window before each conversion
+func (s *AttributionService) Attribute(q AnalyticsQuery, req *AttributionRequest) (*AttributionResult, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("attribution requires a project")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	result := &AttributionResult{
+		Model:        req.Model,
+		Dimension:    req.Dimension,
+		LookbackDays: req.LookbackDays,
+	}
+	if result.Model == "" {
+		result.Model = defaultAttributionModel
+	}
+	if result.Dimension == "" {
+		result.Dimension = "channel"
+	}
+	if result.LookbackDays == 0 {
+		result.LookbackDays = defaultLookbackDays
+	}
+	halfLife := req.HalfLifeDays
+	if result.Model == AttributionTimeDecay {
+		if halfLife == 0 {
+			halfLife = defaultHalfLifeDays
+		}
+		result.HalfLifeDays = halfLife
+	}
+
+	conversion, value, err := s.conversion(*q.ProjectID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	where, whereArgs := q.conditions(true)
+	args := []interface{}{*q.ProjectID}
+	args = append(args, value.args...)
+	args = append(args, whereArgs...)
+	args = append(args, conversion.args...)
+	args = append(args, *q.ProjectID, result.LookbackDays)
+
+	rows, err := s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		conversions AS (
+			SELECT DISTINCT ON (COALESCE(events.order_id, CAST(events.id AS text))) events.id, %s AS person, events.created_at, %s AS value
+			FROM events
+			%s
+			WHERE %s AND %s
+			ORDER BY COALESCE(events.order_id, CAST(events.id AS text)), events.created_at
+		)
+		SELECT
+			conversions.id AS conversion_id,
+			COALESCE(conversions.value, 0) AS value,
+			sessions.start_time,
+			%s AS dimension,
+			sessions.channel
+		FROM conversions
+		LEFT JOIN sessions ON (sessions.user_id = conversions.person OR sessions.id = conversions.person)
+			AND sessions.project_id = ?
+			AND sessions.start_time <= conversions.created_at
+			AND sessions.start_time >= conversions.created_at - make_interval(days => ?)
+		ORDER BY conversions.id, sessions.start_time
+	`, sessionPeopleCTE, personColumn, value.sql, sessionPeopleJoin, where, conversion.sql, attributionDimensionColumns[result.Dimension]), args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[string]*AttributionRow)
+	var touches []touchpoint
+	var current uuid.UUID
+	var revenue float64
+	var totalTouches int64
+	flush := func() {
+		if current == uuid.Nil {
+			return
+		}
+		result.Conversions++
+		result.Revenue += revenue
+		totalTouches += int64(len(touches))
+		if len(touches) == 0 {
+			touches = []touchpoint{{value: BreakdownNone}}
+		}
+		for i, weight := range attributionWeights(result.Model, touches, halfLife) {
+			if weight == 0 {
+				continue
+			}
+			row := credits[touches[i].value]
+			if row == nil {
+				row = &AttributionRow{Value: touches[i].value}
+				credits[touches[i].value] = row
+			}
+			row.Conversions += weight
+			row.Revenue += weight * revenue
+		}
+	}
+
+	for rows.Next() {
+		var row attributionRow
+		if err := s.db.ScanRows(rows, &row); err != nil {
+			return nil, err
+		}
+		if row.ConversionID != current {
+			flush()
+			current = row.ConversionID
+			revenue = row.Value
+			touches = touches[:0]
+		}
+		if row.StartTime == nil {
+			continue
+		}
+		value := stringValue(row.Dimension)
+		if value == "" {
+			value = BreakdownNone
+		}
+		touches = append(touches, touchpoint{at: *row.StartTime, value: value, channel: stringValue(row.Channel)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	if result.Conversions > 0 {
+		result.AverageTouchpoints = float64(totalTouches) / float64(result.Conversions)
+	}
+	result.Rows = make([]AttributionRow, 0, len(credits))
+	for _, row := range credits {
+		if result.Conversions > 0 {
+			row.Share = row.Conversions / float64(result.Conversions)
+		}
+		result.Rows = append(result.Rows, *row)
+	}
+	sort.Slice(result.Rows, func(i, j int) bool {
+		a, b := result.Rows[i], result.Rows[j]
+		if a.Conversions != b.Conversions {
+			return a.Conversions > b.Conversions
+		}
+		return a.Value < b.Value
+	})
+	if len(result.Rows) > maxAttributionRows {
+		result.Rows = result.Rows[:maxAttributionRows]
+	}
+
+	return result, nil
+}
+
+// conversion renders the condition selecting the conversion events and the value of a conversion.
+// Conversion events are worth their revenue; goal completions are worth the goal value. Repeated
+// events of an order are one conversion, as in the revenue report.
+func (s *AttributionService) conversion(projectID uuid.UUID, req *AttributionRequest) (sqlFragment, sqlFragment, error) {
+	if req.GoalID != nil {
+		var goal models.Goal
+		if err := s.db.Where("id = ? AND project_id = ?", *req.GoalID, projectID).First(&goal).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return sqlFragment{}, sqlFragment{}, ErrGoalNotFound
+			}
+			return sqlFragment{}, sqlFragment{}, err
+		}
+		condition, err := goalCondition(&goal)
+		if err != nil {
+			return sqlFragment{}, sqlFragment{}, err
+		}
+		value, err := goalValue(&goal)
+		if err != nil {
+			return sqlFragment{}, sqlFragment{}, err
+		}
+		return condition, value, nil
+	}
+
+	condition := sqlFragment{sql: "TRUE"}
+	if req.EventType != "" {
+		condition.sql += " AND events.event_type = ?"
+		condition.args = append(condition.args, req.EventType)
+	}
+	if req.EventName != "" {
+		condition.sql += " AND events.event_name = ?"
+		condition.args = append(condition.args, req.EventName)
+	}
+	return condition, sqlFragment{sql: "events.base_revenue"}, nil
+}
+
+// attributionWeights splits one conversion across its touches, oldest first. The weights sum to 1.
+func attributionWeights(model string, touches []touchpoint, halfLifeDays float64) []float64 {
+	n := len(touches)
+	weights := make([]float64, n)
+	if n == 0 {
+		return weights
+	}
+
+	switch model {
+	case AttributionFirstTouch:
+		weights[0] = 1
+	case AttributionLastTouch:
+		weights[n-1] = 1
+	case AttributionLastNonDirect:
+		last := n - 1
+		for i := n - 1; i >= 0; i-- {
+			if touches[i].channel != utils.ChannelDirect && touches[i].channel != "" {
+				last = i
+				break
+			}
+		}
+		weights[last] = 1
+	case AttributionLinear:
+		for i := range weights {
+			weights[i] = 1 / float64(n)
+		}
+	case AttributionTimeDecay:
+		// A touch's weight halves with every half-life between it and the conversion. The decay
+		// is measured from the newest touch, whose weight is 1, so a short half-life can't bring
+		// every weight to 0.
+		total := 0.0
+		for i, touch := range touches {
+			days := touches[n-1].at.Sub(touch.at).Hours() / 24
+			weights[i] = math.Pow(2, -days/halfLifeDays)
+			total += weights[i]
+		}
+		for i := range weights {
+			weights[i] /= total
+		}
+	case AttributionPositionBased:
+		switch n {
+		case 1:
+			weights[0] = 1
+		case 2:
+			weights[0], weights[1] = 0.5, 0.5
+		default:
+			weights[0], weights[n-1] = positionBasedEndpointRate, positionBasedEndpointRate
+			middle := (1 - 2*positionBasedEndpointRate) / float64(n-2)
+			for i := 1; i < n-1; i++ {
+				weights[i] = middle
+			}
+		}
+	}
+	return weights
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"analytic-app/pkg/utils"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAttributionWeights(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time { return now.AddDate(0, 0, -days) }
+	touches := []touchpoint{
+		{at: daysAgo(14), channel: utils.ChannelEmail},
+		{at: daysAgo(7), channel: utils.ChannelOrganicSearch},
+		{at: daysAgo(7), channel: utils.ChannelReferral},
+		{at: daysAgo(0), channel: utils.ChannelDirect},
+	}
+
+	tests := []struct {
+		name         string
+		model        string
+		touches      []touchpoint
+		halfLifeDays float64
+		want         []float64
+	}{
+		{"first touch", AttributionFirstTouch, touches, 0, []float64{1, 0, 0, 0}},
+		{"last touch", AttributionLastTouch, touches, 0, []float64{0, 0, 0, 1}},
+		{"last non-direct", AttributionLastNonDirect, touches, 0, []float64{0, 0, 1, 0}},
+		{"last non-direct, all direct", AttributionLastNonDirect, []touchpoint{
+			{at: daysAgo(3), channel: utils.ChannelDirect},
+			{at: daysAgo(1), channel: utils.ChannelDirect},
+		}, 0, []float64{0, 1}},
+		{"linear", AttributionLinear, touches, 0, []float64{0.25, 0.25, 0.25, 0.25}},
+		{"time decay", AttributionTimeDecay, touches, 7, []float64{1.0 / 9, 2.0 / 9, 2.0 / 9, 4.0 / 9}},
+		{"time decay, half-life far shorter than the touches", AttributionTimeDecay, []touchpoint{
+			{at: daysAgo(30)},
+			{at: daysAgo(20)},
+		}, 0.01, []float64{0, 1}},
+		{"position based, one touch", AttributionPositionBased, touches[:1], 0, []float64{1}},
+		{"position based, two touches", AttributionPositionBased, touches[:2], 0, []float64{0.5, 0.5}},
+		{"position based", AttributionPositionBased, touches, 0, []float64{0.4, 0.1, 0.1, 0.4}},
+		{"no touches", AttributionLinear, nil, 0, []float64{}},
+	}
+
+	for _, tt := range tests {
+		got := attributionWeights(tt.model, tt.touches, tt.halfLifeDays)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d weights, want %d", tt.name, len(got), len(tt.want))
+			continue
+		}
+		for i := range got {
+			if math.IsNaN(got[i]) || math.Abs(got[i]-tt.want[i]) > 1e-9 {
+				t.Errorf("%s: weights = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
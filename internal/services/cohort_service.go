@@ -0,0 +1,495 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Cohort types
+const (
+	CohortStatic  = "static"  // an uploaded list of user IDs
+	CohortDynamic = "dynamic" // the users matching a behavioural definition
+)
+
+// How the criteria of a dynamic cohort combine
+const (
+	CohortMatchAll = "all"
+	CohortMatchAny = "any"
+)
+
+const (
+	maxCohortCriteria     = 10
+	maxCohortLookbackDays = 365
+	maxCohortUpload       = 100000
+	maxCohortUserIDLength = 255
+	cohortMemberBatchSize = 1000
+)
+
+type CohortService struct {
+	db *database.DB
+}
+
+func NewCohortService(db *database.DB) *CohortService {
+	return &CohortService{db: db}
+}
+
+// CohortRequest represents the request to create a cohort. Static cohorts take their
+// members from UserIDs, dynamic cohorts from the Definition.
+type CohortRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Description *string           `json:"description,omitempty"`
+	Type        string            `json:"type" binding:"required"`
+	Definition  *CohortDefinition `json:"definition,omitempty"`
+	UserIDs     []string          `json:"user_ids,omitempty"`
+}
+
+// UpdateCohortRequest represents the request to update a cohort. The definition only
+// applies to dynamic cohorts; static members are changed with AddMembers.
+type UpdateCohortRequest struct {
+	Name        *string           `json:"name,omitempty"`
+	Description *string           `json:"description,omitempty"`
+	Definition  *CohortDefinition `json:"definition,omitempty"`
+}
+
+// CohortMembersRequest adds user IDs to a static cohort, or replaces its members
+type CohortMembersRequest struct {
+	UserIDs []string `json:"user_ids"`
+	Replace bool     `json:"replace,omitempty"`
+}
+
+// CohortDefinition selects the identified users of a project matching all (default) or any
+// of the criteria
+type CohortDefinition struct {
+	Match    string            `json:"match,omitempty"`
+	Criteria []CohortCriterion `json:"criteria"`
+}
+
+// CohortCriterion matches the users with at least MinCount events matching all the filters
+// in the last WithinDays days (ever when 0), or, negated, the users without them
+type CohortCriterion struct {
+	Filters    []InsightFilter `json:"filters,omitempty"`
+	MinCount   int             `json:"min_count,omitempty"` // defaults to 1
+	WithinDays int             `json:"within_days,omitempty"`
+	Negate     bool            `json:"negate,omitempty"`
+}
+
+// CohortMemberRow is a member of a cohort with their first and last seen dates, which are
+// missing for uploaded user IDs that never sent an event
+type CohortMemberRow struct {
+	UserID    string
+	FirstSeen *time.Time
+	LastSeen  *time.Time
+}
+
+// Validate checks the match mode and the criteria
+func (d *CohortDefinition) Validate() error {
+	switch d.Match {
+	case "", CohortMatchAll, CohortMatchAny:
+	default:
+		return fmt.Errorf("unknown match %q, expected all or any", d.Match)
+	}
+	if len(d.Criteria) == 0 {
+		return errors.New("a dynamic cohort requires at least one criterion")
+	}
+	if len(d.Criteria) > maxCohortCriteria {
+		return fmt.Errorf("at most %d criteria are allowed", maxCohortCriteria)
+	}
+	for i, criterion := range d.Criteria {
+		if len(criterion.Filters) > maxInsightFilters {
+			return fmt.Errorf("criterion %d: at most %d filters are allowed", i+1, maxInsightFilters)
+		}
+		if _, err := filtersCondition(criterion.Filters); err != nil {
+			return fmt.Errorf("criterion %d: %v", i+1, err)
+		}
+		if criterion.MinCount < 0 {
+			return fmt.Errorf("criterion %d: min_count cannot be negative", i+1)
+		}
+		if criterion.WithinDays < 0 || criterion.WithinDays > maxCohortLookbackDays {
+			return fmt.Errorf("criterion %d: within_days must be between 0 and %d", i+1, maxCohortLookbackDays)
+		}
+	}
+	return nil
+}
+
+// CreateCohort creates a static or dynamic cohort for a project
+func (s *CohortService) CreateCohort(projectID uuid.UUID, req *CohortRequest) (*models.Cohort, error) {
+	cohort := &models.Cohort{
+		ProjectID:   projectID,
+		Name:        req.Name,
+		Description: req.Description,
+		Type:        req.Type,
+		Definition:  "{}",
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	switch req.Type {
+	case CohortStatic:
+		if req.Definition != nil {
+			return nil, errors.New("static cohorts take user_ids, not a definition")
+		}
+	case CohortDynamic:
+		if req.Definition == nil {
+			return nil, errors.New("dynamic cohorts require a definition")
+		}
+		if len(req.UserIDs) > 0 {
+			return nil, errors.New("dynamic cohorts take a definition, not user_ids")
+		}
+		if err := setCohortDefinition(cohort, req.Definition); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown cohort type %q, expected static or dynamic", req.Type)
+	}
+
+	userIDs, err := cleanUserIDs(req.UserIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(cohort).Error; err != nil {
+			return err
+		}
+		if cohort.Type != CohortStatic {
+			return nil
+		}
+		return replaceCohortMembers(tx, cohort, userIDs, true)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cohort, nil
+}
+
+// GetCohorts returns all cohorts of a project
+func (s *CohortService) GetCohorts(projectID uuid.UUID) ([]models.Cohort, error) {
+	var cohorts []models.Cohort
+	if err := s.db.Where("project_id = ?", projectID).Order("name").Find(&cohorts).Error; err != nil {
+		return nil, err
+	}
+	if cohorts == nil {
+		cohorts = []models.Cohort{}
+	}
+	return cohorts, nil
+}
+
+// GetCohort returns a cohort of a project
+func (s *CohortService) GetCohort(projectID, cohortID uuid.UUID) (*models.Cohort, error) {
+	var cohort models.Cohort
+	if err := s.db.Where("id = ? AND project_id = ?", cohortID, projectID).First(&cohort).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("cohort not found")
+		}
+		return nil, err
+	}
+	return &cohort, nil
+}
+
+// UpdateCohort updates the name, description or definition of a cohort
+func (s *CohortService) UpdateCohort(projectID, cohortID uuid.UUID, req *UpdateCohortRequest) (*models.Cohort, error) {
+	cohort, err := s.GetCohort(projectID, cohortID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		cohort.Name = *req.Name
+	}
+	if req.Description != nil {
+		cohort.Description = req.Description
+	}
+	if req.Definition != nil {
+		if cohort.Type != CohortDynamic {
+			return nil, errors.New("only dynamic cohorts have a definition")
+		}
+		if err := setCohortDefinition(cohort, req.Definition); err != nil {
+			return nil, err
+		}
+		// The cached size belongs to the previous definition
+		cohort.Size = 0
+		cohort.CalculatedAt = nil
+	}
+	cohort.UpdatedAt = time.Now()
+
+	if err := s.db.Save(cohort).Error; err != nil {
+		return nil, err
+	}
+
+	return cohort, nil
+}
+
+// DeleteCohort deletes a cohort and its members. Cohorts used by a segment are kept.
+func (s *CohortService) DeleteCohort(projectID, cohortID uuid.UUID) error {
+	cohort, err := s.GetCohort(projectID, cohortID)
+	if err != nil {
+		return err
+	}
+
+	var segments []models.Segment
+	if err := s.db.Where("cohort_id = ?", cohort.ID).Limit(1).Find(&segments).Error; err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		return fmt.Errorf("cohort is used by segment %q", segments[0].Name)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cohort_id = ?", cohort.ID).Delete(&models.CohortMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(cohort).Error
+	})
+}
+
+// AddMembers adds user IDs to a static cohort, replacing its members when asked to
+func (s *CohortService) AddMembers(projectID, cohortID uuid.UUID, req *CohortMembersRequest) (*models.Cohort, error) {
+	cohort, err := s.GetCohort(projectID, cohortID)
+	if err != nil {
+		return nil, err
+	}
+	if cohort.Type != CohortStatic {
+		return nil, errors.New("only static cohorts have uploaded members")
+	}
+	userIDs, err := cleanUserIDs(req.UserIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		return replaceCohortMembers(tx, cohort, userIDs, req.Replace)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cohort, nil
+}
+
+// CalculateSize counts the current members of a cohort and caches the size on the cohort
+func (s *CohortService) CalculateSize(projectID, cohortID uuid.UUID) (*models.Cohort, error) {
+	cohort, err := s.GetCohort(projectID, cohortID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := cohortMembersSQL(cohort)
+	if err != nil {
+		return nil, err
+	}
+
+	var size int64
+	if err := s.db.Raw(fmt.Sprintf("SELECT COUNT(*) FROM (%s) AS members", members.sql), members.args...).Scan(&size).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	cohort.Size = size
+	cohort.CalculatedAt = &now
+	if err := s.db.Model(cohort).Updates(map[string]interface{}{"size": size, "calculated_at": now}).Error; err != nil {
+		return nil, err
+	}
+	return cohort, nil
+}
+
+// EachMember calls fn for every current member of a cohort, in user ID order, without
+// loading them all in memory. First and last seen come from the project's events, people
+// resolved as in the user list.
+func (s *CohortService) EachMember(cohort *models.Cohort, fn func(CohortMemberRow) error) error {
+	members, err := cohortMembersSQL(cohort)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{cohort.ProjectID}
+	args = append(args, members.args...)
+	args = append(args, cohort.ProjectID)
+	rows, err := s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		members AS (%s),
+		seen AS (
+			SELECT %s AS user_id, MIN(events.created_at) AS first_seen, MAX(events.created_at) AS last_seen
+			FROM events
+			%s
+			WHERE events.project_id = ? AND events.is_internal = FALSE AND %s IN (SELECT user_id FROM members)
+			GROUP BY 1
+		)
+		SELECT members.user_id, seen.first_seen, seen.last_seen
+		FROM members
+		LEFT JOIN seen ON seen.user_id = members.user_id
+		ORDER BY members.user_id
+	`, sessionPeopleCTE, members.sql, identifiedPersonColumn, sessionPeopleJoin, identifiedPersonColumn), args...).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row CohortMemberRow
+		if err := rows.Scan(&row.UserID, &row.FirstSeen, &row.LastSeen); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Resolve loads a cohort as a restriction on events: those of its members
+func (s *CohortService) Resolve(cohortID uuid.UUID) (*QuerySegment, error) {
+	var cohort models.Cohort
+	if err := s.db.Where("id = ?", cohortID).First(&cohort).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("cohort not found")
+		}
+		return nil, err
+	}
+
+	condition, err := cohortCondition(&cohort)
+	if err != nil {
+		return nil, err
+	}
+	return &QuerySegment{ID: cohort.ID, Type: "cohort", Name: cohort.Name, ProjectID: cohort.ProjectID, condition: condition}, nil
+}
+
+// cohortCondition restricts events to those of the cohort members. Only the event's own
+// user_id is used, so anonymous events never match.
+func cohortCondition(cohort *models.Cohort) (sqlFragment, error) {
+	members, err := cohortMembersSQL(cohort)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+	return sqlFragment{sql: "events.user_id IN (" + members.sql + ")", args: members.args}, nil
+}
+
+// cohortMembersSQL renders a query returning the user_id of every member of a cohort.
+// Dynamic cohorts are evaluated against the project's identified users as of now.
+func cohortMembersSQL(cohort *models.Cohort) (sqlFragment, error) {
+	if cohort.Type == CohortStatic {
+		return sqlFragment{
+			sql:  "SELECT cohort_members.user_id FROM cohort_members WHERE cohort_members.cohort_id = ?",
+			args: []interface{}{cohort.ID},
+		}, nil
+	}
+
+	var definition CohortDefinition
+	if err := json.Unmarshal([]byte(cohort.Definition), &definition); err != nil {
+		return sqlFragment{}, fmt.Errorf("invalid cohort definition: %v", err)
+	}
+	if err := definition.Validate(); err != nil {
+		return sqlFragment{}, err
+	}
+
+	args := []interface{}{cohort.ProjectID}
+	clauses := make([]string, 0, len(definition.Criteria))
+	for _, criterion := range definition.Criteria {
+		filters, _ := filtersCondition(criterion.Filters)
+		where := "events.project_id = ? AND events.is_internal = FALSE AND events.user_id IS NOT NULL AND events.user_id <> ''"
+		args = append(args, cohort.ProjectID)
+		if criterion.WithinDays > 0 {
+			where += " AND events.created_at >= ?"
+			args = append(args, time.Now().AddDate(0, 0, -criterion.WithinDays))
+		}
+		args = append(args, filters.args...)
+		minCount := criterion.MinCount
+		if minCount == 0 {
+			minCount = 1
+		}
+		args = append(args, minCount)
+
+		operator := "IN"
+		if criterion.Negate {
+			operator = "NOT IN"
+		}
+		clauses = append(clauses, fmt.Sprintf(
+			"cohort_people.user_id %s (SELECT events.user_id FROM events WHERE %s AND %s GROUP BY events.user_id HAVING COUNT(*) >= ?)",
+			operator, where, filters.sql))
+	}
+
+	join := " AND "
+	if definition.Match == CohortMatchAny {
+		join = " OR "
+	}
+	return sqlFragment{
+		sql: fmt.Sprintf(`SELECT cohort_people.user_id FROM (
+			SELECT DISTINCT events.user_id
+			FROM events
+			WHERE events.project_id = ? AND events.is_internal = FALSE AND events.user_id IS NOT NULL AND events.user_id <> ''
+		) AS cohort_people
+		WHERE %s`, strings.Join(clauses, join)),
+		args: args,
+	}, nil
+}
+
+// setCohortDefinition validates and stores the definition of a dynamic cohort
+func setCohortDefinition(cohort *models.Cohort, definition *CohortDefinition) error {
+	if err := definition.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(definition)
+	if err != nil {
+		return err
+	}
+	cohort.Definition = string(data)
+	return nil
+}
+
+// replaceCohortMembers inserts the members of a static cohort, after removing the current
+// ones when replacing, and updates the cached size
+func replaceCohortMembers(tx *gorm.DB, cohort *models.Cohort, userIDs []string, replace bool) error {
+	if replace {
+		if err := tx.Where("cohort_id = ?", cohort.ID).Delete(&models.CohortMember{}).Error; err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	members := make([]models.CohortMember, 0, len(userIDs))
+	for _, userID := range userIDs {
+		members = append(members, models.CohortMember{CohortID: cohort.ID, UserID: userID, CreatedAt: now})
+	}
+	if len(members) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&members, cohortMemberBatchSize).Error; err != nil {
+			return err
+		}
+	}
+
+	var size int64
+	if err := tx.Model(&models.CohortMember{}).Where("cohort_id = ?", cohort.ID).Count(&size).Error; err != nil {
+		return err
+	}
+	cohort.Size = size
+	cohort.CalculatedAt = &now
+	cohort.UpdatedAt = now
+	return tx.Model(cohort).Updates(map[string]interface{}{"size": size, "calculated_at": now, "updated_at": now}).Error
+}
+
+// cleanUserIDs trims and deduplicates uploaded user IDs, dropping blanks
+func cleanUserIDs(userIDs []string) ([]string, error) {
+	if len(userIDs) > maxCohortUpload {
+		return nil, fmt.Errorf("at most %d user IDs can be uploaded at once", maxCohortUpload)
+	}
+	seen := make(map[string]bool, len(userIDs))
+	cleaned := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		userID = strings.TrimSpace(userID)
+		if userID == "" || seen[userID] {
+			continue
+		}
+		if len(userID) > maxCohortUserIDLength {
+			return nil, fmt.Errorf("user IDs are at most %d characters", maxCohortUserIDLength)
+		}
+		seen[userID] = true
+		cleaned = append(cleaned, userID)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Comparison modes
+const (
+	ComparePreviousPeriod = "previous_period"
+	ComparePreviousYear   = "previous_year"
+	CompareCustom         = "custom"
+)
+
+// Delta compares a value between the current and the comparison period
+type Delta struct {
+	Current  float64  `json:"current"`
+	Previous float64  `json:"previous"`
+	Change   float64  `json:"change"`
+	Percent  *float64 `json:"percent"` // nil when the previous value is zero
+}
+
+// NewDelta computes the absolute and percentage change from previous to current
+func NewDelta(current, previous float64) Delta {
+	d := Delta{
+		Current:  current,
+		Previous: previous,
+		Change:   current - previous,
+	}
+	if previous != 0 {
+		percent := (current - previous) / previous * 100
+		d.Percent = &percent
+	}
+	return d
+}
+
+// RowComparison compares one row of a top-N breakdown
+type RowComparison struct {
+	Key string `json:"key"`
+	Delta
+}
+
+// AlignedPoint pairs a bucket of the current series with the bucket at the same offset in the comparison series
+type AlignedPoint struct {
+	Bucket         time.Time        `json:"bucket"`
+	PreviousBucket *time.Time       `json:"previous_bucket,omitempty"`
+	Values         map[string]Delta `json:"values"`
+}
+
+// ComparisonPeriod describes the window a report is compared against
+type ComparisonPeriod struct {
+	Mode string    `json:"mode"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// ComparisonQuery derives the query for the comparison period of q.
+// Custom ranges use from and to; the other modes shift the window of q.
+func ComparisonQuery(q AnalyticsQuery, mode string, from, to time.Time) (AnalyticsQuery, error) {
+	prev := q
+	prev.In = nil
+
+	end := q.To
+	if end.IsZero() {
+		end = q.now()
+	}
+
+	switch mode {
+	case ComparePreviousPeriod:
+		if q.From.IsZero() {
+			return prev, errors.New("comparing with the previous period requires a from date")
+		}
+		length := end.Sub(q.From)
+		prev.From = q.From.Add(-length)
+		prev.To = q.From
+		prev.Now = q.now().Add(-length)
+	case ComparePreviousYear:
+		if q.From.IsZero() {
+			return prev, errors.New("comparing with the previous year requires a from date")
+		}
+		prev.From = q.From.AddDate(-1, 0, 0)
+		prev.To = end.AddDate(-1, 0, 0)
+		prev.Now = q.now().AddDate(-1, 0, 0)
+	case CompareCustom:
+		if from.IsZero() || to.IsZero() || !from.Before(to) {
+			return prev, errors.New("custom comparison requires compare_from before compare_to")
+		}
+		prev.From = from
+		prev.To = to
+		prev.Now = to
+	default:
+		return prev, fmt.Errorf("unknown comparison mode %q", mode)
+	}
+
+	return prev, nil
+}
+
+// Period describes the comparison window of a derived query
+func (q AnalyticsQuery) Period(mode string) ComparisonPeriod {
+	return ComparisonPeriod{
+		Mode: mode,
+		From: q.From.In(q.location()),
+		To:   q.To.In(q.location()),
+	}
+}
+
+// CompareTotals compares every numeric field of two report values of the same type
+func CompareTotals(current, previous interface{}) (map[string]Delta, error) {
+	cur, err := numericFields(current)
+	if err != nil {
+		return nil, err
+	}
+	prev, err := numericFields(previous)
+	if err != nil {
+		return nil, err
+	}
+
+	deltas := make(map[string]Delta, len(cur))
+	for key, value := range cur {
+		deltas[key] = NewDelta(value, prev[key])
+	}
+	return deltas, nil
+}
+
+// CompareRows compares the rows of a current top-N breakdown with the same keys in the comparison period
+func CompareRows[T any](current, previous []T, key func(T) string, value func(T) float64) []RowComparison {
+	prev := make(map[string]float64, len(previous))
+	for _, row := range previous {
+		prev[key(row)] += value(row)
+	}
+
+	rows := make([]RowComparison, 0, len(current))
+	for _, row := range current {
+		k := key(row)
+		rows = append(rows, RowComparison{Key: k, Delta: NewDelta(value(row), prev[k])})
+	}
+	return rows
+}
+
+// AlignSeries pairs each current bucket with the comparison bucket at the same position
+func AlignSeries(current, previous *TimeSeries) []AlignedPoint {
+	points := make([]AlignedPoint, 0, len(current.Points))
+	for i, point := range current.Points {
+		aligned := AlignedPoint{Bucket: point.Bucket, Values: make(map[string]Delta, len(point.Values))}
+		var prevValues map[string]float64
+		if i < len(previous.Points) {
+			bucket := previous.Points[i].Bucket
+			aligned.PreviousBucket = &bucket
+			prevValues = previous.Points[i].Values
+		}
+		for metric, value := range point.Values {
+			aligned.Values[metric] = NewDelta(value, prevValues[metric])
+		}
+		points = append(points, aligned)
+	}
+	return points
+}
+
+// numericFields flattens a report value into its top-level numeric JSON fields
+func numericFields(v interface{}) (map[string]float64, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	numbers := make(map[string]float64, len(fields))
+	for key, value := range fields {
+		if n, ok := value.(float64); ok {
+			numbers[key] = n
+		}
+	}
+	return numbers, nil
+}
+
+// AlignedDay pairs a day of the current daily counts with the day at the same offset in the comparison period
+type AlignedDay struct {
+	Date         string `json:"date"`
+	PreviousDate string `json:"previous_date"`
+	Delta
+}
+
+// AlignDays pairs daily counts with the comparison day shifted by the distance between both windows.
+// Days missing from either side count as zero.
+func AlignDays(current, previous []EventCountByDay, q, prev AnalyticsQuery) []AlignedDay {
+	offset := int(math.Round(q.From.Sub(prev.From).Hours() / 24))
+
+	counts := make(map[string]int64, len(previous))
+	for _, day := range previous {
+		counts[day.Date] = day.Count
+	}
+
+	days := make([]AlignedDay, 0, len(current))
+	for _, day := range current {
+		date, err := time.Parse("2006-01-02", day.Date)
+		if err != nil {
+			continue
+		}
+		previousDate := date.AddDate(0, 0, -offset).Format("2006-01-02")
+		days = append(days, AlignedDay{
+			Date:         day.Date,
+			PreviousDate: previousDate,
+			Delta:        NewDelta(float64(day.Count), float64(counts[previousDate])),
+		})
+	}
+	return days
+}
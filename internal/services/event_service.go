@@ -3,19 +3,33 @@ package services
 import (
 	"analytic-app/internal/database"
 	"analytic-app/internal/models"
+	"analytic-app/pkg/utils"
 	"encoding/json"
+	"fmt"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type EventService struct {
-	db *database.DB
+	db                     *database.DB
+	transformService       *TransformService
+	internalTrafficService *InternalTrafficService
+	revenueService         *RevenueService
+	groupService           *GroupService
 }
 
-func NewEventService(db *database.DB) *EventService {
-	return &EventService{db: db}
+func NewEventService(db *database.DB, transformService *TransformService, internalTrafficService *InternalTrafficService, revenueService *RevenueService, groupService *GroupService) *EventService {
+	return &EventService{
+		db:                     db,
+		transformService:       transformService,
+		internalTrafficService: internalTrafficService,
+		revenueService:         revenueService,
+		groupService:           groupService,
+	}
 }
 
 type CreateEventRequest struct {
@@ -29,18 +43,79 @@ type CreateEventRequest struct {
 	PageTitle    *string                `json:"page_title,omitempty"`
 	Referrer     *string                `json:"referrer,omitempty"`
 	UserAgent    *string                `json:"user_agent,omitempty"`
-	IPAddress    string                 `json:"ip_address",omitempty`
+	IPAddress    string                 `json:"ip_address,omitempty"`
 	Country      *string                `json:"country,omitempty"`
 	City         *string                `json:"city,omitempty"`
 	ScreenWidth  *int                   `json:"screen_width,omitempty"`
 	ScreenHeight *int                   `json:"screen_height,omitempty"`
 	Language     *string                `json:"language,omitempty"`
 	Platform     *string                `json:"platform,omitempty"`
+	Groups       map[string]string      `json:"groups,omitempty"` // group type to group key, e.g. {"company": "acme"}
+
+	// E-commerce info for purchase and refund events
+	OrderID  *string            `json:"order_id,omitempty"`
+	Revenue  *float64           `json:"revenue,omitempty"`
+	Currency *string            `json:"currency,omitempty"`
+	Items    []OrderItemRequest `json:"items,omitempty"`
+
+	// Set by the server, never read from the payload
+	Origin     *RequestOrigin `json:"-"`
+	IsInternal bool           `json:"-"`
 }
 
 func (s *EventService) CreateEvent(req *CreateEventRequest) (*models.Event, error) {
+	// Run the project's transformation rules before anything is stored
+	if s.transformService != nil {
+		result, err := s.transformService.ApplyRules(req)
+		if err != nil {
+			return nil, err
+		}
+		if result.Dropped {
+			return nil, ErrEventDropped
+		}
+	}
+
+	// Recognise the project's own traffic once rules have settled the final project and user
+	if s.internalTrafficService != nil && req.ProjectID != nil {
+		if internal, action := s.internalTrafficService.Classify(*req.ProjectID, req.UserID, req.Origin); internal {
+			if action == InternalTrafficDrop {
+				return nil, ErrInternalTraffic
+			}
+			req.IsInternal = true
+		}
+	}
+
+	if err := req.validateOrder(); err != nil {
+		return nil, err
+	}
+
+	// Trait operations update the user rather than being stored with the event
+	traits := extractTraitOperations(req.Properties)
+	if !traits.Empty() {
+		if req.UserID == nil || *req.UserID == "" || req.ProjectID == nil {
+			return nil, fmt.Errorf("%w: $set and $set_once require a user_id and a project", ErrInvalidTraits)
+		}
+		if err := traits.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Groups must be of the project's group types
+	if len(req.Groups) > 0 {
+		if s.groupService == nil || req.ProjectID == nil {
+			return nil, fmt.Errorf("%w: groups require a project", ErrInvalidGroups)
+		}
+		if err := s.groupService.ValidateGroups(*req.ProjectID, req.Groups); err != nil {
+			return nil, err
+		}
+	}
+	groupsJSON, err := encodeGroups(req.Groups)
+	if err != nil {
+		return nil, err
+	}
+
 	// Convert properties to JSON string
-	var propertiesJSON string
+	propertiesJSON := "{}"
 	if req.Properties != nil {
 		data, err := json.Marshal(req.Properties)
 		if err != nil {
@@ -57,6 +132,7 @@ func (s *EventService) CreateEvent(req *CreateEventRequest) (*models.Event, erro
 		EventType:    req.EventType,
 		EventName:    req.EventName,
 		Properties:   propertiesJSON,
+		Groups:       groupsJSON,
 		PageURL:      req.PageURL,
 		PageTitle:    req.PageTitle,
 		Referrer:     req.Referrer,
@@ -68,18 +144,51 @@ func (s *EventService) CreateEvent(req *CreateEventRequest) (*models.Event, erro
 		ScreenHeight: req.ScreenHeight,
 		Language:     req.Language,
 		Platform:     req.Platform,
+		IsInternal:   req.IsInternal,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 	}
 
-	if err := s.db.Create(event).Error; err != nil {
+	var items []models.OrderItem
+	if s.revenueService != nil && req.ProjectID != nil {
+		var err error
+		if items, err = s.revenueService.prepareOrder(req, event); err != nil {
+			return nil, err
+		}
+	}
+
+	// Traits change with the event so an event's operations apply after those of earlier events
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(event).Error; err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			if err := tx.Create(&items).Error; err != nil {
+				return err
+			}
+		}
+		if !traits.Empty() {
+			if _, err := applyTraits(tx, *req.ProjectID, *req.UserID, traits, TraitSourceEvent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	// Update session and user stats
-	go s.updateSessionStats(req.SessionID)
+	go s.updateSessionStats(event)
+	if len(req.Groups) > 0 {
+		go func(projectID uuid.UUID, groups map[string]string) {
+			if err := s.groupService.EnsureGroups(projectID, groups); err != nil {
+				log.Printf("Failed to create groups of event %s: %v", event.ID, err)
+			}
+		}(*req.ProjectID, req.Groups)
+	}
 	if req.UserID != nil {
-		go s.updateUserStats(*req.UserID, req.IPAddress, req.Country, req.City)
+		go s.updateUserStats(*req.UserID, interactionCount(event.EventType), req.IPAddress, req.Country, req.City)
 	}
 
 	return event, nil
@@ -101,31 +210,92 @@ func (s *EventService) GetEvents(limit, offset int, sessionID string) ([]models.
 	return events, err
 }
 
-func (s *EventService) updateSessionStats(sessionID string) {
-	var session models.Session
-	if err := s.db.Where("id = ?", sessionID).First(&session).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			// Create new session
-			session = models.Session{
-				ID:         sessionID,
-				StartTime:  time.Now(),
-				EventCount: 1,
-				CreatedAt:  time.Now(),
-				UpdatedAt:  time.Now(),
-			}
-			s.db.Create(&session)
-		}
-		return
+func (s *EventService) updateSessionStats(event *models.Event) {
+	isPageView := event.EventType == EventTypePageView && event.PageURL != nil && *event.PageURL != ""
+
+	// The first events of a session arrive together, so the session is created and updated in
+	// one statement. In the update, sessions.* is the stored session and EXCLUDED.* the session
+	// this event would start: its start_time is the event time, event_count leaves out scroll
+	// and engagement events, and page_views and exit_page are only set for page views. An event
+	// older than the session moves its start, and its landing page when it is a page view.
+	session := newSession(event)
+	session.EventCount = interactionCount(event.EventType)
+	if isPageView {
+		session.PageViews = 1
+		session.ExitPage = event.PageURL
 	}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"event_count": gorm.Expr("sessions.event_count + EXCLUDED.event_count"),
+			"page_views":  gorm.Expr("sessions.page_views + EXCLUDED.page_views"),
+			"start_time":  gorm.Expr("LEAST(sessions.start_time, EXCLUDED.start_time)"),
+			"end_time":    gorm.Expr("GREATEST(COALESCE(sessions.end_time, sessions.start_time), EXCLUDED.start_time)"),
+			"duration":    gorm.Expr("CAST(EXTRACT(EPOCH FROM (GREATEST(COALESCE(sessions.end_time, sessions.start_time), EXCLUDED.start_time) - LEAST(sessions.start_time, EXCLUDED.start_time))) AS bigint)"),
+			// Events are processed out of order; only the latest page view is the exit page. The
+			// event is stored by now, so a later page view of the session is already visible.
+			"exit_page":    gorm.Expr("CASE WHEN EXCLUDED.exit_page IS NOT NULL AND NOT EXISTS (SELECT 1 FROM events WHERE events.session_id = EXCLUDED.id AND events.event_type = ? AND events.page_url != '' AND events.created_at > EXCLUDED.start_time) THEN EXCLUDED.exit_page ELSE sessions.exit_page END", EventTypePageView),
+			"landing_page": gorm.Expr("CASE WHEN EXCLUDED.exit_page IS NOT NULL AND EXCLUDED.start_time < sessions.start_time THEN EXCLUDED.exit_page ELSE COALESCE(sessions.landing_page, EXCLUDED.exit_page) END"),
+			"user_id":      gorm.Expr("COALESCE(sessions.user_id, EXCLUDED.user_id)"),
+			"project_id":   gorm.Expr("COALESCE(sessions.project_id, EXCLUDED.project_id)"),
+			"updated_at":   gorm.Expr("EXCLUDED.updated_at"),
+		}),
+	}).Create(&session).Error
+	if err != nil {
+		log.Printf("Failed to update session %s: %v", event.SessionID, err)
+	}
+}
 
-	// Update session
-	s.db.Model(&session).Updates(map[string]interface{}{
-		"event_count": gorm.Expr("event_count + 1"),
-		"updated_at":  time.Now(),
-	})
+// newSession builds a session from its first event
+func newSession(event *models.Event) models.Session {
+	pageURL := stringValue(event.PageURL)
+	referrer := stringValue(event.Referrer)
+	utm := utils.ParseUTM(pageURL)
+	channel := utils.ClassifyChannel(referrer, pageURL, utm)
+	deviceType := utils.DeviceType(stringValue(event.UserAgent), event.ScreenWidth)
+	duration := int64(0)
+	endTime := event.CreatedAt
+
+	return models.Session{
+		ID:          event.SessionID,
+		ProjectID:   event.ProjectID,
+		UserID:      event.UserID,
+		StartTime:   event.CreatedAt,
+		EndTime:     &endTime,
+		Duration:    &duration,
+		EventCount:  1,
+		LandingPage: event.PageURL,
+		Referrer:    event.Referrer,
+		Channel:     &channel,
+		UTMSource:   optionalString(utm.Source),
+		UTMMedium:   optionalString(utm.Medium),
+		UTMCampaign: optionalString(utm.Campaign),
+		UserAgent:   event.UserAgent,
+		IPAddress:   event.IPAddress,
+		Country:     event.Country,
+		City:        event.City,
+		DeviceType:  &deviceType,
+		IsInternal:  event.IsInternal,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
 }
 
-func (s *EventService) updateUserStats(userID, ipAddress string, country, city *string) {
+func (s *EventService) updateUserStats(userID string, events int, ipAddress string, country, city *string) {
 	var user models.User
 	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
@@ -135,7 +305,7 @@ func (s *EventService) updateUserStats(userID, ipAddress string, country, city *
 				FirstSeen:    time.Now(),
 				LastSeen:     time.Now(),
 				SessionCount: 1,
-				EventCount:   1,
+				EventCount:   events,
 				Country:      country,
 				City:         city,
 				CreatedAt:    time.Now(),
@@ -149,7 +319,7 @@ func (s *EventService) updateUserStats(userID, ipAddress string, country, city *
 	// Update user
 	updates := map[string]interface{}{
 		"last_seen":   time.Now(),
-		"event_count": gorm.Expr("event_count + 1"),
+		"event_count": gorm.Expr("event_count + ?", events),
 		"updated_at":  time.Now(),
 	}
 
@@ -0,0 +1,672 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"analytic-app/pkg/stats"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Experiment statuses
+const (
+	ExperimentDraft   = "draft"
+	ExperimentRunning = "running"
+	ExperimentStopped = "stopped"
+)
+
+const (
+	DefaultExposureEvent    = "experiment_exposure"
+	defaultVariantProperty  = "variant"
+	defaultConfidenceLevel  = 0.95
+	maxExperimentVariants   = 10
+	maxExperimentGoals      = 10
+	srmThreshold            = 0.001 // p-value below which the split is reported as a sample ratio mismatch
+	experimentPropertyField = propertyPrefix + "experiment"
+	minExposuresPerVariant  = 100 // below this the normal approximations are unreliable
+	keyDescription          = "letters, digits, '_', '-' and '.'"
+)
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,64}$`)
+
+type ExperimentService struct {
+	db *database.DB
+}
+
+func NewExperimentService(db *database.DB) *ExperimentService {
+	return &ExperimentService{db: db}
+}
+
+// ExperimentVariant is an arm of an experiment. Weight is its planned share of the
+// traffic, relative to the other variants; variants without weights split evenly.
+type ExperimentVariant struct {
+	Key    string  `json:"key"`
+	Weight float64 `json:"weight,omitempty"`
+}
+
+// ExperimentRequest represents the request to create an experiment
+type ExperimentRequest struct {
+	Key              string              `json:"key" binding:"required"`
+	Name             string              `json:"name" binding:"required"`
+	Description      *string             `json:"description,omitempty"`
+	Variants         []ExperimentVariant `json:"variants" binding:"required"`
+	ExposureEvent    string              `json:"exposure_event,omitempty"`
+	VariantProperty  string              `json:"variant_property,omitempty"`
+	GoalIDs          []uuid.UUID         `json:"goal_ids" binding:"required"`
+	ConfidenceLevel  float64             `json:"confidence_level,omitempty"`
+	TargetSampleSize *int64              `json:"target_sample_size,omitempty"`
+}
+
+// UpdateExperimentRequest represents the request to update an experiment
+type UpdateExperimentRequest struct {
+	Name             *string             `json:"name,omitempty"`
+	Description      *string             `json:"description,omitempty"`
+	Variants         []ExperimentVariant `json:"variants,omitempty"`
+	ExposureEvent    *string             `json:"exposure_event,omitempty"`
+	VariantProperty  *string             `json:"variant_property,omitempty"`
+	GoalIDs          []uuid.UUID         `json:"goal_ids,omitempty"`
+	ConfidenceLevel  *float64            `json:"confidence_level,omitempty"`
+	TargetSampleSize *int64              `json:"target_sample_size,omitempty"`
+	Status           *string             `json:"status,omitempty"`
+}
+
+// VariantResult compares a variant with the control on one goal. Rates are fractions between
+// 0 and 1; lift is relative to the control rate. The control carries no comparison.
+type VariantResult struct {
+	Variant                  string          `json:"variant"`
+	Control                  bool            `json:"control"`
+	Exposures                int64           `json:"exposures"`
+	Conversions              int64           `json:"conversions"`
+	ConversionRate           float64         `json:"conversion_rate"`
+	Interval                 stats.Interval  `json:"interval"`
+	Value                    float64         `json:"value"`
+	ValuePerExposure         float64         `json:"value_per_exposure"`
+	Lift                     *float64        `json:"lift,omitempty"`
+	LiftInterval             *stats.Interval `json:"lift_interval,omitempty"`
+	PValue                   *float64        `json:"p_value,omitempty"`
+	Significant              bool            `json:"significant"`
+	ProbabilityToBeatControl *float64        `json:"probability_to_beat_control,omitempty"`
+	CredibleInterval         stats.Interval  `json:"credible_interval"`
+}
+
+// ExperimentMetricResult holds the variant comparison of one goal
+type ExperimentMetricResult struct {
+	GoalID   uuid.UUID       `json:"goal_id"`
+	Name     string          `json:"name"`
+	Variants []VariantResult `json:"variants"`
+}
+
+// SampleRatioCheck compares the exposures of each variant with the planned split
+type SampleRatioCheck struct {
+	Expected  map[string]float64 `json:"expected"` // planned share of each variant
+	Observed  map[string]int64   `json:"observed"`
+	ChiSquare float64            `json:"chi_square"`
+	PValue    float64            `json:"p_value"`
+	Mismatch  bool               `json:"mismatch"`
+}
+
+// SequentialCheck adjusts the significance threshold for looking at results before the
+// target sample size is reached (O'Brien-Fleming alpha spending)
+type SequentialCheck struct {
+	InformationFraction float64 `json:"information_fraction"`
+	AdjustedAlpha       float64 `json:"adjusted_alpha"`
+}
+
+// ExperimentResults is the analysis of an experiment
+type ExperimentResults struct {
+	ExperimentID    uuid.UUID                `json:"experiment_id"`
+	Status          string                   `json:"status"`
+	ConfidenceLevel float64                  `json:"confidence_level"`
+	Exposures       int64                    `json:"exposures"`
+	Excluded        int64                    `json:"excluded"` // people exposed to more than one variant
+	SampleRatio     SampleRatioCheck         `json:"sample_ratio"`
+	Sequential      *SequentialCheck         `json:"sequential,omitempty"`
+	Metrics         []ExperimentMetricResult `json:"metrics"`
+	Warnings        []string                 `json:"warnings"`
+}
+
+type exposureCountRow struct {
+	Variant   string
+	Exposures int64
+}
+
+type variantConversionRow struct {
+	Variant     string
+	Conversions int64
+	Value       float64
+}
+
+// CreateExperiment creates a draft experiment for a project
+func (s *ExperimentService) CreateExperiment(projectID uuid.UUID, req *ExperimentRequest) (*models.Experiment, error) {
+	if !keyPattern.MatchString(req.Key) {
+		return nil, fmt.Errorf("experiment keys are 1 to 64 %s", keyDescription)
+	}
+	var existing int64
+	if err := s.db.Model(&models.Experiment{}).Where("project_id = ? AND key = ?", projectID, req.Key).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, fmt.Errorf("experiment key %q is already used", req.Key)
+	}
+
+	experiment := &models.Experiment{
+		ProjectID:        projectID,
+		Key:              req.Key,
+		Name:             req.Name,
+		Description:      req.Description,
+		ExposureEvent:    req.ExposureEvent,
+		VariantProperty:  req.VariantProperty,
+		ConfidenceLevel:  req.ConfidenceLevel,
+		TargetSampleSize: req.TargetSampleSize,
+		Status:           ExperimentDraft,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if experiment.ExposureEvent == "" {
+		experiment.ExposureEvent = DefaultExposureEvent
+	}
+	if experiment.VariantProperty == "" {
+		experiment.VariantProperty = defaultVariantProperty
+	}
+	if experiment.ConfidenceLevel == 0 {
+		experiment.ConfidenceLevel = defaultConfidenceLevel
+	}
+
+	if err := s.setDesign(experiment, req.Variants, req.GoalIDs); err != nil {
+		return nil, err
+	}
+	if err := validateExperiment(experiment); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(experiment).Error; err != nil {
+		return nil, err
+	}
+
+	return experiment, nil
+}
+
+// GetExperiments returns all experiments of a project
+func (s *ExperimentService) GetExperiments(projectID uuid.UUID) ([]models.Experiment, error) {
+	var experiments []models.Experiment
+	err := s.db.Where("project_id = ?", projectID).
+		Order("created_at DESC").
+		Find(&experiments).Error
+
+	if experiments == nil {
+		experiments = []models.Experiment{}
+	}
+
+	return experiments, err
+}
+
+// GetExperiment returns a single experiment of a project
+func (s *ExperimentService) GetExperiment(projectID, experimentID uuid.UUID) (*models.Experiment, error) {
+	var experiment models.Experiment
+	if err := s.db.Where("id = ? AND project_id = ?", experimentID, projectID).First(&experiment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("experiment not found")
+		}
+		return nil, err
+	}
+	return &experiment, nil
+}
+
+// UpdateExperiment updates an experiment. Moving it to running records the start time
+// and stopping it records the end time.
+func (s *ExperimentService) UpdateExperiment(projectID, experimentID uuid.UUID, req *UpdateExperimentRequest) (*models.Experiment, error) {
+	experiment, err := s.GetExperiment(projectID, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		experiment.Name = *req.Name
+	}
+	if req.Description != nil {
+		experiment.Description = req.Description
+	}
+	if req.ExposureEvent != nil {
+		experiment.ExposureEvent = *req.ExposureEvent
+	}
+	if req.VariantProperty != nil {
+		experiment.VariantProperty = *req.VariantProperty
+	}
+	if req.ConfidenceLevel != nil {
+		experiment.ConfidenceLevel = *req.ConfidenceLevel
+	}
+	if req.TargetSampleSize != nil {
+		// Zero clears the target
+		if *req.TargetSampleSize == 0 {
+			experiment.TargetSampleSize = nil
+		} else {
+			experiment.TargetSampleSize = req.TargetSampleSize
+		}
+	}
+	if req.Variants != nil || req.GoalIDs != nil {
+		variants, err := experimentVariants(experiment)
+		if err != nil {
+			return nil, err
+		}
+		goalIDs, err := experimentGoalIDs(experiment)
+		if err != nil {
+			return nil, err
+		}
+		if req.Variants != nil {
+			variants = req.Variants
+		}
+		if req.GoalIDs != nil {
+			goalIDs = req.GoalIDs
+		}
+		if err := s.setDesign(experiment, variants, goalIDs); err != nil {
+			return nil, err
+		}
+	}
+	if req.Status != nil && *req.Status != experiment.Status {
+		now := time.Now()
+		switch *req.Status {
+		case ExperimentRunning:
+			if experiment.StartedAt == nil {
+				experiment.StartedAt = &now
+			}
+			experiment.EndedAt = nil
+		case ExperimentStopped:
+			if experiment.StartedAt == nil {
+				return nil, errors.New("only a started experiment can be stopped")
+			}
+			experiment.EndedAt = &now
+		case ExperimentDraft:
+			if experiment.StartedAt != nil {
+				return nil, errors.New("a started experiment cannot return to draft")
+			}
+		default:
+			return nil, fmt.Errorf("unknown experiment status %q", *req.Status)
+		}
+		experiment.Status = *req.Status
+	}
+
+	if err := validateExperiment(experiment); err != nil {
+		return nil, err
+	}
+
+	experiment.UpdatedAt = time.Now()
+	if err := s.db.Save(experiment).Error; err != nil {
+		return nil, err
+	}
+
+	return experiment, nil
+}
+
+// DeleteExperiment deletes an experiment
+func (s *ExperimentService) DeleteExperiment(projectID, experimentID uuid.UUID) error {
+	experiment, err := s.GetExperiment(projectID, experimentID)
+	if err != nil {
+		return err
+	}
+	return s.db.Delete(experiment).Error
+}
+
+// GetResults compares the variants of an experiment on each of its goals. People are
+// assigned the variant of their first exposure in the window and convert when they complete
+// the goal after it; people exposed to several variants are excluded.
+func (s *ExperimentService) GetResults(q AnalyticsQuery, experiment *models.Experiment) (*ExperimentResults, error) {
+	variants, err := experimentVariants(experiment)
+	if err != nil {
+		return nil, err
+	}
+	goalIDs, err := experimentGoalIDs(experiment)
+	if err != nil {
+		return nil, err
+	}
+	var goals []models.Goal
+	if err := s.db.Where("project_id = ? AND id IN ?", experiment.ProjectID, goalIDs).Find(&goals).Error; err != nil {
+		return nil, err
+	}
+	goalsByID := make(map[uuid.UUID]models.Goal, len(goals))
+	for _, goal := range goals {
+		goalsByID[goal.ID] = goal
+	}
+
+	exposures, args, err := s.exposuresCTE(q, experiment, variants)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &ExperimentResults{
+		ExperimentID:    experiment.ID,
+		Status:          experiment.Status,
+		ConfidenceLevel: experiment.ConfidenceLevel,
+		Metrics:         make([]ExperimentMetricResult, 0, len(goalIDs)),
+		Warnings:        []string{},
+	}
+
+	var counts []exposureCountRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s, %s
+		SELECT CASE WHEN variants > 1 THEN '' ELSE variant END AS variant, COUNT(*) AS exposures
+		FROM exposures
+		GROUP BY 1
+	`, sessionPeopleCTE, exposures), args...).Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+	exposed := make(map[string]int64, len(variants))
+	for _, row := range counts {
+		if row.Variant == "" {
+			results.Excluded = row.Exposures
+			continue
+		}
+		exposed[row.Variant] = row.Exposures
+		results.Exposures += row.Exposures
+	}
+
+	results.SampleRatio = sampleRatioCheck(variants, exposed)
+	if results.SampleRatio.Mismatch {
+		results.Warnings = append(results.Warnings, "Sample ratio mismatch: the exposures do not match the planned split, so the assignment or tracking may be broken and the results should not be trusted")
+	}
+
+	alpha := 1 - experiment.ConfidenceLevel
+	if experiment.Status == ExperimentRunning {
+		if experiment.TargetSampleSize != nil && *experiment.TargetSampleSize > 0 {
+			fraction := float64(results.Exposures) / float64(*experiment.TargetSampleSize)
+			if fraction < 1 {
+				results.Sequential = &SequentialCheck{
+					InformationFraction: fraction,
+					AdjustedAlpha:       stats.OBrienFlemingAlpha(alpha, fraction),
+				}
+				alpha = results.Sequential.AdjustedAlpha
+				results.Warnings = append(results.Warnings, fmt.Sprintf("The experiment has reached %.0f%% of its target sample size; significance uses the stricter interim threshold p < %.4g", fraction*100, alpha))
+			}
+		} else {
+			results.Warnings = append(results.Warnings, "The experiment is still running without a target sample size: p-values assume a single look at the data, so stopping as soon as a result looks significant inflates false positives")
+		}
+	}
+	for _, variant := range variants {
+		if exposed[variant.Key] < minExposuresPerVariant {
+			results.Warnings = append(results.Warnings, fmt.Sprintf("Variant %q has fewer than %d exposures; the intervals and p-values are unreliable", variant.Key, minExposuresPerVariant))
+		}
+	}
+
+	for _, goalID := range goalIDs {
+		goal, ok := goalsByID[goalID]
+		if !ok {
+			results.Warnings = append(results.Warnings, fmt.Sprintf("Goal %s no longer exists", goalID))
+			continue
+		}
+		conversions, err := s.variantConversions(q, &goal, exposures, args)
+		if err != nil {
+			return nil, err
+		}
+		results.Metrics = append(results.Metrics, ExperimentMetricResult{
+			GoalID:   goal.ID,
+			Name:     goal.Name,
+			Variants: compareVariants(variants, exposed, conversions, experiment.ConfidenceLevel, alpha),
+		})
+	}
+
+	return results, nil
+}
+
+// exposuresCTE renders the first exposure of each person, or group with aggregate_by, with the
+// number of variants they saw.
+// Exposure events must carry the experiment key in their experiment property when they have one.
+func (s *ExperimentService) exposuresCTE(q AnalyticsQuery, experiment *models.Experiment, variants []ExperimentVariant) (string, []interface{}, error) {
+	variantText, err := fieldText(propertyPrefix + experiment.VariantProperty)
+	if err != nil {
+		return "", nil, err
+	}
+	experimentText, err := fieldText(experimentPropertyField)
+	if err != nil {
+		return "", nil, err
+	}
+
+	keys := make([]string, 0, len(variants))
+	for _, variant := range variants {
+		keys = append(keys, variant.Key)
+	}
+
+	where, whereArgs := q.conditions(true)
+	args := []interface{}{*q.ProjectID}
+	args = append(args, variantText.args...)
+	args = append(args, variantText.args...)
+	args = append(args, whereArgs...)
+	args = append(args, experiment.ExposureEvent)
+	args = append(args, variantText.args...)
+	args = append(args, keys)
+	args = append(args, experimentText.args...)
+	args = append(args, experimentText.args...)
+	args = append(args, experiment.Key)
+
+	sql := fmt.Sprintf(`exposures AS (
+		SELECT
+			%s AS person,
+			MIN(events.created_at) AS exposed_at,
+			(ARRAY_AGG(%s ORDER BY events.created_at))[1] AS variant,
+			COUNT(DISTINCT %s) AS variants
+		FROM events
+		%s
+		WHERE %s AND events.event_name = ? AND %s IN ? AND (%s IS NULL OR %s = ?)
+		GROUP BY 1
+	)`, q.actorColumn(), variantText.sql, variantText.sql, sessionPeopleJoin, where, variantText.sql, experimentText.sql, experimentText.sql)
+
+	return sql, args, nil
+}
+
+// variantConversions counts, per variant, the people who completed a goal after their first exposure
+func (s *ExperimentService) variantConversions(q AnalyticsQuery, goal *models.Goal, exposures string, exposureArgs []interface{}) (map[string]variantConversionRow, error) {
+	condition, err := goalCondition(goal)
+	if err != nil {
+		return nil, err
+	}
+	value, err := goalValue(goal)
+	if err != nil {
+		return nil, err
+	}
+
+	where, whereArgs := q.baseConditions(true)
+	args := append([]interface{}{}, exposureArgs...)
+	args = append(args, value.args...)
+	args = append(args, whereArgs...)
+	args = append(args, condition.args...)
+
+	var rows []variantConversionRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s, %s,
+		completions AS (
+			SELECT %s AS person, events.created_at, %s AS value
+			FROM events
+			%s
+			WHERE %s AND %s
+		)
+		SELECT exposures.variant, COUNT(*) AS conversions, COALESCE(SUM(converted.value), 0) AS value
+		FROM exposures
+		JOIN (
+			SELECT completions.person, SUM(completions.value) AS value
+			FROM completions
+			JOIN exposures ON exposures.person = completions.person AND completions.created_at >= exposures.exposed_at
+			GROUP BY completions.person
+		) AS converted ON converted.person = exposures.person
+		WHERE exposures.variants = 1
+		GROUP BY exposures.variant
+	`, sessionPeopleCTE, exposures, q.actorColumn(), value.sql, sessionPeopleJoin, where, condition.sql), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	conversions := make(map[string]variantConversionRow, len(rows))
+	for _, row := range rows {
+		conversions[row.Variant] = row
+	}
+	return conversions, nil
+}
+
+// compareVariants computes the rates of each variant and compares the others with the control (the first variant)
+func compareVariants(variants []ExperimentVariant, exposed map[string]int64, conversions map[string]variantConversionRow, confidence, alpha float64) []VariantResult {
+	results := make([]VariantResult, 0, len(variants))
+	control := variants[0].Key
+	controlN, controlC := exposed[control], conversions[control].Conversions
+
+	for i, variant := range variants {
+		n := exposed[variant.Key]
+		row := conversions[variant.Key]
+		result := VariantResult{
+			Variant:          variant.Key,
+			Control:          i == 0,
+			Exposures:        n,
+			Conversions:      row.Conversions,
+			Interval:         stats.WilsonInterval(row.Conversions, n, confidence),
+			CredibleInterval: stats.CredibleInterval(row.Conversions, n, confidence),
+			Value:            row.Value,
+		}
+		if n > 0 {
+			result.ConversionRate = float64(row.Conversions) / float64(n)
+			result.ValuePerExposure = row.Value / float64(n)
+		}
+
+		if i > 0 && n > 0 && controlN > 0 {
+			_, pValue := stats.TwoProportionZTest(controlC, controlN, row.Conversions, n)
+			probability := stats.ProbabilityBBeatsA(controlC, controlN, row.Conversions, n)
+			result.PValue = &pValue
+			result.Significant = pValue < alpha
+			result.ProbabilityToBeatControl = &probability
+
+			if controlC > 0 {
+				controlRate := float64(controlC) / float64(controlN)
+				lift := (result.ConversionRate - controlRate) / controlRate
+				interval := stats.LiftInterval(controlC, controlN, row.Conversions, n, confidence)
+				result.Lift = &lift
+				result.LiftInterval = &interval
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// sampleRatioCheck tests the exposures of the variants against their planned weights
+func sampleRatioCheck(variants []ExperimentVariant, exposed map[string]int64) SampleRatioCheck {
+	check := SampleRatioCheck{
+		Expected: make(map[string]float64, len(variants)),
+		Observed: make(map[string]int64, len(variants)),
+	}
+
+	observed := make([]int64, 0, len(variants))
+	shares := make([]float64, 0, len(variants))
+	total := 0.0
+	for _, variant := range variants {
+		total += variantWeight(variant)
+	}
+	for _, variant := range variants {
+		share := variantWeight(variant) / total
+		check.Expected[variant.Key] = share
+		check.Observed[variant.Key] = exposed[variant.Key]
+		observed = append(observed, exposed[variant.Key])
+		shares = append(shares, share)
+	}
+
+	check.ChiSquare, check.PValue = stats.ChiSquareGoodnessOfFit(observed, shares)
+	check.Mismatch = check.PValue < srmThreshold
+	return check
+}
+
+// setDesign validates and stores the variants and goals of an experiment
+func (s *ExperimentService) setDesign(experiment *models.Experiment, variants []ExperimentVariant, goalIDs []uuid.UUID) error {
+	if len(variants) < 2 || len(variants) > maxExperimentVariants {
+		return fmt.Errorf("an experiment needs between 2 and %d variants", maxExperimentVariants)
+	}
+	seen := make(map[string]bool, len(variants))
+	weighted := 0
+	for _, variant := range variants {
+		if variant.Key == "" {
+			return errors.New("variants require a key")
+		}
+		if seen[variant.Key] {
+			return fmt.Errorf("duplicate variant %q", variant.Key)
+		}
+		seen[variant.Key] = true
+		if variant.Weight < 0 {
+			return fmt.Errorf("variant %q has a negative weight", variant.Key)
+		}
+		if variant.Weight > 0 {
+			weighted++
+		}
+	}
+	if weighted != 0 && weighted != len(variants) {
+		return errors.New("give every variant a weight, or none for an even split")
+	}
+
+	if len(goalIDs) == 0 || len(goalIDs) > maxExperimentGoals {
+		return fmt.Errorf("an experiment needs between 1 and %d goals", maxExperimentGoals)
+	}
+	var found int64
+	if err := s.db.Model(&models.Goal{}).Where("project_id = ? AND id IN ?", experiment.ProjectID, goalIDs).Count(&found).Error; err != nil {
+		return err
+	}
+	if found != int64(len(goalIDs)) {
+		return errors.New("every goal must exist in the project")
+	}
+
+	variantsJSON, err := json.Marshal(variants)
+	if err != nil {
+		return err
+	}
+	goalsJSON, err := json.Marshal(goalIDs)
+	if err != nil {
+		return err
+	}
+	experiment.Variants = string(variantsJSON)
+	experiment.GoalIDs = string(goalsJSON)
+	return nil
+}
+
+func validateExperiment(experiment *models.Experiment) error {
+	if experiment.ExposureEvent == "" {
+		return errors.New("exposure_event cannot be empty")
+	}
+	if _, err := propertyPath(propertyPrefix + experiment.VariantProperty); err != nil {
+		return fmt.Errorf("invalid variant_property: %v", err)
+	}
+	if experiment.ConfidenceLevel < 0.8 || experiment.ConfidenceLevel > 0.999 {
+		return errors.New("confidence_level must be between 0.8 and 0.999")
+	}
+	if experiment.TargetSampleSize != nil && *experiment.TargetSampleSize < 0 {
+		return errors.New("target_sample_size cannot be negative")
+	}
+	return nil
+}
+
+func experimentVariants(experiment *models.Experiment) ([]ExperimentVariant, error) {
+	var variants []ExperimentVariant
+	if err := json.Unmarshal([]byte(experiment.Variants), &variants); err != nil {
+		return nil, err
+	}
+	if len(variants) < 2 {
+		return nil, errors.New("experiment has fewer than 2 variants")
+	}
+	return variants, nil
+}
+
+func experimentGoalIDs(experiment *models.Experiment) ([]uuid.UUID, error) {
+	var goalIDs []uuid.UUID
+	if experiment.GoalIDs == "" {
+		return goalIDs, nil
+	}
+	if err := json.Unmarshal([]byte(experiment.GoalIDs), &goalIDs); err != nil {
+		return nil, err
+	}
+	return goalIDs, nil
+}
+
+// variantWeight defaults missing weights to an even split
+func variantWeight(variant ExperimentVariant) float64 {
+	if variant.Weight == 0 {
+		return 1
+	}
+	return variant.Weight
+}
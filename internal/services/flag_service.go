@@ -0,0 +1,573 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Reasons given for a flag evaluation
+const (
+	FlagReasonMatch     = "match"     // the person is served the flag
+	FlagReasonInactive  = "inactive"  // the flag or its experiment is switched off
+	FlagReasonTargeting = "targeting" // the properties do not match the targeting
+	FlagReasonRollout   = "rollout"   // the person falls outside the rollout percentage
+	FlagReasonNoID      = "no_id"     // no identifier to hash
+	FlagReasonError     = "error"     // the flag is misconfigured
+)
+
+// ErrExposureSessionRequired is returned when flags linked to an experiment are evaluated with
+// exposure tracking but without a session_id
+var ErrExposureSessionRequired = errors.New("a session_id is required to evaluate experiment flags with exposure tracking")
+
+const (
+	FlagExposureEvent = "feature_flag_exposure"
+	eventTypeExposure = "exposure"
+	maxFlagVariants   = 10
+	maxFlagTargeting  = 20
+
+	// exposureTTL is how long a recorded exposure suppresses repeats of the same flag in a session
+	exposureTTL = 12 * time.Hour
+)
+
+type FlagService struct {
+	db           *database.DB
+	eventService *EventService
+	exposures    *exposureSet
+}
+
+func NewFlagService(db *database.DB, eventService *EventService) *FlagService {
+	return &FlagService{db: db, eventService: eventService, exposures: newExposureSet()}
+}
+
+// exposureSet remembers the flags recently exposed in each session, so evaluations record an
+// exposure once per session without querying the events. It is kept per server instance; a
+// restart or another instance may record an exposure again, which the analyses tolerate since
+// they use the first exposure of each person.
+type exposureSet struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newExposureSet() *exposureSet {
+	return &exposureSet{seen: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+// add marks a key as exposed and reports whether it was not already
+func (e *exposureSet) add(key string) bool {
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if now.Sub(e.lastSweep) > time.Minute {
+		for k, at := range e.seen {
+			if now.Sub(at) > exposureTTL {
+				delete(e.seen, k)
+			}
+		}
+		e.lastSweep = now
+	}
+
+	if at, ok := e.seen[key]; ok && now.Sub(at) <= exposureTTL {
+		return false
+	}
+	e.seen[key] = now
+	return true
+}
+
+// FlagRequest represents the request to create a feature flag. Variants and an experiment
+// are exclusive: a flag linked to an experiment serves the experiment's variants.
+type FlagRequest struct {
+	Key               string              `json:"key" binding:"required"`
+	Name              string              `json:"name" binding:"required"`
+	Description       *string             `json:"description,omitempty"`
+	IsActive          *bool               `json:"is_active,omitempty"`
+	RolloutPercentage *float64            `json:"rollout_percentage,omitempty"` // defaults to 100
+	Targeting         []PropertyFilter    `json:"targeting,omitempty"`
+	Variants          []ExperimentVariant `json:"variants,omitempty"`
+	ExperimentID      *uuid.UUID          `json:"experiment_id,omitempty"`
+}
+
+// UpdateFlagRequest represents the request to update a feature flag
+type UpdateFlagRequest struct {
+	Name              *string             `json:"name,omitempty"`
+	Description       *string             `json:"description,omitempty"`
+	IsActive          *bool               `json:"is_active,omitempty"`
+	RolloutPercentage *float64            `json:"rollout_percentage,omitempty"`
+	Targeting         []PropertyFilter    `json:"targeting,omitempty"`
+	Variants          []ExperimentVariant `json:"variants,omitempty"`
+	ExperimentID      *uuid.UUID          `json:"experiment_id,omitempty"`
+}
+
+// FlagEvaluationRequest identifies the person to evaluate flags for. People are bucketed on
+// their user ID, falling back to the anonymous ID and then the session ID, so a flag stays
+// stable for as long as the identifier does.
+type FlagEvaluationRequest struct {
+	UserID        *string                `json:"user_id,omitempty"`
+	AnonymousID   string                 `json:"anonymous_id,omitempty"`
+	SessionID     string                 `json:"session_id,omitempty"`
+	Properties    map[string]interface{} `json:"properties,omitempty"`
+	Flags         []string               `json:"flags,omitempty"`          // keys to evaluate, all active flags when empty
+	TrackExposure *bool                  `json:"track_exposure,omitempty"` // defaults to true
+
+	// Set by the server, never read from the payload
+	IPAddress string         `json:"-"`
+	UserAgent *string        `json:"-"`
+	Origin    *RequestOrigin `json:"-"`
+}
+
+// FlagEvaluation is the decision of one flag for a person
+type FlagEvaluation struct {
+	Enabled    bool    `json:"enabled"`
+	Variant    *string `json:"variant,omitempty"`
+	Reason     string  `json:"reason"`
+	Experiment *string `json:"experiment,omitempty"` // key of the running experiment the variant belongs to
+}
+
+// CreateFlag creates a feature flag for a project
+func (s *FlagService) CreateFlag(projectID uuid.UUID, req *FlagRequest) (*models.FeatureFlag, error) {
+	if !keyPattern.MatchString(req.Key) {
+		return nil, fmt.Errorf("flag keys are 1 to 64 %s", keyDescription)
+	}
+	var existing int64
+	if err := s.db.Model(&models.FeatureFlag{}).Where("project_id = ? AND key = ?", projectID, req.Key).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, fmt.Errorf("flag key %q is already used", req.Key)
+	}
+
+	flag := &models.FeatureFlag{
+		ProjectID:         projectID,
+		Key:               req.Key,
+		Name:              req.Name,
+		Description:       req.Description,
+		IsActive:          true,
+		RolloutPercentage: 100,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+	}
+	if req.IsActive != nil {
+		flag.IsActive = *req.IsActive
+	}
+	if req.RolloutPercentage != nil {
+		flag.RolloutPercentage = *req.RolloutPercentage
+	}
+
+	if err := s.setRules(flag, req.Targeting, req.Variants, req.ExperimentID); err != nil {
+		return nil, err
+	}
+	if err := validateFlag(flag); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(flag).Error; err != nil {
+		return nil, err
+	}
+
+	return flag, nil
+}
+
+// GetFlags returns all feature flags of a project
+func (s *FlagService) GetFlags(projectID uuid.UUID) ([]models.FeatureFlag, error) {
+	var flags []models.FeatureFlag
+	if err := s.db.Where("project_id = ?", projectID).Order("key").Find(&flags).Error; err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// GetFlag returns a feature flag of a project
+func (s *FlagService) GetFlag(projectID, flagID uuid.UUID) (*models.FeatureFlag, error) {
+	var flag models.FeatureFlag
+	if err := s.db.Where("id = ? AND project_id = ?", flagID, projectID).First(&flag).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("flag not found")
+		}
+		return nil, err
+	}
+	return &flag, nil
+}
+
+// UpdateFlag updates a feature flag. The key is immutable so assignments stay stable.
+func (s *FlagService) UpdateFlag(projectID, flagID uuid.UUID, req *UpdateFlagRequest) (*models.FeatureFlag, error) {
+	flag, err := s.GetFlag(projectID, flagID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		flag.Name = *req.Name
+	}
+	if req.Description != nil {
+		flag.Description = req.Description
+	}
+	if req.IsActive != nil {
+		flag.IsActive = *req.IsActive
+	}
+	if req.RolloutPercentage != nil {
+		flag.RolloutPercentage = *req.RolloutPercentage
+	}
+
+	if req.Targeting != nil || req.Variants != nil || req.ExperimentID != nil {
+		targeting, err := flagTargeting(flag)
+		if err != nil {
+			return nil, err
+		}
+		if req.Targeting != nil {
+			targeting = req.Targeting
+		}
+		variants, err := flagVariants(flag)
+		if err != nil {
+			return nil, err
+		}
+		experimentID := flag.ExperimentID
+		if req.Variants != nil {
+			variants = req.Variants
+			experimentID = nil
+		}
+		if req.ExperimentID != nil {
+			experimentID = req.ExperimentID
+			variants = nil
+			if *req.ExperimentID == uuid.Nil {
+				experimentID = nil
+			}
+		}
+		if err := s.setRules(flag, targeting, variants, experimentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateFlag(flag); err != nil {
+		return nil, err
+	}
+
+	flag.UpdatedAt = time.Now()
+	if err := s.db.Save(flag).Error; err != nil {
+		return nil, err
+	}
+
+	return flag, nil
+}
+
+// DeleteFlag deletes a feature flag
+func (s *FlagService) DeleteFlag(projectID, flagID uuid.UUID) error {
+	result := s.db.Where("id = ? AND project_id = ?", flagID, projectID).Delete(&models.FeatureFlag{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("flag not found")
+	}
+	return nil
+}
+
+// Evaluate decides the flags of a project for a person and records an exposure event, once
+// per session, for every flag served. Exposures of flags linked to a running experiment use
+// the experiment's exposure event so they feed its results.
+func (s *FlagService) Evaluate(project *models.Project, req *FlagEvaluationRequest) (map[string]FlagEvaluation, error) {
+	query := s.db.Where("project_id = ?", project.ID)
+	if len(req.Flags) > 0 {
+		query = query.Where("key IN ?", req.Flags)
+	}
+	var flags []models.FeatureFlag
+	if err := query.Find(&flags).Error; err != nil {
+		return nil, err
+	}
+
+	experiments, err := s.linkedExperiments(project.ID, flags)
+	if err != nil {
+		return nil, err
+	}
+	// Experiment results are read from the exposure events, which belong to a session
+	if req.SessionID == "" && len(experiments) > 0 && (req.TrackExposure == nil || *req.TrackExposure) {
+		return nil, ErrExposureSessionRequired
+	}
+
+	id := req.SessionID
+	if req.AnonymousID != "" {
+		id = req.AnonymousID
+	}
+	if req.UserID != nil && *req.UserID != "" {
+		id = *req.UserID
+	}
+
+	evaluations := make(map[string]FlagEvaluation, len(flags))
+	for i := range flags {
+		flag := &flags[i]
+		var experiment *models.Experiment
+		if flag.ExperimentID != nil {
+			experiment = experiments[*flag.ExperimentID]
+		}
+
+		evaluation := evaluateFlag(flag, experiment, id, req.Properties)
+		evaluations[flag.Key] = evaluation
+
+		if evaluation.Enabled && (req.TrackExposure == nil || *req.TrackExposure) {
+			s.recordExposure(project, req, flag, experiment, evaluation)
+		}
+	}
+
+	// Unknown keys evaluate to off so callers can rely on every requested key being present
+	for _, key := range req.Flags {
+		if _, ok := evaluations[key]; !ok {
+			evaluations[key] = FlagEvaluation{Reason: FlagReasonInactive}
+		}
+	}
+
+	return evaluations, nil
+}
+
+// linkedExperiments loads the experiments the flags are linked to
+func (s *FlagService) linkedExperiments(projectID uuid.UUID, flags []models.FeatureFlag) (map[uuid.UUID]*models.Experiment, error) {
+	var ids []uuid.UUID
+	for _, flag := range flags {
+		if flag.ExperimentID != nil {
+			ids = append(ids, *flag.ExperimentID)
+		}
+	}
+	experiments := make(map[uuid.UUID]*models.Experiment, len(ids))
+	if len(ids) == 0 {
+		return experiments, nil
+	}
+
+	var rows []models.Experiment
+	if err := s.db.Where("project_id = ? AND id IN ?", projectID, ids).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		experiments[rows[i].ID] = &rows[i]
+	}
+	return experiments, nil
+}
+
+// evaluateFlag applies the targeting, the rollout and the variant split of a flag
+func evaluateFlag(flag *models.FeatureFlag, experiment *models.Experiment, id string, properties map[string]interface{}) FlagEvaluation {
+	if !flag.IsActive {
+		return FlagEvaluation{Reason: FlagReasonInactive}
+	}
+
+	targeting, err := flagTargeting(flag)
+	if err != nil {
+		return FlagEvaluation{Reason: FlagReasonError}
+	}
+	if !matchPropertyFilters(targeting, properties) {
+		return FlagEvaluation{Reason: FlagReasonTargeting}
+	}
+
+	if id == "" {
+		return FlagEvaluation{Reason: FlagReasonNoID}
+	}
+	if flagBucket(flag.Key, "", id)*100 >= flag.RolloutPercentage {
+		return FlagEvaluation{Reason: FlagReasonRollout}
+	}
+
+	variants, err := flagVariants(flag)
+	if flag.ExperimentID != nil {
+		// Outside a running experiment nobody is served its variants
+		if experiment == nil || experiment.Status != ExperimentRunning {
+			return FlagEvaluation{Reason: FlagReasonInactive}
+		}
+		variants, err = experimentVariants(experiment)
+	}
+	if err != nil {
+		return FlagEvaluation{Reason: FlagReasonError}
+	}
+
+	evaluation := FlagEvaluation{Enabled: true, Reason: FlagReasonMatch}
+	if len(variants) > 0 {
+		variant := chooseVariant(variants, flagBucket(flag.Key, "variant.", id))
+		evaluation.Variant = &variant
+	}
+	if experiment != nil {
+		evaluation.Experiment = &experiment.Key
+	}
+	return evaluation
+}
+
+// flagBucket maps a flag key, a salt and an identifier to a stable point in [0, 1). The rollout
+// and the variant split use different salts so the split is independent of the rollout.
+func flagBucket(key, salt, id string) float64 {
+	sum := sha1.Sum([]byte(key + "." + salt + id))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// chooseVariant picks the variant whose share of the cumulative weights contains the bucket
+func chooseVariant(variants []ExperimentVariant, bucket float64) string {
+	total := 0.0
+	for _, variant := range variants {
+		total += variantWeight(variant)
+	}
+	cumulative := 0.0
+	for _, variant := range variants {
+		cumulative += variantWeight(variant) / total
+		if bucket < cumulative {
+			return variant.Key
+		}
+	}
+	return variants[len(variants)-1].Key
+}
+
+// recordExposure tracks that a person was served a flag, once per session. The event is
+// created in the background and failures are logged, so tracking never delays or breaks
+// flag delivery. Without a session, which Evaluate only allows for flags outside experiments,
+// nothing is recorded.
+func (s *FlagService) recordExposure(project *models.Project, req *FlagEvaluationRequest, flag *models.FeatureFlag, experiment *models.Experiment, evaluation FlagEvaluation) {
+	if s.eventService == nil || req.SessionID == "" {
+		return
+	}
+
+	eventName := FlagExposureEvent
+	properties := map[string]interface{}{"flag": flag.Key}
+	if evaluation.Variant != nil {
+		properties["variant"] = *evaluation.Variant
+	}
+	if experiment != nil {
+		eventName = experiment.ExposureEvent
+		properties["experiment"] = experiment.Key
+		setPropertyPath(properties, strings.Split(experiment.VariantProperty, "."), *evaluation.Variant)
+	}
+
+	if !s.exposures.add(project.ID.String() + "\x00" + req.SessionID + "\x00" + eventName + "\x00" + flag.Key) {
+		return
+	}
+
+	event := &CreateEventRequest{
+		ProjectID:  &project.ID,
+		SessionID:  req.SessionID,
+		UserID:     req.UserID,
+		EventType:  eventTypeExposure,
+		EventName:  eventName,
+		Properties: properties,
+		UserAgent:  req.UserAgent,
+		IPAddress:  req.IPAddress,
+		Origin:     req.Origin,
+	}
+	go func(key string) {
+		_, err := s.eventService.CreateEvent(event)
+		if err != nil && err != ErrEventDropped && err != ErrInternalTraffic {
+			log.Printf("Failed to record exposure of flag %s: %v", key, err)
+		}
+	}(flag.Key)
+}
+
+// setPropertyPath sets a value at a dotted property path, nesting objects along it, so the
+// experiment's property lookup finds it
+func setPropertyPath(properties map[string]interface{}, keys []string, value interface{}) {
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := properties[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			properties[key] = next
+		}
+		properties = next
+	}
+	properties[keys[len(keys)-1]] = value
+}
+
+// setRules validates and stores the targeting, the variants and the experiment link of a flag
+func (s *FlagService) setRules(flag *models.FeatureFlag, targeting []PropertyFilter, variants []ExperimentVariant, experimentID *uuid.UUID) error {
+	if len(targeting) > maxFlagTargeting {
+		return fmt.Errorf("a flag allows at most %d targeting filters", maxFlagTargeting)
+	}
+	for _, filter := range targeting {
+		if err := filter.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if len(variants) > 0 && experimentID != nil {
+		return errors.New("a flag linked to an experiment serves its variants")
+	}
+	if len(variants) > 0 {
+		if len(variants) < 2 || len(variants) > maxFlagVariants {
+			return fmt.Errorf("a flag needs between 2 and %d variants", maxFlagVariants)
+		}
+		seen := make(map[string]bool, len(variants))
+		for _, variant := range variants {
+			if variant.Key == "" {
+				return errors.New("variants require a key")
+			}
+			if seen[variant.Key] {
+				return fmt.Errorf("duplicate variant %q", variant.Key)
+			}
+			seen[variant.Key] = true
+			if variant.Weight < 0 {
+				return fmt.Errorf("variant %q has a negative weight", variant.Key)
+			}
+		}
+	}
+
+	if experimentID != nil {
+		var found int64
+		if err := s.db.Model(&models.Experiment{}).Where("id = ? AND project_id = ?", *experimentID, flag.ProjectID).Count(&found).Error; err != nil {
+			return err
+		}
+		if found == 0 {
+			return errors.New("experiment not found")
+		}
+	}
+
+	targetingJSON, err := json.Marshal(targeting)
+	if err != nil {
+		return err
+	}
+	variantsJSON, err := json.Marshal(variants)
+	if err != nil {
+		return err
+	}
+	if targeting == nil {
+		targetingJSON = []byte("[]")
+	}
+	if variants == nil {
+		variantsJSON = []byte("[]")
+	}
+	flag.Targeting = string(targetingJSON)
+	flag.Variants = string(variantsJSON)
+	flag.ExperimentID = experimentID
+	return nil
+}
+
+func validateFlag(flag *models.FeatureFlag) error {
+	if flag.Name == "" {
+		return errors.New("flag name is required")
+	}
+	if flag.RolloutPercentage < 0 || flag.RolloutPercentage > 100 {
+		return errors.New("rollout_percentage must be between 0 and 100")
+	}
+	return nil
+}
+
+func flagTargeting(flag *models.FeatureFlag) ([]PropertyFilter, error) {
+	var targeting []PropertyFilter
+	if flag.Targeting == "" {
+		return targeting, nil
+	}
+	if err := json.Unmarshal([]byte(flag.Targeting), &targeting); err != nil {
+		return nil, err
+	}
+	return targeting, nil
+}
+
+func flagVariants(flag *models.FeatureFlag) ([]ExperimentVariant, error) {
+	var variants []ExperimentVariant
+	if flag.Variants == "" {
+		return variants, nil
+	}
+	if err := json.Unmarshal([]byte(flag.Variants), &variants); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
@@ -0,0 +1,534 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Funnel step ordering
+const (
+	FunnelOrderStrict = "strict" // steps must happen in the given order
+	FunnelOrderAny    = "any"    // steps may happen in any order
+)
+
+// Funnel actors
+const (
+	CountByUser    = "user"    // the person behind the event, or its group, see actorColumn
+	CountBySession = "session" // each session converts on its own
+)
+
+const (
+	maxFunnelSteps          = 20
+	maxFunnelBreakdowns     = 25
+	defaultConversionWindow = 7 * 24 * time.Hour
+	maxConversionWindow     = 90 * 24 * time.Hour
+)
+
+// Breakdown values for events without the dimension and for the values past the limit
+const (
+	BreakdownNone  = "(none)"
+	BreakdownOther = "(other)"
+)
+
+type FunnelService struct {
+	db *database.DB
+}
+
+func NewFunnelService(db *database.DB) *FunnelService {
+	return &FunnelService{db: db}
+}
+
+// FunnelStep selects the events that complete a step, either by event and properties or
+// as the completions of a goal of the project
+type FunnelStep struct {
+	Label     string           `json:"label,omitempty"`
+	EventType string           `json:"event_type,omitempty"`
+	EventName string           `json:"event_name,omitempty"`
+	Filters   []PropertyFilter `json:"filters,omitempty"`
+	GoalID    *uuid.UUID       `json:"goal_id,omitempty"`
+}
+
+// FunnelRequest represents a funnel to compute
+type FunnelRequest struct {
+	Steps                   []FunnelStep `json:"steps" binding:"required"`
+	ConversionWindowSeconds int64        `json:"conversion_window_seconds"`
+	Order                   string       `json:"order" binding:"omitempty,oneof=strict any"`
+	CountBy                 string       `json:"count_by" binding:"omitempty,oneof=user session"`
+	BreakdownBy             string       `json:"breakdown_by,omitempty"`
+}
+
+// FunnelStepResult holds the conversion figures of one step
+type FunnelStepResult struct {
+	Step               int      `json:"step"`
+	Label              string   `json:"label"`
+	Count              int64    `json:"count"`
+	DropOff            int64    `json:"drop_off"`
+	ConversionRate     float64  `json:"conversion_rate"`      // relative to the first step
+	StepConversionRate float64  `json:"step_conversion_rate"` // relative to the previous step
+	MedianTimeSeconds  *float64 `json:"median_time_seconds"`  // from the previous step, nil for the first step
+}
+
+// FunnelBreakdown holds the funnel of the actors sharing a dimension value on their first step
+type FunnelBreakdown struct {
+	Value string             `json:"value"`
+	Steps []FunnelStepResult `json:"steps"`
+}
+
+// FunnelResult is a computed funnel
+type FunnelResult struct {
+	Order                   string             `json:"order"`
+	CountBy                 string             `json:"count_by"`
+	ConversionWindowSeconds int64              `json:"conversion_window_seconds"`
+	Steps                   []FunnelStepResult `json:"steps"`
+	BreakdownBy             string             `json:"breakdown_by,omitempty"`
+	Breakdowns              []FunnelBreakdown  `json:"breakdowns,omitempty"`
+}
+
+type funnelEventRow struct {
+	Actor       string
+	EventType   string
+	EventName   string
+	Properties  string
+	CreatedAt   time.Time
+	Breakdown   *string
+	GoalMatches string // one '1' or '0' per goal step, in step order
+}
+
+type funnelEvent struct {
+	at        time.Time
+	matches   []bool
+	breakdown string
+}
+
+// funnelCounter accumulates how far actors got and how long each step took
+type funnelCounter struct {
+	counts    []int64
+	durations [][]float64
+}
+
+// Validate checks the steps, window and breakdown dimension
+func (r *FunnelRequest) Validate() error {
+	if len(r.Steps) < 2 || len(r.Steps) > maxFunnelSteps {
+		return fmt.Errorf("a funnel needs between 2 and %d steps", maxFunnelSteps)
+	}
+	for i, step := range r.Steps {
+		if step.GoalID != nil {
+			if step.EventType != "" || step.EventName != "" || len(step.Filters) > 0 {
+				return fmt.Errorf("step %d: a goal_id step cannot also set event_type, event_name or filters", i+1)
+			}
+			continue
+		}
+		if step.EventType == "" && step.EventName == "" {
+			return fmt.Errorf("step %d requires an event_type, event_name or goal_id", i+1)
+		}
+		for _, filter := range step.Filters {
+			if err := filter.Validate(); err != nil {
+				return fmt.Errorf("step %d: %v", i+1, err)
+			}
+		}
+	}
+	if r.ConversionWindowSeconds < 0 || r.ConversionWindowSeconds > int64(maxConversionWindow/time.Second) {
+		return fmt.Errorf("conversion_window_seconds must be between 0 (default) and %d", int64(maxConversionWindow/time.Second))
+	}
+	if r.BreakdownBy != "" {
+		if _, ok := dimensionColumn(r.BreakdownBy); !ok {
+			return fmt.Errorf("unknown breakdown dimension %q", r.BreakdownBy)
+		}
+	}
+	return nil
+}
+
+// Run computes the funnel for the actors whose first step happens inside the query window.
+// Later steps must follow within the conversion window and may fall after the end of the query window.
+// The request must have passed Validate and the query window must be bounded, see WithDefaultWindow.
+func (s *FunnelService) Run(q AnalyticsQuery, req *FunnelRequest) (*FunnelResult, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("funnels require a project")
+	}
+
+	order := req.Order
+	if order == "" {
+		order = FunnelOrderStrict
+	}
+	countBy := req.CountBy
+	if countBy == "" {
+		countBy = CountByUser
+	}
+	window := defaultConversionWindow
+	if req.ConversionWindowSeconds > 0 {
+		window = time.Duration(req.ConversionWindowSeconds) * time.Second
+	}
+
+	breakdown := sqlFragment{sql: "NULL"}
+	if req.BreakdownBy != "" {
+		breakdown, _ = dimensionColumn(req.BreakdownBy)
+	}
+
+	goals, err := s.stepGoals(*q.ProjectID, req.Steps)
+	if err != nil {
+		return nil, err
+	}
+	// Goal conditions are evaluated in SQL and reported as one flag per goal step
+	goalMatches, goalArgs := "''", []interface{}{}
+	if len(goals) > 0 {
+		var flags []string
+		for _, goal := range goals {
+			flags = append(flags, "CASE WHEN ("+goal.sql+") THEN '1' ELSE '0' END")
+			goalArgs = append(goalArgs, goal.args...)
+		}
+		goalMatches = "CONCAT(" + strings.Join(flags, ", ") + ")"
+	}
+
+	where, whereArgs := q.conditions(false)
+	args := append(append(append([]interface{}{}, breakdown.args...), goalArgs...), whereArgs...)
+	var stepClauses []string
+	goalIndex := 0
+	for _, step := range req.Steps {
+		if step.GoalID != nil {
+			stepClauses = append(stepClauses, "("+goals[goalIndex].sql+")")
+			args = append(args, goals[goalIndex].args...)
+			goalIndex++
+			continue
+		}
+		var clause []string
+		if step.EventType != "" {
+			clause = append(clause, "events.event_type = ?")
+			args = append(args, step.EventType)
+		}
+		if step.EventName != "" {
+			clause = append(clause, "events.event_name = ?")
+			args = append(args, step.EventName)
+		}
+		stepClauses = append(stepClauses, "("+strings.Join(clause, " AND ")+")")
+	}
+	args = append(args, q.From, q.To.Add(window))
+
+	with, join, actor := "", "", "events.session_id"
+	if countBy == CountByUser {
+		with, join, actor = "WITH "+sessionPeopleCTE, sessionPeopleJoin, q.actorColumn()
+		args = append([]interface{}{*q.ProjectID}, args...)
+	}
+
+	rows, err := s.db.Raw(fmt.Sprintf(`
+		%s
+		SELECT
+			%s AS actor,
+			events.event_type,
+			events.event_name,
+			events.properties,
+			events.created_at,
+			%s AS breakdown,
+			%s AS goal_matches
+		FROM events
+		%s
+		WHERE %s AND (%s) AND events.created_at >= ? AND events.created_at < ?
+		ORDER BY actor, events.created_at
+	`, with, actor, breakdown.sql, goalMatches, join, where, strings.Join(stepClauses, " OR ")), args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	total := newFunnelCounter(len(req.Steps))
+	breakdowns := make(map[string]*funnelCounter)
+
+	var current string
+	var events []funnelEvent
+	flush := func() {
+		if len(events) == 0 {
+			return
+		}
+		var times []time.Time
+		var value string
+		if order == FunnelOrderAny {
+			times, value = matchAnyOrder(events, len(req.Steps), window, q.From, q.To)
+		} else {
+			times, value = matchStrictOrder(events, len(req.Steps), window, q.From, q.To)
+		}
+		if len(times) == 0 {
+			return
+		}
+		total.add(times)
+		if req.BreakdownBy != "" {
+			if breakdowns[value] == nil {
+				breakdowns[value] = newFunnelCounter(len(req.Steps))
+			}
+			breakdowns[value].add(times)
+		}
+	}
+
+	for rows.Next() {
+		var row funnelEventRow
+		if err := s.db.ScanRows(rows, &row); err != nil {
+			return nil, err
+		}
+		if row.Actor != current {
+			flush()
+			current = row.Actor
+			events = events[:0]
+		}
+
+		event := funnelEvent{at: row.CreatedAt, matches: make([]bool, len(req.Steps)), breakdown: BreakdownNone}
+		if row.Breakdown != nil && *row.Breakdown != "" {
+			event.breakdown = *row.Breakdown
+		}
+		var properties map[string]interface{}
+		goalIndex := 0
+		for i, step := range req.Steps {
+			if step.GoalID != nil {
+				event.matches[i] = goalIndex < len(row.GoalMatches) && row.GoalMatches[goalIndex] == '1'
+				goalIndex++
+				continue
+			}
+			if (step.EventType != "" && step.EventType != row.EventType) || (step.EventName != "" && step.EventName != row.EventName) {
+				continue
+			}
+			if len(step.Filters) > 0 && properties == nil {
+				properties = decodeProperties(row.Properties)
+			}
+			event.matches[i] = matchPropertyFilters(step.Filters, properties)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	result := &FunnelResult{
+		Order:                   order,
+		CountBy:                 countBy,
+		ConversionWindowSeconds: int64(window / time.Second),
+		Steps:                   total.results(req.Steps, order),
+		BreakdownBy:             req.BreakdownBy,
+	}
+	if req.BreakdownBy != "" {
+		result.Breakdowns = funnelBreakdowns(breakdowns, req.Steps, order)
+	}
+
+	return result, nil
+}
+
+// stepGoals loads the conditions of the goal steps, in step order, and labels unlabelled
+// goal steps with the goal name
+func (s *FunnelService) stepGoals(projectID uuid.UUID, steps []FunnelStep) ([]sqlFragment, error) {
+	var goals []sqlFragment
+	for i, step := range steps {
+		if step.GoalID == nil {
+			continue
+		}
+		var goal models.Goal
+		if err := s.db.Where("id = ? AND project_id = ?", *step.GoalID, projectID).First(&goal).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, ErrGoalNotFound
+			}
+			return nil, err
+		}
+		condition, err := goalCondition(&goal)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, condition)
+		if step.Label == "" {
+			steps[i].Label = goal.Name
+		}
+	}
+	return goals, nil
+}
+
+// matchStrictOrder finds the entry that gets an actor furthest through the steps in order.
+// It returns the time each reached step was completed and the breakdown value of the entry.
+func matchStrictOrder(events []funnelEvent, steps int, window time.Duration, from, to time.Time) ([]time.Time, string) {
+	var best []time.Time
+	var value string
+	for i, entry := range events {
+		if !entry.matches[0] || entry.at.Before(from) || !entry.at.Before(to) {
+			continue
+		}
+
+		deadline := entry.at.Add(window)
+		times := []time.Time{entry.at}
+		for _, event := range events[i+1:] {
+			if len(times) == steps || event.at.After(deadline) {
+				break
+			}
+			if event.matches[len(times)] {
+				times = append(times, event.at)
+			}
+		}
+
+		if len(times) > len(best) {
+			best, value = times, entry.breakdown
+		}
+		if len(best) == steps {
+			break
+		}
+	}
+	return best, value
+}
+
+// matchAnyOrder finds the entry after which an actor completes the most distinct steps.
+// Step N of an any-order funnel counts the actors who completed at least N of the steps.
+func matchAnyOrder(events []funnelEvent, steps int, window time.Duration, from, to time.Time) ([]time.Time, string) {
+	var best []time.Time
+	var value string
+	for i, entry := range events {
+		if entry.at.Before(from) || !entry.at.Before(to) || !anyMatch(entry.matches) {
+			continue
+		}
+
+		deadline := entry.at.Add(window)
+		done := make([]bool, steps)
+		var times []time.Time
+		for _, event := range events[i:] {
+			if len(times) == steps || event.at.After(deadline) {
+				break
+			}
+			for step, match := range event.matches {
+				if match && !done[step] {
+					done[step] = true
+					times = append(times, event.at)
+					break
+				}
+			}
+		}
+
+		if len(times) > len(best) {
+			best, value = times, entry.breakdown
+		}
+		if len(best) == steps {
+			break
+		}
+	}
+	return best, value
+}
+
+func anyMatch(matches []bool) bool {
+	for _, match := range matches {
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func newFunnelCounter(steps int) *funnelCounter {
+	return &funnelCounter{
+		counts:    make([]int64, steps),
+		durations: make([][]float64, steps),
+	}
+}
+
+// add records an actor that completed the steps at the given times
+func (c *funnelCounter) add(times []time.Time) {
+	for i := range times {
+		c.counts[i]++
+		if i > 0 {
+			c.durations[i] = append(c.durations[i], times[i].Sub(times[i-1]).Seconds())
+		}
+	}
+}
+
+// merge adds the actors of another counter
+func (c *funnelCounter) merge(other *funnelCounter) {
+	for i := range c.counts {
+		c.counts[i] += other.counts[i]
+		c.durations[i] = append(c.durations[i], other.durations[i]...)
+	}
+}
+
+func (c *funnelCounter) results(steps []FunnelStep, order string) []FunnelStepResult {
+	results := make([]FunnelStepResult, 0, len(steps))
+	for i, step := range steps {
+		result := FunnelStepResult{
+			Step:  i + 1,
+			Label: funnelStepLabel(step, i, len(steps), order),
+			Count: c.counts[i],
+		}
+		if c.counts[0] > 0 {
+			result.ConversionRate = float64(c.counts[i]) / float64(c.counts[0]) * 100
+		}
+		if i == 0 {
+			if c.counts[0] > 0 {
+				result.StepConversionRate = 100
+			}
+		} else {
+			if c.counts[i-1] > 0 {
+				result.StepConversionRate = float64(c.counts[i]) / float64(c.counts[i-1]) * 100
+			}
+			result.MedianTimeSeconds = median(c.durations[i])
+		}
+		if i+1 < len(steps) {
+			result.DropOff = c.counts[i] - c.counts[i+1]
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func funnelStepLabel(step FunnelStep, i, total int, order string) string {
+	if order == FunnelOrderAny {
+		return fmt.Sprintf("%d of %d steps", i+1, total)
+	}
+	if step.Label != "" {
+		return step.Label
+	}
+	if step.EventName != "" {
+		return step.EventName
+	}
+	return step.EventType
+}
+
+// funnelBreakdowns sorts breakdown values by entries and folds the values past the limit into BreakdownOther
+func funnelBreakdowns(counters map[string]*funnelCounter, steps []FunnelStep, order string) []FunnelBreakdown {
+	values := make([]string, 0, len(counters))
+	for value := range counters {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		a, b := counters[values[i]].counts[0], counters[values[j]].counts[0]
+		if a != b {
+			return a > b
+		}
+		return values[i] < values[j]
+	})
+
+	var other *funnelCounter
+	breakdowns := make([]FunnelBreakdown, 0, len(values))
+	for i, value := range values {
+		if i >= maxFunnelBreakdowns {
+			if other == nil {
+				other = newFunnelCounter(len(steps))
+			}
+			other.merge(counters[value])
+			continue
+		}
+		breakdowns = append(breakdowns, FunnelBreakdown{Value: value, Steps: counters[value].results(steps, order)})
+	}
+	if other != nil {
+		breakdowns = append(breakdowns, FunnelBreakdown{Value: BreakdownOther, Steps: other.results(steps, order)})
+	}
+	return breakdowns
+}
+
+// median returns the median of values, or nil when there are none
+func median(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	m := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		m = (sorted[len(sorted)/2-1] + m) / 2
+	}
+	return &m
+}
@@ -0,0 +1,426 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxGoalConditions = 10
+
+// ErrGoalNotFound is returned when a goal does not exist in the project or, for reports, is inactive
+var ErrGoalNotFound = errors.New("goal not found")
+
+// goalBreakdownColumns maps the dimensions goal conversions can be broken down by to session columns
+var goalBreakdownColumns = map[string]string{
+	"channel":      "sessions.channel",
+	"utm_source":   "sessions.utm_source",
+	"utm_campaign": "sessions.utm_campaign",
+	"country":      "sessions.country",
+	"device_type":  "sessions.device_type",
+	"landing_page": "sessions.landing_page",
+}
+
+type GoalService struct {
+	db *database.DB
+}
+
+func NewGoalService(db *database.DB) *GoalService {
+	return &GoalService{db: db}
+}
+
+// GoalRequest represents the request to create a goal
+type GoalRequest struct {
+	Name          string          `json:"name" binding:"required"`
+	Description   *string         `json:"description,omitempty"`
+	Conditions    []InsightFilter `json:"conditions" binding:"required,min=1"`
+	ValueProperty *string         `json:"value_property,omitempty"`
+	Value         float64         `json:"value"`
+	IsActive      *bool           `json:"is_active,omitempty"`
+}
+
+// UpdateGoalRequest represents the request to update a goal
+type UpdateGoalRequest struct {
+	Name          *string         `json:"name,omitempty"`
+	Description   *string         `json:"description,omitempty"`
+	Conditions    []InsightFilter `json:"conditions,omitempty"`
+	ValueProperty *string         `json:"value_property,omitempty"`
+	Value         *float64        `json:"value,omitempty"`
+	IsActive      *bool           `json:"is_active,omitempty"`
+}
+
+// GoalMetrics are the conversions of a goal. Conversions count the sessions that completed
+// the goal at least once; ConversionRate is their share of all sessions (0 to 1).
+type GoalMetrics struct {
+	Sessions       int64   `json:"sessions"`
+	Conversions    int64   `json:"conversions"`
+	Completions    int64   `json:"completions"`
+	ConversionRate float64 `json:"conversion_rate"`
+	Value          float64 `json:"value"`
+}
+
+// GoalSummary holds the metrics of one goal
+type GoalSummary struct {
+	GoalID uuid.UUID `json:"goal_id"`
+	Name   string    `json:"name"`
+	GoalMetrics
+}
+
+// GoalBreakdown holds the metrics of the sessions sharing a dimension value
+type GoalBreakdown struct {
+	Value string `json:"value"`
+	GoalMetrics
+}
+
+// GoalReport holds the metrics of a goal, optionally broken down by a session dimension
+type GoalReport struct {
+	GoalSummary
+	Breakdown  string          `json:"breakdown,omitempty"`
+	Breakdowns []GoalBreakdown `json:"breakdowns,omitempty"`
+}
+
+// QueryGoal is a goal resolved for the conversion metrics of reports: a session converts when
+// it completes the goal
+type QueryGoal struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	ProjectID uuid.UUID `json:"-"`
+	condition sqlFragment
+}
+
+type goalMetricsRow struct {
+	Value       string
+	Sessions    int64
+	Conversions int64
+	Completions int64
+	GoalValue   float64
+}
+
+// CreateGoal creates a goal for a project
+func (s *GoalService) CreateGoal(projectID uuid.UUID, req *GoalRequest) (*models.Goal, error) {
+	if err := validateGoal(req.Conditions, req.ValueProperty); err != nil {
+		return nil, err
+	}
+
+	conditions, err := json.Marshal(req.Conditions)
+	if err != nil {
+		return nil, err
+	}
+
+	goal := &models.Goal{
+		ProjectID:     projectID,
+		Name:          req.Name,
+		Description:   req.Description,
+		Conditions:    string(conditions),
+		ValueProperty: req.ValueProperty,
+		Value:         req.Value,
+		IsActive:      req.IsActive == nil || *req.IsActive,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	if err := s.db.Create(goal).Error; err != nil {
+		return nil, err
+	}
+
+	return goal, nil
+}
+
+// GetGoals returns all goals of a project
+func (s *GoalService) GetGoals(projectID uuid.UUID) ([]models.Goal, error) {
+	var goals []models.Goal
+	err := s.db.Where("project_id = ?", projectID).
+		Order("created_at ASC").
+		Find(&goals).Error
+
+	if goals == nil {
+		goals = []models.Goal{}
+	}
+
+	return goals, err
+}
+
+// GetGoal returns a single goal of a project
+func (s *GoalService) GetGoal(projectID, goalID uuid.UUID) (*models.Goal, error) {
+	var goal models.Goal
+	if err := s.db.Where("id = ? AND project_id = ?", goalID, projectID).First(&goal).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrGoalNotFound
+		}
+		return nil, err
+	}
+	return &goal, nil
+}
+
+// Resolve loads an active goal as the conversion of reports
+func (s *GoalService) Resolve(goalID uuid.UUID) (*QueryGoal, error) {
+	var goal models.Goal
+	if err := s.db.Where("id = ? AND is_active = ?", goalID, true).First(&goal).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrGoalNotFound
+		}
+		return nil, err
+	}
+
+	condition, err := goalCondition(&goal)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryGoal{ID: goal.ID, Name: goal.Name, ProjectID: goal.ProjectID, condition: condition}, nil
+}
+
+// UpdateGoal updates a goal
+func (s *GoalService) UpdateGoal(projectID, goalID uuid.UUID, req *UpdateGoalRequest) (*models.Goal, error) {
+	goal, err := s.GetGoal(projectID, goalID)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions, err := decodeGoalConditions(goal.Conditions)
+	if err != nil {
+		return nil, err
+	}
+	if req.Conditions != nil {
+		conditions = req.Conditions
+	}
+	valueProperty := goal.ValueProperty
+	if req.ValueProperty != nil {
+		valueProperty = req.ValueProperty
+	}
+	if err := validateGoal(conditions, valueProperty); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"updated_at": time.Now(),
+	}
+
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Conditions != nil {
+		data, err := json.Marshal(req.Conditions)
+		if err != nil {
+			return nil, err
+		}
+		updates["conditions"] = string(data)
+	}
+	if req.ValueProperty != nil {
+		// An empty string clears the value property
+		if *req.ValueProperty == "" {
+			updates["value_property"] = nil
+		} else {
+			updates["value_property"] = *req.ValueProperty
+		}
+	}
+	if req.Value != nil {
+		updates["value"] = *req.Value
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+
+	if err := s.db.Model(goal).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+
+	return s.GetGoal(projectID, goalID)
+}
+
+// DeleteGoal deletes a goal
+func (s *GoalService) DeleteGoal(projectID, goalID uuid.UUID) error {
+	goal, err := s.GetGoal(projectID, goalID)
+	if err != nil {
+		return err
+	}
+	return s.db.Delete(goal).Error
+}
+
+// GetGoalSummaries returns the metrics of every active goal of the project
+func (s *GoalService) GetGoalSummaries(q AnalyticsQuery) ([]GoalSummary, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("goals require a project")
+	}
+
+	var goals []models.Goal
+	if err := s.db.Where("project_id = ? AND is_active = ?", *q.ProjectID, true).Order("created_at ASC").Find(&goals).Error; err != nil {
+		return nil, err
+	}
+
+	summaries := make([]GoalSummary, 0, len(goals))
+	for i := range goals {
+		rows, err := s.goalMetrics(q, &goals[i], "")
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, GoalSummary{GoalID: goals[i].ID, Name: goals[i].Name, GoalMetrics: rows[0].metrics()})
+	}
+	return summaries, nil
+}
+
+// GetGoalReport returns the metrics of a goal, broken down by a session dimension when one is given
+func (s *GoalService) GetGoalReport(q AnalyticsQuery, goal *models.Goal, breakdown string) (*GoalReport, error) {
+	if breakdown != "" {
+		if _, ok := goalBreakdownColumns[breakdown]; !ok {
+			return nil, fmt.Errorf("unknown goal breakdown %q", breakdown)
+		}
+	}
+
+	total, err := s.goalMetrics(q, goal, "")
+	if err != nil {
+		return nil, err
+	}
+	report := &GoalReport{
+		GoalSummary: GoalSummary{GoalID: goal.ID, Name: goal.Name, GoalMetrics: total[0].metrics()},
+		Breakdown:   breakdown,
+	}
+	if breakdown == "" {
+		return report, nil
+	}
+
+	rows, err := s.goalMetrics(q, goal, breakdown)
+	if err != nil {
+		return nil, err
+	}
+	report.Breakdowns = make([]GoalBreakdown, 0, len(rows))
+	for _, row := range rows {
+		report.Breakdowns = append(report.Breakdowns, GoalBreakdown{Value: row.Value, GoalMetrics: row.metrics()})
+	}
+	return report, nil
+}
+
+// goalMetrics joins the sessions started in the window with their goal completions.
+// Without a breakdown it returns a single total row.
+func (s *GoalService) goalMetrics(q AnalyticsQuery, goal *models.Goal, breakdown string) ([]goalMetricsRow, error) {
+	condition, err := goalCondition(goal)
+	if err != nil {
+		return nil, err
+	}
+	value, err := goalValue(goal)
+	if err != nil {
+		return nil, err
+	}
+
+	// Completions may happen after the window ends, but never before the session started
+	eventWhere, eventArgs := q.baseConditions(false)
+	if !q.From.IsZero() {
+		eventWhere += " AND events.created_at >= ?"
+		eventArgs = append(eventArgs, q.From)
+	}
+	sessionWhere, sessionArgs := q.sessionConditions(SessionFilter{})
+
+	selectValue, groupBy, orderBy := "'total'", "", ""
+	if breakdown != "" {
+		selectValue = fmt.Sprintf("COALESCE(NULLIF(%s, ''), '%s')", goalBreakdownColumns[breakdown], BreakdownNone)
+		groupBy = "GROUP BY 1"
+		orderBy = fmt.Sprintf("ORDER BY sessions DESC, value LIMIT %d", maxSessionBreakdowns)
+	}
+
+	args := append(append([]interface{}{}, value.args...), eventArgs...)
+	args = append(args, condition.args...)
+	args = append(args, sessionArgs...)
+
+	var rows []goalMetricsRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH completions AS (
+			SELECT events.session_id, COUNT(*) AS completions, SUM(%s) AS goal_value
+			FROM events
+			WHERE %s AND %s
+			GROUP BY events.session_id
+		)
+		SELECT
+			%s AS value,
+			COUNT(*) AS sessions,
+			COUNT(completions.session_id) AS conversions,
+			COALESCE(SUM(completions.completions), 0) AS completions,
+			COALESCE(SUM(completions.goal_value), 0) AS goal_value
+		FROM sessions
+		LEFT JOIN completions ON completions.session_id = sessions.id
+		WHERE %s
+		%s
+		%s
+	`, value.sql, eventWhere, condition.sql, selectValue, sessionWhere, groupBy, orderBy), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 && breakdown == "" {
+		rows = append(rows, goalMetricsRow{Value: "total"})
+	}
+	return rows, nil
+}
+
+// goalCondition compiles the goal conditions to a SQL condition on events
+func goalCondition(goal *models.Goal) (sqlFragment, error) {
+	conditions, err := decodeGoalConditions(goal.Conditions)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+
+	if len(conditions) == 0 {
+		return sqlFragment{}, errors.New("goal has no conditions")
+	}
+	return filtersCondition(conditions)
+}
+
+// goalValue renders the value of one completion: the value property when it is numeric, else the fixed value
+func goalValue(goal *models.Goal) (sqlFragment, error) {
+	if goal.ValueProperty == nil || *goal.ValueProperty == "" {
+		return sqlFragment{sql: "CAST(? AS double precision)", args: []interface{}{goal.Value}}, nil
+	}
+	number, err := propertyNumber(*goal.ValueProperty)
+	if err != nil {
+		return sqlFragment{}, err
+	}
+	return sqlFragment{
+		sql:  "COALESCE(" + number.sql + ", CAST(? AS double precision))",
+		args: append(number.args, goal.Value),
+	}, nil
+}
+
+func validateGoal(conditions []InsightFilter, valueProperty *string) error {
+	if len(conditions) == 0 || len(conditions) > maxGoalConditions {
+		return fmt.Errorf("a goal needs between 1 and %d conditions", maxGoalConditions)
+	}
+	for _, condition := range conditions {
+		if _, err := condition.fragment(); err != nil {
+			return err
+		}
+	}
+	if valueProperty != nil && *valueProperty != "" {
+		if _, err := propertyPath(*valueProperty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeGoalConditions(data string) ([]InsightFilter, error) {
+	var conditions []InsightFilter
+	if err := json.Unmarshal([]byte(data), &conditions); err != nil {
+		return nil, err
+	}
+	return conditions, nil
+}
+
+func (r goalMetricsRow) metrics() GoalMetrics {
+	m := GoalMetrics{
+		Sessions:    r.Sessions,
+		Conversions: r.Conversions,
+		Completions: r.Completions,
+		Value:       r.GoalValue,
+	}
+	if r.Sessions > 0 {
+		m.ConversionRate = float64(r.Conversions) / float64(r.Sessions)
+	}
+	return m
+}
@@ -0,0 +1,422 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxGroupTypes         = 5
+	maxGroupKeyLength     = 256
+	maxGroupProperties    = 100
+	maxGroupPropertyBytes = 4096
+	groupFieldPrefix      = "group."
+	groupTypeDescription  = "lowercase letters, digits and '_'"
+)
+
+// groupTypePattern restricts group type keys to characters that are safe to render in SQL
+var groupTypePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// ErrInvalidGroups is returned for events or group calls naming unknown group types or invalid keys
+var ErrInvalidGroups = errors.New("invalid groups")
+
+// groupListOrders maps the sort options of the group list to ORDER BY clauses
+var groupListOrders = map[string]string{
+	"last_seen":  "stats.last_seen DESC NULLS LAST",
+	"first_seen": "stats.first_seen DESC NULLS LAST",
+	"events":     "events DESC",
+	"people":     "people DESC",
+}
+
+type GroupService struct {
+	db *database.DB
+}
+
+func NewGroupService(db *database.DB) *GroupService {
+	return &GroupService{db: db}
+}
+
+// GroupTypeRequest represents the request to create a group type
+type GroupTypeRequest struct {
+	Key  string `json:"key" binding:"required"`
+	Name string `json:"name" binding:"required"`
+}
+
+// GroupIdentifyRequest creates a group or updates its properties. Properties are merged
+// into the existing ones and a null value removes a property.
+type GroupIdentifyRequest struct {
+	GroupType  string                 `json:"group_type" binding:"required"`
+	GroupKey   string                 `json:"group_key" binding:"required"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// GroupListRequest selects and orders the groups of a type
+type GroupListRequest struct {
+	GroupType string
+	Search    string // substring of the group key
+	Sort      string // last_seen (default), first_seen, events or people
+	Limit     int
+	Offset    int
+}
+
+// GroupSummary is a group with its activity in the query window
+type GroupSummary struct {
+	models.Group
+	Events    int64      `json:"events"`
+	People    int64      `json:"people"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+}
+
+// CreateGroupType registers a group type for a project
+func (s *GroupService) CreateGroupType(projectID uuid.UUID, req *GroupTypeRequest) (*models.GroupType, error) {
+	if !groupTypePattern.MatchString(req.Key) {
+		return nil, fmt.Errorf("group type keys are 1 to 32 %s", groupTypeDescription)
+	}
+
+	var types []models.GroupType
+	if err := s.db.Where("project_id = ?", projectID).Find(&types).Error; err != nil {
+		return nil, err
+	}
+	if len(types) >= maxGroupTypes {
+		return nil, fmt.Errorf("a project has at most %d group types", maxGroupTypes)
+	}
+	for _, groupType := range types {
+		if groupType.Key == req.Key {
+			return nil, fmt.Errorf("group type %q already exists", req.Key)
+		}
+	}
+
+	groupType := &models.GroupType{
+		ProjectID: projectID,
+		Key:       req.Key,
+		Name:      req.Name,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := s.db.Create(groupType).Error; err != nil {
+		return nil, err
+	}
+
+	return groupType, nil
+}
+
+// GetGroupTypes returns the group types of a project
+func (s *GroupService) GetGroupTypes(projectID uuid.UUID) ([]models.GroupType, error) {
+	var types []models.GroupType
+	if err := s.db.Where("project_id = ?", projectID).Order("key").Find(&types).Error; err != nil {
+		return nil, err
+	}
+	if types == nil {
+		types = []models.GroupType{}
+	}
+	return types, nil
+}
+
+// DeleteGroupType removes a group type and its groups. Events keep their group keys.
+func (s *GroupService) DeleteGroupType(projectID, groupTypeID uuid.UUID) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var groupType models.GroupType
+		if err := tx.Where("id = ? AND project_id = ?", groupTypeID, projectID).First(&groupType).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return errors.New("group type not found")
+			}
+			return err
+		}
+		if err := tx.Where("project_id = ? AND group_type = ?", projectID, groupType.Key).Delete(&models.Group{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&groupType).Error
+	})
+}
+
+// ValidateGroups checks that the groups of an event refer to the project's group types
+func (s *GroupService) ValidateGroups(projectID uuid.UUID, groups map[string]string) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	if len(groups) > maxGroupTypes {
+		return fmt.Errorf("%w: at most %d groups per event", ErrInvalidGroups, maxGroupTypes)
+	}
+
+	known, err := s.groupTypeKeys(projectID)
+	if err != nil {
+		return err
+	}
+	for groupType, groupKey := range groups {
+		if !known[groupType] {
+			return fmt.Errorf("%w: unknown group type %q", ErrInvalidGroups, groupType)
+		}
+		if err := validateGroupKey(groupKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureGroups creates the groups an event is associated with when they are not known yet
+func (s *GroupService) EnsureGroups(projectID uuid.UUID, groups map[string]string) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	now := time.Now()
+	records := make([]models.Group, 0, len(groups))
+	for _, groupType := range sortedGroupTypes(groups) {
+		records = append(records, models.Group{
+			ProjectID:  projectID,
+			GroupType:  groupType,
+			GroupKey:   groups[groupType],
+			Properties: "{}",
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		})
+	}
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&records).Error
+}
+
+// IdentifyGroup creates a group or merges properties into it. The group row is locked so
+// concurrent calls do not lose updates.
+func (s *GroupService) IdentifyGroup(projectID uuid.UUID, req *GroupIdentifyRequest) (*models.Group, error) {
+	if err := s.ValidateGroups(projectID, map[string]string{req.GroupType: req.GroupKey}); err != nil {
+		return nil, err
+	}
+	if len(req.Properties) > maxGroupProperties {
+		return nil, fmt.Errorf("%w: at most %d properties per call", ErrInvalidGroups, maxGroupProperties)
+	}
+	for key, value := range req.Properties {
+		if !propertyKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%w: invalid property name %q", ErrInvalidGroups, key)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: property %q: %v", ErrInvalidGroups, key, err)
+		}
+		if len(data) > maxGroupPropertyBytes {
+			return nil, fmt.Errorf("%w: property %q is larger than %d bytes", ErrInvalidGroups, key, maxGroupPropertyBytes)
+		}
+	}
+
+	var group models.Group
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		placeholder := models.Group{ProjectID: projectID, GroupType: req.GroupType, GroupKey: req.GroupKey, Properties: "{}", CreatedAt: now, UpdatedAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&placeholder).Error; err != nil {
+			return err
+		}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("project_id = ? AND group_type = ? AND group_key = ?", projectID, req.GroupType, req.GroupKey).
+			First(&group).Error
+		if err != nil {
+			return err
+		}
+		if len(req.Properties) == 0 {
+			return nil
+		}
+
+		properties := decodeProperties(group.Properties)
+		for key, value := range req.Properties {
+			if value == nil {
+				delete(properties, key)
+			} else {
+				properties[key] = value
+			}
+		}
+		data, err := json.Marshal(properties)
+		if err != nil {
+			return err
+		}
+		group.Properties = string(data)
+		group.UpdatedAt = now
+		return tx.Model(&group).Updates(map[string]interface{}{"properties": group.Properties, "updated_at": now}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// ListGroups returns the groups of a type with their activity in the query window and the
+// total number of matching groups. Groups without events in the window are listed last.
+func (s *GroupService) ListGroups(q AnalyticsQuery, req *GroupListRequest) ([]GroupSummary, int64, error) {
+	if q.ProjectID == nil {
+		return nil, 0, errors.New("groups require a project")
+	}
+	if !groupTypePattern.MatchString(req.GroupType) {
+		return nil, 0, fmt.Errorf("group type keys are 1 to 32 %s", groupTypeDescription)
+	}
+	sortBy := req.Sort
+	if sortBy == "" {
+		sortBy = "last_seen"
+	}
+	order, ok := groupListOrders[sortBy]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown sort %q", req.Sort)
+	}
+
+	where := []string{"groups.project_id = ?", "groups.group_type = ?"}
+	whereArgs := []interface{}{*q.ProjectID, req.GroupType}
+	if req.Search != "" {
+		where = append(where, "groups.group_key ILIKE ?")
+		whereArgs = append(whereArgs, "%"+escapeLike(req.Search)+"%")
+	}
+
+	var total int64
+	err := s.db.Model(&models.Group{}).Where(strings.Join(where, " AND "), whereArgs...).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	groups, err := s.groupSummaries(q, req.GroupType, strings.Join(where, " AND "), whereArgs,
+		fmt.Sprintf("ORDER BY %s, groups.group_key LIMIT %d OFFSET %d", order, req.Limit, req.Offset))
+	if err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+// GetGroup returns a group with its activity in the query window
+func (s *GroupService) GetGroup(q AnalyticsQuery, groupType, groupKey string) (*GroupSummary, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("groups require a project")
+	}
+	if !groupTypePattern.MatchString(groupType) {
+		return nil, errors.New("group not found")
+	}
+
+	groups, err := s.groupSummaries(q, groupType,
+		"groups.project_id = ? AND groups.group_type = ? AND groups.group_key = ?",
+		[]interface{}{*q.ProjectID, groupType, groupKey}, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, errors.New("group not found")
+	}
+	return &groups[0], nil
+}
+
+// groupSummaries joins the matching groups with their event counts, people and first and
+// last events in the query window
+func (s *GroupService) groupSummaries(q AnalyticsQuery, groupType, where string, whereArgs []interface{}, tail string) ([]GroupSummary, error) {
+	key := groupKeyColumn(groupType)
+	eventsWhere, eventsArgs := q.conditions(true)
+	args := []interface{}{*q.ProjectID}
+	args = append(args, eventsArgs...)
+	args = append(args, whereArgs...)
+
+	var groups []GroupSummary
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		stats AS (
+			SELECT
+				%s AS group_key,
+				COUNT(*) AS events,
+				COUNT(DISTINCT %s) AS people,
+				MIN(events.created_at) AS first_seen,
+				MAX(events.created_at) AS last_seen
+			FROM events
+			%s
+			WHERE %s AND %s IS NOT NULL
+			GROUP BY 1
+		)
+		SELECT groups.*, COALESCE(stats.events, 0) AS events, COALESCE(stats.people, 0) AS people, stats.first_seen, stats.last_seen
+		FROM groups
+		LEFT JOIN stats ON stats.group_key = groups.group_key
+		WHERE %s
+		%s
+	`, sessionPeopleCTE, key, personColumn, sessionPeopleJoin, eventsWhere, key, where, tail), args...).Scan(&groups).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Always return an array, even if empty
+	if groups == nil {
+		groups = []GroupSummary{}
+	}
+	return groups, nil
+}
+
+// groupTypeKeys returns the set of group type keys of a project
+func (s *GroupService) groupTypeKeys(projectID uuid.UUID) (map[string]bool, error) {
+	var keys []string
+	if err := s.db.Model(&models.GroupType{}).Where("project_id = ?", projectID).Pluck("key", &keys).Error; err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[key] = true
+	}
+	return known, nil
+}
+
+func validateGroupKey(groupKey string) error {
+	if groupKey == "" || len(groupKey) > maxGroupKeyLength {
+		return fmt.Errorf("%w: group keys are 1 to %d characters", ErrInvalidGroups, maxGroupKeyLength)
+	}
+	return nil
+}
+
+// groupKeyColumn renders the key of the event's group of a type, NULL when the event has none.
+// The group type is inlined, so it must have been checked against groupTypePattern.
+func groupKeyColumn(groupType string) string {
+	if !groupTypePattern.MatchString(groupType) {
+		return "NULL"
+	}
+	return fmt.Sprintf("NULLIF(events.groups ->> '%s', '')", groupType)
+}
+
+// groupText renders group.<type> as the key of the event's group and group.<type>.<property>
+// as a property of that group, like event properties
+func groupText(field string) (sqlFragment, error) {
+	parts := strings.SplitN(strings.TrimPrefix(field, groupFieldPrefix), ".", 2)
+	if !groupTypePattern.MatchString(parts[0]) {
+		return sqlFragment{}, fmt.Errorf("invalid group type %q", parts[0])
+	}
+	if len(parts) == 1 {
+		return sqlFragment{sql: "(" + groupKeyColumn(parts[0]) + ")"}, nil
+	}
+	if !propertyKeyPattern.MatchString(parts[1]) {
+		return sqlFragment{}, fmt.Errorf("invalid group property %q", parts[1])
+	}
+	return sqlFragment{
+		sql: fmt.Sprintf("(SELECT groups.properties ->> CAST(? AS text) FROM groups WHERE groups.project_id = events.project_id AND groups.group_type = '%s' AND groups.group_key = %s)",
+			parts[0], groupKeyColumn(parts[0])),
+		args: []interface{}{parts[1]},
+	}, nil
+}
+
+func isGroupField(field string) bool {
+	return strings.HasPrefix(field, groupFieldPrefix)
+}
+
+// encodeGroups renders the groups of an event as a JSON object
+func encodeGroups(groups map[string]string) (string, error) {
+	if len(groups) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(groups)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func sortedGroupTypes(groups map[string]string) []string {
+	types := make([]string, 0, len(groups))
+	for groupType := range groups {
+		types = append(types, groupType)
+	}
+	sort.Strings(types)
+	return types
+}
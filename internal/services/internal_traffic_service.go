@@ -0,0 +1,266 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrInternalTraffic is returned by CreateEvent when internal traffic is configured to be dropped
+var ErrInternalTraffic = errors.New("internal traffic excluded")
+
+// ErrInternalTrafficFilterNotFound is returned for projects without an internal traffic filter
+var ErrInternalTrafficFilterNotFound = errors.New("internal traffic filter not found")
+
+// Internal traffic actions
+const (
+	InternalTrafficDrop = "drop"
+	InternalTrafficFlag = "flag"
+)
+
+type InternalTrafficService struct {
+	db      *database.DB
+	filters *projectCache[*models.InternalTrafficFilter] // nil for projects without a filter
+	// bypassHeaders holds, under uuid.Nil, the canonical bypass header names of every active
+	// filter; CORS preflights carry no API key, so they are not known per project
+	bypassHeaders *projectCache[map[string]bool]
+}
+
+func NewInternalTrafficService(db *database.DB) *InternalTrafficService {
+	return &InternalTrafficService{
+		db:            db,
+		filters:       newProjectCache[*models.InternalTrafficFilter](),
+		bypassHeaders: newProjectCache[map[string]bool](),
+	}
+}
+
+// InternalTrafficRequest represents the request to configure a project's internal traffic filter
+type InternalTrafficRequest struct {
+	ExcludedCIDRs     []string `json:"excluded_cidrs"`
+	ExcludedUserIDs   []string `json:"excluded_user_ids"`
+	BypassHeader      *string  `json:"bypass_header,omitempty"`
+	BypassHeaderValue *string  `json:"bypass_header_value,omitempty"`
+	BypassCookie      *string  `json:"bypass_cookie,omitempty"`
+	BypassCookieValue *string  `json:"bypass_cookie_value,omitempty"`
+	Action            string   `json:"action" binding:"omitempty,oneof=drop flag"`
+	IsActive          *bool    `json:"is_active,omitempty"`
+}
+
+// RequestOrigin carries the parts of the HTTP request used to recognise internal traffic
+type RequestOrigin struct {
+	IP      string
+	Header  http.Header
+	Cookies []*http.Cookie
+}
+
+// GetFilter returns the internal traffic filter of a project
+func (s *InternalTrafficService) GetFilter(projectID uuid.UUID) (*models.InternalTrafficFilter, error) {
+	var filter models.InternalTrafficFilter
+	if err := s.db.Where("project_id = ?", projectID).First(&filter).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, ErrInternalTrafficFilterNotFound
+		}
+		return nil, err
+	}
+	return &filter, nil
+}
+
+// SaveFilter creates or replaces the internal traffic filter of a project
+func (s *InternalTrafficService) SaveFilter(projectID uuid.UUID, req *InternalTrafficRequest) (*models.InternalTrafficFilter, error) {
+	cidrs := make([]string, 0, len(req.ExcludedCIDRs))
+	for _, value := range req.ExcludedCIDRs {
+		network, err := parseNetwork(value)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, network.String())
+	}
+
+	userIDs := req.ExcludedUserIDs
+	if userIDs == nil {
+		userIDs = []string{}
+	}
+
+	cidrsJSON, err := json.Marshal(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	userIDsJSON, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	action := req.Action
+	if action == "" {
+		action = InternalTrafficDrop
+	}
+
+	filter := &models.InternalTrafficFilter{
+		ProjectID:         projectID,
+		ExcludedCIDRs:     string(cidrsJSON),
+		ExcludedUserIDs:   string(userIDsJSON),
+		BypassHeader:      req.BypassHeader,
+		BypassHeaderValue: req.BypassHeaderValue,
+		BypassCookie:      req.BypassCookie,
+		BypassCookieValue: req.BypassCookieValue,
+		Action:            action,
+		IsActive:          req.IsActive == nil || *req.IsActive,
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+	}
+
+	if existing, err := s.GetFilter(projectID); err == nil {
+		filter.CreatedAt = existing.CreatedAt
+	}
+
+	if err := s.db.Save(filter).Error; err != nil {
+		return nil, err
+	}
+	s.filters.invalidate(projectID)
+	s.bypassHeaders.invalidate(uuid.Nil)
+
+	return filter, nil
+}
+
+// DeleteFilter removes the internal traffic filter of a project
+func (s *InternalTrafficService) DeleteFilter(projectID uuid.UUID) error {
+	filter, err := s.GetFilter(projectID)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Delete(filter).Error; err != nil {
+		return err
+	}
+	s.filters.invalidate(projectID)
+	s.bypassHeaders.invalidate(uuid.Nil)
+	return nil
+}
+
+// Classify reports whether an event counts as internal traffic and which action applies
+func (s *InternalTrafficService) Classify(projectID uuid.UUID, userID *string, origin *RequestOrigin) (bool, string) {
+	filter, err := s.filters.get(projectID, func() (*models.InternalTrafficFilter, error) {
+		filter, err := s.GetFilter(projectID)
+		if errors.Is(err, ErrInternalTrafficFilterNotFound) {
+			return nil, nil
+		}
+		return filter, err
+	})
+	if err != nil || filter == nil || !filter.IsActive {
+		return false, ""
+	}
+
+	if matchesInternalTraffic(filter, userID, origin) {
+		return true, filter.Action
+	}
+	return false, ""
+}
+
+// AllowedBypassHeaders returns the headers requested by a CORS preflight, a comma-separated
+// Access-Control-Request-Headers value, that an active filter names as its bypass header
+func (s *InternalTrafficService) AllowedBypassHeaders(requested string) []string {
+	if requested == "" {
+		return nil
+	}
+	names, err := s.bypassHeaders.get(uuid.Nil, func() (map[string]bool, error) {
+		var headers []string
+		err := s.db.Model(&models.InternalTrafficFilter{}).
+			Where("is_active = ? AND bypass_header IS NOT NULL AND bypass_header <> ''", true).
+			Distinct().Pluck("bypass_header", &headers).Error
+		if err != nil {
+			return nil, err
+		}
+		names := make(map[string]bool, len(headers))
+		for _, header := range headers {
+			names[http.CanonicalHeaderKey(header)] = true
+		}
+		return names, nil
+	})
+	if err != nil || len(names) == 0 {
+		return nil
+	}
+
+	var allowed []string
+	for _, header := range strings.Split(requested, ",") {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if names[header] {
+			allowed = append(allowed, header)
+		}
+	}
+	return allowed
+}
+
+func matchesInternalTraffic(filter *models.InternalTrafficFilter, userID *string, origin *RequestOrigin) bool {
+	if userID != nil && *userID != "" {
+		var userIDs []string
+		json.Unmarshal([]byte(filter.ExcludedUserIDs), &userIDs)
+		for _, id := range userIDs {
+			if id == *userID {
+				return true
+			}
+		}
+	}
+
+	if origin == nil {
+		return false
+	}
+
+	if ip := net.ParseIP(origin.IP); ip != nil {
+		var cidrs []string
+		json.Unmarshal([]byte(filter.ExcludedCIDRs), &cidrs)
+		for _, cidr := range cidrs {
+			if network, err := parseNetwork(cidr); err == nil && network.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	if filter.BypassHeader != nil && *filter.BypassHeader != "" && origin.Header != nil {
+		if value := origin.Header.Get(*filter.BypassHeader); value != "" &&
+			(filter.BypassHeaderValue == nil || *filter.BypassHeaderValue == "" || value == *filter.BypassHeaderValue) {
+			return true
+		}
+	}
+
+	if filter.BypassCookie != nil && *filter.BypassCookie != "" {
+		for _, cookie := range origin.Cookies {
+			if cookie.Name == *filter.BypassCookie &&
+				(filter.BypassCookieValue == nil || *filter.BypassCookieValue == "" || cookie.Value == *filter.BypassCookieValue) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// parseNetwork accepts a CIDR range or a single IP address
+func parseNetwork(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR range %q", value)
+	}
+	return network, nil
+}
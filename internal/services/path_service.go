@@ -0,0 +1,337 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Path node types
+const (
+	PathPageViews = "page_views" // nodes are the URLs of page views
+	PathEvents    = "events"     // nodes are event names
+)
+
+const (
+	defaultPathDepth        = 5
+	maxPathDepth            = 10
+	defaultPathNodesPerStep = 10
+	maxPathNodesPerStep     = 50
+	maxPathSequences        = 20
+)
+
+// PathOther groups the nodes past the per-step limit
+const PathOther = "(other)"
+
+type PathService struct {
+	db *database.DB
+}
+
+func NewPathService(db *database.DB) *PathService {
+	return &PathService{db: db}
+}
+
+// PathRequest selects the sessions and steps of a path analysis
+type PathRequest struct {
+	PathType          string
+	StartPoint        string // paths start at the first occurrence of this node
+	EndPoint          string // paths end at the first occurrence of this node
+	Depth             int
+	NodesPerStep      int
+	ExcludeEventTypes []string
+}
+
+// PathNode is a node value at a step. Step 0 is the start or end point; steps
+// count forward from a start point and backward (negative) to an end point.
+type PathNode struct {
+	ID    string `json:"id"`
+	Step  int    `json:"step"`
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// PathLink counts the sessions that moved from one node to the next
+type PathLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Value  int64  `json:"value"`
+}
+
+// PathSequence is a complete path and the number of sessions that followed it
+type PathSequence struct {
+	Steps []string `json:"steps"`
+	Count int64    `json:"count"`
+}
+
+// PathsResult holds Sankey nodes and links plus the most common full paths
+type PathsResult struct {
+	PathType   string         `json:"path_type"`
+	StartPoint string         `json:"start_point,omitempty"`
+	EndPoint   string         `json:"end_point,omitempty"`
+	Depth      int            `json:"depth"`
+	Sessions   int64          `json:"sessions"`
+	Nodes      []PathNode     `json:"nodes"`
+	Links      []PathLink     `json:"links"`
+	Paths      []PathSequence `json:"paths"`
+}
+
+type pathEventRow struct {
+	SessionID string
+	EventType string
+	EventName string
+	PageURL   *string
+}
+
+// Validate checks the path type, the start and end points and the limits
+func (r *PathRequest) Validate() error {
+	switch r.PathType {
+	case "", PathPageViews, PathEvents:
+	default:
+		return fmt.Errorf("unknown path type %q", r.PathType)
+	}
+	if r.StartPoint != "" && r.EndPoint != "" {
+		return errors.New("use either a start point or an end point")
+	}
+	if r.Depth != 0 && (r.Depth < 2 || r.Depth > maxPathDepth) {
+		return fmt.Errorf("depth must be between 2 and %d", maxPathDepth)
+	}
+	if r.NodesPerStep < 0 || r.NodesPerStep > maxPathNodesPerStep {
+		return fmt.Errorf("nodes_per_step must be between 0 (default) and %d", maxPathNodesPerStep)
+	}
+	return nil
+}
+
+// GetPaths follows each session through its events in created_at order. Consecutive
+// repeats of the same node are collapsed; sessions that never reach the start or end
+// point are left out.
+func (s *PathService) GetPaths(q AnalyticsQuery, req *PathRequest) (*PathsResult, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("paths require a project")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	pathType := req.PathType
+	if pathType == "" {
+		pathType = PathPageViews
+	}
+	depth := req.Depth
+	if depth == 0 {
+		depth = defaultPathDepth
+	}
+	nodesPerStep := req.NodesPerStep
+	if nodesPerStep == 0 {
+		nodesPerStep = defaultPathNodesPerStep
+	}
+
+	where, args := q.conditions(true)
+	if pathType == PathPageViews {
+		where += " AND events.event_type = ? AND events.page_url IS NOT NULL AND events.page_url != ''"
+		args = append(args, EventTypePageView)
+	}
+	if pathType == PathEvents {
+		// Events sent without a visitor action, such as engagement heartbeats, are not steps
+		where += " AND " + interactionCondition
+	}
+	if len(req.ExcludeEventTypes) > 0 {
+		where += " AND events.event_type NOT IN ?"
+		args = append(args, req.ExcludeEventTypes)
+	}
+
+	rows, err := s.db.Raw(fmt.Sprintf(`
+		SELECT events.session_id, events.event_type, events.event_name, events.page_url
+		FROM events
+		WHERE %s
+		ORDER BY events.session_id, events.created_at
+	`, where), args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths [][]string
+	var current string
+	var sequence []string
+	flush := func() {
+		if path := selectPath(sequence, req.StartPoint, req.EndPoint, depth); len(path) > 0 {
+			paths = append(paths, path)
+		}
+	}
+
+	for rows.Next() {
+		var row pathEventRow
+		if err := s.db.ScanRows(rows, &row); err != nil {
+			return nil, err
+		}
+		if row.SessionID != current {
+			flush()
+			current = row.SessionID
+			sequence = sequence[:0]
+		}
+
+		node := row.EventName
+		if pathType == PathPageViews && row.PageURL != nil {
+			node = *row.PageURL
+		}
+		if node == "" {
+			node = row.EventType
+		}
+		if len(sequence) == 0 || sequence[len(sequence)-1] != node {
+			sequence = append(sequence, node)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	result := buildPaths(paths, req.EndPoint != "", nodesPerStep)
+	result.PathType = pathType
+	result.StartPoint = req.StartPoint
+	result.EndPoint = req.EndPoint
+	result.Depth = depth
+
+	return result, nil
+}
+
+// selectPath cuts a session's node sequence to at most depth nodes from the start point,
+// up to the end point, or from the beginning of the session
+func selectPath(sequence []string, start, end string, depth int) []string {
+	switch {
+	case start != "":
+		for i, node := range sequence {
+			if node == start {
+				return append([]string(nil), sequence[i:min(len(sequence), i+depth)]...)
+			}
+		}
+		return nil
+	case end != "":
+		for i, node := range sequence {
+			if node == end {
+				return append([]string(nil), sequence[max(0, i-depth+1):i+1]...)
+			}
+		}
+		return nil
+	}
+	return append([]string(nil), sequence[:min(len(sequence), depth)]...)
+}
+
+// buildPaths groups rare nodes per step into PathOther and aggregates nodes, links and sequences.
+// Paths to an end point are aligned on their last node.
+func buildPaths(paths [][]string, alignEnd bool, nodesPerStep int) *PathsResult {
+	stepOf := func(path []string, i int) int {
+		if alignEnd {
+			return i - (len(path) - 1)
+		}
+		return i
+	}
+
+	// Keep the most frequent nodes of each step
+	stepCounts := make(map[int]map[string]int64)
+	for _, path := range paths {
+		for i, node := range path {
+			step := stepOf(path, i)
+			if stepCounts[step] == nil {
+				stepCounts[step] = make(map[string]int64)
+			}
+			stepCounts[step][node]++
+		}
+	}
+	kept := make(map[int]map[string]bool)
+	for step, counts := range stepCounts {
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if counts[names[i]] != counts[names[j]] {
+				return counts[names[i]] > counts[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		kept[step] = make(map[string]bool)
+		for _, name := range names[:min(len(names), nodesPerStep)] {
+			kept[step][name] = true
+		}
+	}
+
+	nodes := make(map[string]*PathNode)
+	links := make(map[[2]string]int64)
+	sequences := make(map[string]*PathSequence)
+	for _, path := range paths {
+		ids := make([]string, len(path))
+		grouped := make([]string, len(path))
+		for i, name := range path {
+			step := stepOf(path, i)
+			if !kept[step][name] {
+				name = PathOther
+			}
+			grouped[i] = name
+			ids[i] = fmt.Sprintf("%d_%s", step, name)
+			if nodes[ids[i]] == nil {
+				nodes[ids[i]] = &PathNode{ID: ids[i], Step: step, Name: name}
+			}
+			nodes[ids[i]].Count++
+			if i > 0 {
+				links[[2]string{ids[i-1], ids[i]}]++
+			}
+		}
+
+		key := strings.Join(grouped, "\x00")
+		if sequences[key] == nil {
+			sequences[key] = &PathSequence{Steps: grouped}
+		}
+		sequences[key].Count++
+	}
+
+	result := &PathsResult{
+		Sessions: int64(len(paths)),
+		Nodes:    make([]PathNode, 0, len(nodes)),
+		Links:    make([]PathLink, 0, len(links)),
+		Paths:    make([]PathSequence, 0, len(sequences)),
+	}
+	for _, node := range nodes {
+		result.Nodes = append(result.Nodes, *node)
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool {
+		a, b := result.Nodes[i], result.Nodes[j]
+		if a.Step != b.Step {
+			return a.Step < b.Step
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+	for link, value := range links {
+		result.Links = append(result.Links, PathLink{Source: link[0], Target: link[1], Value: value})
+	}
+	sort.Slice(result.Links, func(i, j int) bool {
+		a, b := result.Links[i], result.Links[j]
+		if a.Value != b.Value {
+			return a.Value > b.Value
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Target < b.Target
+	})
+	for _, sequence := range sequences {
+		result.Paths = append(result.Paths, *sequence)
+	}
+	sort.Slice(result.Paths, func(i, j int) bool {
+		a, b := result.Paths[i], result.Paths[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return strings.Join(a.Steps, "\x00") < strings.Join(b.Steps, "\x00")
+	})
+	if len(result.Paths) > maxPathSequences {
+		result.Paths = result.Paths[:maxPathSequences]
+	}
+
+	return result
+}
@@ -0,0 +1,40 @@
+package services
+
+// sessionPeopleCTE maps each session to the user identified in it, so that the anonymous
+// events of a session are attributed to the user who later logged in. It takes the
+// project ID as its only argument.
+const sessionPeopleCTE = `session_people AS (
+	SELECT events.session_id, MAX(events.user_id) AS user_id
+	FROM events
+	WHERE events.project_id = ? AND events.user_id IS NOT NULL AND events.user_id != ''
+	GROUP BY events.session_id
+)`
+
+// sessionPeopleJoin joins sessionPeopleCTE onto events
+const sessionPeopleJoin = "LEFT JOIN session_people ON session_people.session_id = events.session_id"
+
+// personColumn resolves the person behind an event: its user ID, the user identified
+// in its session, or the anonymous session itself
+const personColumn = "COALESCE(NULLIF(events.user_id, ''), session_people.user_id, events.session_id)"
+
+// actorColumn resolves what person-based reports count: the person behind the event (see
+// personColumn), or its group when the query aggregates by a group type
+func (q AnalyticsQuery) actorColumn() string {
+	if q.GroupType != "" {
+		return groupKeyColumn(q.GroupType)
+	}
+	return personColumn
+}
+
+// uniqueUserColumn resolves what unique user counts count: the user ID of identified events,
+// or the group when the query aggregates by a group type
+func (q AnalyticsQuery) uniqueUserColumn() string {
+	if q.GroupType != "" {
+		return groupKeyColumn(q.GroupType)
+	}
+	return "events.user_id"
+}
+
+// identifiedPersonColumn is personColumn restricted to identified people: it is NULL for
+// the events of sessions in which nobody logged in
+const identifiedPersonColumn = "COALESCE(NULLIF(events.user_id, ''), session_people.user_id)"
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// projectCacheTTL bounds how long a cached project setting is served, so changes made through
+// another server instance are picked up
+const projectCacheTTL = time.Minute
+
+// projectCache keeps a per-project value the ingestion path needs on every event, such as the
+// compiled transformation rules, so it is not reloaded from the database each time. Services
+// invalidate a project's entry when they change the underlying rows.
+type projectCache[T any] struct {
+	mu         sync.RWMutex
+	entries    map[uuid.UUID]projectCacheEntry[T]
+	generation uint64
+}
+
+type projectCacheEntry[T any] struct {
+	value    T
+	loadedAt time.Time
+}
+
+func newProjectCache[T any]() *projectCache[T] {
+	return &projectCache[T]{entries: make(map[uuid.UUID]projectCacheEntry[T])}
+}
+
+// get returns the cached value of a project, calling load on a miss or an expired entry
+func (c *projectCache[T]) get(projectID uuid.UUID, load func() (T, error)) (T, error) {
+	c.mu.RLock()
+	entry, ok := c.entries[projectID]
+	generation := c.generation
+	c.mu.RUnlock()
+	if ok && time.Since(entry.loadedAt) < projectCacheTTL {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	// An invalidation during the load means the value may predate the change
+	if c.generation == generation {
+		c.entries[projectID] = projectCacheEntry[T]{value: value, loadedAt: time.Now()}
+	}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// invalidate drops the cached value of a project
+func (c *projectCache[T]) invalidate(projectID uuid.UUID) {
+	c.mu.Lock()
+	delete(c.entries, projectID)
+	c.generation++
+	c.mu.Unlock()
+}
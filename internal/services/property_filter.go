@@ -0,0 +1,152 @@
+package services
+
+import (
+	"analytic-app/pkg/expr"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Property filter operators
+const (
+	PropertyEquals      = "eq"
+	PropertyNotEquals   = "neq"
+	PropertyContains    = "contains"
+	PropertyNotContains = "not_contains"
+	PropertyGreater     = "gt"
+	PropertyGreaterOrEq = "gte"
+	PropertyLess        = "lt"
+	PropertyLessOrEq    = "lte"
+	PropertyIn          = "in"
+	PropertyNotIn       = "not_in"
+	PropertyExists      = "exists"
+	PropertyNotExists   = "not_exists"
+)
+
+// PropertyFilter matches a value in an event's properties. Property is a dotted
+// path such as "plan" or "cart.total"; Operator defaults to "eq".
+type PropertyFilter struct {
+	Property string      `json:"property"`
+	Operator string      `json:"operator,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+}
+
+// Validate checks the property path, the operator and the value shape
+func (f PropertyFilter) Validate() error {
+	if strings.TrimSpace(f.Property) == "" {
+		return fmt.Errorf("property filter requires a property")
+	}
+
+	switch f.operator() {
+	case PropertyEquals, PropertyNotEquals, PropertyContains, PropertyNotContains:
+		if f.Value == nil {
+			return fmt.Errorf("property filter %q requires a value", f.Property)
+		}
+	case PropertyGreater, PropertyGreaterOrEq, PropertyLess, PropertyLessOrEq:
+		if _, ok := toFloat(f.Value); !ok {
+			return fmt.Errorf("property filter %q requires a numeric value", f.Property)
+		}
+	case PropertyIn, PropertyNotIn:
+		if _, ok := f.Value.([]interface{}); !ok {
+			return fmt.Errorf("property filter %q requires a list value", f.Property)
+		}
+	case PropertyExists, PropertyNotExists:
+	default:
+		return fmt.Errorf("unknown property filter operator %q", f.Operator)
+	}
+	return nil
+}
+
+// Match reports whether the decoded event properties satisfy the filter
+func (f PropertyFilter) Match(properties map[string]interface{}) bool {
+	value := expr.Lookup(properties, f.Property)
+
+	switch f.operator() {
+	case PropertyExists:
+		return value != nil
+	case PropertyNotExists:
+		return value == nil
+	case PropertyEquals:
+		return value != nil && expr.ToString(value) == expr.ToString(f.Value)
+	case PropertyNotEquals:
+		return value == nil || expr.ToString(value) != expr.ToString(f.Value)
+	case PropertyContains:
+		return value != nil && strings.Contains(expr.ToString(value), expr.ToString(f.Value))
+	case PropertyNotContains:
+		return value == nil || !strings.Contains(expr.ToString(value), expr.ToString(f.Value))
+	case PropertyIn, PropertyNotIn:
+		found := false
+		if value != nil {
+			list, _ := f.Value.([]interface{})
+			for _, item := range list {
+				if expr.ToString(value) == expr.ToString(item) {
+					found = true
+					break
+				}
+			}
+		}
+		return found == (f.operator() == PropertyIn)
+	}
+
+	actual, ok := toFloat(value)
+	if !ok {
+		return false
+	}
+	expected, _ := toFloat(f.Value)
+	switch f.operator() {
+	case PropertyGreater:
+		return actual > expected
+	case PropertyGreaterOrEq:
+		return actual >= expected
+	case PropertyLess:
+		return actual < expected
+	case PropertyLessOrEq:
+		return actual <= expected
+	}
+	return false
+}
+
+func (f PropertyFilter) operator() string {
+	if f.Operator == "" {
+		return PropertyEquals
+	}
+	return f.Operator
+}
+
+// matchPropertyFilters reports whether all filters match the decoded properties
+func matchPropertyFilters(filters []PropertyFilter, properties map[string]interface{}) bool {
+	for _, filter := range filters {
+		if !filter.Match(properties) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeProperties decodes an event's properties column, treating invalid JSON as empty
+func decodeProperties(data string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	if data != "" {
+		json.Unmarshal([]byte(data), &properties)
+	}
+	return properties
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -53,61 +53,66 @@ type PageStats struct {
 }
 
 // GetProjectStats returns real-time statistics for a specific project
-func (s *RealTimeService) GetProjectStats(projectID uuid.UUID) (*ProjectStats, error) {
+func (s *RealTimeService) GetProjectStats(q AnalyticsQuery) (*ProjectStats, error) {
 	stats := &ProjectStats{}
-	today := time.Now().Format("2006-01-02")
+	todayStart, todayEnd := q.Today()
 
 	// Last 5 minutes for "active" sessions
-	fiveMinutesAgo := time.Now().Add(-5 * time.Minute)
+	fiveMinutesAgo := q.now().Add(-5 * time.Minute)
 
 	// Total counts for the project
-	s.db.Model(&models.Event{}).Where("project_id = ?", projectID).Count(&stats.TotalEvents)
+	s.db.Model(&models.Event{}).Scopes(q.scope, interactionEvents).Count(&stats.TotalEvents)
 	s.db.Model(&models.Session{}).
 		Joins("JOIN events ON events.session_id = sessions.id").
-		Where("events.project_id = ?", projectID).
+		Scopes(q.scope).
 		Distinct("sessions.id").
 		Count(&stats.TotalSessions)
 
 	s.db.Model(&models.User{}).
 		Joins("JOIN events ON events.user_id = users.id").
-		Where("events.project_id = ?", projectID).
+		Scopes(q.scope).
 		Distinct("users.id").
 		Count(&stats.TotalUsers)
 
-	// Today's counts
+	// Today's counts, where "today" is the current day in the reporting timezone
 	s.db.Model(&models.Event{}).
-		Where("project_id = ? AND DATE(created_at) = ?", projectID, today).
+		Scopes(q.projectScope, interactionEvents).
+		Where("events.created_at >= ? AND events.created_at < ?", todayStart, todayEnd).
 		Count(&stats.EventsToday)
 
 	s.db.Model(&models.Session{}).
 		Joins("JOIN events ON events.session_id = sessions.id").
-		Where("events.project_id = ? AND DATE(sessions.created_at) = ?", projectID, today).
+		Scopes(q.projectScope).
+		Where("sessions.created_at >= ? AND sessions.created_at < ?", todayStart, todayEnd).
 		Distinct("sessions.id").
 		Count(&stats.SessionsToday)
 
 	s.db.Model(&models.User{}).
 		Joins("JOIN events ON events.user_id = users.id").
-		Where("events.project_id = ? AND DATE(events.created_at) = ?", projectID, today).
+		Scopes(q.projectScope).
+		Where("events.created_at >= ? AND events.created_at < ?", todayStart, todayEnd).
 		Distinct("users.id").
 		Count(&stats.UsersToday)
 
 	// Active sessions (sessions with events in last 5 minutes)
 	s.db.Model(&models.Session{}).
 		Joins("JOIN events ON events.session_id = sessions.id").
-		Where("events.project_id = ? AND events.created_at > ?", projectID, fiveMinutesAgo).
+		Scopes(q.projectScope).
+		Where("events.created_at > ? AND events.created_at <= ?", fiveMinutesAgo, q.now()).
 		Distinct("sessions.id").
 		Count(&stats.ActiveSessions)
 
 	// Current visitors (unique users active in last 5 minutes)
 	s.db.Model(&models.User{}).
 		Joins("JOIN events ON events.user_id = users.id").
-		Where("events.project_id = ? AND events.created_at > ?", projectID, fiveMinutesAgo).
+		Scopes(q.projectScope).
+		Where("events.created_at > ? AND events.created_at <= ?", fiveMinutesAgo, q.now()).
 		Distinct("users.id").
 		Count(&stats.CurrentVisitors)
 
 	// Last event time
 	var lastEvent models.Event
-	if err := s.db.Where("project_id = ?", projectID).
+	if err := s.db.Scopes(q.scope).
 		Order("created_at DESC").
 		First(&lastEvent).Error; err == nil {
 		stats.LastEventTime = &lastEvent.CreatedAt
@@ -117,15 +122,15 @@ func (s *RealTimeService) GetProjectStats(projectID uuid.UUID) (*ProjectStats, e
 }
 
 // GetRecentEvents returns recent events for a specific project
-func (s *RealTimeService) GetRecentEvents(projectID uuid.UUID, limit int) ([]RecentEvent, error) {
+func (s *RealTimeService) GetRecentEvents(q AnalyticsQuery, limit int) ([]RecentEvent, error) {
 	if limit <= 0 {
 		limit = 50
 	}
 
 	var events []RecentEvent
 	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope).
 		Select("id, event_type, event_name, page_url, page_title, country, session_id, user_id, properties, created_at").
-		Where("project_id = ?", projectID).
 		Order("created_at DESC").
 		Limit(limit).
 		Find(&events).Error
@@ -139,15 +144,15 @@ func (s *RealTimeService) GetRecentEvents(projectID uuid.UUID, limit int) ([]Rec
 }
 
 // GetEventTypeStats returns event type statistics for a project
-func (s *RealTimeService) GetEventTypeStats(projectID uuid.UUID, limit int) ([]EventTypeStats, error) {
+func (s *RealTimeService) GetEventTypeStats(q AnalyticsQuery, limit int) ([]EventTypeStats, error) {
 	if limit <= 0 {
 		limit = 10
 	}
 
 	var stats []EventTypeStats
 	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope, interactionEvents).
 		Select("event_type, COUNT(*) as count").
-		Where("project_id = ?", projectID).
 		Group("event_type").
 		Order("count DESC").
 		Limit(limit).
@@ -162,15 +167,16 @@ func (s *RealTimeService) GetEventTypeStats(projectID uuid.UUID, limit int) ([]E
 }
 
 // GetCountryStats returns country statistics for a project
-func (s *RealTimeService) GetCountryStats(projectID uuid.UUID, limit int) ([]CountryStats, error) {
+func (s *RealTimeService) GetCountryStats(q AnalyticsQuery, limit int) ([]CountryStats, error) {
 	if limit <= 0 {
 		limit = 10
 	}
 
 	var stats []CountryStats
 	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope, interactionEvents).
 		Select("country, COUNT(*) as count").
-		Where("project_id = ? AND country IS NOT NULL", projectID).
+		Where("country IS NOT NULL").
 		Group("country").
 		Order("count DESC").
 		Limit(limit).
@@ -185,15 +191,16 @@ func (s *RealTimeService) GetCountryStats(projectID uuid.UUID, limit int) ([]Cou
 }
 
 // GetPageStats returns page statistics for a project
-func (s *RealTimeService) GetPageStats(projectID uuid.UUID, limit int) ([]PageStats, error) {
+func (s *RealTimeService) GetPageStats(q AnalyticsQuery, limit int) ([]PageStats, error) {
 	if limit <= 0 {
 		limit = 10
 	}
 
 	var stats []PageStats
 	err := s.db.Model(&models.Event{}).
+		Scopes(q.scope, interactionEvents).
 		Select("page_url, page_title, COUNT(*) as count").
-		Where("project_id = ? AND page_url IS NOT NULL", projectID).
+		Where("page_url IS NOT NULL").
 		Group("page_url, page_title").
 		Order("count DESC").
 		Limit(limit).
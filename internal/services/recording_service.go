@@ -0,0 +1,487 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidRecording is returned when a recording chunk cannot be decoded
+var ErrInvalidRecording = errors.New("invalid recording chunk")
+
+// Recording limits
+const (
+	MaxRecordingChunkSize     = 1 << 20 // compressed bytes accepted per chunk
+	maxRecordingChunkData     = 8 << 20 // decompressed bytes accepted per chunk
+	maxRecordingChunks        = 5000
+	defaultRecordingRetention = 30
+	maxRecordingRetention     = 365
+	maxRecordingSelector      = 500
+)
+
+type RecordingService struct {
+	db *database.DB
+}
+
+func NewRecordingService(db *database.DB) *RecordingService {
+	return &RecordingService{db: db}
+}
+
+// RecordingSettingsRequest updates the recording settings of a project; omitted fields keep
+// their current value
+type RecordingSettingsRequest struct {
+	Enabled          *bool    `json:"enabled,omitempty"`
+	SampleRate       *float64 `json:"sample_rate,omitempty"`
+	RetentionDays    *int     `json:"retention_days,omitempty"`
+	MaskAllInputs    *bool    `json:"mask_all_inputs,omitempty"`
+	MaskAllText      *bool    `json:"mask_all_text,omitempty"`
+	MaskTextSelector *string  `json:"mask_text_selector,omitempty"`
+	BlockSelector    *string  `json:"block_selector,omitempty"`
+}
+
+// StartRecordingRequest asks whether a session is recorded
+type StartRecordingRequest struct {
+	SessionID string `json:"session_id" binding:"required"`
+}
+
+// RecordingStart tells the recorder whether to record the session and how to mask it
+type RecordingStart struct {
+	Recording        bool       `json:"recording"`
+	RecordingID      *uuid.UUID `json:"recording_id,omitempty"`
+	MaskAllInputs    bool       `json:"mask_all_inputs"`
+	MaskAllText      bool       `json:"mask_all_text"`
+	MaskTextSelector *string    `json:"mask_text_selector,omitempty"`
+	BlockSelector    *string    `json:"block_selector,omitempty"`
+}
+
+// RecordingChunkRequest is a chunk of recorded events sent by the recorder. Data is a JSON
+// array of events, gzip-compressed when Compressed is set.
+type RecordingChunkRequest struct {
+	SessionID  string
+	Sequence   int
+	Data       []byte
+	Compressed bool
+}
+
+// RecordingDetail is a recording with the metadata of its chunks in playback order
+type RecordingDetail struct {
+	models.Recording
+	ChunkList []models.RecordingChunk `json:"chunk_list"`
+}
+
+// GetSettings returns the recording settings of a project, or the defaults (recording
+// disabled) when they were never saved
+func (s *RecordingService) GetSettings(projectID uuid.UUID) (*models.RecordingSettings, error) {
+	var settings models.RecordingSettings
+	if err := s.db.Where("project_id = ?", projectID).First(&settings).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return &models.RecordingSettings{
+				ProjectID:     projectID,
+				RetentionDays: defaultRecordingRetention,
+				MaskAllInputs: true,
+			}, nil
+		}
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SaveSettings updates the recording settings of a project
+func (s *RecordingService) SaveSettings(projectID uuid.UUID, req *RecordingSettingsRequest) (*models.RecordingSettings, error) {
+	settings, err := s.GetSettings(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Enabled != nil {
+		settings.Enabled = *req.Enabled
+	}
+	if req.SampleRate != nil {
+		if *req.SampleRate < 0 || *req.SampleRate > 1 {
+			return nil, errors.New("sample_rate must be between 0 and 1")
+		}
+		settings.SampleRate = *req.SampleRate
+	}
+	retentionChanged := false
+	if req.RetentionDays != nil {
+		if *req.RetentionDays < 1 || *req.RetentionDays > maxRecordingRetention {
+			return nil, fmt.Errorf("retention_days must be between 1 and %d", maxRecordingRetention)
+		}
+		retentionChanged = *req.RetentionDays != settings.RetentionDays
+		settings.RetentionDays = *req.RetentionDays
+	}
+	if req.MaskAllInputs != nil {
+		settings.MaskAllInputs = *req.MaskAllInputs
+	}
+	if req.MaskAllText != nil {
+		settings.MaskAllText = *req.MaskAllText
+	}
+	if req.MaskTextSelector != nil {
+		settings.MaskTextSelector, err = recordingSelector(*req.MaskTextSelector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if req.BlockSelector != nil {
+		settings.BlockSelector, err = recordingSelector(*req.BlockSelector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if settings.CreatedAt.IsZero() {
+		settings.CreatedAt = time.Now()
+	}
+	settings.UpdatedAt = time.Now()
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(settings).Error; err != nil {
+			return err
+		}
+		if !retentionChanged {
+			return nil
+		}
+		// Existing recordings follow the new retention from the time they were started
+		return tx.Model(&models.Recording{}).
+			Where("project_id = ?", projectID).
+			Update("expires_at", gorm.Expr("created_at + make_interval(days => ?)", settings.RetentionDays)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// StartRecording decides whether a session is recorded and creates its recording when it is.
+// Sampling is a stable function of the session ID, so repeated calls agree.
+func (s *RecordingService) StartRecording(projectID uuid.UUID, sessionID string) (*RecordingStart, error) {
+	settings, err := s.GetSettings(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	start := &RecordingStart{
+		MaskAllInputs:    settings.MaskAllInputs,
+		MaskAllText:      settings.MaskAllText,
+		MaskTextSelector: settings.MaskTextSelector,
+		BlockSelector:    settings.BlockSelector,
+	}
+	if !settings.Enabled || flagBucket("recording", projectID.String(), sessionID) >= settings.SampleRate {
+		return start, nil
+	}
+
+	recording := &models.Recording{
+		ProjectID: projectID,
+		SessionID: sessionID,
+		ExpiresAt: time.Now().AddDate(0, 0, settings.RetentionDays),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := s.db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "session_id"}}, DoNothing: true}).Create(recording).Error; err != nil {
+		return nil, err
+	}
+
+	recording, err = s.recording(projectID, sessionID)
+	if err != nil {
+		if err.Error() == "recording not found" {
+			// The session ID is recorded in another project
+			return start, nil
+		}
+		return nil, err
+	}
+	if err := s.linkSession(recording); err != nil {
+		return nil, err
+	}
+
+	start.Recording = true
+	start.RecordingID = &recording.ID
+	return start, nil
+}
+
+// AddChunk stores a chunk of a started recording. A chunk whose sequence number is already
+// stored is a retry and is ignored.
+func (s *RecordingService) AddChunk(projectID uuid.UUID, req *RecordingChunkRequest) (*models.RecordingChunk, error) {
+	if req.Sequence < 0 || req.Sequence >= maxRecordingChunks {
+		return nil, fmt.Errorf("%w: sequence must be between 0 and %d", ErrInvalidRecording, maxRecordingChunks-1)
+	}
+	if len(req.Data) > MaxRecordingChunkSize {
+		return nil, fmt.Errorf("%w: chunks are limited to %d bytes", ErrInvalidRecording, MaxRecordingChunkSize)
+	}
+
+	events, err := decodeRecordingChunk(req.Data, req.Compressed)
+	if err != nil {
+		return nil, err
+	}
+	data := req.Data
+	if !req.Compressed {
+		if data, err = compressRecordingChunk(req.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	recording, err := s.recording(projectID, req.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk := &models.RecordingChunk{
+		RecordingID: recording.ID,
+		Sequence:    req.Sequence,
+		Data:        data,
+		Events:      len(events),
+		Size:        len(data),
+		CreatedAt:   time.Now(),
+	}
+	first, last := recordingTimestamps(events)
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(chunk)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		updates := map[string]interface{}{
+			"chunks":     gorm.Expr("chunks + 1"),
+			"events":     gorm.Expr("events + ?", chunk.Events),
+			"size":       gorm.Expr("size + ?", chunk.Size),
+			"updated_at": time.Now(),
+		}
+		if first != nil {
+			// LEAST and GREATEST ignore the NULL bounds of an empty recording
+			updates["started_at"] = gorm.Expr("LEAST(started_at, ?)", *first)
+			updates["ended_at"] = gorm.Expr("GREATEST(ended_at, ?)", *last)
+		}
+		return tx.Model(recording).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The session row may not have existed when the recording started
+	if err := s.linkSession(recording); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// ListRecordings returns a page of the recordings of a project, newest first, and their number
+func (s *RecordingService) ListRecordings(projectID uuid.UUID, limit, offset int) ([]models.Recording, int64, error) {
+	var total int64
+	if err := s.db.Model(&models.Recording{}).Where("project_id = ?", projectID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var recordings []models.Recording
+	err := s.db.Where("project_id = ?", projectID).
+		Order("created_at DESC, id").
+		Limit(limit).
+		Offset(offset).
+		Find(&recordings).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return recordings, total, nil
+}
+
+// GetRecording returns the recording of a session with the metadata of its chunks
+func (s *RecordingService) GetRecording(projectID uuid.UUID, sessionID string) (*RecordingDetail, error) {
+	recording, err := s.recording(projectID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	detail := &RecordingDetail{Recording: *recording, ChunkList: []models.RecordingChunk{}}
+	err = s.db.Model(&models.RecordingChunk{}).
+		Select("recording_id", "sequence", "events", "size", "created_at").
+		Where("recording_id = ?", recording.ID).
+		Order("sequence").
+		Find(&detail.ChunkList).Error
+	if err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// EachEvent calls fn for every recorded event of a session in playback order, decompressing
+// one chunk at a time
+func (s *RecordingService) EachEvent(recording *models.Recording, fn func(json.RawMessage) error) error {
+	rows, err := s.db.Model(&models.RecordingChunk{}).
+		Select("data").
+		Where("recording_id = ?", recording.ID).
+		Order("sequence").
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		events, err := decodeRecordingChunk(data, true)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// DeleteRecording deletes the recording of a session and its chunks
+func (s *RecordingService) DeleteRecording(projectID uuid.UUID, sessionID string) error {
+	recording, err := s.recording(projectID, sessionID)
+	if err != nil {
+		return err
+	}
+	return s.deleteRecordings(s.db.Model(&models.Recording{}).Select("id").Where("id = ?", recording.ID))
+}
+
+// PurgeExpired deletes the recordings past their retention and returns how many were deleted
+func (s *RecordingService) PurgeExpired() (int64, error) {
+	now := time.Now()
+	var expired int64
+	if err := s.db.Model(&models.Recording{}).Where("expires_at < ?", now).Count(&expired).Error; err != nil || expired == 0 {
+		return 0, err
+	}
+	return expired, s.deleteRecordings(s.db.Model(&models.Recording{}).Select("id").Where("expires_at < ?", now))
+}
+
+// StartRetention purges expired recordings in the background, now and then at every interval
+func (s *RecordingService) StartRetention(interval time.Duration) {
+	go func() {
+		for {
+			if purged, err := s.PurgeExpired(); err != nil {
+				log.Printf("Failed to purge expired recordings: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d expired recordings", purged)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// recording returns the recording of a session of a project
+func (s *RecordingService) recording(projectID uuid.UUID, sessionID string) (*models.Recording, error) {
+	var recording models.Recording
+	if err := s.db.Where("session_id = ? AND project_id = ?", sessionID, projectID).First(&recording).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("recording not found")
+		}
+		return nil, err
+	}
+	return &recording, nil
+}
+
+// linkSession points the recorded session at its recording
+func (s *RecordingService) linkSession(recording *models.Recording) error {
+	return s.db.Model(&models.Session{}).
+		Where("id = ? AND project_id = ? AND recording_id IS NULL", recording.SessionID, recording.ProjectID).
+		Update("recording_id", recording.ID).Error
+}
+
+// deleteRecordings deletes the recordings selected by a subquery of IDs, their chunks and
+// the links of their sessions
+func (s *RecordingService) deleteRecordings(ids *gorm.DB) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("recording_id IN (?)", ids).Delete(&models.RecordingChunk{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Session{}).Where("recording_id IN (?)", ids).Update("recording_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN (?)", ids).Delete(&models.Recording{}).Error
+	})
+}
+
+// decodeRecordingChunk reads the events of a chunk, refusing chunks that decompress past
+// maxRecordingChunkData
+func decodeRecordingChunk(data []byte, compressed bool) ([]json.RawMessage, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if compressed {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRecording, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(reader, maxRecordingChunkData+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecording, err)
+	}
+	if len(raw) > maxRecordingChunkData {
+		return nil, fmt.Errorf("%w: chunks are limited to %d bytes uncompressed", ErrInvalidRecording, maxRecordingChunkData)
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(raw, &events); err != nil {
+		return nil, fmt.Errorf("%w: a chunk must be a JSON array of events", ErrInvalidRecording)
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%w: a chunk must hold at least one event", ErrInvalidRecording)
+	}
+	return events, nil
+}
+
+func compressRecordingChunk(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// recordingTimestamps returns the bounds of the millisecond timestamps of recorded events,
+// or nils when none has one
+func recordingTimestamps(events []json.RawMessage) (*time.Time, *time.Time) {
+	var first, last *time.Time
+	for _, raw := range events {
+		var event struct {
+			Timestamp int64 `json:"timestamp"`
+		}
+		if json.Unmarshal(raw, &event) != nil || event.Timestamp <= 0 {
+			continue
+		}
+		at := time.UnixMilli(event.Timestamp)
+		if first == nil || at.Before(*first) {
+			first = &at
+		}
+		if last == nil || at.After(*last) {
+			last = &at
+		}
+	}
+	return first, last
+}
+
+// recordingSelector validates a CSS selector setting, an empty value clearing it
+func recordingSelector(value string) (*string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if len(value) > maxRecordingSelector {
+		return nil, fmt.Errorf("selectors are limited to %d characters", maxRecordingSelector)
+	}
+	return &value, nil
+}
@@ -0,0 +1,489 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/pkg/expr"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retention start types
+const (
+	RetentionFirstTime = "first_time" // people enter the cohort of their first ever start event
+	RetentionRecurring = "recurring"  // people enter every cohort in which they did the start event
+)
+
+// Retention modes
+const (
+	RetentionUnbounded = "unbounded" // returned in period N or any later period
+	RetentionBracketed = "bracketed" // returned in period N exactly
+)
+
+const (
+	defaultRetentionPeriods = 8
+	maxRetentionPeriods     = 90
+	maxRetentionBreakdowns  = 25
+)
+
+type RetentionService struct {
+	db *database.DB
+}
+
+func NewRetentionService(db *database.DB) *RetentionService {
+	return &RetentionService{db: db}
+}
+
+// RetentionEvent selects the events that start or renew retention
+type RetentionEvent struct {
+	EventType string           `json:"event_type,omitempty"`
+	EventName string           `json:"event_name,omitempty"`
+	Filters   []PropertyFilter `json:"filters,omitempty"`
+}
+
+// RetentionRequest represents a retention table to compute
+type RetentionRequest struct {
+	StartEvent        RetentionEvent  `json:"start_event"`
+	ReturnEvent       *RetentionEvent `json:"return_event,omitempty"` // any event when omitted
+	Period            string          `json:"period" binding:"omitempty,oneof=day week month"`
+	Periods           int             `json:"periods"`
+	StartType         string          `json:"start_type" binding:"omitempty,oneof=first_time recurring"`
+	Mode              string          `json:"mode" binding:"omitempty,oneof=unbounded bracketed"`
+	BreakdownProperty string          `json:"breakdown_property,omitempty"`
+}
+
+// RetentionValue is the number and share of a cohort retained in a period
+type RetentionValue struct {
+	Period int     `json:"period"`
+	Count  int64   `json:"count"`
+	Rate   float64 `json:"rate"`
+}
+
+// RetentionCohort is one row of a retention table. Values only cover periods that have started.
+type RetentionCohort struct {
+	Cohort time.Time        `json:"cohort"`
+	Size   int64            `json:"size"`
+	Values []RetentionValue `json:"values"`
+}
+
+// RetentionTable is a set of cohorts with their size-weighted average
+type RetentionTable struct {
+	Cohorts []RetentionCohort `json:"cohorts"`
+	Average []RetentionValue  `json:"average"`
+}
+
+// RetentionBreakdown is the retention table of the people sharing a start event property value
+type RetentionBreakdown struct {
+	Value string `json:"value"`
+	RetentionTable
+}
+
+// RetentionResult is a computed retention analysis
+type RetentionResult struct {
+	Period    string `json:"period"`
+	Periods   int    `json:"periods"`
+	StartType string `json:"start_type"`
+	Mode      string `json:"mode"`
+	RetentionTable
+	BreakdownProperty string               `json:"breakdown_property,omitempty"`
+	Breakdowns        []RetentionBreakdown `json:"breakdowns,omitempty"`
+}
+
+type retentionEventRow struct {
+	Actor      string
+	EventType  string
+	EventName  string
+	Properties string
+	CreatedAt  time.Time
+}
+
+type retentionEvent struct {
+	at        time.Time
+	start     bool
+	renew     bool
+	breakdown string
+}
+
+// retentionCounter accumulates cohort sizes and retained people per period
+type retentionCounter struct {
+	sizes  []int64
+	counts [][]int64
+}
+
+// Validate checks the start and return events and the period count
+func (r *RetentionRequest) Validate() error {
+	if r.StartEvent.EventType == "" && r.StartEvent.EventName == "" {
+		return errors.New("start_event requires an event_type or event_name")
+	}
+	events := []RetentionEvent{r.StartEvent}
+	if r.ReturnEvent != nil {
+		events = append(events, *r.ReturnEvent)
+	}
+	for _, event := range events {
+		for _, filter := range event.Filters {
+			if err := filter.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	if r.Periods < 0 || r.Periods > maxRetentionPeriods {
+		return fmt.Errorf("periods must be between 0 (default) and %d", maxRetentionPeriods)
+	}
+	return nil
+}
+
+// matches reports whether an event row belongs to the retention event
+func (e RetentionEvent) matches(row *retentionEventRow, properties func() map[string]interface{}) bool {
+	if (e.EventType != "" && e.EventType != row.EventType) || (e.EventName != "" && e.EventName != row.EventName) {
+		return false
+	}
+	return len(e.Filters) == 0 || matchPropertyFilters(e.Filters, properties())
+}
+
+// condition renders the event type and name restriction, or "" when every event matches
+func (e RetentionEvent) condition() (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	if e.EventType != "" {
+		clauses = append(clauses, "events.event_type = ?")
+		args = append(args, e.EventType)
+	}
+	if e.EventName != "" {
+		clauses = append(clauses, "events.event_name = ?")
+		args = append(args, e.EventName)
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// Run computes retention for the cohorts starting inside the query window. People are
+// resolved through their user ID or the user identified in their session (see personColumn).
+func (s *RetentionService) Run(q AnalyticsQuery, req *RetentionRequest) (*RetentionResult, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("retention requires a project")
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	period := req.Period
+	if period == "" {
+		period = GranularityWeek
+	}
+	periods := req.Periods
+	if periods == 0 {
+		periods = defaultRetentionPeriods
+	}
+	startType := req.StartType
+	if startType == "" {
+		startType = RetentionFirstTime
+	}
+	mode := req.Mode
+	if mode == "" {
+		mode = RetentionUnbounded
+	}
+
+	q = WithDefaultWindow(q, period)
+	loc := q.location()
+	cohorts, err := timeBuckets(q.From, q.To, loc, period)
+	if err != nil {
+		return nil, err
+	}
+	if len(cohorts) == 0 {
+		return nil, errors.New("the query window contains no cohort")
+	}
+	cohortIndex := make(map[string]int, len(cohorts))
+	for i, cohort := range cohorts {
+		cohortIndex[cohort.Format(bucketLayout)] = i
+	}
+
+	// Return events are needed until the last period of the last cohort has ended
+	end := cohorts[len(cohorts)-1]
+	for i := 0; i <= periods; i++ {
+		end = nextBucket(end, period)
+	}
+
+	// First-time cohorts need every earlier start event to know whether it is the first one
+	startSQL, startArgs := req.StartEvent.condition()
+	if startType == RetentionRecurring {
+		startSQL += " AND events.created_at >= ?"
+		startArgs = append(startArgs, cohorts[0])
+	}
+	returnSQL, returnArgs := "TRUE", []interface{}(nil)
+	if req.ReturnEvent != nil {
+		if sql, args := req.ReturnEvent.condition(); sql != "" {
+			returnSQL, returnArgs = sql, args
+		}
+	}
+
+	where, args := q.conditions(false)
+	args = append([]interface{}{*q.ProjectID}, args...)
+	args = append(args, end)
+	args = append(args, startArgs...)
+	args = append(args, returnArgs...)
+	args = append(args, cohorts[0])
+
+	rows, err := s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT
+			%s AS actor,
+			events.event_type,
+			events.event_name,
+			events.properties,
+			events.created_at
+		FROM events
+		%s
+		WHERE %s AND events.created_at < ?
+			AND ((%s) OR ((%s) AND events.created_at >= ?))
+		ORDER BY actor, events.created_at
+	`, sessionPeopleCTE, q.actorColumn(), sessionPeopleJoin, where, startSQL, returnSQL), args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	total := newRetentionCounter(len(cohorts), periods)
+	breakdowns := make(map[string]*retentionCounter)
+
+	var current string
+	var events []retentionEvent
+	flush := func() {
+		for _, entry := range retentionEntries(events, startType, cohortIndex, loc, period) {
+			retained := retainedPeriods(events, cohorts[entry.cohort], periods, mode, loc, period)
+			total.add(entry.cohort, retained)
+			if req.BreakdownProperty != "" {
+				if breakdowns[entry.breakdown] == nil {
+					breakdowns[entry.breakdown] = newRetentionCounter(len(cohorts), periods)
+				}
+				breakdowns[entry.breakdown].add(entry.cohort, retained)
+			}
+		}
+	}
+
+	for rows.Next() {
+		var row retentionEventRow
+		if err := s.db.ScanRows(rows, &row); err != nil {
+			return nil, err
+		}
+		if row.Actor != current {
+			flush()
+			current = row.Actor
+			events = events[:0]
+		}
+
+		var properties map[string]interface{}
+		decode := func() map[string]interface{} {
+			if properties == nil {
+				properties = decodeProperties(row.Properties)
+			}
+			return properties
+		}
+
+		event := retentionEvent{at: row.CreatedAt, start: req.StartEvent.matches(&row, decode), renew: true}
+		if req.ReturnEvent != nil {
+			event.renew = req.ReturnEvent.matches(&row, decode)
+		}
+		if event.start && req.BreakdownProperty != "" {
+			event.breakdown = BreakdownNone
+			if value := lookupProperty(decode(), req.BreakdownProperty); value != "" {
+				event.breakdown = value
+			}
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	now := q.now()
+	result := &RetentionResult{
+		Period:            period,
+		Periods:           periods,
+		StartType:         startType,
+		Mode:              mode,
+		RetentionTable:    total.table(cohorts, period, now),
+		BreakdownProperty: req.BreakdownProperty,
+	}
+	if req.BreakdownProperty != "" {
+		result.Breakdowns = retentionBreakdowns(breakdowns, cohorts, periods, period, now)
+	}
+
+	return result, nil
+}
+
+type retentionEntry struct {
+	cohort    int
+	breakdown string
+}
+
+// retentionEntries lists the cohorts a person enters, with the breakdown value of the entering event
+func retentionEntries(events []retentionEvent, startType string, cohortIndex map[string]int, loc *time.Location, period string) []retentionEntry {
+	var entries []retentionEntry
+	seen := make(map[int]bool)
+	for _, event := range events {
+		if !event.start {
+			continue
+		}
+		index, ok := cohortIndex[truncateTime(event.at, loc, period).Format(bucketLayout)]
+		if ok && !seen[index] {
+			seen[index] = true
+			entries = append(entries, retentionEntry{cohort: index, breakdown: event.breakdown})
+		}
+		if startType == RetentionFirstTime {
+			break
+		}
+	}
+	return entries
+}
+
+// retainedPeriods marks the periods after the cohort in which the person did the return event.
+// Period 0 is the cohort period itself and always counts.
+func retainedPeriods(events []retentionEvent, cohort time.Time, periods int, mode string, loc *time.Location, period string) []bool {
+	retained := make([]bool, periods+1)
+	retained[0] = true
+	for _, event := range events {
+		if !event.renew || event.at.Before(cohort) {
+			continue
+		}
+		offset := periodsBetween(cohort, truncateTime(event.at, loc, period), loc, period)
+		if offset >= 1 && offset <= periods {
+			retained[offset] = true
+		}
+	}
+	if mode == RetentionUnbounded {
+		for i := periods - 1; i >= 1; i-- {
+			retained[i] = retained[i] || retained[i+1]
+		}
+	}
+	return retained
+}
+
+// periodsBetween counts whole periods between two bucket starts
+func periodsBetween(from, to time.Time, loc *time.Location, period string) int {
+	a, b := from.In(loc), to.In(loc)
+	if period == GranularityMonth {
+		return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+	}
+	days := int(time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC).
+		Sub(time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)).Hours() / 24)
+	if period == GranularityWeek {
+		return days / 7
+	}
+	return days
+}
+
+// lookupProperty renders a property value as a breakdown key, or "" when it is missing
+func lookupProperty(properties map[string]interface{}, path string) string {
+	value := expr.Lookup(properties, path)
+	if value == nil {
+		return ""
+	}
+	return expr.ToString(value)
+}
+
+func newRetentionCounter(cohorts, periods int) *retentionCounter {
+	counts := make([][]int64, cohorts)
+	for i := range counts {
+		counts[i] = make([]int64, periods+1)
+	}
+	return &retentionCounter{sizes: make([]int64, cohorts), counts: counts}
+}
+
+func (c *retentionCounter) add(cohort int, retained []bool) {
+	c.sizes[cohort]++
+	for i, ok := range retained {
+		if ok {
+			c.counts[cohort][i]++
+		}
+	}
+}
+
+func (c *retentionCounter) merge(other *retentionCounter) {
+	for cohort := range c.sizes {
+		c.sizes[cohort] += other.sizes[cohort]
+		for i := range c.counts[cohort] {
+			c.counts[cohort][i] += other.counts[cohort][i]
+		}
+	}
+}
+
+func (c *retentionCounter) total() int64 {
+	var total int64
+	for _, size := range c.sizes {
+		total += size
+	}
+	return total
+}
+
+// table renders the cohorts, leaving out periods that have not started yet
+func (c *retentionCounter) table(cohorts []time.Time, period string, now time.Time) RetentionTable {
+	periods := len(c.counts[0]) - 1
+	table := RetentionTable{
+		Cohorts: make([]RetentionCohort, 0, len(cohorts)),
+		Average: make([]RetentionValue, 0, periods+1),
+	}
+	averageCounts := make([]int64, periods+1)
+	averageSizes := make([]int64, periods+1)
+
+	for i, start := range cohorts {
+		cohort := RetentionCohort{Cohort: start, Size: c.sizes[i], Values: make([]RetentionValue, 0, periods+1)}
+		periodStart := start
+		for p := 0; p <= periods && periodStart.Before(now); p++ {
+			value := RetentionValue{Period: p, Count: c.counts[i][p]}
+			if c.sizes[i] > 0 {
+				value.Rate = float64(value.Count) / float64(c.sizes[i]) * 100
+			}
+			cohort.Values = append(cohort.Values, value)
+			averageCounts[p] += c.counts[i][p]
+			averageSizes[p] += c.sizes[i]
+			periodStart = nextBucket(periodStart, period)
+		}
+		table.Cohorts = append(table.Cohorts, cohort)
+	}
+
+	for p := 0; p <= periods; p++ {
+		if averageSizes[p] == 0 {
+			continue
+		}
+		table.Average = append(table.Average, RetentionValue{
+			Period: p,
+			Count:  averageCounts[p],
+			Rate:   float64(averageCounts[p]) / float64(averageSizes[p]) * 100,
+		})
+	}
+	return table
+}
+
+// retentionBreakdowns sorts breakdown values by people and folds the values past the limit into BreakdownOther
+func retentionBreakdowns(counters map[string]*retentionCounter, cohorts []time.Time, periods int, period string, now time.Time) []RetentionBreakdown {
+	values := make([]string, 0, len(counters))
+	for value := range counters {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		a, b := counters[values[i]].total(), counters[values[j]].total()
+		if a != b {
+			return a > b
+		}
+		return values[i] < values[j]
+	})
+
+	var other *retentionCounter
+	breakdowns := make([]RetentionBreakdown, 0, len(values))
+	for i, value := range values {
+		if i >= maxRetentionBreakdowns {
+			if other == nil {
+				other = newRetentionCounter(len(cohorts), periods)
+			}
+			other.merge(counters[value])
+			continue
+		}
+		breakdowns = append(breakdowns, RetentionBreakdown{Value: value, RetentionTable: counters[value].table(cohorts, period, now)})
+	}
+	if other != nil {
+		breakdowns = append(breakdowns, RetentionBreakdown{Value: BreakdownOther, RetentionTable: other.table(cohorts, period, now)})
+	}
+	return breakdowns
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// E-commerce event types
+const (
+	EventTypePurchase = "purchase"
+	EventTypeRefund   = "refund"
+)
+
+// ReferenceCurrency is the currency exchange rates are quoted against; it always has a rate of 1
+const ReferenceCurrency = "USD"
+
+const maxOrderItems = 200
+
+// ErrInvalidOrder is returned for purchase and refund events with inconsistent order data
+var ErrInvalidOrder = errors.New("invalid order")
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type RevenueService struct {
+	db *database.DB
+}
+
+func NewRevenueService(db *database.DB) *RevenueService {
+	return &RevenueService{db: db}
+}
+
+// OrderItemRequest is a line item of a tracked purchase or refund
+type OrderItemRequest struct {
+	ProductID   string  `json:"product_id" binding:"required"`
+	ProductName *string `json:"product_name,omitempty"`
+	Category    *string `json:"category,omitempty"`
+	Price       float64 `json:"price"`
+	Quantity    int     `json:"quantity"`
+}
+
+// CurrencyRateRequest represents the request to set an exchange rate
+type CurrencyRateRequest struct {
+	Rate float64 `json:"rate" binding:"required,gt=0"`
+}
+
+// NormalizeCurrency upper-cases an ISO 4217 currency code and checks its format
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !currencyPattern.MatchString(code) {
+		return "", fmt.Errorf("invalid currency code %q", code)
+	}
+	return code, nil
+}
+
+// GetRates returns the configured exchange rates
+func (s *RevenueService) GetRates() ([]models.CurrencyRate, error) {
+	var rates []models.CurrencyRate
+	err := s.db.Order("currency ASC").Find(&rates).Error
+
+	if rates == nil {
+		rates = []models.CurrencyRate{}
+	}
+
+	return rates, err
+}
+
+// SetRate creates or replaces the exchange rate of a currency
+func (s *RevenueService) SetRate(currency string, rate float64) (*models.CurrencyRate, error) {
+	code, err := NormalizeCurrency(currency)
+	if err != nil {
+		return nil, err
+	}
+	if code == ReferenceCurrency {
+		return nil, fmt.Errorf("%s is the reference currency, its rate is always 1", ReferenceCurrency)
+	}
+	if rate <= 0 {
+		return nil, errors.New("rate must be positive")
+	}
+
+	record := &models.CurrencyRate{Currency: code, Rate: rate, UpdatedAt: time.Now()}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "currency"}},
+			DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+		}).Create(record).Error
+		if err != nil {
+			return err
+		}
+		return convertPendingOrders(tx, code)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// convertPendingOrders converts the orders and line items stored while a currency had no rate,
+// those in the currency or tracked by a project reporting in it, now that the rate is set
+func convertPendingOrders(tx *gorm.DB, currency string) error {
+	rates := `WITH rates AS (
+		SELECT currency, rate FROM currency_rates
+		UNION ALL
+		SELECT ?, 1
+	)`
+	projectCurrency := "COALESCE(NULLIF(projects.currency, ''), ?)"
+
+	result := tx.Exec(fmt.Sprintf(`%s
+		UPDATE events
+		SET base_revenue = events.revenue / from_rates.rate * to_rates.rate
+		FROM projects, rates AS from_rates, rates AS to_rates
+		WHERE projects.id = events.project_id
+			AND events.event_type IN (?, ?) AND events.revenue IS NOT NULL AND events.base_revenue IS NULL
+			AND from_rates.currency = events.currency AND to_rates.currency = %s
+			AND ? IN (events.currency, %s)
+	`, rates, projectCurrency, projectCurrency),
+		ReferenceCurrency, EventTypePurchase, EventTypeRefund, ReferenceCurrency, currency, ReferenceCurrency)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Converted %d orders and refunds pending a %s rate", result.RowsAffected, currency)
+	}
+
+	return tx.Exec(fmt.Sprintf(`%s
+		UPDATE order_items
+		SET base_revenue = order_items.price * order_items.quantity / from_rates.rate * to_rates.rate
+		FROM events, projects, rates AS from_rates, rates AS to_rates
+		WHERE events.id = order_items.event_id AND projects.id = order_items.project_id
+			AND order_items.base_revenue IS NULL
+			AND from_rates.currency = events.currency AND to_rates.currency = %s
+			AND ? IN (events.currency, %s)
+	`, rates, projectCurrency, projectCurrency),
+		ReferenceCurrency, ReferenceCurrency, currency, ReferenceCurrency).Error
+}
+
+// DeleteRate removes the exchange rate of a currency
+func (s *RevenueService) DeleteRate(currency string) error {
+	code, err := NormalizeCurrency(currency)
+	if err != nil {
+		return err
+	}
+
+	result := s.db.Where("currency = ?", code).Delete(&models.CurrencyRate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("currency rate not found")
+	}
+	return nil
+}
+
+// Convert converts an amount between currencies through the reference currency
+func (s *RevenueService) Convert(amount float64, from, to string) (float64, error) {
+	factor, err := s.conversionFactor(from, to)
+	if err != nil {
+		return 0, err
+	}
+	return amount * factor, nil
+}
+
+// conversionFactor returns what one unit of a currency is worth in another
+func (s *RevenueService) conversionFactor(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	fromRate, err := s.rate(from)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := s.rate(to)
+	if err != nil {
+		return 0, err
+	}
+	return toRate / fromRate, nil
+}
+
+// rate returns the units of a currency per unit of the reference currency
+func (s *RevenueService) rate(currency string) (float64, error) {
+	if currency == ReferenceCurrency {
+		return 1, nil
+	}
+
+	var record models.CurrencyRate
+	if err := s.db.Where("currency = ?", currency).First(&record).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return 0, fmt.Errorf("no exchange rate configured for %s", currency)
+		}
+		return 0, err
+	}
+	return record.Rate, nil
+}
+
+// hasOrder reports whether the request carries any order data
+func (req *CreateEventRequest) hasOrder() bool {
+	return req.OrderID != nil || req.Revenue != nil || req.Currency != nil || len(req.Items) > 0
+}
+
+// validateOrder checks the order data of a request. Only purchase and refund events carry
+// orders, and both need an order ID.
+func (req *CreateEventRequest) validateOrder() error {
+	if req.EventType != EventTypePurchase && req.EventType != EventTypeRefund {
+		if req.hasOrder() {
+			return fmt.Errorf("%w: order data is only accepted on %s and %s events", ErrInvalidOrder, EventTypePurchase, EventTypeRefund)
+		}
+		return nil
+	}
+
+	if req.OrderID == nil || strings.TrimSpace(*req.OrderID) == "" {
+		return fmt.Errorf("%w: %s events require an order_id", ErrInvalidOrder, req.EventType)
+	}
+	if req.Revenue != nil && *req.Revenue < 0 {
+		return fmt.Errorf("%w: revenue cannot be negative", ErrInvalidOrder)
+	}
+	if req.Currency != nil {
+		if _, err := NormalizeCurrency(*req.Currency); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidOrder, err)
+		}
+	}
+	if len(req.Items) > maxOrderItems {
+		return fmt.Errorf("%w: at most %d items are allowed", ErrInvalidOrder, maxOrderItems)
+	}
+	for _, item := range req.Items {
+		if item.ProductID == "" {
+			return fmt.Errorf("%w: items require a product_id", ErrInvalidOrder)
+		}
+		if item.Price < 0 || item.Quantity < 0 {
+			return fmt.Errorf("%w: item price and quantity cannot be negative", ErrInvalidOrder)
+		}
+	}
+	if req.EventType == EventTypePurchase && req.Revenue == nil && len(req.Items) == 0 {
+		return fmt.Errorf("%w: purchase events require revenue or items", ErrInvalidOrder)
+	}
+	return nil
+}
+
+// prepareOrder fills in the order fields of a purchase or refund event and builds its line items.
+// Revenue defaults to the sum of the items; a refund without revenue or items refunds the whole
+// order. Amounts are converted to the project currency; when no rate is configured the event is
+// still stored, and left out of revenue reports until SetRate adds the rate and converts it.
+func (s *RevenueService) prepareOrder(req *CreateEventRequest, event *models.Event) ([]models.OrderItem, error) {
+	if req.EventType != EventTypePurchase && req.EventType != EventTypeRefund {
+		return nil, nil
+	}
+
+	var project models.Project
+	if err := s.db.Select("currency").Where("id = ?", *req.ProjectID).First(&project).Error; err != nil {
+		return nil, err
+	}
+	projectCurrency := project.Currency
+	if projectCurrency == "" {
+		projectCurrency = ReferenceCurrency
+	}
+
+	orderID := strings.TrimSpace(*req.OrderID)
+	currency := projectCurrency
+	if req.Currency != nil {
+		currency, _ = NormalizeCurrency(*req.Currency)
+	}
+
+	var revenue *float64
+	switch {
+	case req.Revenue != nil:
+		revenue = req.Revenue
+	case len(req.Items) > 0:
+		total := 0.0
+		for _, item := range req.Items {
+			total += item.Price * float64(itemQuantity(item))
+		}
+		revenue = &total
+	case req.EventType == EventTypeRefund:
+		// A full refund: refund what the order was worth in the project currency
+		var purchase models.Event
+		err := s.db.Where("project_id = ? AND order_id = ? AND event_type = ? AND base_revenue IS NOT NULL", *req.ProjectID, orderID, EventTypePurchase).
+			Order("created_at ASC").
+			First(&purchase).Error
+		if err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, fmt.Errorf("%w: order %q not found for a full refund", ErrInvalidOrder, orderID)
+			}
+			return nil, err
+		}
+		revenue = purchase.BaseRevenue
+		currency = projectCurrency
+	}
+
+	event.OrderID = &orderID
+	event.Revenue = revenue
+	event.Currency = &currency
+
+	// The rates are loaded once for the order and its items
+	factor, err := s.conversionFactor(currency, projectCurrency)
+	if err != nil {
+		log.Printf("Failed to convert order %s to %s: %v", orderID, projectCurrency, err)
+	}
+	convert := func(amount float64) *float64 {
+		if err != nil {
+			return nil
+		}
+		converted := amount * factor
+		return &converted
+	}
+	if revenue != nil {
+		event.BaseRevenue = convert(*revenue)
+	}
+
+	items := make([]models.OrderItem, 0, len(req.Items))
+	for _, item := range req.Items {
+		quantity := itemQuantity(item)
+		items = append(items, models.OrderItem{
+			ProjectID:   *req.ProjectID,
+			EventID:     event.ID,
+			OrderID:     orderID,
+			ProductID:   item.ProductID,
+			ProductName: item.ProductName,
+			Category:    item.Category,
+			Price:       item.Price,
+			Quantity:    quantity,
+			BaseRevenue: convert(item.Price * float64(quantity)),
+			IsRefund:    req.EventType == EventTypeRefund,
+			CreatedAt:   event.CreatedAt,
+		})
+	}
+	return items, nil
+}
+
+// itemQuantity defaults a missing quantity to one
+func itemQuantity(item OrderItemRequest) int {
+	if item.Quantity == 0 {
+		return 1
+	}
+	return item.Quantity
+}
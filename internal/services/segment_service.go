@@ -0,0 +1,223 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SegmentService struct {
+	db            *database.DB
+	cohortService *CohortService
+}
+
+func NewSegmentService(db *database.DB, cohortService *CohortService) *SegmentService {
+	return &SegmentService{db: db, cohortService: cohortService}
+}
+
+// SegmentRequest represents the request to create a segment. Filters are insight filters on
+// event dimensions and properties, session.<name>, user.<trait> and group fields.
+type SegmentRequest struct {
+	Name        string          `json:"name" binding:"required"`
+	Description *string         `json:"description,omitempty"`
+	Filters     []InsightFilter `json:"filters,omitempty"`
+	CohortID    *uuid.UUID      `json:"cohort_id,omitempty"`
+}
+
+// UpdateSegmentRequest represents the request to update a segment
+type UpdateSegmentRequest struct {
+	Name        *string         `json:"name,omitempty"`
+	Description *string         `json:"description,omitempty"`
+	Filters     []InsightFilter `json:"filters,omitempty"`
+	CohortID    *uuid.UUID      `json:"cohort_id,omitempty"`
+}
+
+// QuerySegment is a saved segment or cohort resolved to a restriction on the events of a report
+type QuerySegment struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"` // segment or cohort
+	Name      string    `json:"name"`
+	ProjectID uuid.UUID `json:"-"`
+	condition sqlFragment
+}
+
+// CreateSegment creates a segment for a project
+func (s *SegmentService) CreateSegment(projectID uuid.UUID, req *SegmentRequest) (*models.Segment, error) {
+	segment := &models.Segment{
+		ProjectID:   projectID,
+		Name:        req.Name,
+		Description: req.Description,
+		CohortID:    req.CohortID,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.setFilters(segment, req.Filters); err != nil {
+		return nil, err
+	}
+	if err := s.validateSegment(segment); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Create(segment).Error; err != nil {
+		return nil, err
+	}
+
+	return segment, nil
+}
+
+// GetSegments returns all segments of a project
+func (s *SegmentService) GetSegments(projectID uuid.UUID) ([]models.Segment, error) {
+	var segments []models.Segment
+	if err := s.db.Where("project_id = ?", projectID).Order("name").Find(&segments).Error; err != nil {
+		return nil, err
+	}
+	if segments == nil {
+		segments = []models.Segment{}
+	}
+	return segments, nil
+}
+
+// GetSegment returns a segment of a project
+func (s *SegmentService) GetSegment(projectID, segmentID uuid.UUID) (*models.Segment, error) {
+	var segment models.Segment
+	if err := s.db.Where("id = ? AND project_id = ?", segmentID, projectID).First(&segment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("segment not found")
+		}
+		return nil, err
+	}
+	return &segment, nil
+}
+
+// UpdateSegment updates a segment
+func (s *SegmentService) UpdateSegment(projectID, segmentID uuid.UUID, req *UpdateSegmentRequest) (*models.Segment, error) {
+	segment, err := s.GetSegment(projectID, segmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		segment.Name = *req.Name
+	}
+	if req.Description != nil {
+		segment.Description = req.Description
+	}
+	if req.Filters != nil {
+		if err := s.setFilters(segment, req.Filters); err != nil {
+			return nil, err
+		}
+	}
+	if req.CohortID != nil {
+		segment.CohortID = req.CohortID
+	}
+	if err := s.validateSegment(segment); err != nil {
+		return nil, err
+	}
+	segment.UpdatedAt = time.Now()
+
+	if err := s.db.Save(segment).Error; err != nil {
+		return nil, err
+	}
+
+	return segment, nil
+}
+
+// DeleteSegment deletes a segment
+func (s *SegmentService) DeleteSegment(projectID, segmentID uuid.UUID) error {
+	result := s.db.Where("id = ? AND project_id = ?", segmentID, projectID).Delete(&models.Segment{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("segment not found")
+	}
+	return nil
+}
+
+// Resolve loads a segment as a restriction on events: all of its filters and, when it has
+// a cohort, membership of the cohort
+func (s *SegmentService) Resolve(segmentID uuid.UUID) (*QuerySegment, error) {
+	var segment models.Segment
+	if err := s.db.Where("id = ?", segmentID).First(&segment).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("segment not found")
+		}
+		return nil, err
+	}
+
+	filters, err := segmentFilters(&segment)
+	if err != nil {
+		return nil, err
+	}
+	condition, err := filtersCondition(filters)
+	if err != nil {
+		return nil, err
+	}
+	if segment.CohortID != nil {
+		cohort, err := s.cohortService.GetCohort(segment.ProjectID, *segment.CohortID)
+		if err != nil {
+			return nil, err
+		}
+		members, err := cohortCondition(cohort)
+		if err != nil {
+			return nil, err
+		}
+		condition = sqlFragment{
+			sql:  condition.sql + " AND " + members.sql,
+			args: append(condition.args, members.args...),
+		}
+	}
+
+	return &QuerySegment{ID: segment.ID, Type: "segment", Name: segment.Name, ProjectID: segment.ProjectID, condition: condition}, nil
+}
+
+// setFilters validates and stores the filters of a segment
+func (s *SegmentService) setFilters(segment *models.Segment, filters []InsightFilter) error {
+	if len(filters) > maxInsightFilters {
+		return fmt.Errorf("at most %d filters are allowed", maxInsightFilters)
+	}
+	if _, err := filtersCondition(filters); err != nil {
+		return err
+	}
+	if filters == nil {
+		filters = []InsightFilter{}
+	}
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return err
+	}
+	segment.Filters = string(data)
+	return nil
+}
+
+// validateSegment checks that the segment restricts something and that its cohort belongs
+// to the project
+func (s *SegmentService) validateSegment(segment *models.Segment) error {
+	filters, err := segmentFilters(segment)
+	if err != nil {
+		return err
+	}
+	if len(filters) == 0 && segment.CohortID == nil {
+		return errors.New("a segment requires filters or a cohort")
+	}
+	if segment.CohortID != nil {
+		if _, err := s.cohortService.GetCohort(segment.ProjectID, *segment.CohortID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func segmentFilters(segment *models.Segment) ([]InsightFilter, error) {
+	var filters []InsightFilter
+	if err := json.Unmarshal([]byte(segment.Filters), &filters); err != nil {
+		return nil, err
+	}
+	return filters, nil
+}
@@ -0,0 +1,630 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"analytic-app/pkg/expr"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrEventDropped is returned by CreateEvent when a transformation rule drops the event
+var ErrEventDropped = errors.New("event dropped by transformation rule")
+
+type TransformService struct {
+	db             *database.DB
+	rules          *projectCache[[]compiledRule] // active rules of each project, compiled
+	activeProjects *projectCache[bool]           // whether route targets are active
+}
+
+func NewTransformService(db *database.DB) *TransformService {
+	return &TransformService{
+		db:             db,
+		rules:          newProjectCache[[]compiledRule](),
+		activeProjects: newProjectCache[bool](),
+	}
+}
+
+// Rule action types
+const (
+	RuleActionSet   = "set"
+	RuleActionUnset = "unset"
+	RuleActionCopy  = "copy"
+	RuleActionDrop  = "drop"
+	RuleActionRoute = "route"
+)
+
+// writableFields lists the event fields rule actions may modify besides properties.*
+var writableFields = map[string]bool{
+	"session_id": true,
+	"user_id":    true,
+	"event_type": true,
+	"event_name": true,
+	"page_url":   true,
+	"page_title": true,
+	"referrer":   true,
+	"country":    true,
+	"city":       true,
+	"language":   true,
+	"platform":   true,
+}
+
+// RuleAction describes a single change applied when a rule matches
+type RuleAction struct {
+	Type      string     `json:"type"`                 // set, unset, copy, drop or route
+	Field     string     `json:"field,omitempty"`      // target field for set, unset and copy
+	From      string     `json:"from,omitempty"`       // source field for copy
+	Value     string     `json:"value,omitempty"`      // expression evaluated for set
+	ProjectID *uuid.UUID `json:"project_id,omitempty"` // destination project for route
+}
+
+// TransformRuleRequest represents the request to create a transformation rule
+type TransformRuleRequest struct {
+	Name        string       `json:"name" binding:"required"`
+	Description *string      `json:"description,omitempty"`
+	Position    *int         `json:"position,omitempty"`
+	Condition   string       `json:"condition"`
+	Actions     []RuleAction `json:"actions" binding:"required,min=1"`
+	StopOnMatch bool         `json:"stop_on_match"`
+	IsActive    *bool        `json:"is_active,omitempty"`
+}
+
+// UpdateTransformRuleRequest represents the request to update a transformation rule
+type UpdateTransformRuleRequest struct {
+	Name        *string      `json:"name,omitempty"`
+	Description *string      `json:"description,omitempty"`
+	Position    *int         `json:"position,omitempty"`
+	Condition   *string      `json:"condition,omitempty"`
+	Actions     []RuleAction `json:"actions,omitempty"`
+	StopOnMatch *bool        `json:"stop_on_match,omitempty"`
+	IsActive    *bool        `json:"is_active,omitempty"`
+}
+
+// DryRunRequest represents a sample payload to run through a project's rules
+type DryRunRequest struct {
+	Event CreateEventRequest `json:"event" binding:"required"`
+	// Rules optionally replaces the stored rules, to preview changes before saving
+	Rules []TransformRuleRequest `json:"rules,omitempty"`
+}
+
+// TransformResult describes what the rule engine did to an event
+type TransformResult struct {
+	Dropped      bool     `json:"dropped"`
+	AppliedRules []string `json:"applied_rules"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// DryRunResult shows a sample payload before and after transformation
+type DryRunResult struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	TransformResult
+}
+
+// compiledRule is a rule with its condition and action expressions parsed
+type compiledRule struct {
+	name        string
+	condition   *expr.Expr
+	actions     []RuleAction
+	values      []*expr.Expr
+	stopOnMatch bool
+}
+
+// CreateRule creates a transformation rule for a project
+func (s *TransformService) CreateRule(projectID uuid.UUID, req *TransformRuleRequest) (*models.TransformRule, error) {
+	if err := s.validateRule(req.Condition, req.Actions); err != nil {
+		return nil, err
+	}
+
+	actions, err := json.Marshal(req.Actions)
+	if err != nil {
+		return nil, err
+	}
+
+	position := 0
+	if req.Position != nil {
+		position = *req.Position
+	} else {
+		// Append after the last existing rule
+		var last models.TransformRule
+		if err := s.db.Where("project_id = ?", projectID).Order("position DESC").First(&last).Error; err == nil {
+			position = last.Position + 1
+		}
+	}
+
+	rule := &models.TransformRule{
+		ProjectID:   projectID,
+		Name:        req.Name,
+		Description: req.Description,
+		Position:    position,
+		Condition:   req.Condition,
+		Actions:     string(actions),
+		StopOnMatch: req.StopOnMatch,
+		IsActive:    req.IsActive == nil || *req.IsActive,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if err := s.db.Create(rule).Error; err != nil {
+		return nil, err
+	}
+	s.rules.invalidate(projectID)
+
+	return rule, nil
+}
+
+// GetRules returns all transformation rules for a project in evaluation order
+func (s *TransformService) GetRules(projectID uuid.UUID) ([]models.TransformRule, error) {
+	var rules []models.TransformRule
+	err := s.db.Where("project_id = ?", projectID).
+		Order("position ASC, created_at ASC").
+		Find(&rules).Error
+
+	if rules == nil {
+		rules = []models.TransformRule{}
+	}
+
+	return rules, err
+}
+
+// GetRule returns a single transformation rule of a project
+func (s *TransformService) GetRule(projectID, ruleID uuid.UUID) (*models.TransformRule, error) {
+	var rule models.TransformRule
+	if err := s.db.Where("id = ? AND project_id = ?", ruleID, projectID).First(&rule).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, errors.New("rule not found")
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// UpdateRule updates a transformation rule
+func (s *TransformService) UpdateRule(projectID, ruleID uuid.UUID, req *UpdateTransformRuleRequest) (*models.TransformRule, error) {
+	rule, err := s.GetRule(projectID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	condition := rule.Condition
+	if req.Condition != nil {
+		condition = *req.Condition
+	}
+	actions, err := decodeRuleActions(rule.Actions)
+	if err != nil {
+		return nil, err
+	}
+	if req.Actions != nil {
+		actions = req.Actions
+	}
+	if err := s.validateRule(condition, actions); err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"updated_at": time.Now(),
+	}
+
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Position != nil {
+		updates["position"] = *req.Position
+	}
+	if req.Condition != nil {
+		updates["condition"] = *req.Condition
+	}
+	if req.Actions != nil {
+		data, err := json.Marshal(req.Actions)
+		if err != nil {
+			return nil, err
+		}
+		updates["actions"] = string(data)
+	}
+	if req.StopOnMatch != nil {
+		updates["stop_on_match"] = *req.StopOnMatch
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+
+	if err := s.db.Model(rule).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	s.rules.invalidate(projectID)
+
+	return s.GetRule(projectID, ruleID)
+}
+
+// DeleteRule deletes a transformation rule
+func (s *TransformService) DeleteRule(projectID, ruleID uuid.UUID) error {
+	rule, err := s.GetRule(projectID, ruleID)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Delete(rule).Error; err != nil {
+		return err
+	}
+	s.rules.invalidate(projectID)
+	return nil
+}
+
+// ApplyRules runs the project's active rules against an incoming event in place
+func (s *TransformService) ApplyRules(req *CreateEventRequest) (*TransformResult, error) {
+	result := &TransformResult{AppliedRules: []string{}}
+	if req.ProjectID == nil {
+		return result, nil
+	}
+
+	compiled, err := s.rules.get(*req.ProjectID, func() ([]compiledRule, error) {
+		return s.compileActiveRules(*req.ProjectID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(compiled) == 0 {
+		return result, nil
+	}
+
+	return s.run(req, compiled, result)
+}
+
+// compileActiveRules loads and compiles the project's active rules in evaluation order.
+// Rules that no longer compile are skipped.
+func (s *TransformService) compileActiveRules(projectID uuid.UUID) ([]compiledRule, error) {
+	var rules []models.TransformRule
+	if err := s.db.Where("project_id = ? AND is_active = ?", projectID, true).
+		Order("position ASC, created_at ASC").
+		Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		actions, err := decodeRuleActions(rule.Actions)
+		if err != nil {
+			log.Printf("Skipping transform rule %s: %v", rule.ID, err)
+			continue
+		}
+		cr, err := compileRule(rule.Name, rule.Condition, actions, rule.StopOnMatch)
+		if err != nil {
+			log.Printf("Skipping transform rule %s: %v", rule.ID, err)
+			continue
+		}
+		compiled = append(compiled, *cr)
+	}
+	return compiled, nil
+}
+
+// DryRun shows how a sample event would be transformed, without storing it
+func (s *TransformService) DryRun(projectID uuid.UUID, req *DryRunRequest) (*DryRunResult, error) {
+	event := req.Event
+	event.ProjectID = &projectID
+	before := eventEnv(&event)
+
+	var (
+		transform *TransformResult
+		err       error
+	)
+	if req.Rules != nil {
+		compiled := make([]compiledRule, 0, len(req.Rules))
+		for _, rule := range req.Rules {
+			if err := s.validateRule(rule.Condition, rule.Actions); err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			if rule.IsActive != nil && !*rule.IsActive {
+				continue
+			}
+			cr, err := compileRule(rule.Name, rule.Condition, rule.Actions, rule.StopOnMatch)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, *cr)
+		}
+		transform, err = s.run(&event, compiled, &TransformResult{AppliedRules: []string{}})
+	} else {
+		transform, err = s.ApplyRules(&event)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &DryRunResult{
+		Before:          before,
+		After:           eventEnv(&event),
+		TransformResult: *transform,
+	}, nil
+}
+
+// run evaluates compiled rules in order and writes the result back to the request
+func (s *TransformService) run(req *CreateEventRequest, rules []compiledRule, result *TransformResult) (*TransformResult, error) {
+	env := eventEnv(req)
+
+	for _, rule := range rules {
+		if rule.condition != nil {
+			matched, err := rule.condition.EvalBool(env)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", rule.name, err))
+				continue
+			}
+			if !matched {
+				continue
+			}
+		}
+
+		// Apply actions to a copy so a failing action leaves the event untouched
+		next := cloneEnv(env)
+		var failed error
+		for i, action := range rule.actions {
+			if action.Type == RuleActionDrop {
+				result.Dropped = true
+				break
+			}
+			if action.Type == RuleActionRoute {
+				if !s.projectIsActive(*action.ProjectID) {
+					failed = fmt.Errorf("route target project %s is not active", action.ProjectID)
+					break
+				}
+			}
+			if err := applyAction(next, action, rule.values[i]); err != nil {
+				failed = err
+				break
+			}
+		}
+		if failed != nil {
+			result.Dropped = false
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", rule.name, failed))
+			continue
+		}
+
+		env = next
+		result.AppliedRules = append(result.AppliedRules, rule.name)
+		if result.Dropped || rule.stopOnMatch {
+			break
+		}
+	}
+
+	if err := applyEventEnv(env, req); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *TransformService) validateRule(condition string, actions []RuleAction) error {
+	if len(actions) == 0 {
+		return errors.New("at least one action is required")
+	}
+	if _, err := compileRule("", condition, actions, false); err != nil {
+		return err
+	}
+	for _, action := range actions {
+		if action.Type == RuleActionRoute && !s.projectIsActive(*action.ProjectID) {
+			return fmt.Errorf("route target project %s not found", action.ProjectID)
+		}
+	}
+	return nil
+}
+
+// projectIsActive reports whether a route target project exists and is active. Projects are
+// changed by the admin service, so a change is picked up when the cached entry expires.
+func (s *TransformService) projectIsActive(projectID uuid.UUID) bool {
+	active, err := s.activeProjects.get(projectID, func() (bool, error) {
+		var count int64
+		err := s.db.Model(&models.Project{}).Where("id = ? AND is_active = ?", projectID, true).Count(&count).Error
+		return count > 0, err
+	})
+	return err == nil && active
+}
+
+func compileRule(name, condition string, actions []RuleAction, stopOnMatch bool) (*compiledRule, error) {
+	rule := &compiledRule{
+		name:        name,
+		actions:     actions,
+		values:      make([]*expr.Expr, len(actions)),
+		stopOnMatch: stopOnMatch,
+	}
+
+	if strings.TrimSpace(condition) != "" {
+		cond, err := expr.Compile(condition)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition: %w", err)
+		}
+		rule.condition = cond
+	}
+
+	for i, action := range actions {
+		switch action.Type {
+		case RuleActionSet:
+			if !isWritableField(action.Field) {
+				return nil, fmt.Errorf("field %q cannot be set", action.Field)
+			}
+			value, err := expr.Compile(action.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", action.Field, err)
+			}
+			rule.values[i] = value
+		case RuleActionUnset:
+			if !isWritableField(action.Field) {
+				return nil, fmt.Errorf("field %q cannot be unset", action.Field)
+			}
+		case RuleActionCopy:
+			if !isWritableField(action.Field) {
+				return nil, fmt.Errorf("field %q cannot be set", action.Field)
+			}
+			if action.From == "" {
+				return nil, errors.New("copy action requires a source field")
+			}
+		case RuleActionRoute:
+			if action.ProjectID == nil {
+				return nil, errors.New("route action requires a project_id")
+			}
+		case RuleActionDrop:
+		default:
+			return nil, fmt.Errorf("unknown action type %q", action.Type)
+		}
+	}
+
+	return rule, nil
+}
+
+func applyAction(env map[string]interface{}, action RuleAction, value *expr.Expr) error {
+	switch action.Type {
+	case RuleActionSet:
+		v, err := value.Eval(env)
+		if err != nil {
+			return err
+		}
+		setEnvField(env, action.Field, v)
+	case RuleActionUnset:
+		setEnvField(env, action.Field, nil)
+	case RuleActionCopy:
+		setEnvField(env, action.Field, expr.Lookup(env, action.From))
+	case RuleActionRoute:
+		env["project_id"] = action.ProjectID.String()
+	}
+	return nil
+}
+
+func isWritableField(field string) bool {
+	if key, ok := strings.CutPrefix(field, "properties."); ok {
+		return key != "" && !strings.Contains(key, ".")
+	}
+	return writableFields[field]
+}
+
+func setEnvField(env map[string]interface{}, field string, value interface{}) {
+	if key, ok := strings.CutPrefix(field, "properties."); ok {
+		props, _ := env["properties"].(map[string]interface{})
+		if value == nil {
+			delete(props, key)
+			return
+		}
+		props[key] = value
+		return
+	}
+	env[field] = value
+}
+
+func decodeRuleActions(data string) ([]RuleAction, error) {
+	var actions []RuleAction
+	if data == "" {
+		return actions, nil
+	}
+	if err := json.Unmarshal([]byte(data), &actions); err != nil {
+		return nil, fmt.Errorf("invalid rule actions: %w", err)
+	}
+	return actions, nil
+}
+
+// eventEnv converts an event request into the environment rules are evaluated against
+func eventEnv(req *CreateEventRequest) map[string]interface{} {
+	props := make(map[string]interface{}, len(req.Properties))
+	for k, v := range req.Properties {
+		props[k] = v
+	}
+
+	env := map[string]interface{}{
+		"session_id":    req.SessionID,
+		"user_id":       derefString(req.UserID),
+		"event_type":    req.EventType,
+		"event_name":    req.EventName,
+		"properties":    props,
+		"page_url":      derefString(req.PageURL),
+		"page_title":    derefString(req.PageTitle),
+		"referrer":      derefString(req.Referrer),
+		"user_agent":    derefString(req.UserAgent),
+		"ip_address":    req.IPAddress,
+		"country":       derefString(req.Country),
+		"city":          derefString(req.City),
+		"language":      derefString(req.Language),
+		"platform":      derefString(req.Platform),
+		"screen_width":  derefInt(req.ScreenWidth),
+		"screen_height": derefInt(req.ScreenHeight),
+		"project_id":    nil,
+	}
+	if req.ProjectID != nil {
+		env["project_id"] = req.ProjectID.String()
+	}
+	return env
+}
+
+// applyEventEnv writes a transformed environment back into the event request
+func applyEventEnv(env map[string]interface{}, req *CreateEventRequest) error {
+	if id, ok := env["project_id"].(string); ok && id != "" {
+		projectID, err := uuid.Parse(id)
+		if err != nil {
+			return fmt.Errorf("invalid project_id %q", id)
+		}
+		req.ProjectID = &projectID
+	}
+
+	req.SessionID = expr.ToString(env["session_id"])
+	req.EventType = expr.ToString(env["event_type"])
+	req.EventName = expr.ToString(env["event_name"])
+	req.UserID = envString(env["user_id"])
+	req.PageURL = envString(env["page_url"])
+	req.PageTitle = envString(env["page_title"])
+	req.Referrer = envString(env["referrer"])
+	req.Country = envString(env["country"])
+	req.City = envString(env["city"])
+	req.Language = envString(env["language"])
+	req.Platform = envString(env["platform"])
+
+	if req.SessionID == "" || req.EventType == "" || req.EventName == "" {
+		return errors.New("transformation removed a required field")
+	}
+
+	props, _ := env["properties"].(map[string]interface{})
+	req.Properties = props
+	return nil
+}
+
+func cloneEnv(env map[string]interface{}) map[string]interface{} {
+	clone := make(map[string]interface{}, len(env))
+	for k, v := range env {
+		clone[k] = v
+	}
+	if props, ok := env["properties"].(map[string]interface{}); ok {
+		copied := make(map[string]interface{}, len(props))
+		for k, v := range props {
+			copied[k] = v
+		}
+		clone["properties"] = copied
+	}
+	return clone
+}
+
+func envString(v interface{}) *string {
+	if v == nil {
+		return nil
+	}
+	s := expr.ToString(v)
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func derefString(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+func derefInt(i *int) interface{} {
+	if i == nil {
+		return nil
+	}
+	return float64(*i)
+}
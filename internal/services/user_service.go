@@ -0,0 +1,396 @@
+package services
+
+import (
+	"analytic-app/internal/database"
+	"analytic-app/internal/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// User list sort orders
+const (
+	UserSortLastSeen  = "last_seen"
+	UserSortFirstSeen = "first_seen"
+	UserSortEvents    = "events"
+	UserSortSessions  = "sessions"
+)
+
+const maxProfileBreakdowns = 10
+
+// ErrInvalidUserList is returned for user lists with an unknown sort or invalid property filters
+var ErrInvalidUserList = errors.New("invalid user list")
+
+type UserService struct {
+	db *database.DB
+}
+
+func NewUserService(db *database.DB) *UserService {
+	return &UserService{db: db}
+}
+
+// UserListRequest filters and pages the identified users of a project. Properties keep the
+// users with at least one event whose properties match all the filters.
+type UserListRequest struct {
+	Search         string
+	Country        string
+	LastSeenAfter  time.Time
+	LastSeenBefore time.Time
+	Properties     []InsightFilter
+	Sort           string
+	Limit          int
+	Offset         int
+}
+
+// UserSummary is an identified user with their activity in a project. Country and city are
+// those of the latest event that had them.
+type UserSummary struct {
+	ID        string    `json:"id"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Sessions  int64     `json:"sessions"`
+	Events    int64     `json:"events"`
+	Country   *string   `json:"country,omitempty"`
+	City      *string   `json:"city,omitempty"`
+}
+
+// UserDevice counts the sessions of a user on a device type
+type UserDevice struct {
+	DeviceType string    `json:"device_type"`
+	Sessions   int64     `json:"sessions"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// UserLocation counts the sessions of a user from a place
+type UserLocation struct {
+	Country  string    `json:"country"`
+	City     string    `json:"city"`
+	Sessions int64     `json:"sessions"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// UserGoal counts the completions of a goal by a user
+type UserGoal struct {
+	GoalID          string     `json:"goal_id"`
+	Name            string     `json:"name"`
+	Completions     int64      `json:"completions"`
+	LastCompletedAt *time.Time `json:"last_completed_at,omitempty"`
+}
+
+// UserRevenue totals the orders of a user in the project currency
+type UserRevenue struct {
+	Currency   string  `json:"currency"`
+	Orders     int64   `json:"orders"`
+	Revenue    float64 `json:"revenue"`
+	Refunds    float64 `json:"refunds"`
+	NetRevenue float64 `json:"net_revenue"`
+}
+
+// UserProfile aggregates the activity of a user in a project. Everything in it is computed
+// from the project's own events.
+type UserProfile struct {
+	UserSummary
+	PageViews int64          `json:"page_views"`
+	Devices   []UserDevice   `json:"devices"`
+	Locations []UserLocation `json:"locations"`
+	Goals     []UserGoal     `json:"goals"`
+	Revenue   UserRevenue    `json:"revenue"`
+}
+
+type userSummaryRow struct {
+	UserSummary
+	Total int64
+}
+
+// userProfileRow holds the activity totals of a profile. It is scanned apart from UserProfile,
+// whose nested devices and revenue GORM would take for relations.
+type userProfileRow struct {
+	UserSummary
+	PageViews int64
+}
+
+// userEventsCTE renders the events of one identified user, including the anonymous events of
+// the sessions in which they logged in. It selects into user_events, which the queries read
+// back under the events alias.
+func (q AnalyticsQuery) userEventsCTE(userID string) (string, []interface{}) {
+	where, whereArgs := q.conditions(true)
+	args := append([]interface{}{*q.ProjectID}, whereArgs...)
+	args = append(args, userID)
+	return fmt.Sprintf(`%s,
+		user_events AS (
+			SELECT events.*
+			FROM events
+			%s
+			WHERE %s AND %s = ?
+		)`, sessionPeopleCTE, sessionPeopleJoin, where, identifiedPersonColumn), args
+}
+
+// ListUsers returns a page of the identified users of a project and the number of matching users
+func (s *UserService) ListUsers(q AnalyticsQuery, req *UserListRequest) ([]UserSummary, int64, error) {
+	if q.ProjectID == nil {
+		return nil, 0, errors.New("users require a project")
+	}
+	if len(req.Properties) > maxInsightFilters {
+		return nil, 0, fmt.Errorf("%w: at most %d property filters are allowed", ErrInvalidUserList, maxInsightFilters)
+	}
+	properties, err := filtersCondition(req.Properties)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrInvalidUserList, err)
+	}
+
+	order := map[string]string{
+		"":                "last_seen DESC",
+		UserSortLastSeen:  "last_seen DESC",
+		UserSortFirstSeen: "first_seen DESC",
+		UserSortEvents:    "events DESC",
+		UserSortSessions:  "sessions DESC",
+	}[req.Sort]
+	if order == "" {
+		return nil, 0, fmt.Errorf("%w: unknown sort %q", ErrInvalidUserList, req.Sort)
+	}
+
+	where, whereArgs := q.conditions(true)
+	args := append([]interface{}{*q.ProjectID}, properties.args...)
+	args = append(args, whereArgs...)
+
+	clauses := []string{"matched"}
+	if req.Search != "" {
+		clauses = append(clauses, "id ILIKE ?")
+		args = append(args, "%"+escapeLike(req.Search)+"%")
+	}
+	if req.Country != "" {
+		clauses = append(clauses, "country = ?")
+		args = append(args, req.Country)
+	}
+	if !req.LastSeenAfter.IsZero() {
+		clauses = append(clauses, "last_seen >= ?")
+		args = append(args, req.LastSeenAfter)
+	}
+	if !req.LastSeenBefore.IsZero() {
+		clauses = append(clauses, "last_seen < ?")
+		args = append(args, req.LastSeenBefore)
+	}
+	args = append(args, req.Limit, req.Offset)
+
+	var rows []userSummaryRow
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		project_users AS (
+			SELECT
+				%s AS id,
+				MIN(events.created_at) AS first_seen,
+				MAX(events.created_at) AS last_seen,
+				COUNT(DISTINCT events.session_id) AS sessions,
+				COUNT(*) FILTER (WHERE %s) AS events,
+				(ARRAY_AGG(events.country ORDER BY events.created_at DESC) FILTER (WHERE events.country IS NOT NULL AND events.country != ''))[1] AS country,
+				(ARRAY_AGG(events.city ORDER BY events.created_at DESC) FILTER (WHERE events.city IS NOT NULL AND events.city != ''))[1] AS city,
+				BOOL_OR(%s) AS matched
+			FROM events
+			%s
+			WHERE %s AND %s IS NOT NULL
+			GROUP BY 1
+		)
+		SELECT *, COUNT(*) OVER () AS total
+		FROM project_users
+		WHERE %s
+		ORDER BY %s, id
+		LIMIT ? OFFSET ?
+	`, sessionPeopleCTE, identifiedPersonColumn, interactionCondition, properties.sql, sessionPeopleJoin, where, identifiedPersonColumn, strings.Join(clauses, " AND "), order), args...).Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := make([]UserSummary, 0, len(rows))
+	var total int64
+	for _, row := range rows {
+		users = append(users, row.UserSummary)
+		total = row.Total
+	}
+	if len(rows) == 0 && req.Offset > 0 {
+		// Past the last page the window function has no row to report the total on
+		total, err = s.countUsers(q, req)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return users, total, nil
+}
+
+// countUsers counts the users matching a list request without paging
+func (s *UserService) countUsers(q AnalyticsQuery, req *UserListRequest) (int64, error) {
+	unpaged := *req
+	unpaged.Offset = 0
+	unpaged.Limit = 1
+	_, total, err := s.ListUsers(q, &unpaged)
+	return total, err
+}
+
+// GetProfile aggregates the activity of an identified user in a project
+func (s *UserService) GetProfile(q AnalyticsQuery, currency, userID string) (*UserProfile, error) {
+	if q.ProjectID == nil {
+		return nil, errors.New("users require a project")
+	}
+	cte, args := q.userEventsCTE(userID)
+
+	var row userProfileRow
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT
+			MIN(events.created_at) AS first_seen,
+			MAX(events.created_at) AS last_seen,
+			COUNT(DISTINCT events.session_id) AS sessions,
+			COUNT(*) FILTER (WHERE %s) AS events,
+			COUNT(*) FILTER (WHERE events.event_type = '%s') AS page_views,
+			(ARRAY_AGG(events.country ORDER BY events.created_at DESC) FILTER (WHERE events.country IS NOT NULL AND events.country != ''))[1] AS country,
+			(ARRAY_AGG(events.city ORDER BY events.created_at DESC) FILTER (WHERE events.city IS NOT NULL AND events.city != ''))[1] AS city
+		FROM user_events AS events
+	`, cte, interactionCondition, EventTypePageView), args...).Scan(&row).Error
+	if err != nil {
+		return nil, err
+	}
+	if row.FirstSeen.IsZero() {
+		return nil, errors.New("user not found")
+	}
+	profile := &UserProfile{UserSummary: row.UserSummary, PageViews: row.PageViews}
+	profile.ID = userID
+
+	// Devices and locations come from the sessions the user's events belong to
+	profile.Devices = []UserDevice{}
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT COALESCE(NULLIF(sessions.device_type, ''), '%s') AS device_type, COUNT(*) AS sessions, MAX(sessions.start_time) AS last_seen
+		FROM sessions
+		WHERE sessions.id IN (SELECT DISTINCT session_id FROM user_events)
+		GROUP BY 1
+		ORDER BY sessions DESC, device_type
+		LIMIT %d
+	`, cte, BreakdownNone, maxProfileBreakdowns), args...).Scan(&profile.Devices).Error
+	if err != nil {
+		return nil, err
+	}
+
+	profile.Locations = []UserLocation{}
+	err = s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT
+			COALESCE(NULLIF(sessions.country, ''), '%s') AS country,
+			COALESCE(NULLIF(sessions.city, ''), '%s') AS city,
+			COUNT(*) AS sessions,
+			MAX(sessions.start_time) AS last_seen
+		FROM sessions
+		WHERE sessions.id IN (SELECT DISTINCT session_id FROM user_events)
+		GROUP BY 1, 2
+		ORDER BY sessions DESC, country, city
+		LIMIT %d
+	`, cte, BreakdownNone, BreakdownNone, maxProfileBreakdowns), args...).Scan(&profile.Locations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if profile.Goals, err = s.userGoals(q, cte, args); err != nil {
+		return nil, err
+	}
+	if profile.Revenue, err = s.userRevenue(cte, args, currency); err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// userGoals counts the completions of each active goal of the project by the user, all goals
+// in one pass over the user's events
+func (s *UserService) userGoals(q AnalyticsQuery, cte string, cteArgs []interface{}) ([]UserGoal, error) {
+	var goals []models.Goal
+	if err := s.db.Where("project_id = ? AND is_active = ?", *q.ProjectID, true).Order("created_at ASC").Find(&goals).Error; err != nil {
+		return nil, err
+	}
+	results := make([]UserGoal, len(goals))
+	if len(goals) == 0 {
+		return results, nil
+	}
+
+	columns := make([]string, 0, 2*len(goals))
+	args := append([]interface{}{}, cteArgs...)
+	for i := range goals {
+		condition, err := goalCondition(&goals[i])
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns,
+			fmt.Sprintf("COUNT(*) FILTER (WHERE %s)", condition.sql),
+			fmt.Sprintf("MAX(events.created_at) FILTER (WHERE %s)", condition.sql))
+		args = append(args, condition.args...)
+		args = append(args, condition.args...)
+	}
+
+	row := s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT %s
+		FROM user_events AS events
+	`, cte, strings.Join(columns, ", ")), args...).Row()
+	dest := make([]interface{}, 0, 2*len(goals))
+	for i := range goals {
+		results[i] = UserGoal{GoalID: goals[i].ID.String(), Name: goals[i].Name}
+		dest = append(dest, &results[i].Completions, &results[i].LastCompletedAt)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	// Most completed goals first
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Completions > results[j].Completions
+	})
+	return results, nil
+}
+
+// userRevenue totals the user's orders and refunds, counting repeated purchase events of an order once
+func (s *UserService) userRevenue(cte string, args []interface{}, currency string) (UserRevenue, error) {
+	revenue := UserRevenue{Currency: currency}
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH %s,
+		orders AS (
+			SELECT DISTINCT ON (events.order_id) events.base_revenue
+			FROM user_events AS events
+			WHERE events.event_type = '%s' AND events.order_id IS NOT NULL AND events.base_revenue IS NOT NULL
+			ORDER BY events.order_id, events.created_at
+		)
+		SELECT
+			(SELECT COUNT(*) FROM orders) AS orders,
+			(SELECT COALESCE(SUM(base_revenue), 0) FROM orders) AS revenue,
+			(SELECT COALESCE(SUM(events.base_revenue), 0) FROM user_events AS events
+				WHERE events.event_type = '%s' AND events.order_id IS NOT NULL) AS refunds
+	`, cte, EventTypePurchase, EventTypeRefund), args...).Scan(&revenue).Error
+	if err != nil {
+		return revenue, err
+	}
+	revenue.NetRevenue = revenue.Revenue - revenue.Refunds
+	return revenue, nil
+}
+
+// GetTimeline returns a page of the user's events across sessions, newest first, and their number
+func (s *UserService) GetTimeline(q AnalyticsQuery, userID string, limit, offset int) ([]models.Event, int64, error) {
+	if q.ProjectID == nil {
+		return nil, 0, errors.New("users require a project")
+	}
+	cte, args := q.userEventsCTE(userID)
+
+	var total int64
+	if err := s.db.Raw(fmt.Sprintf("WITH %s SELECT COUNT(*) FROM user_events", cte), args...).Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var events []models.Event
+	err := s.db.Raw(fmt.Sprintf(`
+		WITH %s
+		SELECT * FROM user_events
+		ORDER BY created_at DESC, id
+		LIMIT ? OFFSET ?
+	`, cte), append(args, limit, offset)...).Scan(&events).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
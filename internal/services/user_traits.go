@@ -0,0 +1,275 @@
+package services
+
+import (
+	"analytic-app/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sources of trait changes
+const (
+	TraitSourceIdentify = "identify"
+	TraitSourceEvent    = "event"
+)
+
+// Event properties holding trait operations, removed from the stored properties
+const (
+	traitSetProperty     = "$set"
+	traitSetOnceProperty = "$set_once"
+)
+
+const (
+	maxTraitsPerCall   = 100
+	maxTraitHistory    = 100
+	traitFieldPrefix   = "user."
+	maxTraitValueBytes = 4096
+)
+
+// ErrInvalidTraits is returned for trait operations that cannot be stored
+var ErrInvalidTraits = errors.New("invalid traits")
+
+// TraitOperations change the traits of a user. $set overwrites traits and removes those set
+// to null; $set_once only sets traits the user does not have yet.
+type TraitOperations struct {
+	Set     map[string]interface{} `json:"$set,omitempty"`
+	SetOnce map[string]interface{} `json:"$set_once,omitempty"`
+}
+
+// IdentifyRequest ties a user to their traits and, given a session, to the anonymous events
+// of that session
+type IdentifyRequest struct {
+	UserID    string `json:"user_id" binding:"required"`
+	SessionID string `json:"session_id,omitempty"`
+	TraitOperations
+}
+
+// UserTraits holds the current traits of a user and their latest changes in a project
+type UserTraits struct {
+	UserID  string                   `json:"user_id"`
+	Traits  map[string]interface{}   `json:"traits"`
+	History []models.UserTraitChange `json:"history"`
+}
+
+// Empty reports whether there is nothing to apply
+func (o TraitOperations) Empty() bool {
+	return len(o.Set) == 0 && len(o.SetOnce) == 0
+}
+
+// Validate checks the trait names and sizes
+func (o TraitOperations) Validate() error {
+	if len(o.Set)+len(o.SetOnce) > maxTraitsPerCall {
+		return fmt.Errorf("%w: at most %d traits per call", ErrInvalidTraits, maxTraitsPerCall)
+	}
+	for _, traits := range []map[string]interface{}{o.Set, o.SetOnce} {
+		for key, value := range traits {
+			if !propertyKeyPattern.MatchString(key) {
+				return fmt.Errorf("%w: invalid trait name %q", ErrInvalidTraits, key)
+			}
+			data, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("%w: trait %q: %v", ErrInvalidTraits, key, err)
+			}
+			if len(data) > maxTraitValueBytes {
+				return fmt.Errorf("%w: trait %q is larger than %d bytes", ErrInvalidTraits, key, maxTraitValueBytes)
+			}
+		}
+	}
+	return nil
+}
+
+// extractTraitOperations removes the $set and $set_once objects from event properties
+func extractTraitOperations(properties map[string]interface{}) TraitOperations {
+	var ops TraitOperations
+	if properties == nil {
+		return ops
+	}
+	if set, ok := properties[traitSetProperty].(map[string]interface{}); ok {
+		ops.Set = set
+	}
+	if setOnce, ok := properties[traitSetOnceProperty].(map[string]interface{}); ok {
+		ops.SetOnce = setOnce
+	}
+	delete(properties, traitSetProperty)
+	delete(properties, traitSetOnceProperty)
+	return ops
+}
+
+// ApplyTraits applies trait operations to the traits of a user in a project and records every
+// change. The traits row is locked so concurrent calls do not lose updates.
+func (s *UserService) ApplyTraits(projectID uuid.UUID, userID string, ops TraitOperations, source string) (*models.UserTraits, error) {
+	var traits *models.UserTraits
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		traits, err = applyTraits(tx, projectID, userID, ops, source)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return traits, nil
+}
+
+// applyTraits applies trait operations inside a transaction, so events can update traits in
+// the transaction that stores them and their operations apply in the order events arrive
+func applyTraits(tx *gorm.DB, projectID uuid.UUID, userID string, ops TraitOperations, source string) (*models.UserTraits, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidTraits)
+	}
+	if err := ops.Validate(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	placeholder := models.UserTraits{ProjectID: projectID, UserID: userID, Traits: "{}", CreatedAt: now, UpdatedAt: now}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&placeholder).Error; err != nil {
+		return nil, err
+	}
+	var row models.UserTraits
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("project_id = ? AND user_id = ?", projectID, userID).First(&row).Error; err != nil {
+		return nil, err
+	}
+
+	traits := decodeProperties(row.Traits)
+	var changes []models.UserTraitChange
+	change := func(key string, value interface{}) error {
+		old, had := traits[key]
+		if had && jsonEqual(old, value) {
+			return nil
+		}
+		if !had && value == nil {
+			return nil
+		}
+		record := models.UserTraitChange{
+			UserID:    userID,
+			ProjectID: projectID,
+			Trait:     key,
+			Source:    source,
+			ChangedAt: now,
+		}
+		if had {
+			oldJSON, err := json.Marshal(old)
+			if err != nil {
+				return err
+			}
+			record.OldValue = optionalString(string(oldJSON))
+		}
+		if value == nil {
+			delete(traits, key)
+		} else {
+			newJSON, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			record.NewValue = optionalString(string(newJSON))
+			traits[key] = value
+		}
+		changes = append(changes, record)
+		return nil
+	}
+
+	for _, key := range sortedKeys(ops.SetOnce) {
+		if _, had := traits[key]; had {
+			continue
+		}
+		if err := change(key, ops.SetOnce[key]); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range sortedKeys(ops.Set) {
+		if err := change(key, ops.Set[key]); err != nil {
+			return nil, err
+		}
+	}
+	if len(changes) == 0 {
+		return &row, nil
+	}
+
+	data, err := json.Marshal(traits)
+	if err != nil {
+		return nil, err
+	}
+	row.Traits = string(data)
+	row.UpdatedAt = now
+	err = tx.Model(&models.UserTraits{}).
+		Where("project_id = ? AND user_id = ?", projectID, userID).
+		Updates(map[string]interface{}{"traits": row.Traits, "updated_at": now}).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&changes).Error; err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// GetTraits returns the traits of a user and their latest changes in a project
+func (s *UserService) GetTraits(projectID uuid.UUID, userID string) (*UserTraits, error) {
+	result := &UserTraits{UserID: userID, Traits: map[string]interface{}{}}
+
+	var row models.UserTraits
+	err := s.db.Where("project_id = ? AND user_id = ?", projectID, userID).First(&row).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	found := err == nil
+	if found {
+		result.Traits = decodeProperties(row.Traits)
+	}
+
+	err = s.db.Where("user_id = ? AND project_id = ?", userID, projectID).
+		Order("changed_at DESC").
+		Limit(maxTraitHistory).
+		Find(&result.History).Error
+	if err != nil {
+		return nil, err
+	}
+	if !found && len(result.History) == 0 {
+		return nil, errors.New("user not found")
+	}
+	if result.History == nil {
+		result.History = []models.UserTraitChange{}
+	}
+	return result, nil
+}
+
+// traitText renders a trait of the user behind an event as text, like a property. Traits are
+// those of the event's project and only the event's own user_id is used, so anonymous events
+// have none.
+func traitText(field string) (sqlFragment, error) {
+	key := strings.TrimPrefix(field, traitFieldPrefix)
+	if !propertyKeyPattern.MatchString(key) {
+		return sqlFragment{}, fmt.Errorf("invalid trait name %q", key)
+	}
+	return sqlFragment{
+		sql:  "(SELECT user_traits.traits ->> CAST(? AS text) FROM user_traits WHERE user_traits.project_id = events.project_id AND user_traits.user_id = events.user_id)",
+		args: []interface{}{key},
+	}, nil
+}
+
+func isTraitField(field string) bool {
+	return strings.HasPrefix(field, traitFieldPrefix)
+}
+
+// jsonEqual compares two decoded JSON values
+func jsonEqual(a, b interface{}) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJSON) == string(bJSON)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
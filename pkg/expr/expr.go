@@ -0,0 +1,775 @@
+// Package expr implements a small, side-effect free expression language used
+// by ingestion rules. Expressions operate on a map environment and support
+// literals, dotted field access, comparison and boolean operators, basic
+// arithmetic and a fixed set of helper functions.
+//
+//	event_type == "pageview" && lower(properties.plan) in ["pro", "team"]
+//	concat("v2_", event_name)
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxLength is the longest expression source accepted by Compile
+	MaxLength = 2000
+	// maxDepth bounds nesting so evaluation cannot exhaust the stack
+	maxDepth = 64
+)
+
+// Expr is a compiled expression that can be evaluated many times
+type Expr struct {
+	source string
+	root   node
+}
+
+// Compile parses an expression source string
+func Compile(source string) (*Expr, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, errors.New("expression is empty")
+	}
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("expression exceeds %d characters", MaxLength)
+	}
+
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+
+	return &Expr{source: source, root: root}, nil
+}
+
+// String returns the original source of the expression
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against the given environment
+func (e *Expr) Eval(env map[string]interface{}) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+// EvalBool evaluates the expression and converts the result to a boolean
+func (e *Expr) EvalBool(env map[string]interface{}) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+	return Truthy(v), nil
+}
+
+// Truthy reports whether a value counts as true in a condition
+func Truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case float64:
+		return val != 0
+	case string:
+		return val != ""
+	case []interface{}:
+		return len(val) > 0
+	case map[string]interface{}:
+		return len(val) > 0
+	}
+	return true
+}
+
+// Lookup resolves a dotted path such as "properties.plan" in the environment
+func Lookup(env map[string]interface{}, path string) interface{} {
+	var current interface{} = env
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return normalize(current)
+}
+
+// ---- tokenizer ----
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at position %d", src[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], num: n, pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			quote := c
+			i++
+			var sb strings.Builder
+			for i < len(src) && src[i] != quote {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+					switch src[i] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(src[i])
+					}
+				} else {
+					sb.WriteByte(src[i])
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: start})
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			start := i
+			if i+1 < len(src) {
+				two := src[i : i+2]
+				switch two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, token{kind: tokOp, text: two, pos: start})
+					i += 2
+					continue
+				}
+			}
+			if strings.IndexByte("+-*/%<>!()[],", c) < 0 {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, start)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: string(c), pos: start})
+			i++
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(src)})
+	return tokens, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// ---- parser ----
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expectOp(op string) error {
+	t := p.next()
+	if t.kind != tokOp || t.text != op {
+		return fmt.Errorf("expected %q at position %d", op, t.pos)
+	}
+	return nil
+}
+
+// binary operator precedence, higher binds tighter
+func precedence(t token) int {
+	if t.kind == tokIdent {
+		switch t.text {
+		case "or":
+			return 1
+		case "and":
+			return 2
+		case "in":
+			return 3
+		}
+		return 0
+	}
+	if t.kind != tokOp {
+		return 0
+	}
+	switch t.text {
+	case "||":
+		return 1
+	case "&&":
+		return 2
+	case "==", "!=", "<", "<=", ">", ">=":
+		return 3
+	case "+", "-":
+		return 4
+	case "*", "/", "%":
+		return 5
+	}
+	return 0
+}
+
+func (p *parser) parseExpr(minPrec int) (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, errors.New("expression is nested too deeply")
+	}
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		prec := precedence(t)
+		if prec == 0 || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, err
+		}
+		op := t.text
+		switch op {
+		case "and":
+			op = "&&"
+		case "or":
+			op = "||"
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	if (t.kind == tokOp && (t.text == "!" || t.text == "-")) || (t.kind == tokIdent && t.text == "not") {
+		p.next()
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxDepth {
+			return nil, errors.New("expression is nested too deeply")
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		op := t.text
+		if op == "not" {
+			op = "!"
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &literalNode{value: t.num}, nil
+	case tokString:
+		return &literalNode{value: t.text}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null", "nil":
+			return &literalNode{value: nil}, nil
+		}
+		if next := p.peek(); next.kind == tokOp && next.text == "(" {
+			return p.parseCall(t)
+		}
+		return &fieldNode{path: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			inner, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items: items}, nil
+		}
+	case tokEOF:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", t.text, t.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	p.next() // consume "("
+	args, err := p.parseList(")")
+	if err != nil {
+		return nil, err
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments for %s()", name.text)
+	}
+	return &callNode{name: name.text, fn: fn.call, args: args}, nil
+}
+
+func (p *parser) parseList(closing string) ([]node, error) {
+	var items []node
+	if t := p.peek(); t.kind == tokOp && t.text == closing {
+		p.next()
+		return items, nil
+	}
+	for {
+		item, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		t := p.next()
+		if t.kind == tokOp && t.text == closing {
+			return items, nil
+		}
+		if t.kind != tokOp || t.text != "," {
+			return nil, fmt.Errorf("expected \",\" or %q at position %d", closing, t.pos)
+		}
+	}
+}
+
+// ---- evaluation ----
+
+type node interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type fieldNode struct {
+	path string
+}
+
+func (n *fieldNode) eval(env map[string]interface{}) (interface{}, error) {
+	return Lookup(env, n.path), nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(env map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !Truthy(v), nil
+	}
+	f, ok := toNumber(v)
+	if !ok {
+		return nil, fmt.Errorf("cannot negate %v", v)
+	}
+	return -f, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short-circuit boolean operators
+	switch n.op {
+	case "&&":
+		if !Truthy(left) {
+			return false, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return Truthy(right), nil
+	case "||":
+		if Truthy(left) {
+			return true, nil
+		}
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return Truthy(right), nil
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left), nil
+	case "<", "<=", ">", ">=":
+		cmp, ok := compare(left, right)
+		if !ok {
+			return false, nil
+		}
+		switch n.op {
+		case "<":
+			return cmp < 0, nil
+		case "<=":
+			return cmp <= 0, nil
+		case ">":
+			return cmp > 0, nil
+		default:
+			return cmp >= 0, nil
+		}
+	case "+":
+		if ls, ok := left.(string); ok {
+			return ls + ToString(right), nil
+		}
+		if rs, ok := right.(string); ok {
+			return ToString(left) + rs, nil
+		}
+	}
+
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	if !lok || !rok {
+		return nil, fmt.Errorf("operator %s requires numbers", n.op)
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return math.Mod(l, r), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type callNode struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (n *callNode) eval(env map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, 0, len(n.args))
+	for _, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+	v, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", n.name, err)
+	}
+	return v, nil
+}
+
+// ---- functions ----
+
+type function struct {
+	minArgs, maxArgs int
+	call             func(args []interface{}) (interface{}, error)
+}
+
+var functions = map[string]function{
+	"lower": {1, 1, func(a []interface{}) (interface{}, error) {
+		return strings.ToLower(ToString(a[0])), nil
+	}},
+	"upper": {1, 1, func(a []interface{}) (interface{}, error) {
+		return strings.ToUpper(ToString(a[0])), nil
+	}},
+	"trim": {1, 1, func(a []interface{}) (interface{}, error) {
+		return strings.TrimSpace(ToString(a[0])), nil
+	}},
+	"len": {1, 1, func(a []interface{}) (interface{}, error) {
+		switch v := a[0].(type) {
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return float64(len(ToString(a[0]))), nil
+	}},
+	"contains": {2, 2, func(a []interface{}) (interface{}, error) {
+		if _, ok := a[0].([]interface{}); ok {
+			return contains(a[0], a[1]), nil
+		}
+		return strings.Contains(ToString(a[0]), ToString(a[1])), nil
+	}},
+	"starts_with": {2, 2, func(a []interface{}) (interface{}, error) {
+		return strings.HasPrefix(ToString(a[0]), ToString(a[1])), nil
+	}},
+	"ends_with": {2, 2, func(a []interface{}) (interface{}, error) {
+		return strings.HasSuffix(ToString(a[0]), ToString(a[1])), nil
+	}},
+	"replace": {3, 3, func(a []interface{}) (interface{}, error) {
+		return strings.ReplaceAll(ToString(a[0]), ToString(a[1]), ToString(a[2])), nil
+	}},
+	"matches": {2, 2, func(a []interface{}) (interface{}, error) {
+		pattern := ToString(a[1])
+		if len(pattern) > 256 {
+			return nil, errors.New("pattern too long")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(ToString(a[0])), nil
+	}},
+	"concat": {1, -1, func(a []interface{}) (interface{}, error) {
+		var sb strings.Builder
+		for _, v := range a {
+			sb.WriteString(ToString(v))
+		}
+		return sb.String(), nil
+	}},
+	"coalesce": {1, -1, func(a []interface{}) (interface{}, error) {
+		for _, v := range a {
+			if v != nil && v != "" {
+				return v, nil
+			}
+		}
+		return nil, nil
+	}},
+	"number": {1, 1, func(a []interface{}) (interface{}, error) {
+		if f, ok := toNumber(a[0]); ok {
+			return f, nil
+		}
+		return nil, nil
+	}},
+	"string": {1, 1, func(a []interface{}) (interface{}, error) {
+		return ToString(a[0]), nil
+	}},
+	"round": {1, 1, func(a []interface{}) (interface{}, error) {
+		f, ok := toNumber(a[0])
+		if !ok {
+			return nil, nil
+		}
+		return math.Round(f), nil
+	}},
+}
+
+// ---- value helpers ----
+
+// normalize converts numeric types coming from decoded JSON or Go code to float64
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case int32:
+		return float64(val)
+	case float32:
+		return float64(val)
+	case []string:
+		items := make([]interface{}, len(val))
+		for i, s := range val {
+			items[i] = s
+		}
+		return items
+	}
+	return v
+}
+
+// ToString renders a value the way expressions concatenate it
+func ToString(v interface{}) string {
+	switch val := normalize(v).(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch val := normalize(v).(type) {
+	case float64:
+		return val, true
+	case bool:
+		if val {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func equal(a, b interface{}) bool {
+	a, b = normalize(a), normalize(b)
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if af, ok := a.(float64); ok {
+		if bf, ok := toNumber(b); ok {
+			return af == bf
+		}
+	}
+	if bf, ok := b.(float64); ok {
+		if af, ok := toNumber(a); ok {
+			return af == bf
+		}
+	}
+	switch av := a.(type) {
+	case string, bool:
+		return av == b
+	}
+	return ToString(a) == ToString(b)
+}
+
+func compare(a, b interface{}) (int, bool) {
+	a, b = normalize(a), normalize(b)
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return strings.Compare(as, bs), true
+		}
+	}
+	af, aok := toNumber(a)
+	bf, bok := toNumber(b)
+	if !aok || !bok {
+		return 0, false
+	}
+	switch {
+	case af < bf:
+		return -1, true
+	case af > bf:
+		return 1, true
+	}
+	return 0, true
+}
+
+func contains(collection, item interface{}) bool {
+	switch c := normalize(collection).(type) {
+	case []interface{}:
+		for _, v := range c {
+			if equal(v, item) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		_, ok := c[ToString(item)]
+		return ok
+	case string:
+		return strings.Contains(c, ToString(item))
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+var testEnv = map[string]interface{}{
+	"event_type": "page_view",
+	"event_name": "Signup",
+	"properties": map[string]interface{}{
+		"plan":  "Pro",
+		"price": 19.5,
+		"count": 3,
+		"tags":  []interface{}{"a", "b"},
+		"empty": "",
+	},
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+	}{
+		// literals
+		{`42`, 42.0},
+		{`1.5`, 1.5},
+		{`"double"`, "double"},
+		{`'single'`, "single"},
+		{`"esc\"aped\n"`, "esc\"aped\n"},
+		{`true`, true},
+		{`null`, nil},
+
+		// precedence and associativity
+		{`1 + 2 * 3`, 7.0},
+		{`(1 + 2) * 3`, 9.0},
+		{`10 - 4 - 3`, 3.0},
+		{`12 / 3 / 2`, 2.0},
+		{`10 % 4`, 2.0},
+		{`-2 * 3`, -6.0},
+		{`1 + 2 == 3`, true},
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`1 < 2 and 3 > 2`, true},
+		{`false or not false`, true},
+		{`!true == false`, true},
+		{`"b" in ["a", "b"] && 1 == 1`, true},
+
+		// comparison and equality
+		{`"10" == 10`, true},
+		{`"a" < "b"`, true},
+		{`2 >= 2`, true},
+		{`"a" < 1`, false},
+		{`null == null`, true},
+		{`null != 0`, true},
+
+		// string concatenation
+		{`"v" + 2`, "v2"},
+		{`1 + "x"`, "1x"},
+
+		// field access
+		{`event_type`, "page_view"},
+		{`properties.plan`, "Pro"},
+		{`properties.count + 1`, 4.0},
+		{`properties.missing`, nil},
+		{`event_type.nested`, nil},
+		{`"a" in properties.tags`, true},
+		{`"plan" in properties`, true},
+		{`"view" in event_type`, true},
+
+		// functions
+		{`lower(properties.plan)`, "pro"},
+		{`upper("pro")`, "PRO"},
+		{`trim("  x ")`, "x"},
+		{`len(properties.tags)`, 2.0},
+		{`len(event_name)`, 6.0},
+		{`len(null)`, 0.0},
+		{`contains(properties.tags, "b")`, true},
+		{`contains(event_name, "sign")`, false},
+		{`starts_with(event_type, "page")`, true},
+		{`ends_with(event_type, "view")`, true},
+		{`replace(event_type, "_", "-")`, "page-view"},
+		{`concat("v2_", event_name, 1)`, "v2_Signup1"},
+		{`coalesce(properties.empty, properties.missing, "fallback")`, "fallback"},
+		{`coalesce(properties.missing)`, nil},
+		{`number("3.5") + 1`, 4.5},
+		{`number("x")`, nil},
+		{`string(1.25)`, "1.25"},
+		{`round(properties.price)`, 20.0},
+
+		// short-circuit skips errors on the other side
+		{`false && 1 / 0`, false},
+		{`true || 1 / 0`, true},
+	}
+
+	for _, tt := range tests {
+		e, err := Compile(tt.source)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.source, err)
+			continue
+		}
+		got, err := e.Eval(testEnv)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.source, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%q) = %#v, want %#v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{``, "expression is empty"},
+		{`   `, "expression is empty"},
+		{`1 +`, "unexpected end of expression"},
+		{`(1 + 2`, `expected ")"`},
+		{`1 2`, `unexpected "2"`},
+		{`[1, 2`, `expected "," or "]"`},
+		{`"open`, "unterminated string"},
+		{`1 # 2`, "unexpected character"},
+		{`1..2`, "invalid number"},
+		{`unknown(1)`, `unknown function "unknown"`},
+		{`lower()`, "wrong number of arguments for lower()"},
+		{`replace("a", "b")`, "wrong number of arguments for replace()"},
+		{`)`, `unexpected ")"`},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.source)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Compile(%q) error = %v, want %q", tt.source, err, tt.err)
+		}
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat("(", depth) + "1" + strings.Repeat(")", depth)
+	}
+
+	if _, err := Compile(nested(maxDepth - 1)); err != nil {
+		t.Errorf("nesting below the limit: %v", err)
+	}
+	if _, err := Compile(nested(maxDepth + 1)); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("nesting past the limit: error = %v", err)
+	}
+	if _, err := Compile(strings.Repeat("!", maxDepth+1) + "true"); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+		t.Errorf("unary nesting past the limit: error = %v", err)
+	}
+	if _, err := Compile("[" + nested(maxDepth) + "]"); err == nil {
+		t.Error("list nesting past the limit: want an error")
+	}
+
+	// Flat chains are left-associative and do not count as nesting
+	long := "1" + strings.Repeat(" + 1", (MaxLength-1)/4)
+	e, err := Compile(long)
+	if err != nil {
+		t.Fatalf("expression at the length limit: %v", err)
+	}
+	if got, _ := e.Eval(nil); got != float64(1+(MaxLength-1)/4) {
+		t.Errorf("long sum = %v", got)
+	}
+	if _, err := Compile(long + " + 1"); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("expression past the length limit: error = %v", err)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		source string
+		want   interface{}
+		err    string
+	}{
+		{source: `matches("abc123", "^[a-z]+[0-9]+$")`, want: true},
+		{source: `matches(event_type, "^click")`, want: false},
+		{source: `matches(properties.plan, "(?i)^pro$")`, want: true},
+		{source: `matches("x", "(")`, err: "matches(): error parsing regexp"},
+		{source: `matches("x", "` + strings.Repeat("a", 257) + `")`, err: "matches(): pattern too long"},
+	}
+
+	for _, tt := range tests {
+		e, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.source, err)
+		}
+		got, err := e.Eval(testEnv)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Eval(%q) error = %v, want %q", tt.source, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Eval(%q) = %v, %v, want %v", tt.source, got, err, tt.want)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		err    string
+	}{
+		{`"a" - 1`, "operator - requires numbers"},
+		{`event_type * 2`, "operator * requires numbers"},
+		{`properties.tags / 2`, "operator / requires numbers"},
+		{`-"a"`, "cannot negate"},
+		{`1 / 0`, "division by zero"},
+		{`5 % 0`, "division by zero"},
+		{`lower(1 / 0)`, "division by zero"},
+		{`[1, 1 / 0]`, "division by zero"},
+	}
+
+	for _, tt := range tests {
+		e, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.source, err)
+		}
+		if _, err := e.Eval(testEnv); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Eval(%q) error = %v, want %q", tt.source, err, tt.err)
+		}
+	}
+}
+
+func TestEvalBool(t *testing.T) {
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{`properties.plan`, true},
+		{`properties.empty`, false},
+		{`properties.missing`, false},
+		{`0`, false},
+		{`properties.tags`, true},
+		{`[]`, false},
+	}
+
+	for _, tt := range tests {
+		e, err := Compile(tt.source)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", tt.source, err)
+		}
+		if got, err := e.EvalBool(testEnv); err != nil || got != tt.want {
+			t.Errorf("EvalBool(%q) = %v, %v, want %v", tt.source, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package stats implements the statistics behind experiment analysis: tests and
+// intervals for conversion rates, a Bayesian comparison of two rates, a chi-square
+// test for sample ratio mismatch and an alpha-spending bound for interim looks.
+package stats
+
+import (
+	"math"
+)
+
+// maxExactBayesTerms bounds the series of the exact Bayesian comparison; larger
+// samples use the normal approximation, which is then accurate
+const maxExactBayesTerms = 20000
+
+// Interval is a two-sided interval estimate
+type Interval struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// NormalCDF is the cumulative distribution function of the standard normal distribution
+func NormalCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+// NormalQuantile is the inverse of NormalCDF
+func NormalQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// criticalValue is the two-sided z value of a confidence level such as 0.95
+func criticalValue(confidence float64) float64 {
+	return NormalQuantile(1 - (1-confidence)/2)
+}
+
+// WilsonInterval is the Wilson score interval of a proportion
+func WilsonInterval(successes, trials int64, confidence float64) Interval {
+	if trials <= 0 {
+		return Interval{}
+	}
+	z := criticalValue(confidence)
+	n := float64(trials)
+	p := float64(successes) / n
+	denominator := 1 + z*z/n
+	center := (p + z*z/(2*n)) / denominator
+	margin := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n)) / denominator
+	return Interval{Low: math.Max(0, center-margin), High: math.Min(1, center+margin)}
+}
+
+// TwoProportionZTest compares two proportions with a pooled two-sided z-test and
+// returns the z statistic and p-value
+func TwoProportionZTest(successesA, trialsA, successesB, trialsB int64) (z, pValue float64) {
+	if trialsA <= 0 || trialsB <= 0 {
+		return 0, 1
+	}
+	nA, nB := float64(trialsA), float64(trialsB)
+	pA, pB := float64(successesA)/nA, float64(successesB)/nB
+	pooled := float64(successesA+successesB) / (nA + nB)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/nA + 1/nB))
+	if se == 0 {
+		return 0, 1
+	}
+	z = (pB - pA) / se
+	return z, 2 * (1 - NormalCDF(math.Abs(z)))
+}
+
+// DifferenceInterval is the interval of the difference pB - pA between two proportions
+func DifferenceInterval(successesA, trialsA, successesB, trialsB int64, confidence float64) Interval {
+	if trialsA <= 0 || trialsB <= 0 {
+		return Interval{}
+	}
+	nA, nB := float64(trialsA), float64(trialsB)
+	pA, pB := float64(successesA)/nA, float64(successesB)/nB
+	margin := criticalValue(confidence) * math.Sqrt(pA*(1-pA)/nA+pB*(1-pB)/nB)
+	diff := pB - pA
+	return Interval{Low: diff - margin, High: diff + margin}
+}
+
+// LiftInterval is the interval of the relative lift pB / pA - 1, using the delta method for
+// the variance of the ratio. It is empty when A has no successes.
+func LiftInterval(successesA, trialsA, successesB, trialsB int64, confidence float64) Interval {
+	if trialsA <= 0 || trialsB <= 0 || successesA <= 0 {
+		return Interval{}
+	}
+	nA, nB := float64(trialsA), float64(trialsB)
+	pA, pB := float64(successesA)/nA, float64(successesB)/nB
+	ratio := pB / pA
+	// Var(pB/pA) ~ Var(pB)/pA^2 + pB^2 Var(pA)/pA^4
+	se := math.Sqrt(pB*(1-pB)/nB/(pA*pA) + ratio*ratio*(1-pA)/(nA*pA))
+	margin := criticalValue(confidence) * se
+	return Interval{Low: ratio - 1 - margin, High: ratio - 1 + margin}
+}
+
+// ProbabilityBBeatsA is the posterior probability that the rate of B exceeds the rate of A
+// under uniform Beta(1, 1) priors
+func ProbabilityBBeatsA(successesA, trialsA, successesB, trialsB int64) float64 {
+	alphaA, betaA := float64(successesA+1), float64(trialsA-successesA+1)
+	alphaB, betaB := float64(successesB+1), float64(trialsB-successesB+1)
+
+	if successesB+1 > maxExactBayesTerms {
+		// Normal approximation of both posteriors
+		meanA, varA := betaMoments(alphaA, betaA)
+		meanB, varB := betaMoments(alphaB, betaB)
+		if varA+varB == 0 {
+			return 0.5
+		}
+		return NormalCDF((meanB - meanA) / math.Sqrt(varA+varB))
+	}
+
+	// Exact closed form, summing over the successes of B
+	total := 0.0
+	for i := 0.0; i < alphaB; i++ {
+		total += math.Exp(logBeta(alphaA+i, betaA+betaB) - math.Log(betaB+i) - logBeta(1+i, betaB) - logBeta(alphaA, betaA))
+	}
+	return math.Max(0, math.Min(1, total))
+}
+
+// CredibleInterval is the equal-tailed credible interval of a rate under a uniform prior,
+// using the normal approximation of the Beta posterior
+func CredibleInterval(successes, trials int64, confidence float64) Interval {
+	alpha, beta := float64(successes+1), float64(trials-successes+1)
+	mean, variance := betaMoments(alpha, beta)
+	margin := criticalValue(confidence) * math.Sqrt(variance)
+	return Interval{Low: math.Max(0, mean-margin), High: math.Min(1, mean+margin)}
+}
+
+// ChiSquareGoodnessOfFit tests observed counts against expected shares (which need not sum
+// to 1) and returns the statistic and p-value
+func ChiSquareGoodnessOfFit(observed []int64, shares []float64) (chiSquare, pValue float64) {
+	if len(observed) < 2 || len(observed) != len(shares) {
+		return 0, 1
+	}
+	var total, shareTotal float64
+	for i := range observed {
+		total += float64(observed[i])
+		shareTotal += shares[i]
+	}
+	if total == 0 || shareTotal == 0 {
+		return 0, 1
+	}
+	for i := range observed {
+		expected := total * shares[i] / shareTotal
+		if expected == 0 {
+			continue
+		}
+		diff := float64(observed[i]) - expected
+		chiSquare += diff * diff / expected
+	}
+	return chiSquare, ChiSquareSurvival(chiSquare, float64(len(observed)-1))
+}
+
+// ChiSquareSurvival is P(X > x) for a chi-square distribution with k degrees of freedom
+func ChiSquareSurvival(x, k float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+	return upperIncompleteGamma(k/2, x/2)
+}
+
+// OBrienFlemingAlpha is the significance threshold of an interim look under the
+// Lan-DeMets O'Brien-Fleming spending function, given the fraction of the planned
+// sample collected so far. At the full sample it equals alpha.
+func OBrienFlemingAlpha(alpha, informationFraction float64) float64 {
+	if informationFraction <= 0 {
+		return 0
+	}
+	if informationFraction >= 1 {
+		return alpha
+	}
+	z := NormalQuantile(1 - alpha/2)
+	return 2 * (1 - NormalCDF(z/math.Sqrt(informationFraction)))
+}
+
+func betaMoments(alpha, beta float64) (mean, variance float64) {
+	sum := alpha + beta
+	return alpha / sum, alpha * beta / (sum * sum * (sum + 1))
+}
+
+func logBeta(a, b float64) float64 {
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	return la + lb - lab
+}
+
+// upperIncompleteGamma is the regularized upper incomplete gamma function Q(a, x)
+func upperIncompleteGamma(a, x float64) float64 {
+	const (
+		maxIterations = 500
+		epsilon       = 1e-14
+		tiny          = 1e-300
+	)
+	lga, _ := math.Lgamma(a)
+
+	if x < a+1 {
+		// Series for the lower function P(a, x)
+		sum, term := 1/a, 1/a
+		for n := 1.0; n < maxIterations; n++ {
+			term *= x / (a + n)
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*epsilon {
+				break
+			}
+		}
+		return 1 - sum*math.Exp(-x+a*math.Log(x)-lga)
+	}
+
+	// Continued fraction for Q(a, x), Lentz's method
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1.0; i < maxIterations; i++ {
+		an := -i * (i - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lga) * h
+}
@@ -0,0 +1,217 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func near(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+func TestNormal(t *testing.T) {
+	tests := []struct {
+		x, cdf float64
+	}{
+		{0, 0.5},
+		{1, 0.8413447460685429},
+		{1.959963984540054, 0.975},
+		{-2.5758293035489004, 0.005},
+	}
+
+	for _, tt := range tests {
+		if got := NormalCDF(tt.x); !near(got, tt.cdf, 1e-12) {
+			t.Errorf("NormalCDF(%v) = %v, want %v", tt.x, got, tt.cdf)
+		}
+		if got := NormalQuantile(tt.cdf); !near(got, tt.x, 1e-9) {
+			t.Errorf("NormalQuantile(%v) = %v, want %v", tt.cdf, got, tt.x)
+		}
+	}
+}
+
+func TestWilsonInterval(t *testing.T) {
+	tests := []struct {
+		successes, trials int64
+		confidence        float64
+		want              Interval
+	}{
+		{81, 263, 0.95, Interval{0.2552885198782742, 0.36620957698280004}},
+		{0, 10, 0.95, Interval{0, 0.2775327998628892}},
+		{10, 10, 0.95, Interval{0.7224672001371107, 1}},
+		{5, 100, 0.90, Interval{0.02454736342580413, 0.09916108527905276}},
+		{0, 0, 0.95, Interval{}},
+	}
+
+	for _, tt := range tests {
+		got := WilsonInterval(tt.successes, tt.trials, tt.confidence)
+		if !near(got.Low, tt.want.Low, 1e-9) || !near(got.High, tt.want.High, 1e-9) {
+			t.Errorf("WilsonInterval(%d, %d, %v) = %+v, want %+v", tt.successes, tt.trials, tt.confidence, got, tt.want)
+		}
+	}
+}
+
+func TestTwoProportionZTest(t *testing.T) {
+	tests := []struct {
+		successesA, trialsA, successesB, trialsB int64
+		z, pValue                                float64
+	}{
+		{100, 1000, 130, 1000, 2.102740605622114, 0.03548845046647473},
+		{50, 500, 45, 500, -0.5392422499481173, 0.5897197110271788},
+		{10, 100, 10, 100, 0, 1},
+		{0, 100, 0, 100, 0, 1},
+		{5, 0, 5, 100, 0, 1},
+	}
+
+	for _, tt := range tests {
+		z, pValue := TwoProportionZTest(tt.successesA, tt.trialsA, tt.successesB, tt.trialsB)
+		if !near(z, tt.z, 1e-9) || !near(pValue, tt.pValue, 1e-9) {
+			t.Errorf("TwoProportionZTest(%d/%d, %d/%d) = %v, %v, want %v, %v",
+				tt.successesA, tt.trialsA, tt.successesB, tt.trialsB, z, pValue, tt.z, tt.pValue)
+		}
+	}
+}
+
+func TestDifferenceInterval(t *testing.T) {
+	got := DifferenceInterval(100, 1000, 130, 1000, 0.95)
+	want := Interval{0.0020679344393763656, 0.057932065560623636}
+	if !near(got.Low, want.Low, 1e-9) || !near(got.High, want.High, 1e-9) {
+		t.Errorf("DifferenceInterval = %+v, want %+v", got, want)
+	}
+}
+
+func TestLiftInterval(t *testing.T) {
+	tests := []struct {
+		successesA, trialsA, successesB, trialsB int64
+		want                                     Interval
+	}{
+		{100, 1000, 130, 1000, Interval{-0.01917939771358701, 0.6191793977135871}},
+		{10, 1000, 12, 1000, Interval{-0.8015396783251525, 1.2015396783251524}},
+		{0, 1000, 12, 1000, Interval{}},
+		{10, 0, 12, 1000, Interval{}},
+	}
+
+	for _, tt := range tests {
+		got := LiftInterval(tt.successesA, tt.trialsA, tt.successesB, tt.trialsB, 0.95)
+		if !near(got.Low, tt.want.Low, 1e-9) || !near(got.High, tt.want.High, 1e-9) {
+			t.Errorf("LiftInterval(%d/%d, %d/%d) = %+v, want %+v",
+				tt.successesA, tt.trialsA, tt.successesB, tt.trialsB, got, tt.want)
+		}
+	}
+
+	// The interval of a ratio is wider than the difference interval scaled by the control rate
+	diff := DifferenceInterval(100, 1000, 130, 1000, 0.95)
+	lift := LiftInterval(100, 1000, 130, 1000, 0.95)
+	if lift.High-lift.Low <= (diff.High-diff.Low)/0.1 {
+		t.Errorf("LiftInterval %+v is not wider than the scaled difference %+v", lift, diff)
+	}
+}
+
+func TestProbabilityBBeatsA(t *testing.T) {
+	// References from numerical integration of the Beta posteriors
+	tests := []struct {
+		successesA, trialsA, successesB, trialsB int64
+		want                                     float64
+	}{
+		{0, 1, 1, 1, 5.0 / 6},
+		{1, 1, 0, 1, 1.0 / 6},
+		{30, 100, 30, 100, 0.5},
+		{100, 1000, 130, 1000, 0.9821650658811514},
+		{20, 200, 25, 180, 0.878208943356216},
+		{3, 40, 7, 45, 0.8619751175278566},
+	}
+
+	for _, tt := range tests {
+		if got := ProbabilityBBeatsA(tt.successesA, tt.trialsA, tt.successesB, tt.trialsB); !near(got, tt.want, 1e-6) {
+			t.Errorf("ProbabilityBBeatsA(%d/%d, %d/%d) = %v, want %v",
+				tt.successesA, tt.trialsA, tt.successesB, tt.trialsB, got, tt.want)
+		}
+	}
+
+	// Both sides of the exact series limit against numerical integration
+	if got, want := ProbabilityBBeatsA(19700, 200000, 19998, 200000), 0.9424739296819299; !near(got, want, 1e-6) {
+		t.Errorf("exact series = %v, want %v", got, want)
+	}
+	if got, want := ProbabilityBBeatsA(19700, 200000, 20100, 200000), 0.9826943447423885; !near(got, want, 1e-4) {
+		t.Errorf("normal approximation = %v, want %v", got, want)
+	}
+}
+
+func TestCredibleInterval(t *testing.T) {
+	tests := []struct {
+		successes, trials int64
+		want              Interval
+	}{
+		{100, 1000, Interval{0.0821666816311577, 0.11943012475606785}},
+		{0, 20, Interval{0, 0.1305822924732727}},
+	}
+
+	for _, tt := range tests {
+		got := CredibleInterval(tt.successes, tt.trials, 0.95)
+		if !near(got.Low, tt.want.Low, 1e-9) || !near(got.High, tt.want.High, 1e-9) {
+			t.Errorf("CredibleInterval(%d, %d) = %+v, want %+v", tt.successes, tt.trials, got, tt.want)
+		}
+	}
+}
+
+func TestChiSquareSurvival(t *testing.T) {
+	// Critical values of the chi-square distribution
+	tests := []struct {
+		x, k, want float64
+	}{
+		{3.841458820694124, 1, 0.05},
+		{6.6348966010212145, 1, 0.01},
+		{5.991464547107979, 2, 0.05},
+		{7.814727903251178, 3, 0.05},
+		{18.307038053275146, 10, 0.05},
+		{2, 2, math.Exp(-1)},
+		{0, 3, 1},
+		{-1, 3, 1},
+	}
+
+	for _, tt := range tests {
+		if got := ChiSquareSurvival(tt.x, tt.k); !near(got, tt.want, 1e-9) {
+			t.Errorf("ChiSquareSurvival(%v, %v) = %v, want %v", tt.x, tt.k, got, tt.want)
+		}
+	}
+}
+
+func TestChiSquareGoodnessOfFit(t *testing.T) {
+	tests := []struct {
+		observed          []int64
+		shares            []float64
+		chiSquare, pValue float64
+	}{
+		{[]int64{500, 500}, []float64{0.5, 0.5}, 0, 1},
+		{[]int64{520, 480}, []float64{1, 1}, 1.6, 0.2059032107320684},
+		{[]int64{300, 300, 400}, []float64{1, 1, 1}, 20, math.Exp(-10)},
+		{[]int64{10}, []float64{1}, 0, 1},
+		{[]int64{0, 0}, []float64{0.5, 0.5}, 0, 1},
+	}
+
+	for _, tt := range tests {
+		chiSquare, pValue := ChiSquareGoodnessOfFit(tt.observed, tt.shares)
+		if !near(chiSquare, tt.chiSquare, 1e-9) || !near(pValue, tt.pValue, 1e-9) {
+			t.Errorf("ChiSquareGoodnessOfFit(%v, %v) = %v, %v, want %v, %v",
+				tt.observed, tt.shares, chiSquare, pValue, tt.chiSquare, tt.pValue)
+		}
+	}
+}
+
+func TestOBrienFlemingAlpha(t *testing.T) {
+	// Lan-DeMets O'Brien-Fleming spending at alpha 0.05
+	tests := []struct {
+		fraction, want float64
+	}{
+		{0, 0},
+		{0.25, 8.857543832130332e-05},
+		{0.5, 0.005574596680784527},
+		{1, 0.05},
+		{1.5, 0.05},
+	}
+
+	for _, tt := range tests {
+		if got := OBrienFlemingAlpha(0.05, tt.fraction); !near(got, tt.want, 1e-9) {
+			t.Errorf("OBrienFlemingAlpha(0.05, %v) = %v, want %v", tt.fraction, got, tt.want)
+		}
+	}
+}
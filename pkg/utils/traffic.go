@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Marketing channels
+const (
+	ChannelDirect        = "Direct"
+	ChannelOrganicSearch = "Organic Search"
+	ChannelPaidSearch    = "Paid Search"
+	ChannelOrganicSocial = "Organic Social"
+	ChannelPaidSocial    = "Paid Social"
+	ChannelEmail         = "Email"
+	ChannelAffiliates    = "Affiliates"
+	ChannelDisplay       = "Display"
+	ChannelReferral      = "Referral"
+)
+
+// Device types
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceUnknown = "unknown"
+)
+
+var searchEngines = []string{"google.", "bing.com", "yahoo.", "duckduckgo.com", "baidu.com", "yandex.", "ecosia.org", "naver.com", "coccoc.com"}
+
+var socialNetworks = []string{"facebook.com", "fb.com", "instagram.com", "twitter.com", "x.com", "t.co", "linkedin.com", "lnkd.in", "pinterest.", "reddit.com", "tiktok.com", "youtube.com", "zalo.me"}
+
+// UTM holds the campaign parameters of a landing URL
+type UTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+	Term     string
+	Content  string
+}
+
+// ParseUTM reads the utm_* query parameters of a URL
+func ParseUTM(pageURL string) UTM {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return UTM{}
+	}
+	query := u.Query()
+	return UTM{
+		Source:   query.Get("utm_source"),
+		Medium:   query.Get("utm_medium"),
+		Campaign: query.Get("utm_campaign"),
+		Term:     query.Get("utm_term"),
+		Content:  query.Get("utm_content"),
+	}
+}
+
+// ClassifyChannel assigns a visit to a marketing channel from its campaign parameters,
+// falling back to the referrer. Referrers on the same host as the page count as direct.
+func ClassifyChannel(referrer, pageURL string, utm UTM) string {
+	medium := strings.ToLower(utm.Medium)
+	source := strings.ToLower(utm.Source)
+
+	switch {
+	case medium == "email" || medium == "e-mail" || source == "newsletter":
+		return ChannelEmail
+	case medium == "affiliate" || medium == "affiliates":
+		return ChannelAffiliates
+	case medium == "display" || medium == "banner" || medium == "cpm":
+		return ChannelDisplay
+	case medium == "cpc" || medium == "ppc" || medium == "paid" || medium == "paidsearch" || medium == "paid_social" || medium == "paidsocial":
+		if isSocial(source) || strings.Contains(medium, "social") {
+			return ChannelPaidSocial
+		}
+		return ChannelPaidSearch
+	case medium == "social" || medium == "social-network" || medium == "sm":
+		return ChannelOrganicSocial
+	case medium == "organic":
+		return ChannelOrganicSearch
+	}
+
+	host := hostOf(referrer)
+	if host == "" || host == hostOf(pageURL) {
+		if source != "" {
+			return ChannelReferral
+		}
+		return ChannelDirect
+	}
+	if isSearchEngine(host) {
+		return ChannelOrganicSearch
+	}
+	if isSocial(host) {
+		return ChannelOrganicSocial
+	}
+	return ChannelReferral
+}
+
+// DeviceType classifies a user agent, using the screen width when the user agent is missing
+func DeviceType(userAgent string, screenWidth *int) string {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case ua == "":
+		if screenWidth == nil {
+			return DeviceUnknown
+		}
+		if *screenWidth < 768 {
+			return DeviceMobile
+		}
+		if *screenWidth < 1024 {
+			return DeviceTablet
+		}
+		return DeviceDesktop
+	case strings.Contains(ua, "bot") || strings.Contains(ua, "crawler") || strings.Contains(ua, "spider") || strings.Contains(ua, "headless"):
+		return DeviceBot
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") || (strings.Contains(ua, "android") && !strings.Contains(ua, "mobile")):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone") || strings.Contains(ua, "ipod") || strings.Contains(ua, "android"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+func isSearchEngine(host string) bool {
+	return matchesSite(host, searchEngines)
+}
+
+// isSocial accepts a referrer host or a bare utm_source name such as "facebook"
+func isSocial(name string) bool {
+	return matchesSite(name, socialNetworks)
+}
+
+// matchesSite matches a host against domains ("t.co") and domain prefixes ending in a dot
+// ("google."), or a bare name against their first label
+func matchesSite(host string, sites []string) bool {
+	if host == "" {
+		return false
+	}
+	for _, site := range sites {
+		if strings.HasSuffix(site, ".") {
+			if strings.HasPrefix(host, site) || strings.Contains(host, "."+site) {
+				return true
+			}
+		} else if host == site || strings.HasSuffix(host, "."+site) {
+			return true
+		}
+		if !strings.Contains(host, ".") && host == strings.SplitN(site, ".", 2)[0] {
+			return true
+		}
+	}
+	return false
+}
@@ -175,6 +175,19 @@ class AnalyticsTracker {
         });
     }
 
+    // Track script errors
+    trackError(message, customData = {}) {
+        return this.track({
+            event_type: 'error',
+            event_name: 'Error',
+            page_url: window.location.href,
+            properties: {
+                message: String(message),
+                ...customData
+            }
+        });
+    }
+
     // Set user ID
     setUserId(userId) {
         this.userId = userId;
@@ -214,6 +227,18 @@ class AnalyticsTracker {
             }, 1000); // Throttle to once per second
         });
 
+        // Track uncaught errors
+        window.addEventListener('error', (event) => {
+            this.trackError(event.message, {
+                source: event.filename,
+                line: event.lineno,
+                column: event.colno
+            });
+        });
+        window.addEventListener('unhandledrejection', (event) => {
+            this.trackError(event.reason?.message || event.reason, { source: 'unhandledrejection' });
+        });
+
         // Track page unload
         window.addEventListener('beforeunload', () => {
             this.trackCustomEvent('Page Unload', 'navigation');
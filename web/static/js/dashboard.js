@@ -48,9 +48,19 @@ async function loadDashboardStats() {
 
 async function loadEventsChart() {
     try {
-        const response = await fetch('/api/v1/analytics/events-by-day?days=7');
+        // Last 7 days including today, bucketed in the browser's timezone
+        const from = new Date();
+        from.setDate(from.getDate() - 6);
+        const params = new URLSearchParams({
+            metrics: 'events',
+            granularity: 'day',
+            from: formatISODate(from),
+            timezone: Intl.DateTimeFormat().resolvedOptions().timeZone
+        });
+
+        const response = await fetch('/api/v1/analytics/timeseries?' + params.toString());
         const result = await response.json();
-        const data = result.data || [];
+        const data = (result.data && result.data.points) || [];
         
         const ctx = document.getElementById('events-chart').getContext('2d');
         
@@ -61,10 +71,10 @@ async function loadEventsChart() {
         eventsChart = new Chart(ctx, {
             type: 'line',
             data: {
-                labels: data.map(item => formatDate(item.date)),
+                labels: data.map(point => formatDate(point.bucket)),
                 datasets: [{
                     label: 'Events',
-                    data: data.map(item => item.count),
+                    data: data.map(point => point.values.events),
                     borderColor: 'rgb(75, 192, 192)',
                     backgroundColor: 'rgba(75, 192, 192, 0.2)',
                     tension: 0.1
@@ -236,6 +246,12 @@ function formatDate(dateStr) {
     return date.toLocaleDateString('en-US', { month: 'short', day: 'numeric' });
 }
 
+function formatISODate(date) {
+    const month = String(date.getMonth() + 1).padStart(2, '0');
+    const day = String(date.getDate()).padStart(2, '0');
+    return date.getFullYear() + '-' + month + '-' + day;
+}
+
 function formatDateTime(dateStr) {
     const date = new Date(dateStr);
     return date.toLocaleDateString('en-US', { 